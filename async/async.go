@@ -0,0 +1,259 @@
+// Package async lets the slow endpoints answer before their backends do. The gateway replies with a
+// 202 pointing to a status URL, completes the backend calls in the background and keeps the result
+// in a store until the client polls it or its TTL expires. The results are kept in the memory of the
+// gateway by NewMemoryStore, or shared by all its replicas through redis by NewRedisStore.
+//
+// The status handler must be mounted under StatusPath, for example:
+//
+//	engine.GET(async.StatusPath+":id", gin.WrapH(async.Handler(store)))
+package async
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/proxy"
+)
+
+// StatusPath is the prefix of the status URLs returned to the clients
+const StatusPath = "/__async/"
+
+// DefaultTTL is the time the results are kept when the endpoint does not define it
+const DefaultTTL = 10 * time.Minute
+
+// DefaultMaxPending is the max requests of an endpoint running in the background when the endpoint does
+// not define it
+const DefaultMaxPending = 100
+
+// Statuses of a result
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Result is the state of an asynchronous request
+type Result struct {
+	Status     string                 `json:"status"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	IsComplete bool                   `json:"complete,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Store keeps the results of the asynchronous requests
+type Store interface {
+	// Save stores the result, replacing the previous one, for the received time
+	Save(ctx context.Context, id string, r Result, ttl time.Duration) error
+	// Load returns the result and false if it does not exist or it has expired
+	Load(ctx context.Context, id string) (Result, bool, error)
+}
+
+// NewFactory returns a factory decorating the proxies of the endpoints with an async config, so their
+// results are kept in the store
+func NewFactory(next proxy.Factory, store Store) proxy.Factory {
+	return factory{next, store, backgroundGroup}
+}
+
+// backgroundGroup runs the requests of the factories not bound to a group
+var backgroundGroup = lifecycle.NewGroup(context.Background())
+
+type factory struct {
+	next  proxy.Factory
+	store Store
+	group *lifecycle.Group
+}
+
+func (f factory) New(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+	p, err := f.next.New(cfg)
+	if err != nil || cfg.Async == nil {
+		return p, err
+	}
+	return NewMiddleware(cfg, f.store, f.group)(p), nil
+}
+
+// WithGroup implements the proxy.GroupFactory interface, binding the next factory to the group too
+func (f factory) WithGroup(g *lifecycle.Group) proxy.Factory {
+	return factory{proxy.WithGroup(f.next, g), f.store, g}
+}
+
+// NewMiddleware returns a middleware answering with the status URL of the request while the next proxy
+// runs in the background, bounded by the timeout of the endpoint. The requests beyond the max pending
+// ones get a 503. Stopping the group waits for the running requests instead of canceling them, so the
+// reloads do not fail the accepted requests
+func NewMiddleware(cfg *config.EndpointConfig, store Store, g *lifecycle.Group) proxy.Middleware {
+	ttl := DefaultTTL
+	maxPending := DefaultMaxPending
+	if cfg.Async != nil && cfg.Async.TTL > 0 {
+		ttl = cfg.Async.TTL
+	}
+	if cfg.Async != nil && cfg.Async.MaxPending > 0 {
+		maxPending = cfg.Async.MaxPending
+	}
+	timeout := cfg.Timeout
+	pending := make(chan struct{}, maxPending)
+
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if len(next) > 1 {
+			panic(proxy.ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			select {
+			case pending <- struct{}{}:
+			default:
+				return &proxy.Response{
+					Data:     map[string]interface{}{"error": "too many pending requests"},
+					Metadata: proxy.Metadata{StatusCode: http.StatusServiceUnavailable},
+				}, nil
+			}
+			started := false
+			defer func() {
+				if !started {
+					<-pending
+				}
+			}()
+
+			id, err := newID()
+			if err != nil {
+				return nil, err
+			}
+			// the body of the client request is gone once the gateway answers
+			if request.Body != nil {
+				body, err := ioutil.ReadAll(request.Body)
+				request.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			if err := store.Save(ctx, id, Result{Status: StatusPending}, timeout+ttl); err != nil {
+				return nil, err
+			}
+
+			started = true
+			g.Go(func(groupCtx context.Context) {
+				defer func() { <-pending }()
+				bgCtx, cancel := context.WithTimeout(context.WithoutCancel(groupCtx), timeout)
+				defer cancel()
+				result := Result{Status: StatusDone}
+				resp, err := next[0](bgCtx, request)
+				if err != nil {
+					result = Result{Status: StatusFailed, Error: err.Error()}
+				} else if resp != nil {
+					result.Data = resp.Data
+					result.IsComplete = resp.IsComplete
+				}
+				store.Save(context.Background(), id, result, ttl)
+			})
+
+			location := StatusPath + id
+			return &proxy.Response{
+				Data: map[string]interface{}{
+					"id":         id,
+					"status":     StatusPending,
+					"status_url": location,
+				},
+				Metadata: proxy.Metadata{
+					Headers:    map[string][]string{"Location": {location}},
+					StatusCode: http.StatusAccepted,
+				},
+			}, nil
+		}
+	}
+}
+
+// Handler returns the status of the request identified by the last segment of the path. The pending
+// requests get a 202, the failed ones a 502 and the completed ones a 200 with the data of the response
+func Handler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		result, ok, err := store.Load(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		status := http.StatusOK
+		var body interface{} = result.Data
+		switch result.Status {
+		case StatusPending:
+			status = http.StatusAccepted
+			body = map[string]interface{}{"id": id, "status": result.Status}
+		case StatusFailed:
+			status = http.StatusBadGateway
+			body = map[string]interface{}{"id": id, "status": result.Status, "error": result.Error}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+// NewMemoryStore returns a store keeping the results in the memory of the gateway
+func NewMemoryStore() Store {
+	return &memoryStore{results: map[string]memoryEntry{}}
+}
+
+type memoryEntry struct {
+	result  Result
+	expires time.Time
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	results map[string]memoryEntry
+	saves   int
+}
+
+func (m *memoryStore) Save(_ context.Context, id string, r Result, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.results[id] = memoryEntry{result: r, expires: now.Add(ttl)}
+
+	m.saves++
+	if m.saves%100 == 0 {
+		for k, e := range m.results {
+			if now.After(e.expires) {
+				delete(m.results, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) Load(_ context.Context, id string) (Result, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.results[id]
+	if !ok {
+		return Result{}, false, nil
+	}
+	if time.Now().After(e.expires) {
+		delete(m.results, id)
+		return Result{}, false, nil
+	}
+	return e.result, true, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
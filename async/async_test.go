@@ -0,0 +1,126 @@
+package async
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/proxy"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		Timeout:  time.Second,
+		Async:    &config.Async{TTL: time.Minute},
+	}
+	store := NewMemoryStore()
+	release := make(chan struct{})
+	g := lifecycle.NewGroup(context.Background())
+	p := NewMiddleware(cfg, store, g)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		<-release
+		return &proxy.Response{Data: map[string]interface{}{"supu": "tupu"}, IsComplete: true}, nil
+	})
+
+	response, err := p(context.Background(), &proxy.Request{Method: "POST"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Metadata.StatusCode != http.StatusAccepted {
+		t.Errorf("want status 202, have %d", response.Metadata.StatusCode)
+	}
+	location := response.Metadata.Headers["Location"]
+	if len(location) != 1 || location[0] != StatusPath+response.Data["id"].(string) {
+		t.Errorf("unexpected location: %v", location)
+		return
+	}
+
+	if w := poll(Handler(store), location[0]); w.Code != http.StatusAccepted {
+		t.Errorf("want status 202 while pending, have %d", w.Code)
+	}
+
+	close(release)
+	// stopping the group waits for the running requests
+	g.Stop(context.Background())
+	w := poll(Handler(store), location[0])
+	if w.Code != http.StatusOK {
+		t.Errorf("want status 200 once done, have %d", w.Code)
+		return
+	}
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Error(err)
+		return
+	}
+	if data["supu"] != "tupu" {
+		t.Errorf("unexpected data: %v", data)
+	}
+}
+
+func TestNewMiddleware_failure(t *testing.T) {
+	cfg := &config.EndpointConfig{Endpoint: "/supu", Timeout: time.Second, Async: &config.Async{}}
+	store := NewMemoryStore()
+	g := lifecycle.NewGroup(context.Background())
+	p := NewMiddleware(cfg, store, g)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return nil, errors.New("boom")
+	})
+
+	response, err := p(context.Background(), &proxy.Request{Method: "GET"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	g.Stop(context.Background())
+	if w := poll(Handler(store), response.Metadata.Headers["Location"][0]); w.Code != http.StatusBadGateway {
+		t.Errorf("want status 502, have %d", w.Code)
+	}
+}
+
+func TestNewMiddleware_maxPending(t *testing.T) {
+	cfg := &config.EndpointConfig{Endpoint: "/supu", Timeout: time.Second, Async: &config.Async{MaxPending: 1}}
+	release := make(chan struct{})
+	g := lifecycle.NewGroup(context.Background())
+	p := NewMiddleware(cfg, NewMemoryStore(), g)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		<-release
+		return &proxy.Response{IsComplete: true}, nil
+	})
+
+	response, err := p(context.Background(), &proxy.Request{Method: "POST"})
+	if err != nil || response.Metadata.StatusCode != http.StatusAccepted {
+		t.Errorf("unexpected response: %v %v", response, err)
+	}
+	response, err = p(context.Background(), &proxy.Request{Method: "POST"})
+	if err != nil || response.Metadata.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("the requests beyond the max pending must be rejected: %v %v", response, err)
+	}
+	close(release)
+	g.Stop(context.Background())
+}
+
+func TestHandler_unknown(t *testing.T) {
+	if w := poll(Handler(NewMemoryStore()), StatusPath+"unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("want status 404, have %d", w.Code)
+	}
+}
+
+func TestMemoryStore_expiration(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(context.Background(), "supu", Result{Status: StatusDone}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := store.Load(context.Background(), "supu"); ok {
+		t.Error("the result should have expired")
+	}
+}
+
+func poll(h http.Handler, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
@@ -0,0 +1,67 @@
+package async
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisKeyPrefix is prepended to the id of the requests to build their keys
+const RedisKeyPrefix = "porta:async:"
+
+// NewRedisStore returns a store keeping the results in redis, so every replica of the gateway can
+// answer the polls of the clients
+func NewRedisStore(address string) Store {
+	return &redisStore{pool: &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address)
+		},
+	}}
+}
+
+// NewRedisPoolStore returns a store keeping the results in redis through the received pool
+func NewRedisPoolStore(pool *redis.Pool) Store {
+	return &redisStore{pool: pool}
+}
+
+type redisStore struct {
+	pool *redis.Pool
+}
+
+func (s *redisStore) Save(ctx context.Context, id string, r Result, ttl time.Duration) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("SET", RedisKeyPrefix+id, b, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+func (s *redisStore) Load(ctx context.Context, id string) (Result, bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return Result{}, false, err
+	}
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("GET", RedisKeyPrefix+id))
+	if err == redis.ErrNil {
+		return Result{}, false, nil
+	}
+	if err != nil {
+		return Result{}, false, err
+	}
+	r := Result{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return Result{}, false, err
+	}
+	return r, true, nil
+}
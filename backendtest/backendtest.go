@@ -0,0 +1,170 @@
+// Package backendtest provides a programmable fake backend to test gateways and their configurations
+package backendtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Failure defines how the fake backend misbehaves
+type Failure int
+
+const (
+	// NoFailure sends the scripted response
+	NoFailure Failure = iota
+	// Hang blocks the response until the client gives up
+	Hang
+	// CloseConnection closes the connection without sending any response
+	CloseConnection
+)
+
+// Response is a scripted response of the fake backend
+type Response struct {
+	// status code of the response. 200 if zero
+	Status int
+	// headers to add to the response
+	Headers map[string]string
+	// raw body of the response. It takes precedence over JSON
+	Body []byte
+	// value to encode as the JSON body of the response
+	JSON interface{}
+	// time to wait before sending the response
+	Latency time.Duration
+	// failure mode of the response
+	Failure Failure
+}
+
+// RecordedRequest is a request received by the fake backend
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   []byte
+}
+
+// Server is a fake backend returning the scripted responses and recording all the received requests
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	routes   map[string][]Response
+	calls    map[string]int
+	requests []RecordedRequest
+}
+
+// NewServer starts a fake backend without routes. Unknown routes get a 404
+func NewServer() *Server {
+	s := &Server{
+		routes: map[string][]Response{},
+		calls:  map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Handle scripts the responses to send for the method and the path. The responses are sent in order
+// and the last one is repeated once all of them have been used
+func (s *Server) Handle(method, path string, responses ...Response) {
+	if len(responses) == 0 {
+		responses = []Response{{}}
+	}
+	s.mu.Lock()
+	s.routes[routeKey(method, path)] = responses
+	delete(s.calls, routeKey(method, path))
+	s.mu.Unlock()
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedRequest{}, s.requests...)
+}
+
+// Reset removes the routes and the recorded requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.routes = map[string][]Response{}
+	s.calls = map[string]int{}
+	s.requests = nil
+	s.mu.Unlock()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	key := routeKey(r.Method, r.URL.Path)
+	s.mu.Lock()
+	s.requests = append(s.requests, RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	responses, ok := s.routes[key]
+	i := s.calls[key]
+	s.calls[key]++
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if i >= len(responses) {
+		i = len(responses) - 1
+	}
+	s.write(w, r, responses[i])
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, resp Response) {
+	if resp.Latency > 0 {
+		select {
+		case <-time.After(resp.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch resp.Failure {
+	case Hang:
+		<-r.Context().Done()
+		return
+	case CloseConnection:
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	body := resp.Body
+	if body == nil && resp.JSON != nil {
+		var err error
+		if body, err = json.Marshal(resp.JSON); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func routeKey(method, path string) string {
+	return method + " " + path
+}
@@ -0,0 +1,176 @@
+// Package cdn provides the helpers to integrate the gateway with the CDNs caching its responses
+package cdn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// Namespace is the key of the service extra config holding the settings of the CDN purgers
+const Namespace = "cdn"
+
+var keyParamsPattern = regexp.MustCompile(`\{([a-zA-Z\-_0-9]+)\}`)
+
+// SetHeaders adds the caching hints of the endpoint to the response headers. The surrogate keys are
+// rendered with the params of the request
+func SetHeaders(header http.Header, cfg *config.EdgeCache, params map[string]string) {
+	if cfg == nil {
+		return
+	}
+
+	if cfg.TTL > 0 {
+		directives := fmt.Sprintf("max-age=%d", int(cfg.TTL.Seconds()))
+		if cfg.StaleWhileRevalidate > 0 {
+			directives += fmt.Sprintf(", stale-while-revalidate=%d", int(cfg.StaleWhileRevalidate.Seconds()))
+		}
+		header.Set("Surrogate-Control", directives)
+
+		sMaxAge := fmt.Sprintf("s-maxage=%d", int(cfg.TTL.Seconds()))
+		if cc := header.Get("Cache-Control"); cc != "" {
+			sMaxAge = cc + ", " + sMaxAge
+		}
+		header.Set("Cache-Control", sMaxAge)
+	}
+
+	if keys := SurrogateKeys(cfg.SurrogateKeys, params); len(keys) > 0 {
+		header.Set("Surrogate-Key", strings.Join(keys, " "))
+	}
+
+	for _, h := range cfg.Vary {
+		header.Add("Vary", h)
+	}
+}
+
+// SurrogateKeys renders the key templates with the received params. The keys referencing missing
+// params are discarded
+func SurrogateKeys(templates []string, params map[string]string) []string {
+	keys := make([]string, 0, len(templates))
+	for _, tmpl := range templates {
+		missing := false
+		key := keyParamsPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+			v, ok := params[paramKey(m[1:len(m)-1])]
+			if !ok {
+				missing = true
+			}
+			return v
+		})
+		if !missing && key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// paramKey returns the key of the param in the params of the request, which the routers store with
+// the first letter of every word in upper case
+func paramKey(name string) string {
+	// the casers are stateful, so they can not be shared between goroutines
+	return cases.Title(language.Und, cases.NoLower).String(name)
+}
+
+// Request defines the content to invalidate in the CDNs
+type Request struct {
+	// surrogate keys to purge
+	Keys []string `json:"keys"`
+	// paths to purge
+	Paths []string `json:"paths"`
+}
+
+// Purger invalidates the cached content of a CDN
+type Purger interface {
+	Purge(ctx context.Context, r Request) error
+}
+
+// Purgers is a Purger invalidating the content in all its members
+type Purgers []Purger
+
+// Purge calls all the purgers, returning the first error found
+func (p Purgers) Purge(ctx context.Context, r Request) error {
+	var first error
+	for _, purger := range p {
+		if err := purger.Purge(ctx, r); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// NewPurger returns the purger of the CDNs declared in the extra config of the service, like
+// {"cdn": {"fastly": {"service_id": "svc", "token": "secret", "host": "example.com"}}}. It returns nil
+// if no CDN is declared
+func NewPurger(extra config.ExtraConfig) (Purger, error) {
+	raw, ok := extra[Namespace]
+	if !ok {
+		return nil, nil
+	}
+	var settings struct {
+		Fastly *struct {
+			ServiceID string `mapstructure:"service_id"`
+			Token     string `mapstructure:"token"`
+			Host      string `mapstructure:"host"`
+			Soft      bool   `mapstructure:"soft"`
+		} `mapstructure:"fastly"`
+		CloudFront *struct {
+			DistributionID  string `mapstructure:"distribution_id"`
+			AccessKeyID     string `mapstructure:"access_key_id"`
+			SecretAccessKey string `mapstructure:"secret_access_key"`
+			SessionToken    string `mapstructure:"session_token"`
+		} `mapstructure:"cloudfront"`
+	}
+	if err := config.Decode(raw, &settings); err != nil {
+		return nil, fmt.Errorf("decoding the cdn settings: %w", err)
+	}
+
+	var purgers Purgers
+	if f := settings.Fastly; f != nil {
+		if f.ServiceID == "" || f.Token == "" {
+			return nil, fmt.Errorf("the fastly purger requires a service_id and a token")
+		}
+		fastly := NewFastlyPurger(f.ServiceID, f.Token, f.Host)
+		fastly.Soft = f.Soft
+		purgers = append(purgers, fastly)
+	}
+	if c := settings.CloudFront; c != nil {
+		if c.DistributionID == "" || c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return nil, fmt.Errorf("the cloudfront purger requires a distribution_id, an access_key_id and a secret_access_key")
+		}
+		cloudFront := NewCloudFrontPurger(c.DistributionID, c.AccessKeyID, c.SecretAccessKey)
+		cloudFront.SessionToken = c.SessionToken
+		purgers = append(purgers, cloudFront)
+	}
+	if len(purgers) == 0 {
+		return nil, nil
+	}
+	return purgers, nil
+}
+
+// NewPurgeHandler returns the admin handler invalidating the content described in the JSON body of
+// the request with the received purger
+func NewPurgeHandler(p Purger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.Purge(r.Context(), req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,147 @@
+package cdn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestSetHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Cache-Control", "public, max-age=60")
+	cfg := &config.EdgeCache{
+		TTL:                  time.Hour,
+		StaleWhileRevalidate: time.Minute,
+		SurrogateKeys:        []string{"users", "user-{id}", "group-{group}"},
+		Vary:                 []string{"Accept-Language"},
+	}
+	SetHeaders(header, cfg, map[string]string{"Id": "42"})
+
+	if h := header.Get("Cache-Control"); h != "public, max-age=60, s-maxage=3600" {
+		t.Errorf("unexpected Cache-Control: %s", h)
+	}
+	if h := header.Get("Surrogate-Control"); h != "max-age=3600, stale-while-revalidate=60" {
+		t.Errorf("unexpected Surrogate-Control: %s", h)
+	}
+	if h := header.Get("Surrogate-Key"); h != "users user-42" {
+		t.Errorf("unexpected Surrogate-Key: %s", h)
+	}
+	if h := header.Get("Vary"); h != "Accept-Language" {
+		t.Errorf("unexpected Vary: %s", h)
+	}
+}
+
+func TestSurrogateKeys(t *testing.T) {
+	params := map[string]string{"Id": "42", "User_id": "7", "Tenant-Id": "acme", "UserID": "9"}
+	keys := SurrogateKeys([]string{"user-{id}", "u-{user_id}", "t-{tenant-id}", "v-{userID}", "g-{group}"}, params)
+	if want := []string{"user-42", "u-7", "t-acme", "v-9"}; strings.Join(keys, " ") != strings.Join(want, " ") {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestNewPurger(t *testing.T) {
+	p, err := NewPurger(config.ExtraConfig{})
+	if err != nil || p != nil {
+		t.Errorf("unexpected purger without settings: %v %v", p, err)
+	}
+
+	p, err = NewPurger(config.ExtraConfig{Namespace: map[string]interface{}{
+		"fastly":     map[string]interface{}{"service_id": "svc", "token": "secret", "host": "example.com", "soft": true},
+		"cloudfront": map[string]interface{}{"distribution_id": "dist", "access_key_id": "id", "secret_access_key": "secret"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	purgers, ok := p.(Purgers)
+	if !ok || len(purgers) != 2 {
+		t.Fatalf("unexpected purger: %#v", p)
+	}
+	if f, ok := purgers[0].(*Fastly); !ok || f.ServiceID != "svc" || f.Token != "secret" || f.Host != "example.com" || !f.Soft {
+		t.Errorf("unexpected fastly purger: %#v", purgers[0])
+	}
+	if c, ok := purgers[1].(*CloudFront); !ok || c.DistributionID != "dist" || c.AccessKeyID != "id" || c.SecretAccessKey != "secret" {
+		t.Errorf("unexpected cloudfront purger: %#v", purgers[1])
+	}
+
+	for _, settings := range []interface{}{
+		map[string]interface{}{"fastly": map[string]interface{}{"service_id": "svc"}},
+		map[string]interface{}{"cloudfront": map[string]interface{}{"distribution_id": "dist"}},
+		"fastly",
+	} {
+		if _, err := NewPurger(config.ExtraConfig{Namespace: settings}); err == nil {
+			t.Errorf("%v: the invalid settings were accepted", settings)
+		}
+	}
+}
+
+func TestFastly_Purge(t *testing.T) {
+	var paths, keys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Fastly-Key") != "secret" {
+			t.Errorf("unexpected token: %s", r.Header.Get("Fastly-Key"))
+		}
+		paths = append(paths, r.URL.Path)
+		keys = append(keys, r.Header.Get("Surrogate-Key"))
+	}))
+	defer ts.Close()
+
+	f := NewFastlyPurger("svc", "secret", "example.com")
+	f.api = ts.URL
+	if err := f.Purge(context.Background(), Request{Keys: []string{"a", "b"}, Paths: []string{"/users/42"}}); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(paths) != 2 || paths[0] != "/service/svc/purge" || paths[1] != "/purge/example.com/users/42" {
+		t.Errorf("unexpected paths: %v", paths)
+	}
+	if keys[0] != "a b" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCloudFront_Purge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2020-05-31/distribution/DIST/invalidation" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") == "" || r.Header.Get("X-Amz-Date") != "20200101T000000Z" {
+			t.Errorf("the request is not signed: %v", r.Header)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	c := NewCloudFrontPurger("DIST", "AKID", "secret")
+	c.api = ts.URL
+	c.now = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+	if err := c.Purge(context.Background(), Request{Paths: []string{"/users/*"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewPurgeHandler(t *testing.T) {
+	var received Request
+	handler := NewPurgeHandler(purgerFunc(func(_ context.Context, r Request) error {
+		received = r
+		return nil
+	}))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/__purge", strings.NewReader(`{"keys":["users"]}`))
+	handler(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if len(received.Keys) != 1 || received.Keys[0] != "users" {
+		t.Errorf("unexpected purge request: %v", received)
+	}
+}
+
+type purgerFunc func(context.Context, Request) error
+
+func (f purgerFunc) Purge(ctx context.Context, r Request) error { return f(ctx, r) }
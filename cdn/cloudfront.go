@@ -0,0 +1,148 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	cloudFrontAPI    = "https://cloudfront.amazonaws.com"
+	cloudFrontRegion = "us-east-1"
+)
+
+// CloudFront creates invalidations in a CloudFront distribution. CloudFront has no surrogate keys,
+// so only the paths of the requests are invalidated
+type CloudFront struct {
+	// id of the distribution
+	DistributionID string
+	// AWS credentials allowed to create invalidations
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// client to use. http.DefaultClient if nil
+	Client *http.Client
+
+	api string
+	now func() time.Time
+}
+
+// NewCloudFrontPurger creates a Purger for the CloudFront distribution
+func NewCloudFrontPurger(distributionID, accessKeyID, secretAccessKey string) *CloudFront {
+	return &CloudFront{
+		DistributionID:  distributionID,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+	}
+}
+
+type invalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Paths           []string `xml:"Paths>Items>Path"`
+	CallerReference string   `xml:"CallerReference"`
+}
+
+// Purge creates an invalidation with all the paths of the request
+func (c *CloudFront) Purge(ctx context.Context, r Request) error {
+	if len(r.Paths) == 0 {
+		return nil
+	}
+	now := c.clock()
+	body, err := xml.Marshal(invalidationBatch{
+		Quantity:        len(r.Paths),
+		Paths:           r.Paths,
+		CallerReference: strconv.FormatInt(now.UnixNano(), 10),
+	})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/2020-05-31/distribution/%s/invalidation", c.baseURL(), c.DistributionID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	c.sign(req, body, now)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("cloudfront invalidation failed with status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// sign adds the AWS signature version 4 to the request
+func (c *CloudFront) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + c.SessionToken + "\n"
+	}
+
+	canonicalRequest := req.Method + "\n" + req.URL.EscapedPath() + "\n" + req.URL.RawQuery + "\n" +
+		canonicalHeaders + "\n" + signedHeaders + "\n" + payloadHash
+	scope := date + "/" + cloudFrontRegion + "/cloudfront/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, cloudFrontRegion)
+	key = hmacSHA256(key, "cloudfront")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func (c *CloudFront) baseURL() string {
+	if c.api != "" {
+		return c.api
+	}
+	return cloudFrontAPI
+}
+
+func (c *CloudFront) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,101 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ErrFastlyHost is returned when purging paths from a Fastly purger without host
+var ErrFastlyHost = errors.New("the fastly purger requires a host to purge paths")
+
+const fastlyAPI = "https://api.fastly.com"
+
+// Fastly purges the content cached by a Fastly service
+type Fastly struct {
+	// id of the Fastly service
+	ServiceID string
+	// API token with purge permissions
+	Token string
+	// domain of the service, required to purge paths
+	Host string
+	// soft purges mark the content as stale instead of removing it
+	Soft bool
+	// client to use. http.DefaultClient if nil
+	Client *http.Client
+
+	api string
+}
+
+// NewFastlyPurger creates a Purger for the Fastly service
+func NewFastlyPurger(serviceID, token, host string) *Fastly {
+	return &Fastly{ServiceID: serviceID, Token: token, Host: host}
+}
+
+// Purge invalidates the surrogate keys with a single bulk purge and the paths one by one
+func (f *Fastly) Purge(ctx context.Context, r Request) error {
+	if len(r.Keys) > 0 {
+		req, err := f.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s/service/%s/purge", f.baseURL(), f.ServiceID))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Surrogate-Key", strings.Join(r.Keys, " "))
+		if err := f.do(req); err != nil {
+			return err
+		}
+	}
+	if len(r.Paths) > 0 && f.Host == "" {
+		return ErrFastlyHost
+	}
+	for _, p := range r.Paths {
+		req, err := f.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s/purge/%s/%s", f.baseURL(), f.Host, strings.TrimPrefix(p, "/")))
+		if err != nil {
+			return err
+		}
+		if err := f.do(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fastly) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Fastly-Key", f.Token)
+	req.Header.Set("Accept", "application/json")
+	if f.Soft {
+		req.Header.Set("Fastly-Soft-Purge", "1")
+	}
+	return req, nil
+}
+
+func (f *Fastly) do(req *http.Request) error {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("fastly purge failed with status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+func (f *Fastly) baseURL() string {
+	if f.api != "" {
+		return f.api
+	}
+	return fastlyAPI
+}
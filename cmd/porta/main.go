@@ -0,0 +1,132 @@
+// Command porta runs the tooling of the gateway configs
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/config/policy"
+	"github.com/ph0m1/porta/config/viper"
+	"github.com/ph0m1/porta/monitoring"
+)
+
+const usage = `usage: porta <command> [flags]
+
+commands:
+  check        parse and validate a config file, exiting with 1 if it has problems. With -policy, the
+               config must follow the rules of the policy file. With -probe, the backend hosts must be
+               reachable too
+  healthprobe  request the readiness endpoint of a running gateway, exiting with 1 if it is not ready
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "check":
+		os.Exit(check(os.Args[2:], os.Stdout, os.Stderr))
+	case "healthprobe":
+		os.Exit(healthprobe(os.Args[2:], os.Stderr))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command [%s]\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+// check parses and validates the config file, reporting every problem found. It returns the exit code
+func check(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("c", "porta.yaml", "Path to the configuration file")
+	policyFile := flags.String("policy", "", "Path to the file with the organizational rules of the config")
+	probe := flags.Bool("probe", false, "Probe the reachability of the backend hosts")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	parser := viper.New()
+	if *policyFile != "" {
+		engine, err := policy.ParseFile(*policyFile)
+		if err != nil {
+			fmt.Fprintln(stderr, "ERROR:", err.Error())
+			return 1
+		}
+		parser = policy.NewParser(parser, engine)
+	}
+	cfg, err := parser.Parse(*configFile)
+	// the config breaking the policy is parsed anyway, so its problems are reported along
+	errs := config.ValidationErrors{}
+	switch e := err.(type) {
+	case nil:
+	case policy.Violations:
+		for _, v := range e {
+			errs = append(errs, v)
+		}
+	default:
+		fmt.Fprintln(stderr, "ERROR:", err.Error())
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		if ve, ok := err.(config.ValidationErrors); ok {
+			errs = append(errs, ve...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintln(stderr, "ERROR:", e.Error())
+		}
+		fmt.Fprintf(stderr, "%s: %d problems found\n", *configFile, len(errs))
+		return 1
+	}
+	if *probe {
+		report := monitoring.ProbeBackends(context.Background(), cfg)
+		report.WriteTo(stdout)
+		if err := report.Err(); err != nil {
+			fmt.Fprintln(stderr, "ERROR:", err.Error())
+			return 1
+		}
+	}
+	fmt.Fprintf(stdout, "%s: ok, %d endpoints\n", *configFile, len(cfg.Endpoints))
+	return 0
+}
+
+// healthprobe requests the readiness endpoint, so the container images can declare their HEALTHCHECK
+// and exec probes without shipping an http client. It returns the exit code
+func healthprobe(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("healthprobe", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	url := flags.String("url", "http://127.0.0.1:8080/__ready", "URL of the readiness endpoint")
+	timeout := flags.Duration("timeout", 2*time.Second, "Timeout of the probe")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(*url)
+	if err != nil {
+		fmt.Fprintln(stderr, "ERROR:", err.Error())
+		return 1
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		fmt.Fprintf(stderr, "ERROR: %s answered with the status %d\n", *url, resp.StatusCode)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{
+		"version": 1,
+		"host": ["http://127.0.0.1:8080"],
+		"timeout": "3s",
+		"endpoints": [{"endpoint": "/supu", "backend": [{"url_pattern": "/tupu"}]}]
+	}`), 0o644)
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{
+		"version": 1,
+		"endpoints": [
+			{"endpoint": "/supu", "backend": [{"url_pattern": "/tupu", "encoding": "csv", "timeout": "5s"}]},
+			{"endpoint": "/supu", "backend": [{"url_pattern": "/tupu"}]}
+		]
+	}`), 0o644)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := check([]string{"-c", valid}, stdout, stderr); code != 0 {
+		t.Errorf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "ok, 1 endpoints") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	if code := check([]string{"-c", invalid}, stdout, stderr); code != 1 {
+		t.Errorf("unexpected exit code %d", code)
+	}
+	for _, problem := range []string{"declared more than once", "unknown encoding [csv]", "has no hosts", "the endpoint gives up after 2s"} {
+		if !strings.Contains(stderr.String(), problem) {
+			t.Errorf("the problem [%s] was not reported: %s", problem, stderr.String())
+		}
+	}
+
+	if code := check([]string{"-c", filepath.Join(dir, "missing.json")}, stdout, stderr); code != 1 {
+		t.Errorf("unexpected exit code %d for a missing file", code)
+	}
+}
+
+func TestCheck_policy(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "config.json")
+	os.WriteFile(cfg, []byte(`{
+		"version": 1,
+		"host": ["http://127.0.0.1:8080"],
+		"timeout": "3s",
+		"cors": {"allowed_origins": ["*"], "allow_credentials": true},
+		"endpoints": [
+			{"endpoint": "/supu", "backend": [{"url_pattern": "/tupu"}]},
+			{
+				"endpoint": "/private",
+				"extra_config": {"middlewares": [{"name": "auth", "jwt_secret": "s3cr3t"}]},
+				"backend": [{"url_pattern": "/tupu"}]
+			}
+		]
+	}`), 0o644)
+	rules := filepath.Join(dir, "policy")
+	os.WriteFile(rules, []byte("require_auth\nno_wildcard_cors_with_credentials\n"), 0o644)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := check([]string{"-c", cfg, "-policy", rules}, stdout, stderr); code != 1 {
+		t.Errorf("unexpected exit code %d: %s", code, stdout.String())
+	}
+	for _, problem := range []string{"require_auth: [/supu]", "no_wildcard_cors_with_credentials", "2 problems found"} {
+		if !strings.Contains(stderr.String(), problem) {
+			t.Errorf("the problem [%s] was not reported: %s", problem, stderr.String())
+		}
+	}
+
+	if code := check([]string{"-c", cfg, "-policy", filepath.Join(dir, "missing")}, stdout, stderr); code != 1 {
+		t.Errorf("unexpected exit code %d for a missing policy", code)
+	}
+}
+
+func TestHealthprobe(t *testing.T) {
+	ready := true
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/slow":
+			time.Sleep(100 * time.Millisecond)
+		case !ready:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+
+	stderr := &bytes.Buffer{}
+	if code := healthprobe([]string{"--url", s.URL + "/__ready"}, stderr); code != 0 {
+		t.Errorf("unexpected exit code %d: %s", code, stderr.String())
+	}
+
+	ready = false
+	if code := healthprobe([]string{"--url", s.URL + "/__ready"}, stderr); code != 1 {
+		t.Errorf("unexpected exit code %d for a not ready gateway", code)
+	}
+	if !strings.Contains(stderr.String(), "status 503") {
+		t.Errorf("unexpected output: %s", stderr.String())
+	}
+
+	if code := healthprobe([]string{"--url", s.URL + "/slow", "--timeout", "10ms"}, stderr); code != 1 {
+		t.Errorf("unexpected exit code %d for a timeout", code)
+	}
+
+	if code := healthprobe([]string{"--unknown"}, stderr); code != 2 {
+		t.Errorf("unexpected exit code %d for a usage error", code)
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	errNoEndpoint = errors.New("the builder has no endpoint: add one first")
+	errNoBackend  = errors.New("the last endpoint of the builder has no backend: add one first")
+)
+
+// ServiceBuilder builds a ServiceConfig in go code, for the applications embedding the gateway. The
+// endpoint and backend methods act on the last endpoint and backend added
+type ServiceBuilder struct {
+	cfg  ServiceConfig
+	errs ValidationErrors
+}
+
+// NewServiceConfig returns a builder of a version 1 service config
+func NewServiceConfig() *ServiceBuilder {
+	return &ServiceBuilder{cfg: ServiceConfig{Version: 1}}
+}
+
+// Name sets the name of the gateway
+func (b *ServiceBuilder) Name(name string) *ServiceBuilder {
+	b.cfg.Name = name
+	return b
+}
+
+// Port sets the port of the service
+func (b *ServiceBuilder) Port(port int) *ServiceBuilder {
+	b.cfg.Port = port
+	return b
+}
+
+// Host sets the default hosts of the backends
+func (b *ServiceBuilder) Host(hosts ...string) *ServiceBuilder {
+	b.cfg.Host = hosts
+	return b
+}
+
+// Timeout sets the default timeout of the endpoints
+func (b *ServiceBuilder) Timeout(timeout time.Duration) *ServiceBuilder {
+	b.cfg.Timeout = timeout
+	return b
+}
+
+// CacheTTL sets the default cache TTL of the endpoints
+func (b *ServiceBuilder) CacheTTL(ttl time.Duration) *ServiceBuilder {
+	b.cfg.CacheTTL = ttl
+	return b
+}
+
+// With applies the function to the service config, for the settings without a builder method
+func (b *ServiceBuilder) With(f func(*ServiceConfig)) *ServiceBuilder {
+	f(&b.cfg)
+	return b
+}
+
+// AddEndpoint adds an endpoint answering the method on the path
+func (b *ServiceBuilder) AddEndpoint(method, path string) *ServiceBuilder {
+	b.cfg.Endpoints = append(b.cfg.Endpoints, &EndpointConfig{Method: method, Endpoint: path})
+	return b
+}
+
+// WithEndpoint applies the function to the last endpoint added
+func (b *ServiceBuilder) WithEndpoint(f func(*EndpointConfig)) *ServiceBuilder {
+	if e := b.lastEndpoint(); e != nil {
+		f(e)
+	}
+	return b
+}
+
+// AddBackend adds a backend to the last endpoint added. The backend uses the default hosts of the
+// service if no hosts are received
+func (b *ServiceBuilder) AddBackend(urlPattern string, hosts ...string) *ServiceBuilder {
+	if e := b.lastEndpoint(); e != nil {
+		e.Backend = append(e.Backend, &Backend{URLPattern: urlPattern, Host: hosts})
+	}
+	return b
+}
+
+// WithBackend applies the function to the last backend added
+func (b *ServiceBuilder) WithBackend(f func(*Backend)) *ServiceBuilder {
+	e := b.lastEndpoint()
+	if e == nil {
+		return b
+	}
+	if len(e.Backend) == 0 {
+		b.errs = append(b.errs, errNoBackend)
+		return b
+	}
+	f(e.Backend[len(e.Backend)-1])
+	return b
+}
+
+// Build initializes and validates a copy of the config, returning the misuses of the builder along with
+// the problems found by Validate. The builder can be changed and built again, as Init never modifies
+// its endpoints nor its backends
+func (b *ServiceBuilder) Build() (ServiceConfig, error) {
+	if len(b.errs) > 0 {
+		return ServiceConfig{}, b.errs
+	}
+	cfg := b.cfg
+	cfg.Endpoints = make([]*EndpointConfig, len(b.cfg.Endpoints))
+	for i, e := range b.cfg.Endpoints {
+		endpoint := *e
+		endpoint.Backend = copyBackends(e.Backend)
+		if e.Canary != nil {
+			canary := *e.Canary
+			canary.Backend = copyBackends(e.Canary.Backend)
+			endpoint.Canary = &canary
+		}
+		cfg.Endpoints[i] = &endpoint
+	}
+	if err := cfg.Init(); err != nil {
+		return ServiceConfig{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return ServiceConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (b *ServiceBuilder) lastEndpoint() *EndpointConfig {
+	if len(b.cfg.Endpoints) == 0 {
+		b.errs = append(b.errs, errNoEndpoint)
+		return nil
+	}
+	return b.cfg.Endpoints[len(b.cfg.Endpoints)-1]
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceBuilder(t *testing.T) {
+	cfg, err := NewServiceConfig().
+		Name("embedded").
+		Host("127.0.0.1:8080").
+		Timeout(time.Second).
+		AddEndpoint(GET, "/users/:id").
+		WithEndpoint(func(e *EndpointConfig) { e.CacheTTL = time.Minute }).
+		AddBackend("/users/{{.Id}}").
+		AddBackend("/profiles/{{.Id}}", "http://profiles:8000").
+		WithBackend(func(b *Backend) { b.Group = "profile" }).
+		AddEndpoint(POST, "/users").
+		AddBackend("/users").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != defaultPort || len(cfg.Endpoints) != 2 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	users := cfg.Endpoints[0]
+	if users.CacheTTL != time.Minute || users.Timeout != time.Second {
+		t.Errorf("unexpected endpoint: %+v", users)
+	}
+	if users.Backend[0].Host[0] != "http://127.0.0.1:8080" || users.Backend[1].Group != "profile" {
+		t.Errorf("unexpected backends: %+v %+v", users.Backend[0], users.Backend[1])
+	}
+}
+
+func TestServiceBuilder_buildTwice(t *testing.T) {
+	b := NewServiceConfig().
+		Host("127.0.0.1:8080").
+		Timeout(time.Second).
+		With(func(s *ServiceConfig) { s.BasePath = "/api" }).
+		AddEndpoint(GET, "/users/{id}").
+		AddBackend("/users/{id}")
+
+	first, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range []ServiceConfig{first, second} {
+		if e := cfg.Endpoints[0]; e.Endpoint != "/api/users/:id" || e.Backend[0].URLPattern != "/users/{{.Id}}" {
+			t.Errorf("unexpected endpoint: %s -> %s", e.Endpoint, e.Backend[0].URLPattern)
+		}
+	}
+	if first.Endpoints[0] == second.Endpoints[0] || first.Endpoints[0].Backend[0] == second.Endpoints[0].Backend[0] {
+		t.Error("the built configs share their endpoints")
+	}
+
+	third, err := b.AddEndpoint(GET, "/orders").AddBackend("/orders").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Endpoints) != 1 || len(third.Endpoints) != 2 || third.Endpoints[1].Endpoint != "/api/orders" {
+		t.Errorf("unexpected endpoints: %d %d", len(first.Endpoints), len(third.Endpoints))
+	}
+}
+
+func TestServiceBuilder_errors(t *testing.T) {
+	if _, err := NewServiceConfig().AddBackend("/a").Build(); err == nil || !strings.Contains(err.Error(), "no endpoint") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewServiceConfig().AddEndpoint(GET, "/a").WithBackend(func(*Backend) {}).Build(); err == nil || !strings.Contains(err.Error(), "no backend") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err := NewServiceConfig().AddEndpoint(GET, "/a").AddBackend("/a").Build()
+	if err == nil || !strings.Contains(err.Error(), "has no hosts") {
+		t.Errorf("the config should be validated: %v", err)
+	}
+}
@@ -4,16 +4,26 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/net/idna"
+
 	"github.com/ph0m1/porta/encoding"
+	"github.com/ph0m1/porta/encoding/protobuf"
 )
 
 const (
 	BracketsRouterPatternBuilder = iota
 	ColonRouterPatternBuilder
+	// ServeMuxRouterPatternBuilder keeps the {name} params and registers the catch-all ones as
+	// {name...}, the patterns of the http.ServeMux
+	ServeMuxRouterPatternBuilder
 )
 
 const (
@@ -24,14 +34,25 @@ const (
 	NONE   string = ""
 )
 
+// Formats of the request validation errors
+const (
+	ErrorFormatProblem   = "problem"
+	ErrorFormatPlainText = "plaintext"
+)
+
 var RoutingPattern = ColonRouterPatternBuilder
 
 type HTTPMethod string
 
-// ServiceConfig defines the service
+// ServiceConfig defines the service. The durations, like the timeouts and the TTLs, accept the go
+// duration strings, like "300ms", "2s" or "1m". The bare numbers are read as milliseconds
 type ServiceConfig struct {
+	// name of the gateway, used to identify it in federated topologies
+	Name string `mapstructure:"name"`
 	// set of endpoint definitions
 	Endpoints []*EndpointConfig `mapstructure:"endpoints"`
+	// path prefix of all the endpoints, like /api/v1. The backend urls are not affected by it
+	BasePath string `mapstructure:"base_path"`
 	// default timeout
 	Timeout time.Duration `mapstructure:"timeout"`
 	// default TTL for GET
@@ -40,21 +61,542 @@ type ServiceConfig struct {
 	Host []string `mapstructure:"host"`
 	// port to bind service
 	Port int `mapstructure:"port"`
+	// addresses to listen on. The service listens on the port if empty
+	Listeners []Listener `mapstructure:"listeners"`
+	// address of the service and redirection of the plain HTTP requests. The service listens on the
+	// port if nil
+	Listen *Listen `mapstructure:"listen"`
+	// protocols sharing the port of the service, told apart by the first bytes of their connections.
+	// Every connection is served by the HTTP server if nil
+	Multiplex *Multiplex `mapstructure:"multiplex"`
 	// version code of the configuration
 	Version int `mapstructure:"version"`
+	// extra configuration for customized behaviours
+	ExtraConfig ExtraConfig `mapstructure:"extra_config"`
+	// TLS settings. The service is served over plain HTTP if nil
+	TLS *TLS `mapstructure:"tls"`
+	// time given to the in-flight requests to finish when the service is shutting down
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+	// maximum duration for reading the entire request, including the body
+	ReadTimeout time.Duration `mapstructure:"read_timeout"`
+	// amount of time allowed to read the request headers
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	// maximum duration before timing out writes of the response
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// maximum amount of time to wait for the next request when keep-alives are enabled
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	// maximum number of bytes the server will read parsing the request headers
+	MaxHeaderBytes int `mapstructure:"max_header_bytes"`
+	// default cost attribution tags of the endpoints
+	CostAttribution *CostAttribution `mapstructure:"cost_attribution"`
+	// HTTP/2 settings of the server. HTTP/2 is enabled over TLS with the go defaults if nil
+	HTTP2 *HTTP2 `mapstructure:"http2"`
+	// metrics settings of the gateway. The metrics use the default backend of the binary if nil
+	Metrics *Metrics `mapstructure:"metrics"`
+	// name of the profile adjusting the defaults for the environment, like dev or prod. The
+	// PORTA_PROFILE environment variable is used if empty
+	Profile string `mapstructure:"profile"`
+	// level of the logs of the gateway (DEBUG, INFO, WARNING, ERROR or CRITICAL)
+	LogLevel string `mapstructure:"log_level"`
+	// fraction of the successful requests written to the access log. All of them are logged if zero
+	LogSampling float64 `mapstructure:"log_sampling"`
+	// repeated warnings of the backend calls written per interval. All of them are written if nil
+	LogRateLimit *LogRateLimit `mapstructure:"log_rate_limit"`
+	// syslog server receiving the logs of the gateway. They are written to the standard output if nil
+	Syslog *Syslog `mapstructure:"syslog"`
+	// CORS policy of the service. The cross origin requests are not answered with CORS headers if nil
+	CORS *CORS `mapstructure:"cors"`
+	// add the default security headers, like HSTS or X-Frame-Options, to all the responses
+	SecurityHeaders bool `mapstructure:"security_headers"`
+	// format of the request validation errors: problem, the RFC 9457 problem+json documents, or
+	// plaintext, the legacy text bodies. problem if empty
+	ErrorFormat string `mapstructure:"error_format"`
+	// directories of static assets served by the gateway, like the docs of a developer portal
+	Static []*StaticAssets `mapstructure:"static"`
+	// reusable backend definitions, referenced by their name from the template of the backends
+	BackendTemplates map[string]*Backend `mapstructure:"backend_templates"`
+	// settings of the admin endpoints, like the route debugger. They are not registered if nil
+	Admin *Admin `mapstructure:"admin"`
+	// reachability probe of the backend hosts run when the gateway starts. The hosts are not probed
+	// if nil
+	StartupProbe *StartupProbe `mapstructure:"startup_probe"`
+	// periodic health checks of the backend hosts. The balancers skip the unhealthy hosts. The hosts
+	// are not checked if nil
+	HostChecks *HostChecks `mapstructure:"host_checks"`
+	// requests sent periodically to the gateway itself to measure its end-to-end latency
+	SyntheticProbes []*SyntheticProbe `mapstructure:"synthetic_probes"`
+	// headers describing the rate limit key, the auth method and the backend hosts of the requests,
+	// for the support engineers. They are never sent if nil
+	DebugHeaders *DebugHeaders `mapstructure:"debug_headers"`
+	// default journal of the endpoints declaring one without a path of their own
+	Journal *Journal `mapstructure:"journal"`
+	// burn rate alerts of the SLOs of the endpoints. The SLOs are tracked but no alert is sent if nil
+	SLOAlerts *SLOAlerts `mapstructure:"slo_alerts"`
 
 	// run in Debug Mode
 	Debug bool
 }
 
+// Listener defines an address where the service accepts connections
+type Listener struct {
+	// address to listen on, like :8080, 127.0.0.1:8443, unix:/var/run/porta.sock or systemd:<socket name>
+	Address string `mapstructure:"address"`
+	// serve HTTPS on this listener with the TLS settings of the service
+	TLS bool `mapstructure:"tls"`
+}
+
+// Listen defines where the service accepts the connections
+type Listen struct {
+	// address of the service, like :8443 or 127.0.0.1:8080. It replaces the port
+	Address string `mapstructure:"address"`
+	// address of a plain HTTP server redirecting all the requests to the HTTPS service, like :80
+	RedirectHTTP string `mapstructure:"redirect_http"`
+}
+
+// Protocols of the multiplexed connections
+const (
+	ProtocolHTTP  = "http"
+	ProtocolGRPC  = "grpc"
+	ProtocolAdmin = "admin"
+)
+
+// Multiplex defines how the connections of the port of the service are split between the HTTP
+// endpoints, a gRPC server and the admin API. It requires plain connections, so it can not be
+// combined with the tls settings or the listeners
+type Multiplex struct {
+	// address of the gRPC server receiving the HTTP/2 connections with an application/grpc content type.
+	// The gRPC connections are not told apart if empty
+	GRPC string `mapstructure:"grpc"`
+	// path prefix of the first request of the connections of the admin API. /__ if empty. The admin
+	// connections are not told apart if the admin settings are missing
+	AdminPrefix string `mapstructure:"admin_prefix"`
+	// order in which the protocols are shut down, each one with the grace period of the service. The
+	// missing protocols are shut down last. http, grpc, admin if empty
+	ShutdownOrder []string `mapstructure:"shutdown_order"`
+}
+
+// CORS defines the cross origin requests accepted by the service
+type CORS struct {
+	// origins allowed to call the service. "*" allows any origin and "*.example.com" its subdomains
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
+	AllowedMethods []string `mapstructure:"allowed_methods"`
+	AllowedHeaders []string `mapstructure:"allowed_headers"`
+	ExposedHeaders []string `mapstructure:"exposed_headers"`
+	// allow the requests with credentials, like cookies
+	AllowCredentials bool `mapstructure:"allow_credentials"`
+	// time the preflight responses can be cached by the clients
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
+
+// StaticAssets defines a directory served under a path prefix. The files with a precompressed .br or
+// .gz sibling are served compressed to the clients accepting the encoding
+type StaticAssets struct {
+	// path prefix of the assets, like /docs/
+	Prefix string `mapstructure:"prefix"`
+	// directory holding the assets
+	Dir string `mapstructure:"dir"`
+	// time the clients can cache the assets. They must be revalidated on every use if zero
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
+
+// Admin defines the access to the admin endpoints of the gateway
+type Admin struct {
+	// bearer token required by the admin endpoints
+	Token string `mapstructure:"token"`
+	// body of the 503 responses of the endpoints disabled through the admin endpoint. It is sent as
+	// JSON if valid, as plain text otherwise
+	DisabledBody string `mapstructure:"disabled_body"`
+	// serve the pprof profiles under /debug/pprof/ and the expvar variables under /debug/vars
+	Profiling bool `mapstructure:"profiling"`
+	// address of a separate listener of the profiling endpoints, like 127.0.0.1:6060, so they are not
+	// reachable through the port of the service. They are served on the port of the service if empty
+	ProfilingAddress string `mapstructure:"profiling_address"`
+}
+
+// StartupProbe defines how the backend hosts are probed at boot: DNS resolution, TCP connection, TLS
+// handshake for the https hosts and, optionally, a GET of a health path
+type StartupProbe struct {
+	// time given to the probe of every host. 5s if zero
+	Timeout time.Duration `mapstructure:"timeout"`
+	// path requested to every host, like /health. Only the connection is checked if empty
+	HealthPath string `mapstructure:"health_path"`
+	// refuse to start if any host is unreachable
+	Strict bool `mapstructure:"strict"`
+}
+
+// HostChecks defines the health checks of the backend hosts run by the health checker of the gateway
+type HostChecks struct {
+	// path requested to every host. A 2xx response passes the check. /__health if empty
+	Path string `mapstructure:"path"`
+	// failed checks in a row marking a host as unhealthy. 3 if zero
+	UnhealthyThreshold int `mapstructure:"unhealthy_threshold"`
+	// passed checks in a row marking an unhealthy host as healthy again. 2 if zero
+	HealthyThreshold int `mapstructure:"healthy_threshold"`
+}
+
+func (h *HostChecks) init() {
+	if h.Path == "" {
+		h.Path = "/__health"
+	}
+	if h.UnhealthyThreshold <= 0 {
+		h.UnhealthyThreshold = 3
+	}
+	if h.HealthyThreshold <= 0 {
+		h.HealthyThreshold = 2
+	}
+}
+
+// LogRateLimit defines how many identical warnings are written per interval. The rest are counted and
+// summarized in the next warning written
+type LogRateLimit struct {
+	// identical warnings written per interval. 10 if zero
+	Burst int `mapstructure:"burst"`
+	// 1m if zero
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+func (l *LogRateLimit) init() {
+	if l.Burst <= 0 {
+		l.Burst = 10
+	}
+	if l.Interval <= 0 {
+		l.Interval = time.Minute
+	}
+}
+
+// Syslog defines where the logs are sent as RFC 5424 syslog messages
+type Syslog struct {
+	// udp, tcp or tls to send the logs to a remote server. The local syslog daemon receives them if empty
+	Network string `mapstructure:"network"`
+	// address of the remote server, like logs.example.com:514, or path of the socket of the local
+	// daemon. /dev/log if empty for the local daemon
+	Address string `mapstructure:"address"`
+	// facility of the messages, from kern to local7. local0 if empty
+	Facility string `mapstructure:"facility"`
+	// app name of the messages. The name of the service if empty
+	Tag string `mapstructure:"tag"`
+}
+
+func (s *Syslog) init(name string) error {
+	switch s.Network {
+	case "":
+		if s.Address == "" {
+			s.Address = "/dev/log"
+		}
+	case "udp", "tcp", "tls":
+		if s.Address == "" {
+			return fmt.Errorf("the %s syslog requires the address of the server", s.Network)
+		}
+	default:
+		return fmt.Errorf("unknown syslog network: %s", s.Network)
+	}
+	if s.Facility == "" {
+		s.Facility = "local0"
+	}
+	if s.Tag == "" {
+		s.Tag = name
+	}
+	return nil
+}
+
+// Formats of the payloads of the SLO webhooks
+const (
+	SLOWebhookGeneric   = "generic"
+	SLOWebhookSlack     = "slack"
+	SLOWebhookPagerDuty = "pagerduty"
+)
+
+// SLO defines the objectives of an endpoint, as the percentage of the requests that must be good
+type SLO struct {
+	// percent of the requests answered without a 5xx status, like 99.9. Not tracked if zero
+	Availability float64 `mapstructure:"availability"`
+	// percent of the requests answered within the latency threshold, like 99. Not tracked if zero
+	LatencyTarget float64 `mapstructure:"latency_target"`
+	// latency threshold of the latency objective, like 300ms
+	Latency time.Duration `mapstructure:"latency"`
+}
+
+func (s *SLO) init() error {
+	if s.Availability < 0 || s.Availability >= 100 || s.LatencyTarget < 0 || s.LatencyTarget >= 100 {
+		return errors.New("the SLO targets must be percentages between 0 and 100, like 99.9")
+	}
+	if s.Availability == 0 && s.LatencyTarget == 0 {
+		return errors.New("the SLO requires an availability or a latency target")
+	}
+	if s.LatencyTarget > 0 && s.Latency <= 0 {
+		return errors.New("the latency target of the SLO requires a latency threshold")
+	}
+	return nil
+}
+
+// SLOAlerts defines when the burn of the error budgets of the SLOs is alerted and who is notified. The
+// burn rate is the ratio of bad requests divided by the one allowed by the objective: a burn rate of 1
+// spends the budget exactly by the end of the SLO period
+type SLOAlerts struct {
+	// burn rate rules. An alert fires while any of them is exceeded. If empty, a fast burn of 14.4 over
+	// 1h and 5m and a slow burn of 6 over 6h and 30m
+	Rules []*BurnRateRule `mapstructure:"rules"`
+	// time between the evaluations of the rules. 1m if zero
+	Interval time.Duration `mapstructure:"interval"`
+	// receivers of the fired and resolved alerts
+	Webhooks []*SLOWebhook `mapstructure:"webhooks"`
+}
+
+// BurnRateRule fires when the burn rate exceeds its threshold over both windows. The long window gives
+// the significance and the short one resolves the alert quickly once the burn stops
+type BurnRateRule struct {
+	// name of the rule in the alerts, like page or ticket
+	Name     string        `mapstructure:"name"`
+	BurnRate float64       `mapstructure:"burn_rate"`
+	Window   time.Duration `mapstructure:"window"`
+	// the window divided by 12 if zero
+	ShortWindow time.Duration `mapstructure:"short_window"`
+}
+
+// SLOWebhook defines an HTTP receiver of the SLO alerts
+type SLOWebhook struct {
+	URL string `mapstructure:"url"`
+	// format of the payload: generic, the JSON of the alert, slack, an incoming webhook message, or
+	// pagerduty, an event of the Events API v2. generic if empty
+	Format string `mapstructure:"format"`
+	// integration key of the pagerduty events
+	RoutingKey string            `mapstructure:"routing_key"`
+	Headers    map[string]string `mapstructure:"headers"`
+}
+
+// DefaultBurnRateRules are the burn rate rules of the SLO alerts declaring none: a budget of 30 days
+// burning 2% in an hour or 5% in six hours
+var DefaultBurnRateRules = []BurnRateRule{
+	{Name: "fast_burn", BurnRate: 14.4, Window: time.Hour, ShortWindow: 5 * time.Minute},
+	{Name: "slow_burn", BurnRate: 6, Window: 6 * time.Hour, ShortWindow: 30 * time.Minute},
+}
+
+func (a *SLOAlerts) init() error {
+	if len(a.Rules) == 0 {
+		for _, r := range DefaultBurnRateRules {
+			r := r
+			a.Rules = append(a.Rules, &r)
+		}
+	}
+	if a.Interval <= 0 {
+		a.Interval = time.Minute
+	}
+	for i, r := range a.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule_%d", i)
+		}
+		if r.BurnRate <= 0 || r.Window <= 0 {
+			return fmt.Errorf("the burn rate rule [%s] requires a burn rate and a window", r.Name)
+		}
+		if r.ShortWindow <= 0 {
+			r.ShortWindow = r.Window / 12
+		}
+		if r.ShortWindow > r.Window {
+			return fmt.Errorf("the short window of the burn rate rule [%s] is longer than its window", r.Name)
+		}
+	}
+	for _, w := range a.Webhooks {
+		if w.Format == "" {
+			w.Format = SLOWebhookGeneric
+		}
+		switch w.Format {
+		case SLOWebhookGeneric, SLOWebhookSlack:
+			if w.URL == "" {
+				return errors.New("the SLO webhooks require an url")
+			}
+		case SLOWebhookPagerDuty:
+			if w.RoutingKey == "" {
+				return errors.New("the pagerduty SLO webhooks require a routing key")
+			}
+			if w.URL == "" {
+				w.URL = "https://events.pagerduty.com/v2/enqueue"
+			}
+		default:
+			return fmt.Errorf("unknown format of SLO webhook: %s", w.Format)
+		}
+	}
+	return nil
+}
+
+// DebugHeaders defines which responses get the decision headers
+type DebugHeaders struct {
+	// role of the authenticated clients getting the headers
+	Role string `mapstructure:"role"`
+	// send the headers to any client sending the X-Porta-Debug header. Not intended for production
+	AllowFlag bool `mapstructure:"allow_flag"`
+}
+
+// SyntheticProbe defines a request sent periodically through the loopback interface to an endpoint of
+// the gateway, so the latency added by its middlewares is measured even without real traffic
+type SyntheticProbe struct {
+	// name of the probe in the metrics. Unique among the probes
+	Name   string `mapstructure:"name"`
+	Method string `mapstructure:"method"`
+	// path and query of the request, like /users/1?fields=name
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+	Body    string            `mapstructure:"body"`
+	// time between the requests. 1m if zero
+	Interval time.Duration `mapstructure:"interval"`
+	// timeout of the requests. 5s if zero
+	Timeout time.Duration `mapstructure:"timeout"`
+	// status expected in the responses. 200 if zero
+	ExpectedStatus int `mapstructure:"expected_status"`
+	// max latency of the successful requests. The slow responses are counted apart. No limit if zero
+	LatencySLO time.Duration `mapstructure:"latency_slo"`
+}
+
+func (p *SyntheticProbe) init() error {
+	if p.Name == "" {
+		return errors.New("the synthetic probes require a name")
+	}
+	if !strings.HasPrefix(p.URL, "/") {
+		return fmt.Errorf("the url of the synthetic probe [%s] must be a path of the gateway", p.Name)
+	}
+	if p.Method == "" {
+		p.Method = "GET"
+	}
+	p.Method = strings.ToUpper(p.Method)
+	if p.Interval == 0 {
+		p.Interval = time.Minute
+	}
+	if p.Timeout == 0 {
+		p.Timeout = 5 * time.Second
+	}
+	if p.ExpectedStatus == 0 {
+		p.ExpectedStatus = 200
+	}
+	return nil
+}
+
+// Metrics defines where the gateway exports its metrics
+type Metrics struct {
+	// name of the metrics backend: prometheus, expvar, statsd or noop. prometheus if empty, or expvar in the
+	// binaries built with the noprometheus tag, which do not link the prometheus client
+	Backend string `mapstructure:"backend"`
+	// StatsD server the metrics are pushed to. Required by the statsd backend. With the prometheus and
+	// the expvar backends the metrics are pushed to it as well
+	StatsD *StatsD `mapstructure:"statsd"`
+}
+
+// Flavors of the StatsD protocol
+const (
+	StatsDFlavorDog   = "dogstatsd"
+	StatsDFlavorPlain = "statsd"
+)
+
+// StatsD defines the StatsD server receiving the metrics
+type StatsD struct {
+	// host:port of the UDP listener of the server
+	Address string `mapstructure:"address"`
+	// prepended to the names of the metrics, like porta.
+	Prefix string `mapstructure:"prefix"`
+	// dogstatsd (default) sends the labels as tags. statsd appends their values to the names, for the
+	// servers without tags
+	Flavor string `mapstructure:"flavor"`
+	// tags added to all the metrics, like env:prod. Ignored by the statsd flavor
+	Tags []string `mapstructure:"tags"`
+	// max time the metrics wait to be sent. 1s if zero
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// max size of the UDP packets. 1432 if zero, fitting the usual MTU
+	MaxPacketSize int `mapstructure:"max_packet_size"`
+}
+
+func (m *Metrics) init() error {
+	if m.Backend == "statsd" && m.StatsD == nil {
+		return errors.New("the statsd metrics backend requires the statsd config")
+	}
+	if m.StatsD == nil {
+		return nil
+	}
+	if m.StatsD.Address == "" {
+		return errors.New("the statsd metrics require an address")
+	}
+	switch m.StatsD.Flavor {
+	case "":
+		m.StatsD.Flavor = StatsDFlavorDog
+	case StatsDFlavorDog, StatsDFlavorPlain:
+	default:
+		return fmt.Errorf("unknown statsd flavor %q", m.StatsD.Flavor)
+	}
+	if m.StatsD.FlushInterval == 0 {
+		m.StatsD.FlushInterval = time.Second
+	}
+	if m.StatsD.MaxPacketSize == 0 {
+		m.StatsD.MaxPacketSize = 1432
+	}
+	return nil
+}
+
+// HTTP2 defines how the service speaks HTTP/2
+type HTTP2 struct {
+	// serve only HTTP/1.1, even over TLS
+	Disabled bool `mapstructure:"disabled"`
+	// accept HTTP/2 over cleartext connections (h2c), for the internal traffic
+	H2C bool `mapstructure:"h2c"`
+	// max number of concurrent streams per connection. The go default if zero
+	MaxConcurrentStreams uint32 `mapstructure:"max_concurrent_streams"`
+}
+
+// TLS defines the certificates and the protocol settings used to serve HTTPS
+type TLS struct {
+	// path to the PEM encoded certificate
+	CertFile string `mapstructure:"cert_file"`
+	// path to the PEM encoded private key
+	KeyFile string `mapstructure:"key_file"`
+	// minimum TLS version accepted (TLS10, TLS11, TLS12 or TLS13)
+	MinVersion string `mapstructure:"min_version"`
+	// names of the cipher suites to enable. The go defaults are used if empty
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// path to the CA bundle used to verify the client certificates (mTLS)
+	ClientCA string `mapstructure:"client_ca"`
+	// accept the connections without a client certificate, leaving the check to the mtls middleware of
+	// the endpoints requiring them
+	ClientCertOptional bool `mapstructure:"client_cert_optional"`
+	// obtain and renew the certificates through ACME instead of loading the cert and key files
+	AutoCert *AutoCert `mapstructure:"autocert"`
+	// Strict-Transport-Security policy sent with the HTTPS responses. The header is not sent if nil
+	HSTS *HSTS `mapstructure:"hsts"`
+}
+
+// HSTS defines the Strict-Transport-Security header
+type HSTS struct {
+	// time the clients must use only HTTPS to reach the host
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// apply the policy to the subdomains too
+	IncludeSubdomains bool `mapstructure:"include_subdomains"`
+	// allow the host to be included in the preload lists of the browsers
+	Preload bool `mapstructure:"preload"`
+}
+
+// AutoCert defines how the certificates are obtained from an ACME CA, like Let's Encrypt
+type AutoCert struct {
+	// hosts allowed to get a certificate
+	Domains []string `mapstructure:"domains"`
+	// contact address of the account, notified about the problems with the certificates
+	Email string `mapstructure:"email"`
+	// directory storing the accounts and the certificates between restarts
+	CacheDir string `mapstructure:"cache_dir"`
+	// directory endpoint of the CA. Let's Encrypt production if empty
+	DirectoryURL string `mapstructure:"directory_url"`
+	// address of the plain HTTP server answering the HTTP-01 challenges and redirecting the rest of the
+	// requests to HTTPS, like :80. The challenges are answered only by the service listeners if empty
+	ChallengeAddress string `mapstructure:"challenge_address"`
+}
+
 // EndpointConfig defines the configuration of a single endpoint to be exposed by service
 type EndpointConfig struct {
-	// url pattern to be registered and exposed to the world
+	// url pattern to be registered and exposed to the world. A trailing /*name segment catches the rest
+	// of the path, which is appended to the url pattern of the backends not using the {name} param
 	Endpoint string `mapstructure:"endpoint"`
 	// HTTP method of the endpoint (GET, POST, PUT, etc)
 	Method string `mapstructure:"method"`
+	// set of HTTP methods served by the endpoint. The endpoint is registered once per method
+	Methods []string `mapstructure:"methods"`
 	// set of definitions of the backends to be linked to this endpoint
 	Backend []*Backend `mapstructure:"backend"`
+	// backends replacing the default ones for the listed methods
+	BackendOverrides map[string][]*Backend `mapstructure:"backend_overrides"`
 	// number of concurrent calls this endpoint must send to the backends
 	ConcurrentCalls int `mapstructure:"concurrent_calls"`
 	// timeout of this endpoint
@@ -63,10 +605,159 @@ type EndpointConfig struct {
 	CacheTTL time.Duration `mapstructure:"cache_ttl"`
 	// list of query string params to be extracted from the URI
 	QueryString []string `mapstructure:"querystring_params"`
+	// types of the query string params, validated and coerced before reaching the backends. The typed
+	// params are added to the querystring_params
+	QueryTypes map[string]*QueryParam `mapstructure:"querystring_types"`
+	// list of headers to be passed from the client request to the backends
+	HeadersToPass []string `mapstructure:"headers_to_pass"`
+	// headers passed from the client request only to the federated backends of the endpoint
+	FederatedHeaders []string
+	// encoding of the responses sent to the clients (json, xml, yaml, string or no-op), independent of
+	// the encoding of the backends. json if empty
+	OutputEncoding string `mapstructure:"output_encoding"`
+	// settings of the gateway cache of the responses. The responses are not cached by the gateway if nil
+	WarmCache *WarmCache `mapstructure:"warm_cache"`
+	// response served when all the backends fail. The errors are returned to the client if nil
+	Fallback *Fallback `mapstructure:"fallback"`
+	// asynchronous mode of the endpoint. The clients wait for the backends if nil
+	Async *Async `mapstructure:"async"`
+	// backends receiving a copy of the traffic of the endpoint. No traffic is mirrored if nil
+	Canary *Canary `mapstructure:"canary"`
+	// sampling of the backend responses to detect changes of their contract. Disabled if nil
+	ContractDrift *ContractDrift `mapstructure:"contract_drift"`
+	// caching hints for the CDN in front of the gateway. No hints are sent if nil
+	EdgeCache *EdgeCache `mapstructure:"edge_cache"`
+	// tags added to the backend requests to attribute their load. The service ones are used if nil
+	CostAttribution *CostAttribution `mapstructure:"cost_attribution"`
+	// alternative shapes of the response selected per request. The response is not reshaped if nil
+	ResponseVariants *ResponseVariants `mapstructure:"response_variants"`
+	// opt-in for the non GET endpoints sending the request to several backends. Only the GET endpoints
+	// can have several backends if nil
+	WriteFanOut *WriteFanOut `mapstructure:"write_fan_out"`
+	// timeout and cache overrides accepted from the trusted clients. The override headers are ignored
+	// if nil
+	TrustedOverrides *TrustedOverrides `mapstructure:"trusted_overrides"`
+	// wrap the response in a {"data", "meta", "errors"} document, with the latency, the backends and the
+	// request id as metadata and the failures of the backends as errors
+	ResponseEnvelope bool `mapstructure:"response_envelope"`
+	// record the write requests in an append-only journal before proxying them. The requests are not
+	// recorded if nil
+	Journal *Journal `mapstructure:"journal"`
+	// availability and latency objectives of the endpoint. The endpoint has no SLO if nil
+	SLO *SLO `mapstructure:"slo"`
+	// extra configuration for customized behaviours
+	ExtraConfig ExtraConfig `mapstructure:"extra_config"`
+}
+
+// WarmCache defines how the gateway caches the responses of an endpoint during its cache TTL, refreshing
+// the popular ones before they expire
+type WarmCache struct {
+	// number of hits required to refresh an entry before it expires
+	MinHits int `mapstructure:"min_hits"`
+	// time before the expiration when the popular entries are refreshed
+	RefreshBefore time.Duration `mapstructure:"refresh_before"`
+	// max number of entries to keep
+	MaxKeys int `mapstructure:"max_keys"`
+}
+
+// Fallback defines the response to serve when the backends of an endpoint are not available
+type Fallback struct {
+	// static data of the response
+	Data map[string]interface{} `mapstructure:"data"`
+	// serve the last complete response for the same request, if any, instead of the static data
+	LastGood bool `mapstructure:"last_good"`
+}
+
+// ResponseVariants defines the alternative shapes of the response of an endpoint, selected by the value
+// of a request header or of a claim of the authenticated client, so new schemas can be tried with a
+// group of clients. The requests without a matching variant get the regular response
+type ResponseVariants struct {
+	// header whose value selects the variant. It is passed to the backends too
+	Header string `mapstructure:"header"`
+	// claim of the authenticated client selecting the variant when the header is not set: user_id,
+	// client_id or roles. The first role with a variant is selected
+	Claim string `mapstructure:"claim"`
+	// variants keyed by the value of the header or the claim
+	Variants map[string]ResponseVariant `mapstructure:"variants"`
+}
+
+// ResponseVariant reshapes the merged response of the endpoint like the backend settings with the same
+// names reshape the responses of the backends
+type ResponseVariant struct {
+	Target    string            `mapstructure:"target"`
+	Whitelist []string          `mapstructure:"whitelist"`
+	Blacklist []string          `mapstructure:"blacklist"`
+	Mapping   map[string]string `mapstructure:"mapping"`
+	Group     string            `mapstructure:"group"`
+}
+
+// Async defines how an endpoint answers before its backends do, letting the clients poll the result
+type Async struct {
+	// time the results are kept once the backends answer
+	TTL time.Duration `mapstructure:"ttl"`
+	// max requests of the endpoint running in the background at once. The requests beyond it are
+	// rejected with a 503. 100 if zero
+	MaxPending int `mapstructure:"max_pending"`
+}
+
+// Canary defines the backends receiving a copy of the requests of an endpoint. Their responses are
+// discarded, once compared with the ones of the primary backends if the comparison is enabled
+type Canary struct {
+	// set of definitions of the canary backends
+	Backend []*Backend `mapstructure:"backend"`
+	// compare the responses of the canary with the primary ones
+	Compare bool `mapstructure:"compare"`
+	// max relative difference between two numbers considered equal, like 0.01 for 1%
+	Tolerance float64 `mapstructure:"tolerance"`
+	// paths of the fields excluded from the comparison, like "meta.timestamp"
+	Ignore []string `mapstructure:"ignore"`
+	// number of mismatches kept for inspection. 10 if zero
+	MaxSamples int `mapstructure:"max_samples"`
+	// ratio of the requests sent to the canary, from 0 to 1. 1 if zero
+	SampleRate float64 `mapstructure:"sample_rate"`
+	// send a copy of the requests of the endpoints with unsafe methods, like POST or DELETE, as well.
+	// The canary backends must not share the storage of the primary ones, or the writes are applied
+	// twice. Only the GET, HEAD and OPTIONS endpoints are mirrored if false
+	MirrorUnsafeMethods bool `mapstructure:"mirror_unsafe_methods"`
 }
 
+// ContractDrift defines how the responses of the backends are compared with their expected shape
+type ContractDrift struct {
+	// min time between two samples of the same backend. One minute if zero
+	Interval time.Duration `mapstructure:"interval"`
+	// expected shape of the responses of the backends, keyed by their url_pattern. Every shape maps the
+	// path of the fields to their type, like "user.name": "string". The backends without schema use their
+	// first sampled response as the snapshot
+	Schemas map[string]map[string]string `mapstructure:"schemas"`
+}
+
+// EdgeCache defines how the CDNs should cache the responses of an endpoint
+type EdgeCache struct {
+	// time the shared caches may store the response (s-maxage)
+	TTL time.Duration `mapstructure:"ttl"`
+	// time the shared caches may serve a stale response while they revalidate it
+	StaleWhileRevalidate time.Duration `mapstructure:"stale_while_revalidate"`
+	// keys tagging the response for purging it. They accept the params of the endpoint, like "user-{id}"
+	SurrogateKeys []string `mapstructure:"surrogate_keys"`
+	// request headers the CDN should add to the cache key
+	Vary []string `mapstructure:"vary"`
+}
+
+// CostAttribution defines the tags identifying the consumers of the backends
+type CostAttribution struct {
+	// team owning the endpoint
+	Team string `mapstructure:"team"`
+	// header of the client request holding the plan of the consumer
+	PlanHeader string `mapstructure:"plan_header"`
+}
+
+// ExtraConfig is a set of namespaced settings for the components extending the gateway
+type ExtraConfig map[string]interface{}
+
 // Backend defines how to connect to the backend service and how to process the received response
 type Backend struct {
+	// name of the backend template of the service providing the settings not declared by the backend
+	Template string `mapstructure:"template"`
 	// the name of the group the response should be moved to
 	Group string `mapstructure:"group"`
 	// HTTP method of the request to send to the backend
@@ -83,60 +774,500 @@ type Backend struct {
 	Mapping map[string]string `mapstructure:"mapping"`
 	// the encoding format
 	Encoding string `mapstructure:"encoding"`
+	// the response has a JSON array at its root, like json-collection encoding
+	IsCollection bool `mapstructure:"is_collection"`
+	// key of the array of the collection responses. collection if empty
+	CollectionKey string `mapstructure:"collection_key"`
+	// descriptor set file describing the messages of the protobuf encoding, generated with
+	// protoc --include_imports --descriptor_set_out
+	ProtoDescriptor string `mapstructure:"proto_descriptor"`
+	// full name of the message of the protobuf responses, like acme.users.User
+	ProtoMessage string `mapstructure:"proto_message"`
+	// key of the body of the responses of the string encoding. content if empty
+	StringKey string `mapstructure:"string_key"`
 	// name of the field to extract to the root
 	Target string `mapstructure:"target"`
+	// circuit breaker settings. The circuit breaker is disabled if nil
+	CircuitBreaker *CircuitBreaker `mapstructure:"circuit_breaker"`
+	// the backend is another gateway, so the auth, request ID, deadline and Via headers are propagated
+	Federated bool `mapstructure:"federated"`
+	// limits of the headers sent to the backend, once all the gateway headers are added. The headers
+	// are not limited if nil
+	HeaderLimits *HeaderLimits `mapstructure:"header_limits"`
+	// how the gateway backs off when the backend answers 429 or 503. The backend is called as usual
+	// and its throttling is reported as a bad gateway if nil
+	Throttling *Throttling `mapstructure:"throttling"`
+	// extra configuration for customized behaviours, like the streaming decoding of the responses
+	ExtraConfig ExtraConfig `mapstructure:"extra_config"`
 
 	// list of keys to be replaced in the URLPattern
 	URLKeys []string
 	// number of concurrent calls this endpoint must send to the API
 	ConcurrentCalls int
-	// timeout of this backend
-	Timeout time.Duration
+	// timeout of this backend. The timeout of the endpoint if zero
+	Timeout time.Duration `mapstructure:"timeout"`
 	// decoder to use in order to parse the received response from the API
 	Decoder encoding.Decoder
+	// identifier of the gateway, used to detect loops between federated gateways
+	GatewayID string
+	// headers of the request captured for the federated backends of the endpoint, never sent to this one
+	HiddenHeaders []string
+}
+
+// CircuitBreaker defines when the circuit of a backend should be opened
+type CircuitBreaker struct {
+	// max number of errors allowed during the interval before opening the circuit
+	MaxErrors int `mapstructure:"max_errors"`
+	// size of the window counting the errors
+	Interval time.Duration `mapstructure:"interval"`
+	// time the circuit stays open before letting a trial request reach the backend
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Throttling defines the soft circuit opened when a backend asks the gateway to slow down with a 429 or
+// 503 response. The requests sent while the circuit is open fail without reaching the backend
+type Throttling struct {
+	// time the circuit stays open when the response has no Retry-After header. 1s if zero
+	DefaultBackoff time.Duration `mapstructure:"default_backoff"`
+	// max time the circuit stays open, whatever the Retry-After of the backend. 30s if zero
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// answer the clients with the status and the Retry-After of the backend instead of a 502
+	Propagate bool `mapstructure:"propagate"`
+}
+
+// TrustedOverrides defines the request headers the clients with a role can use to override the
+// timeout of the endpoint or to skip its caches, like the internal batch jobs or the debugging sessions
+type TrustedOverrides struct {
+	// role of the authenticated clients allowed to send the override headers
+	Role string `mapstructure:"role"`
+	// max timeout the clients can request. The timeout can not be overridden if zero
+	MaxTimeout time.Duration `mapstructure:"max_timeout"`
+	// allow the clients to skip the cached responses
+	CacheBypass bool `mapstructure:"cache_bypass"`
+}
+
+// Consistency modes of the write fan-outs
+const (
+	// WriteConsistencyAllOrError fails the request if any backend fails
+	WriteConsistencyAllOrError = "all_or_error"
+	// WriteConsistencyBestEffort answers with the responses of the backends that succeeded, failing
+	// only if all of them fail
+	WriteConsistencyBestEffort = "best_effort"
+)
+
+// Types of the query string params
+const (
+	QueryInt  = "int"
+	QueryBool = "bool"
+	QueryDate = "date"
+	QueryEnum = "enum"
+)
+
+// QueryParam defines the type of a query string param
+type QueryParam struct {
+	// int, bool, date or enum
+	Type string `mapstructure:"type"`
+	// accepted values of the enums, matched ignoring the case
+	Values []string `mapstructure:"values"`
+	// layout of the dates, as in the time package. 2006-01-02 if empty
+	Layout string `mapstructure:"layout"`
+	// reject the requests without the param
+	Required bool `mapstructure:"required"`
+}
+
+func (q *QueryParam) init() error {
+	switch q.Type {
+	case QueryInt, QueryBool:
+	case QueryDate:
+		if q.Layout == "" {
+			q.Layout = "2006-01-02"
+		}
+	case QueryEnum:
+		if len(q.Values) == 0 {
+			return errors.New("the enums require their values")
+		}
+	default:
+		return fmt.Errorf("unknown type %s", q.Type)
+	}
+	return nil
+}
+
+// JournalIdempotencyHeader is the header of the idempotency keys recorded in the journals
+const JournalIdempotencyHeader = "Idempotency-Key"
+
+// Journal defines the append-only file recording the write requests, so the writes lost during the
+// outages of the backends can be audited or replayed
+type Journal struct {
+	// path of the file. The journal of the service if empty
+	Path string `mapstructure:"path"`
+	// size in bytes rotating the file: it is renamed with a .1 suffix, replacing the previous one, and
+	// a new file is started. The one of the journal of the service if it shares its path, or 64MB if zero
+	MaxSize int64 `mapstructure:"max_size"`
+}
+
+// WriteFanOut defines how a non GET endpoint sends the request to all its backends. The request body
+// is sent to every backend and the writes are not transactional: the backends that succeeded are not
+// rolled back when others fail
+type WriteFanOut struct {
+	// all_or_error or best_effort. all_or_error if empty
+	Consistency string `mapstructure:"consistency"`
+}
+
+// Actions of the header limits when a backend request exceeds them
+const (
+	HeaderLimitReject = "reject"
+	HeaderLimitTrim   = "trim"
+)
+
+// HeaderLimits defines the max size of the headers of the backend requests, so the headers added by
+// the gateway do not trip the limits of the proxies in front of the backends
+type HeaderLimits struct {
+	// max number of header lines. Not limited if zero
+	MaxCount int `mapstructure:"max_count"`
+	// max size of the headers, counted as the name, the value and the line overhead of every line. Not
+	// limited if zero
+	MaxBytes int `mapstructure:"max_bytes"`
+	// reject the requests exceeding the limits or trim the headers not fitting in them. reject if empty
+	Action string `mapstructure:"action"`
 }
 
 var (
 	simpleURLKeysPattern   = regexp.MustCompile(`\{([a-zA-Z\-_0-9]+)\}`)
 	endpointURLKeysPattern = regexp.MustCompile(`/\{([a-zA-Z\-_0-9]+)\}`)
+	catchAllPattern        = regexp.MustCompile(`/\*([a-zA-Z\-_0-9]+)$`)
 	errInvalidHost         = errors.New("invalid host")
-	hostPattern            = regexp.MustCompile(`(https?://)?([a-zA-Z0-9\._\-]+)(:[0-9]{2,6})?/?`)
+	errTLSWithoutCerts     = errors.New("the tls config requires a cert_file and a key_file or an autocert")
+	errNoListenAddress     = errors.New("the listeners require an address")
+	errNoAutoCertDomains   = errors.New("the autocert requires the list of allowed domains")
+	errStaticWithoutDir    = errors.New("the static assets require a prefix and a dir")
+	errAdminWithoutToken   = errors.New("the admin endpoints require a token")
+	errRedirectWithoutTLS  = errors.New("the redirection to HTTPS requires the tls config")
+	errNegativeTimeout     = errors.New("the timeouts can not be negative")
+	errMultiplexWithTLS    = errors.New("the multiplexed port requires plain connections: remove the tls config or the listeners")
 	debugPattern           = "^[^/]|/__debug(/.*)?$"
 	defaultPort            = 8080
+	defaultGracePeriod     = 5 * time.Second
+	defaultName            = "porta"
+	// FederationHeaders are the headers passed to the federated backends
+	FederationHeaders = []string{"Authorization", "X-Request-Id", "Via"}
+)
+
+// DefaultTimeout is the timeout of the services, endpoints and backends without one. The backends
+// inherit the timeout of their endpoint and the endpoints the one of the service
+const DefaultTimeout = 2 * time.Second
+
+// default timeouts of the server
+var (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 90 * time.Second
 )
 
+// default settings of the warm cache
+const (
+	defaultWarmCacheMinHits = 2
+	defaultWarmCacheMaxKeys = 1000
+)
+
+// Init resolves the ${ENV_VAR} and ${file:/path} placeholders of the config, checks it and applies
+// the defaults
 func (s *ServiceConfig) Init() error {
 	if s.Version != 1 {
 		return fmt.Errorf("Unsupported version: %d\n", s.Version)
 	}
+	if err := resolvePlaceholders(reflect.ValueOf(s).Elem()); err != nil {
+		return err
+	}
+	if profile := s.profileName(); profile != "" {
+		if err := s.ApplyProfile(profile); err != nil {
+			return err
+		}
+	}
 	if s.Port == 0 {
 		s.Port = defaultPort
 	}
-	s.Host = s.cleanHosts(s.Host)
+	if s.GracePeriod == 0 {
+		s.GracePeriod = defaultGracePeriod
+	}
+	if s.Name == "" {
+		s.Name = defaultName
+	}
+	if s.LogRateLimit != nil {
+		s.LogRateLimit.init()
+	}
+	if s.Syslog != nil {
+		if err := s.Syslog.init(s.Name); err != nil {
+			return err
+		}
+	}
+	if s.Metrics != nil {
+		if err := s.Metrics.init(); err != nil {
+			return err
+		}
+	}
+	if s.HostChecks != nil {
+		s.HostChecks.init()
+	}
+	if s.SLOAlerts != nil {
+		if err := s.SLOAlerts.init(); err != nil {
+			return err
+		}
+	}
+	if s.Timeout < 0 {
+		return errNegativeTimeout
+	}
+	if s.Timeout == 0 {
+		s.Timeout = DefaultTimeout
+	}
+	if s.ReadHeaderTimeout == 0 {
+		s.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = defaultIdleTimeout
+	}
+	if s.TLS != nil && s.TLS.AutoCert == nil && (s.TLS.CertFile == "" || s.TLS.KeyFile == "") {
+		return errTLSWithoutCerts
+	}
+	if s.TLS != nil && s.TLS.AutoCert != nil && len(s.TLS.AutoCert.Domains) == 0 {
+		return errNoAutoCertDomains
+	}
+	switch s.ErrorFormat {
+	case "":
+		s.ErrorFormat = ErrorFormatProblem
+	case ErrorFormatProblem, ErrorFormatPlainText:
+	default:
+		return fmt.Errorf("unknown error format [%s]: use %s or %s", s.ErrorFormat, ErrorFormatProblem, ErrorFormatPlainText)
+	}
+	if s.Listen != nil && s.Listen.RedirectHTTP != "" && s.TLS == nil {
+		return errRedirectWithoutTLS
+	}
+	if s.Admin != nil && s.Admin.Token == "" {
+		return errAdminWithoutToken
+	}
+	if m := s.Multiplex; m != nil {
+		if s.TLS != nil || len(s.Listeners) > 0 {
+			return errMultiplexWithTLS
+		}
+		if m.AdminPrefix == "" {
+			m.AdminPrefix = "/__"
+		}
+		for _, p := range m.ShutdownOrder {
+			if p != ProtocolHTTP && p != ProtocolGRPC && p != ProtocolAdmin {
+				return fmt.Errorf("unknown multiplexed protocol [%s]: use %s, %s or %s", p, ProtocolHTTP, ProtocolGRPC, ProtocolAdmin)
+			}
+		}
+		m.ShutdownOrder = appendMissing(m.ShutdownOrder, ProtocolHTTP, ProtocolGRPC, ProtocolAdmin)
+	}
+	for _, a := range s.Static {
+		if a.Prefix == "" || a.Dir == "" {
+			return errStaticWithoutDir
+		}
+		if a.Prefix = strings.Trim(a.Prefix, "/"); a.Prefix != "" {
+			a.Prefix += "/"
+		}
+		a.Prefix = "/" + a.Prefix
+	}
+	probeNames := map[string]bool{}
+	for _, p := range s.SyntheticProbes {
+		if err := p.init(); err != nil {
+			return err
+		}
+		if probeNames[p.Name] {
+			return fmt.Errorf("duplicated synthetic probe [%s]", p.Name)
+		}
+		probeNames[p.Name] = true
+	}
+	for _, l := range s.Listeners {
+		if l.Address == "" {
+			return errNoListenAddress
+		}
+		if l.TLS && s.TLS == nil {
+			return fmt.Errorf("the listener [%s] requires the tls config of the service", l.Address)
+		}
+	}
+	hosts, err := s.cleanHosts(s.Host)
+	if err != nil {
+		return err
+	}
+	s.Host = hosts
+	s.Endpoints = expandMethods(s.Endpoints)
+	if s.BasePath != "" {
+		s.BasePath = strings.TrimSuffix(s.cleanPath(s.BasePath), "/")
+	}
 	for i, e := range s.Endpoints {
 		e.Endpoint = s.cleanPath(e.Endpoint)
 
 		if err := e.validate(); err != nil {
 			return err
 		}
+		e.Endpoint = s.BasePath + e.Endpoint
 
 		inputParams := s.extractPlaceHoldersFromURLTemplate(e.Endpoint, endpointURLKeysPattern)
+		catchAll := s.extractPlaceHoldersFromURLTemplate(e.Endpoint, catchAllPattern)
 		inputSet := map[string]interface{}{}
-		for ip := range inputParams {
-			inputSet[inputParams[ip]] = nil
+		for _, ip := range append(inputParams, catchAll...) {
+			inputSet[ip] = nil
 		}
 		e.Endpoint = s.getEndpointPath(e.Endpoint, inputParams)
 
 		s.initEndpointDefaults(i)
+		if j := e.Journal; j != nil {
+			if j.Path == "" && s.Journal != nil {
+				j.Path = s.Journal.Path
+			}
+			if j.MaxSize == 0 && s.Journal != nil && j.Path == s.Journal.Path {
+				j.MaxSize = s.Journal.MaxSize
+			}
+			if j.Path == "" {
+				return fmt.Errorf("the journal of the endpoint [%s] requires a path or the journal of the service", e.Endpoint)
+			}
+			e.HeadersToPass = appendMissing(e.HeadersToPass, JournalIdempotencyHeader)
+		}
+		if e.SLO != nil {
+			if err := e.SLO.init(); err != nil {
+				return fmt.Errorf("the endpoint [%s]: %s", e.Endpoint, err)
+			}
+		}
+		for name, q := range e.QueryTypes {
+			if err := q.init(); err != nil {
+				return fmt.Errorf("the query string param [%s] of the endpoint [%s]: %s", name, e.Endpoint, err)
+			}
+			e.QueryString = appendMissing(e.QueryString, name)
+		}
+		if rv := e.ResponseVariants; rv != nil {
+			if rv.Header == "" && rv.Claim == "" {
+				return fmt.Errorf("the response variants of the endpoint [%s] require a header or a claim", e.Endpoint)
+			}
+			if rv.Header != "" {
+				e.HeadersToPass = appendMissing(e.HeadersToPass, rv.Header)
+			}
+		}
 
-		for j, b := range e.Backend {
-			s.initBackendDefaults(i, j)
+		backends := e.Backend
+		if e.Canary != nil {
+			if len(e.Canary.Backend) == 0 {
+				return fmt.Errorf("the canary of the endpoint [%s] has no backends", e.Endpoint)
+			}
+			if e.Canary.SampleRate < 0 || e.Canary.SampleRate > 1 {
+				return fmt.Errorf("the sample rate of the canary of the endpoint [%s] must be between 0 and 1", e.Endpoint)
+			}
+			if e.Canary.SampleRate == 0 {
+				e.Canary.SampleRate = 1
+			}
+			backends = append(append([]*Backend{}, e.Backend...), e.Canary.Backend...)
+		}
+		for _, b := range backends {
+			if err := s.applyBackendTemplate(b); err != nil {
+				return fmt.Errorf("the backend [%s] of the endpoint [%s]: %s", b.URLPattern, e.Endpoint, err)
+			}
+			if err := s.initBackendDefaults(e, b); err != nil {
+				return fmt.Errorf("the backend [%s] of the endpoint [%s]: %s", b.URLPattern, e.Endpoint, err)
+			}
+			if err := b.HeaderLimits.init(); err != nil {
+				return fmt.Errorf("the backend [%s] of the endpoint [%s]: %s", b.URLPattern, e.Endpoint, err)
+			}
+			if err := b.CircuitBreaker.validate(); err != nil {
+				return fmt.Errorf("the backend [%s] of the endpoint [%s]: %s", b.URLPattern, e.Endpoint, err)
+			}
+			if t := b.Throttling; t != nil {
+				if t.DefaultBackoff == 0 {
+					t.DefaultBackoff = time.Second
+				}
+				if t.MaxBackoff == 0 {
+					t.MaxBackoff = 30 * time.Second
+				}
+			}
 			b.Method = strings.ToTitle(b.Method)
+			for _, key := range catchAll {
+				b.URLPattern = passthroughURLPattern(b.URLPattern, key)
+			}
 
-			if err := s.initBackendURLMappings(i, j, inputSet); err != nil {
+			if err := s.initURLMappings(b, inputSet); err != nil {
 				return err
 			}
 		}
+		initFederatedHeaders(e, backends)
+	}
+	return nil
+}
+
+// initFederatedHeaders captures the federation headers for the federated backends of the endpoint. The
+// ones the endpoint does not pass already are hidden from the rest of its backends, so the credentials
+// of the clients do not reach the third parties merged with the federated gateways
+func initFederatedHeaders(e *EndpointConfig, backends []*Backend) {
+	federated := false
+	for _, b := range backends {
+		federated = federated || b.Federated
+	}
+	if !federated {
+		return
+	}
+	passed := len(e.HeadersToPass)
+	e.FederatedHeaders = appendMissing(append([]string{}, e.HeadersToPass...), FederationHeaders...)[passed:]
+	if len(e.FederatedHeaders) == 0 {
+		return
+	}
+	for _, b := range backends {
+		if !b.Federated {
+			b.HiddenHeaders = e.FederatedHeaders
+		}
+	}
+}
+
+func (l *HeaderLimits) init() error {
+	if l == nil {
+		return nil
+	}
+	switch l.Action {
+	case "":
+		l.Action = HeaderLimitReject
+	case HeaderLimitReject, HeaderLimitTrim:
+	default:
+		return fmt.Errorf("unknown header limits action %s", l.Action)
+	}
+	if l.MaxCount < 0 || l.MaxBytes < 0 {
+		return errors.New("the header limits can not be negative")
+	}
+	return nil
+}
+
+func (c *CircuitBreaker) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxErrors <= 0 {
+		return errors.New("the circuit breaker requires a positive max_errors")
+	}
+	if c.Interval <= 0 && c.MaxErrors > 1 {
+		return errors.New("the circuit breaker requires an interval to count several errors")
+	}
+	return nil
+}
+
+// applyBackendTemplate copies the settings of the template of the backend to its zero fields, so the
+// backend overrides the template with the settings it declares. A backend can not turn off a boolean
+// set by its template
+func (s *ServiceConfig) applyBackendTemplate(b *Backend) error {
+	if b.Template == "" {
+		return nil
+	}
+	var t *Backend
+	// the names are case insensitive, since the parsers can lowercase the keys of the maps
+	for name, template := range s.BackendTemplates {
+		if strings.EqualFold(name, b.Template) {
+			t = template
+		}
+	}
+	if t == nil {
+		return fmt.Errorf("unknown backend template [%s]", b.Template)
+	}
+	dst, src := reflect.ValueOf(b).Elem(), reflect.ValueOf(t).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if _, ok := dst.Type().Field(i).Tag.Lookup("mapstructure"); !ok {
+			continue
+		}
+		if f := dst.Field(i); f.IsZero() {
+			f.Set(src.Field(i))
+		}
 	}
 	return nil
 }
@@ -160,44 +1291,139 @@ func (s *ServiceConfig) initEndpointDefaults(e int) {
 	if s.CacheTTL != 0 && endpoint.CacheTTL == 0 {
 		endpoint.CacheTTL = s.CacheTTL
 	}
-	if s.Timeout != 0 && endpoint.Timeout == 0 {
+	if endpoint.Timeout == 0 {
 		endpoint.Timeout = s.Timeout
 	}
+	if endpoint.WarmCache != nil {
+		s.initWarmCacheDefaults(endpoint)
+	}
 	if endpoint.ConcurrentCalls == 0 {
 		endpoint.ConcurrentCalls = 1
 	}
+	if endpoint.CostAttribution == nil {
+		endpoint.CostAttribution = s.CostAttribution
+	}
+	if endpoint.CostAttribution != nil && endpoint.CostAttribution.PlanHeader != "" {
+		endpoint.HeadersToPass = appendMissing(endpoint.HeadersToPass, endpoint.CostAttribution.PlanHeader)
+	}
 }
 
-func (s *ServiceConfig) initBackendDefaults(e, b int) {
-	endpoint := s.Endpoints[e]
-	backend := endpoint.Backend[b]
+func (s *ServiceConfig) initWarmCacheDefaults(endpoint *EndpointConfig) {
+	if endpoint.WarmCache.MinHits == 0 {
+		endpoint.WarmCache.MinHits = defaultWarmCacheMinHits
+	}
+	if endpoint.WarmCache.RefreshBefore == 0 {
+		endpoint.WarmCache.RefreshBefore = endpoint.CacheTTL / 10
+	}
+	if endpoint.WarmCache.MaxKeys == 0 {
+		endpoint.WarmCache.MaxKeys = defaultWarmCacheMaxKeys
+	}
+}
+
+func (s *ServiceConfig) initBackendDefaults(endpoint *EndpointConfig, backend *Backend) error {
 	if len(backend.Host) == 0 {
 		backend.Host = s.Host
 	} else {
-		backend.Host = s.cleanHosts(backend.Host)
+		hosts, err := s.cleanHosts(backend.Host)
+		if err != nil {
+			return err
+		}
+		backend.Host = hosts
 	}
 	if backend.Method == NONE {
 		backend.Method = endpoint.Method
 	}
-	backend.Timeout = endpoint.Timeout
+	if backend.Timeout < 0 {
+		return errNegativeTimeout
+	}
+	if backend.Timeout == 0 {
+		backend.Timeout = endpoint.Timeout
+	}
 	backend.ConcurrentCalls = endpoint.ConcurrentCalls
+	// the no-op endpoints stream the content of their backends as is
+	if backend.Encoding == "" && endpoint.OutputEncoding == encoding.NOOP {
+		backend.Encoding = encoding.NOOP
+	}
+	backend.GatewayID = strings.Replace(s.Name, " ", "-", -1)
 
-	switch strings.ToLower(backend.Encoding) {
-	case "xml":
-		backend.Decoder = encoding.XMLDecoder
-	case "json":
-		backend.Decoder = encoding.JSONDecoder
-	case "toml":
-		backend.Decoder = encoding.TOMLDecoder
-	case "yaml":
-		backend.Decoder = encoding.YAMLDecoder
-	default:
-		backend.Decoder = encoding.YAMLDecoder
+	decoder, err := backendDecoder(backend)
+	if err != nil {
+		return err
+	}
+	backend.Decoder = decoder
+	return nil
+}
+
+// backendDecoder returns the decoder of the encoding of the backend. The backends without a known
+// encoding are decoded as YAML, a superset of JSON
+func backendDecoder(backend *Backend) (encoding.Decoder, error) {
+	name := strings.ToLower(backend.Encoding)
+	if settings, ok := backend.ExtraConfig[encoding.StreamNamespace]; ok {
+		return streamingDecoder(backend, name, settings)
+	}
+	if backend.IsCollection || name == encoding.JSONCollection {
+		switch name {
+		case "", encoding.JSON, encoding.JSONCollection:
+		default:
+			return nil, fmt.Errorf("the collection backend [%s] can not have the %s encoding", backend.URLPattern, backend.Encoding)
+		}
+		backend.IsCollection = true
+		return encoding.NewJSONCollectionDecoder(backend.CollectionKey), nil
 	}
+	if name == encoding.STRING {
+		return encoding.NewStringDecoder(backend.StringKey), nil
+	}
+	if name == encoding.PROTOBUF {
+		if backend.ProtoDescriptor == "" || backend.ProtoMessage == "" {
+			return nil, fmt.Errorf("the protobuf backend [%s] requires a descriptor set and a message", backend.URLPattern)
+		}
+		decoder, err := protobuf.NewDecoder(backend.ProtoDescriptor, backend.ProtoMessage)
+		if err != nil {
+			return nil, fmt.Errorf("the protobuf backend [%s]: %s", backend.URLPattern, err)
+		}
+		return decoder, nil
+	}
+	if decoder, ok := decoders[name]; ok {
+		return decoder, nil
+	}
+	return encoding.YAMLDecoder, nil
+}
+
+// outputEncodings are the encodings the routers can render the responses with
+var outputEncodings = map[string]bool{
+	"":              true,
+	encoding.JSON:   true,
+	encoding.XML:    true,
+	encoding.YAML:   true,
+	encoding.STRING: true,
+	encoding.NOOP:   true,
+}
+
+// backendEncodings are the encodings the backends can declare, the ones decoded by backendDecoder
+var backendEncodings = map[string]bool{
+	encoding.JSON:           true,
+	encoding.XML:            true,
+	encoding.YAML:           true,
+	encoding.TOML:           true,
+	encoding.STRING:         true,
+	encoding.JSONCollection: true,
+	encoding.PROTOBUF:       true,
+	encoding.NOOP:           true,
+}
+
+// decoders maps the encodings accepted by the backends to their decoders
+var decoders = map[string]encoding.Decoder{
+	"xml":  encoding.XMLDecoder,
+	"json": encoding.JSONDecoder,
+	"toml": encoding.TOMLDecoder,
+	"yaml": encoding.YAMLDecoder,
 }
 
 func (s *ServiceConfig) initBackendURLMappings(e, b int, inputParams map[string]interface{}) error {
-	backend := s.Endpoints[e].Backend[b]
+	return s.initURLMappings(s.Endpoints[e].Backend[b], inputParams)
+}
+
+func (s *ServiceConfig) initURLMappings(backend *Backend, inputParams map[string]interface{}) error {
 	backend.URLPattern = s.cleanPath(backend.URLPattern)
 
 	outputParams := s.extractPlaceHoldersFromURLTemplate(backend.URLPattern, simpleURLKeysPattern)
@@ -224,24 +1450,134 @@ func (s *ServiceConfig) initBackendURLMappings(e, b int, inputParams map[string]
 	return nil
 }
 
-func (s *ServiceConfig) cleanHosts(hosts []string) []string {
+// expandMethods replaces the endpoints serving several methods with a copy per method, using the
+// overridden backends of the method if any
+func expandMethods(endpoints []*EndpointConfig) []*EndpointConfig {
+	expanded := make([]*EndpointConfig, 0, len(endpoints))
+	for _, e := range endpoints {
+		if len(e.Methods) == 0 {
+			expanded = append(expanded, e)
+			continue
+		}
+		methods := e.Methods
+		if e.Method != NONE {
+			methods = appendMissing([]string{e.Method}, methods...)
+		}
+		overrides := make(map[string][]*Backend, len(e.BackendOverrides))
+		for m, backends := range e.BackendOverrides {
+			overrides[strings.ToUpper(m)] = backends
+		}
+
+		for _, m := range methods {
+			m = strings.ToUpper(m)
+			endpoint := *e
+			endpoint.Method = m
+			endpoint.Methods = nil
+			endpoint.BackendOverrides = nil
+			endpoint.QueryString = append([]string{}, e.QueryString...)
+			endpoint.HeadersToPass = append([]string{}, e.HeadersToPass...)
+
+			backends, ok := overrides[m]
+			if !ok {
+				backends = e.Backend
+			}
+			endpoint.Backend = copyBackends(backends)
+			if e.Canary != nil {
+				canary := *e.Canary
+				canary.Backend = copyBackends(e.Canary.Backend)
+				endpoint.Canary = &canary
+			}
+			expanded = append(expanded, &endpoint)
+		}
+	}
+	return expanded
+}
+
+func copyBackends(backends []*Backend) []*Backend {
+	copies := make([]*Backend, len(backends))
+	for i, b := range backends {
+		backend := *b
+		copies[i] = &backend
+	}
+	return copies
+}
+
+func appendMissing(values []string, candidates ...string) []string {
+	for _, c := range candidates {
+		found := false
+		for _, v := range values {
+			if strings.EqualFold(v, c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, c)
+		}
+	}
+	return values
+}
+
+func (s *ServiceConfig) cleanHosts(hosts []string) ([]string, error) {
 	cleaned := []string{}
 	for i := range hosts {
-		cleaned = append(cleaned, s.cleanHost(hosts[i]))
+		h, err := s.cleanHost(hosts[i])
+		if err != nil {
+			return nil, err
+		}
+		cleaned = append(cleaned, h)
 	}
-	return cleaned
+	return cleaned, nil
 }
 
-func (s *ServiceConfig) cleanHost(host string) string {
-	matches := hostPattern.FindAllStringSubmatch(host, -1)
-	if len(matches) != 1 {
-		panic(errInvalidHost)
+// hostProfile converts the internationalized domain names to punycode. The underscores are allowed,
+// since they are common in the names of the internal services
+var hostProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.Transitional(false))
+
+// cleanHost normalizes the host to scheme://host[:port], defaulting the scheme to http. The domain names
+// are lowercased and converted to punycode and the IPv6 literals must be enclosed in brackets
+func (s *ServiceConfig) cleanHost(host string) (string, error) {
+	raw := strings.TrimSpace(host)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w [%s]: %s", errInvalidHost, host, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%w [%s]: unsupported scheme %s", errInvalidHost, host, u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%w [%s]", errInvalidHost, host)
+	}
+
+	hostname := u.Hostname()
+	if strings.Contains(hostname, ":") {
+		ip, zone := hostname, ""
+		if i := strings.IndexByte(hostname, '%'); i >= 0 {
+			ip, zone = hostname[:i], hostname[i:]
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return "", fmt.Errorf("%w [%s]: bad IPv6 literal", errInvalidHost, host)
+		}
+		hostname = "[" + parsed.String() + strings.Replace(zone, "%", "%25", 1) + "]"
+	} else if net.ParseIP(hostname) == nil {
+		if hostname, err = hostProfile.ToASCII(strings.TrimSuffix(hostname, ".")); err != nil {
+			return "", fmt.Errorf("%w [%s]: %s", errInvalidHost, host, err)
+		}
 	}
-	keys := matches[0][1:]
-	if keys[0] == "" {
-		keys[0] = "http://"
+
+	if port := u.Port(); port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return "", fmt.Errorf("%w [%s]: bad port %s", errInvalidHost, host, port)
+		}
+		hostname += ":" + port
+	} else if strings.HasSuffix(u.Host, ":") {
+		return "", fmt.Errorf("%w [%s]: empty port", errInvalidHost, host)
 	}
-	return strings.Join(keys, "")
+	return u.Scheme + "://" + hostname, nil
 }
 
 func (s *ServiceConfig) cleanPath(path string) string {
@@ -255,8 +1591,21 @@ func (s *ServiceConfig) getEndpointPath(path string, params []string) string {
 		for p := range params {
 			result = strings.Replace(result, "/{"+params[p]+"}", "/:"+params[p], -1)
 		}
+		return result
+	}
+	if RoutingPattern == ServeMuxRouterPatternBuilder {
+		return catchAllPattern.ReplaceAllString(result, "/{${1}...}")
+	}
+	return catchAllPattern.ReplaceAllString(result, "/{${1}:.*}")
+}
+
+// passthroughURLPattern appends the placeholder of the catch-all param to the backend url pattern, so
+// the rest of the request path is proxied under it, unless the pattern already places the param
+func passthroughURLPattern(pattern, key string) string {
+	if strings.Contains(pattern, "{"+key+"}") {
+		return pattern
 	}
-	return result
+	return strings.TrimSuffix(pattern, "/") + "/{" + key + "}"
 }
 
 func (e *EndpointConfig) validate() error {
@@ -268,10 +1617,28 @@ func (e *EndpointConfig) validate() error {
 	if matched {
 		return fmt.Errorf("ERROR: the endpoint url path [%s] is not a valid one!!! Ignoring\n", e.Endpoint)
 	}
+	if e.Timeout < 0 {
+		return fmt.Errorf("the endpoint [%s]: %w", e.Endpoint, errNegativeTimeout)
+	}
 
 	if len(e.Backend) == 0 {
 		return fmt.Errorf("WARNING: the [%s] endpoint has 0 backends defined! Ignoring\n", e.Endpoint)
 	}
+	if !outputEncodings[e.OutputEncoding] {
+		return fmt.Errorf("the endpoint [%s] has the unknown output encoding [%s]", e.Endpoint, e.OutputEncoding)
+	}
+	if e.TrustedOverrides != nil && e.TrustedOverrides.Role == "" {
+		return fmt.Errorf("the trusted overrides of the endpoint [%s] require a role", e.Endpoint)
+	}
+	if e.WriteFanOut != nil {
+		switch e.WriteFanOut.Consistency {
+		case "":
+			e.WriteFanOut.Consistency = WriteConsistencyAllOrError
+		case WriteConsistencyAllOrError, WriteConsistencyBestEffort:
+		default:
+			return fmt.Errorf("the endpoint [%s] has the unknown write consistency [%s]", e.Endpoint, e.WriteFanOut.Consistency)
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// DurationHook decodes the bare numbers set to the durations, like the timeouts and the TTLs, as
+// milliseconds. The strings with units, like "300ms", "2s" or "1m", are left to the next hook
+func DurationHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != durationType {
+		return data, nil
+	}
+	var ms float64
+	switch from.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ms = float64(reflect.ValueOf(data).Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		ms = float64(reflect.ValueOf(data).Uint())
+	case reflect.Float32, reflect.Float64:
+		ms = reflect.ValueOf(data).Float()
+	case reflect.String:
+		f, err := strconv.ParseFloat(reflect.ValueOf(data).String(), 64)
+		if err != nil {
+			return data, nil
+		}
+		ms = f
+	default:
+		return data, nil
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// Decode copies the settings, like the ones of an extra config namespace, into the struct pointed by
+// output through its mapstructure tags. The durations follow the rules of the config files: bare
+// numbers are milliseconds and strings like "30s" are parsed
+func Decode(settings, output interface{}) error {
+	return DecodeWithTag(settings, output, "mapstructure")
+}
+
+// DecodeWithTag works like Decode, reading the names of the fields from the tag, like json. The
+// embedded structs are squashed into their parent, like encoding/json does
+func DecodeWithTag(settings, output interface{}, tag string) error {
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			DurationHook,
+			mapstructure.StringToTimeDurationHookFunc(),
+		),
+		TagName: tag,
+		Squash:  true,
+		Result:  output,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Decode(settings)
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var placeholderPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// filePlaceholderPrefix marks the placeholders replaced by the content of a file
+const filePlaceholderPrefix = "file:"
+
+// ResolvePlaceholders replaces the ${NAME} placeholders of the value with the content of the
+// environment variable NAME and the ${file:/path} ones with the content of the file, without its
+// trailing line breaks. It returns an error if the variable is not set or the file can not be read
+func ResolvePlaceholders(value string) (string, error) {
+	var err error
+	resolved := placeholderPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+		if err != nil {
+			return placeholder
+		}
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		if path := strings.TrimPrefix(name, filePlaceholderPrefix); path != name {
+			content, readErr := os.ReadFile(path)
+			if readErr != nil {
+				err = fmt.Errorf("resolving the placeholder %s: %w", placeholder, readErr)
+				return placeholder
+			}
+			return strings.TrimRight(string(content), "\r\n")
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			err = fmt.Errorf("resolving the placeholder %s: the environment variable is not set", placeholder)
+			return placeholder
+		}
+		return v
+	})
+	return resolved, err
+}
+
+// resolvePlaceholders replaces the placeholders of all the strings reachable from the received value
+func resolvePlaceholders(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface && v.Elem().Kind() == reflect.String {
+			s, err := ResolvePlaceholders(v.Elem().String())
+			if err != nil {
+				return err
+			}
+			if v.CanSet() {
+				v.Set(reflect.ValueOf(s))
+			}
+			return nil
+		}
+		return resolvePlaceholders(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := resolvePlaceholders(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolvePlaceholders(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// the map values are not addressable, so they are resolved in a copy stored back in the map
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+			if err := resolvePlaceholders(value); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), value)
+		}
+	case reflect.String:
+		if !v.CanSet() || !strings.Contains(v.String(), "${") {
+			return nil
+		}
+		s, err := ResolvePlaceholders(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePlaceholders(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PORTA_TEST_USER", "supu")
+
+	have, err := ResolvePlaceholders("${PORTA_TEST_USER}:${file:" + secret + "}@{host}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have != "supu:s3cr3t@{host}" {
+		t.Errorf("unexpected value: %s", have)
+	}
+
+	if _, err := ResolvePlaceholders("${PORTA_TEST_UNDEFINED}"); err == nil {
+		t.Error("expecting an error for the undefined variable")
+	}
+	if _, err := ResolvePlaceholders("${file:/nowhere/in/the/fs}"); err == nil {
+		t.Error("expecting an error for the missing file")
+	}
+}
+
+func TestConfig_initPlaceholders(t *testing.T) {
+	t.Setenv("PORTA_TEST_HOST", "backend.local")
+	t.Setenv("PORTA_TEST_KEY", "abc")
+	subject := ServiceConfig{
+		Version: 1,
+		Host:    []string{"${PORTA_TEST_HOST}"},
+		Endpoints: []*EndpointConfig{
+			{
+				Endpoint: "/supu",
+				Backend:  []*Backend{{URLPattern: "/tupu"}},
+				ExtraConfig: ExtraConfig{
+					"auth": map[string]interface{}{"api_keys": []interface{}{"${PORTA_TEST_KEY}"}},
+				},
+			},
+		},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if subject.Host[0] != "http://backend.local" {
+		t.Errorf("unexpected host: %s", subject.Host[0])
+	}
+	keys := subject.Endpoints[0].ExtraConfig["auth"].(map[string]interface{})["api_keys"].([]interface{})
+	if keys[0] != "abc" {
+		t.Errorf("unexpected api key: %v", keys[0])
+	}
+
+	subject.Host = []string{"${PORTA_TEST_UNDEFINED}"}
+	if err := subject.Init(); err == nil {
+		t.Error("expecting an error")
+	}
+}
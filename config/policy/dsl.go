@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Parse builds an engine with the rules declared in the received reader. The rules are declared one
+// per line and the lines starting with '#' are ignored:
+//
+//	max_timeout 5s
+//	require_auth except /__health /public/*
+//	no_wildcard_cors_with_credentials
+func Parse(r io.Reader) (*Engine, error) {
+	rules := []Rule{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule, err := parseRule(fields[0], fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("parsing the policy at line %d: %s", line, err.Error())
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return New(rules...), nil
+}
+
+// ParseFile builds an engine with the rules declared in the received file
+func ParseFile(path string) (*Engine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func parseRule(name string, args []string) (Rule, error) {
+	switch name {
+	case "max_timeout":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects a single duration", name)
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return MaxTimeout(d), nil
+	case "require_auth":
+		if len(args) == 0 {
+			return RequireAuth{}, nil
+		}
+		if args[0] != "except" || len(args) == 1 {
+			return nil, fmt.Errorf("%s expects 'except' followed by the public endpoint patterns", name)
+		}
+		return RequireAuth{Public: args[1:]}, nil
+	case "no_wildcard_cors_with_credentials":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s does not accept arguments", name)
+		}
+		return NoWildcardCORSWithCredentials{}, nil
+	}
+	return nil, fmt.Errorf("unknown rule [%s]", name)
+}
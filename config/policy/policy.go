@@ -0,0 +1,229 @@
+// Package policy validates the service configuration against a set of organizational rules
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/security"
+)
+
+const (
+	// Namespace is the key of the endpoint extra config holding the policy annotations
+	Namespace = "policy"
+	// middlewaresNamespace is the key of the endpoint extra config listing its middlewares, as read by
+	// the router/middleware package
+	middlewaresNamespace = "middlewares"
+)
+
+// AuthMiddlewares are the names of the endpoint middlewares authenticating the requests
+var AuthMiddlewares = []string{"auth", "oidc", "signature", "mtls"}
+
+// Violation is a broken rule
+type Violation struct {
+	Rule     string
+	Endpoint string
+	Message  string
+}
+
+func (v Violation) Error() string {
+	if v.Endpoint == "" {
+		return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", v.Rule, v.Endpoint, v.Message)
+}
+
+// Violations is the error returned by the engine when the config breaks some rules
+type Violations []Violation
+
+func (vs Violations) Error() string {
+	msgs := make([]string, len(vs))
+	for i, v := range vs {
+		msgs[i] = v.Error()
+	}
+	return fmt.Sprintf("policy violations: %s", strings.Join(msgs, "; "))
+}
+
+// Rule checks a single organizational constraint
+type Rule interface {
+	Name() string
+	Check(cfg *config.ServiceConfig) []Violation
+}
+
+// Engine validates configurations against a set of rules
+type Engine struct {
+	rules []Rule
+}
+
+// New creates an engine with the received rules
+func New(rules ...Rule) *Engine {
+	return &Engine{rules: rules}
+}
+
+// Rules returns the rules of the engine
+func (e *Engine) Rules() []Rule {
+	return e.rules
+}
+
+// Validate returns a Violations error if the config breaks any rule
+func (e *Engine) Validate(cfg *config.ServiceConfig) error {
+	violations := Violations{}
+	for _, r := range e.rules {
+		violations = append(violations, r.Check(cfg)...)
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// CORSRule is a Rule checking the CORS settings declared out of the service config too, like the ones
+// of the security file of the gateways
+type CORSRule interface {
+	Rule
+	CheckCORS(cors *security.CORSConfig) []Violation
+}
+
+// ValidateCORS returns a Violations error if the CORS settings break any CORSRule of the engine
+func (e *Engine) ValidateCORS(cors *security.CORSConfig) error {
+	violations := Violations{}
+	for _, r := range e.rules {
+		if cr, ok := r.(CORSRule); ok {
+			violations = append(violations, cr.CheckCORS(cors)...)
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// NewParser decorates the received parser, rejecting the configurations breaking the rules of the engine
+func NewParser(parser config.Parser, engine *Engine) config.Parser {
+	return policyParser{parser, engine}
+}
+
+type policyParser struct {
+	parser config.Parser
+	engine *Engine
+}
+
+func (p policyParser) Parse(configFile string) (config.ServiceConfig, error) {
+	cfg, err := p.parser.Parse(configFile)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, p.engine.Validate(&cfg)
+}
+
+// MaxTimeout rejects the endpoints without a timeout or with a timeout longer than the max
+type MaxTimeout time.Duration
+
+func (MaxTimeout) Name() string { return "max_timeout" }
+
+func (m MaxTimeout) Check(cfg *config.ServiceConfig) []Violation {
+	violations := []Violation{}
+	for _, e := range cfg.Endpoints {
+		if e.Timeout <= 0 || e.Timeout > time.Duration(m) {
+			violations = append(violations, Violation{
+				Rule:     m.Name(),
+				Endpoint: e.Endpoint,
+				Message:  fmt.Sprintf("timeout %s out of the range (0, %s]", e.Timeout, time.Duration(m)),
+			})
+		}
+	}
+	return violations
+}
+
+// RequireAuth rejects the non-public endpoints without any of the AuthMiddlewares in their middlewares.
+// An endpoint is public if it matches one of the patterns or if it is annotated as public in its extra
+// config
+type RequireAuth struct {
+	Public []string
+}
+
+func (RequireAuth) Name() string { return "require_auth" }
+
+func (r RequireAuth) Check(cfg *config.ServiceConfig) []Violation {
+	violations := []Violation{}
+	for _, e := range cfg.Endpoints {
+		if r.isPublic(e) {
+			continue
+		}
+		if authenticated(e) {
+			continue
+		}
+		violations = append(violations, Violation{
+			Rule:     r.Name(),
+			Endpoint: e.Endpoint,
+			Message:  "non public endpoint without authentication",
+		})
+	}
+	return violations
+}
+
+func (r RequireAuth) isPublic(e *config.EndpointConfig) bool {
+	if annotations, ok := e.ExtraConfig[Namespace].(map[string]interface{}); ok {
+		if public, ok := annotations["public"].(bool); ok && public {
+			return true
+		}
+	}
+	for _, pattern := range r.Public {
+		if matched, err := path.Match(pattern, e.Endpoint); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func authenticated(e *config.EndpointConfig) bool {
+	items, _ := e.ExtraConfig[middlewaresNamespace].([]interface{})
+	for _, item := range items {
+		settings, _ := item.(map[string]interface{})
+		name, _ := settings["name"].(string)
+		for _, auth := range AuthMiddlewares {
+			if name == auth {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NoWildcardCORSWithCredentials rejects the CORS settings allowing any origin and credentials at the same time
+type NoWildcardCORSWithCredentials struct{}
+
+func (NoWildcardCORSWithCredentials) Name() string { return "no_wildcard_cors_with_credentials" }
+
+func (n NoWildcardCORSWithCredentials) Check(cfg *config.ServiceConfig) []Violation {
+	if cfg.CORS == nil {
+		return nil
+	}
+	return n.check(cfg.CORS.AllowedOrigins, cfg.CORS.AllowCredentials)
+}
+
+// CheckCORS implements the CORSRule interface
+func (n NoWildcardCORSWithCredentials) CheckCORS(cors *security.CORSConfig) []Violation {
+	if cors == nil {
+		return nil
+	}
+	return n.check(cors.AllowedOrigins, cors.AllowCredentials)
+}
+
+func (n NoWildcardCORSWithCredentials) check(origins []string, credentials bool) []Violation {
+	if !credentials {
+		return nil
+	}
+	for _, o := range origins {
+		if o == "*" {
+			return []Violation{{
+				Rule:    n.Name(),
+				Message: "wildcard origin with credentials allowed",
+			}}
+		}
+	}
+	return nil
+}
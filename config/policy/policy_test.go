@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/security"
+)
+
+const samplePolicy = `
+# organizational rules
+max_timeout 5s
+require_auth except /__health /public/*
+no_wildcard_cors_with_credentials
+`
+
+func TestParse(t *testing.T) {
+	engine, err := Parse(strings.NewReader(samplePolicy))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(engine.Rules()) != 3 {
+		t.Errorf("unexpected number of rules: %d", len(engine.Rules()))
+	}
+}
+
+func TestParse_ko(t *testing.T) {
+	for _, sample := range []string{
+		"unknown_rule",
+		"max_timeout",
+		"max_timeout supu",
+		"require_auth /public",
+		"no_wildcard_cors_with_credentials true",
+	} {
+		if _, err := Parse(strings.NewReader(sample)); err == nil || !strings.HasPrefix(err.Error(), "parsing the policy at line 1:") {
+			t.Errorf("error expected parsing %s. have: %v", sample, err)
+		}
+	}
+}
+
+func TestEngine_Validate(t *testing.T) {
+	engine, err := Parse(strings.NewReader(samplePolicy))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cfg := config.ServiceConfig{
+		CORS: &config.CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		Endpoints: []*config.EndpointConfig{
+			{Endpoint: "/__health", Timeout: time.Second},
+			{Endpoint: "/public/supu", Timeout: time.Second},
+			{
+				Endpoint:    "/annotated",
+				Timeout:     time.Second,
+				ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{"public": true}},
+			},
+			{
+				Endpoint: "/private",
+				Timeout:  time.Second,
+				ExtraConfig: config.ExtraConfig{"middlewares": []interface{}{
+					map[string]interface{}{"name": "ratelimit"},
+					map[string]interface{}{"name": "oidc"},
+				}},
+			},
+			{
+				Endpoint:    "/unauthenticated",
+				Timeout:     time.Second,
+				ExtraConfig: config.ExtraConfig{"middlewares": []interface{}{map[string]interface{}{"name": "ratelimit"}}},
+			},
+			{Endpoint: "/slow", Timeout: time.Minute},
+		},
+	}
+
+	err = engine.Validate(&cfg)
+	violations, ok := err.(Violations)
+	if !ok {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if len(violations) != 4 {
+		t.Errorf("unexpected violations: %s", violations.Error())
+		return
+	}
+	for i, rule := range []string{"max_timeout", "require_auth", "require_auth", "no_wildcard_cors_with_credentials"} {
+		if violations[i].Rule != rule {
+			t.Errorf("unexpected violation at %d: %s", i, violations[i].Error())
+		}
+	}
+}
+
+func TestEngine_ValidateCORS(t *testing.T) {
+	engine, err := Parse(strings.NewReader(samplePolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ValidateCORS(&security.CORSConfig{AllowedOrigins: []string{"https://example.com"}, AllowCredentials: true}); err != nil {
+		t.Error(err)
+	}
+	err = engine.ValidateCORS(&security.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	if violations, ok := err.(Violations); !ok || len(violations) != 1 || violations[0].Rule != "no_wildcard_cors_with_credentials" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
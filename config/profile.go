@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// ProfileEnv is the environment variable selecting the profile of the configs not declaring one
+const ProfileEnv = "PORTA_PROFILE"
+
+// Names of the built-in profiles
+const (
+	ProfileDev  = "dev"
+	ProfileProd = "prod"
+)
+
+// Profile adjusts the defaults of the service for an environment. The profiles only fill the settings
+// left unset by the config, except the debug endpoints, which are switched by the profile
+type Profile func(s *ServiceConfig)
+
+// Profiles holds the profiles selectable by name. The applications can register their own ones before
+// parsing the config
+var Profiles = map[string]Profile{
+	ProfileDev:  DevProfile,
+	ProfileProd: ProdProfile,
+}
+
+// DevProfile enables the debug endpoints and the verbose logs, and accepts the requests from any origin
+func DevProfile(s *ServiceConfig) {
+	s.Debug = true
+	if s.LogLevel == "" {
+		s.LogLevel = "DEBUG"
+	}
+	if s.CORS == nil {
+		s.CORS = &CORS{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{GET, POST, PUT, "PATCH", DELETE, "HEAD", "OPTIONS"},
+			AllowedHeaders: []string{"*"},
+		}
+	}
+}
+
+// ProdProfile disables the debug endpoints, adds the security headers, logs the warnings, limiting the
+// repeated ones, and samples a tenth of the successful requests in the access log. No cross origin
+// request is accepted unless the config declares a CORS policy
+func ProdProfile(s *ServiceConfig) {
+	s.Debug = false
+	s.SecurityHeaders = true
+	if s.LogLevel == "" {
+		s.LogLevel = "WARNING"
+	}
+	if s.LogSampling == 0 {
+		s.LogSampling = 0.1
+	}
+	if s.LogRateLimit == nil {
+		s.LogRateLimit = &LogRateLimit{}
+	}
+}
+
+// ApplyProfile adjusts the config with the named profile
+func (s *ServiceConfig) ApplyProfile(name string) error {
+	profile, ok := Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile [%s]", name)
+	}
+	s.Profile = name
+	profile(s)
+	return nil
+}
+
+func (s *ServiceConfig) profileName() string {
+	if s.Profile != "" {
+		return s.Profile
+	}
+	return os.Getenv(ProfileEnv)
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig_initProfile(t *testing.T) {
+	newConfig := func() ServiceConfig {
+		return ServiceConfig{
+			Version:   1,
+			Host:      []string{"127.0.0.1:8080"},
+			LogLevel:  "ERROR",
+			Endpoints: []*EndpointConfig{{Endpoint: "/supu", Backend: []*Backend{{URLPattern: "/tupu"}}}},
+		}
+	}
+
+	subject := newConfig()
+	subject.Profile = ProfileDev
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if !subject.Debug || subject.CORS == nil || subject.CORS.AllowedOrigins[0] != "*" {
+		t.Errorf("the dev profile was not applied: %+v", subject)
+	}
+	if subject.LogLevel != "ERROR" {
+		t.Errorf("the profile replaced the log level of the config: %s", subject.LogLevel)
+	}
+
+	t.Setenv(ProfileEnv, ProfileProd)
+	subject = newConfig()
+	subject.Debug = true
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if subject.Profile != ProfileProd || subject.Debug || !subject.SecurityHeaders || subject.LogSampling != 0.1 || subject.CORS != nil {
+		t.Errorf("the prod profile was not applied: %+v", subject)
+	}
+	if l := subject.LogRateLimit; l == nil || l.Burst != 10 || l.Interval != time.Minute {
+		t.Errorf("unexpected log rate limit: %+v", l)
+	}
+
+	subject = newConfig()
+	subject.Profile = "staging"
+	if err := subject.Init(); err == nil {
+		t.Error("expecting an error for the unknown profile")
+	}
+}
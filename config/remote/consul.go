@@ -0,0 +1,88 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Consul reads the keys of the Consul KV store through its HTTP API. The changes are watched with
+// blocking queries
+type Consul struct {
+	// Address of the agent, like http://127.0.0.1:8500
+	Address string
+	// Token sent as X-Consul-Token, if not empty
+	Token string
+	// max duration of every blocking query
+	WaitTime time.Duration
+	// Client must not have a timeout shorter than the WaitTime
+	Client *http.Client
+}
+
+// NewConsul returns a provider for the Consul agent at the address
+func NewConsul(address string) *Consul {
+	return &Consul{
+		Address:  strings.TrimSuffix(address, "/"),
+		WaitTime: 5 * time.Minute,
+		Client:   &http.Client{},
+	}
+}
+
+// Get implements the Provider interface
+func (c *Consul) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	return c.get(ctx, key, url.Values{"raw": {""}})
+}
+
+// Watch implements the Provider interface. The blocking queries expiring without changes are retried
+func (c *Consul) Watch(ctx context.Context, key string, revision uint64) ([]byte, uint64, error) {
+	for {
+		query := url.Values{
+			"raw":   {""},
+			"index": {strconv.FormatUint(revision, 10)},
+			"wait":  {fmt.Sprintf("%ds", int(c.WaitTime.Seconds()))},
+		}
+		value, index, err := c.get(ctx, key, query)
+		if err != nil {
+			return nil, 0, err
+		}
+		// a lower index means the store was reset, so the value must be taken too
+		if index != revision {
+			return value, index, nil
+		}
+	}
+}
+
+func (c *Consul) get(ctx context.Context, key string, query url.Values) ([]byte, uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Address+"/v1/kv/"+strings.TrimPrefix(key, "/")+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, 0, ErrKeyNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, 0, fmt.Errorf("consul answered with the status %d", resp.StatusCode)
+	}
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	index, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid consul index: %s", err)
+	}
+	return value, index, nil
+}
@@ -0,0 +1,120 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Etcd reads the keys of an etcd v3 cluster through its JSON gateway. The changes are watched with the
+// streaming watch API
+type Etcd struct {
+	// Address of the member, like http://127.0.0.1:2379
+	Address string
+	// Client must not have a timeout, so the watch streams are kept open
+	Client *http.Client
+}
+
+// NewEtcd returns a provider for the etcd member at the address
+func NewEtcd(address string) *Etcd {
+	return &Etcd{
+		Address: strings.TrimSuffix(address, "/"),
+		Client:  &http.Client{},
+	}
+}
+
+type etcdKV struct {
+	Value       []byte `json:"value"`
+	ModRevision uint64 `json:"mod_revision,string"`
+}
+
+type etcdEvent struct {
+	Type string `json:"type"`
+	KV   etcdKV `json:"kv"`
+}
+
+// Get implements the Provider interface
+func (e *Etcd) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	resp, err := e.post(ctx, "/v3/kv/range", map[string]interface{}{"key": []byte(key)})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var r struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, 0, err
+	}
+	if len(r.KVs) == 0 {
+		return nil, 0, ErrKeyNotFound
+	}
+	return r.KVs[0].Value, r.KVs[0].ModRevision, nil
+}
+
+// Watch implements the Provider interface
+func (e *Etcd) Watch(ctx context.Context, key string, revision uint64) ([]byte, uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp, err := e.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(key),
+			"start_revision": fmt.Sprint(revision + 1),
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events []etcdEvent `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			return nil, 0, err
+		}
+		if msg.Error != nil {
+			return nil, 0, fmt.Errorf("etcd watch: %s", msg.Error.Message)
+		}
+		for _, event := range msg.Result.Events {
+			if event.KV.ModRevision <= revision {
+				continue
+			}
+			if event.Type == "DELETE" {
+				return nil, 0, ErrKeyNotFound
+			}
+			return event.KV.Value, event.KV.ModRevision, nil
+		}
+	}
+}
+
+func (e *Etcd) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Address+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd answered with the status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
@@ -0,0 +1,130 @@
+// Package remote loads the service config from a central KV store, like Consul or etcd, and watches
+// it so the routers can reload the endpoints when it changes
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/config/viper"
+)
+
+// ErrKeyNotFound is returned when the store does not have the requested key
+var ErrKeyNotFound = errors.New("key not found")
+
+// DefaultRetryDelay is the delay before watching a key again after a failure
+const DefaultRetryDelay = 5 * time.Second
+
+// Provider reads the values of a KV store
+type Provider interface {
+	// Get returns the value of the key and its revision
+	Get(ctx context.Context, key string) ([]byte, uint64, error)
+	// Watch blocks until the key gets a revision newer than the received one, returning its value
+	Watch(ctx context.Context, key string, revision uint64) ([]byte, uint64, error)
+}
+
+// New returns the provider of the store at the url, like consul://127.0.0.1:8500 or
+// etcd://127.0.0.1:2379. The consul+https and etcd+https schemes connect with TLS
+func New(rawurl string) (Provider, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "consul":
+		return NewConsul("http://" + u.Host), nil
+	case "consul+https":
+		return NewConsul("https://" + u.Host), nil
+	case "etcd":
+		return NewEtcd("http://" + u.Host), nil
+	case "etcd+https":
+		return NewEtcd("https://" + u.Host), nil
+	default:
+		return nil, fmt.Errorf("unknown config store [%s]", u.Scheme)
+	}
+}
+
+// Parser is a config.Parser reading the config documents from a provider. The configFile received by
+// Parse is the key of the document
+type Parser struct {
+	Provider Provider
+	// Format of the documents (json, toml, yaml...). json if empty
+	Format string
+}
+
+// Parse implements the config.Parser interface
+func (p Parser) Parse(key string) (config.ServiceConfig, error) {
+	content, _, err := p.Provider.Get(context.Background(), key)
+	if err != nil {
+		return config.ServiceConfig{}, fmt.Errorf("Fatal error config key %s: %s\n", key, err)
+	}
+	return viper.ParseContent(content, format(p.Format))
+}
+
+// Watcher subscribes to the changes of a config document, parsing it again every time it changes
+type Watcher struct {
+	Provider Provider
+	Key      string
+	// Format of the document. json if empty
+	Format string
+	// delay before watching again after a failure. DefaultRetryDelay if zero
+	RetryDelay time.Duration
+	// OnChange receives the config parsed after every change
+	OnChange func(config.ServiceConfig)
+	// OnError receives the errors watching or parsing the document, if not nil. The previous config
+	// is kept until the document is fixed
+	OnError func(error)
+}
+
+// Watch follows the document until the context is done. It returns an error if the document can not be
+// read when it starts
+func (w Watcher) Watch(ctx context.Context) error {
+	_, revision, err := w.Provider.Get(ctx, w.Key)
+	if err != nil {
+		return err
+	}
+	delay := w.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	for {
+		content, next, err := w.Provider.Watch(ctx, w.Key, revision)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			w.fail(err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			continue
+		}
+		revision = next
+		cfg, err := viper.ParseContent(content, format(w.Format))
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		w.OnChange(cfg)
+	}
+}
+
+func (w Watcher) fail(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+func format(f string) string {
+	if f == "" {
+		return "json"
+	}
+	return f
+}
@@ -0,0 +1,134 @@
+package remote
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func document(name string) string {
+	return fmt.Sprintf(`{"version": 1, "name": %q, "endpoints": [{"endpoint": "/supu", "backend": [{"host": ["http://127.0.0.1:8080"], "url_pattern": "/tupu"}]}]}`, name)
+}
+
+func TestConsul(t *testing.T) {
+	updated := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/porta/config" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("index") {
+		case "2":
+			<-r.Context().Done()
+			return
+		case "1":
+			select {
+			case <-updated:
+			case <-r.Context().Done():
+				return
+			}
+			w.Header().Set("X-Consul-Index", "2")
+			fmt.Fprint(w, document("second"))
+			return
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		fmt.Fprint(w, document("first"))
+	}))
+	defer s.Close()
+
+	provider, err := New("consul://" + s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Parser{Provider: provider}).Parse("missing"); err == nil {
+		t.Error("expecting an error for a missing key")
+	}
+	cfg, err := Parser{Provider: provider}.Parse("porta/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "first" || len(cfg.Endpoints) != 1 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	testWatcher(t, provider, func() { close(updated) })
+}
+
+func TestEtcd(t *testing.T) {
+	updated := make(chan struct{})
+	encoded := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			fmt.Fprintf(w, `{"header":{"revision":"7"},"kvs":[{"key":%q,"value":%q,"mod_revision":"5"}],"count":"1"}`, encoded("porta/config"), encoded(document("first")))
+		case "/v3/watch":
+			fmt.Fprint(w, `{"result":{"header":{"revision":"7"},"created":true}}`)
+			w.(http.Flusher).Flush()
+			select {
+			case <-updated:
+			case <-r.Context().Done():
+				return
+			}
+			fmt.Fprintf(w, `{"result":{"header":{"revision":"8"},"events":[{"kv":{"value":%q,"mod_revision":"8"}}]}}`, encoded(document("second")))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	provider, err := New("etcd://" + s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, revision, err := provider.Get(context.Background(), "porta/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 5 || string(value) != document("first") {
+		t.Errorf("unexpected value %d: %s", revision, value)
+	}
+
+	testWatcher(t, provider, func() { close(updated) })
+}
+
+func testWatcher(t *testing.T, provider Provider, update func()) {
+	changes := make(chan config.ServiceConfig, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Watcher{
+			Provider: provider,
+			Key:      "porta/config",
+			OnChange: func(cfg config.ServiceConfig) { changes <- cfg },
+			OnError:  func(err error) { t.Error(err) },
+		}.Watch(ctx)
+	}()
+
+	update()
+	select {
+	case cfg := <-changes:
+		if cfg.Name != "second" {
+			t.Errorf("unexpected config: %+v", cfg)
+		}
+	case <-time.After(time.Second):
+		t.Error("the change was not received")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNew_unknownStore(t *testing.T) {
+	if _, err := New("zookeeper://127.0.0.1:2181"); err == nil {
+		t.Error("expecting an error for an unknown store")
+	}
+}
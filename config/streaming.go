@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ph0m1/porta/encoding"
+)
+
+// streamingDecoder returns the streaming decoder of a JSON backend with the guards of the settings of
+// its extra config, skipping the root fields its formatter would drop anyway
+func streamingDecoder(backend *Backend, name string, settings interface{}) (encoding.Decoder, error) {
+	switch name {
+	case "", encoding.JSON, encoding.JSONCollection:
+	default:
+		return nil, fmt.Errorf("the streaming backend [%s] can not have the %s encoding", backend.URLPattern, backend.Encoding)
+	}
+	var opts encoding.StreamOptions
+	if err := Decode(settings, &opts); err != nil {
+		return nil, fmt.Errorf("the streaming settings of the backend [%s]: %s", backend.URLPattern, err)
+	}
+	if opts.MaxDepth < 0 || opts.MaxSize < 0 {
+		return nil, fmt.Errorf("the streaming limits of the backend [%s] can not be negative", backend.URLPattern)
+	}
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = encoding.DefaultStreamMaxDepth
+	}
+	if backend.IsCollection || name == encoding.JSONCollection {
+		backend.IsCollection = true
+		opts.CollectionKey = backend.CollectionKey
+		if opts.CollectionKey == "" {
+			opts.CollectionKey = encoding.DefaultCollectionKey
+		}
+	}
+	// the whitelist and the blacklist are applied to the target, once extracted
+	switch {
+	case backend.Target != "":
+		opts.Fields = []string{backend.Target}
+	case len(backend.Whitelist) > 0:
+		for _, k := range backend.Whitelist {
+			opts.Fields = append(opts.Fields, strings.Split(k, ".")[0])
+		}
+	default:
+		for _, k := range backend.Blacklist {
+			if !strings.Contains(k, ".") {
+				opts.Skip = append(opts.Skip, k)
+			}
+		}
+	}
+	if opts.CollectionKey != "" && len(opts.Fields) > 0 {
+		opts.Fields = append(opts.Fields, opts.CollectionKey)
+	}
+	return encoding.NewJSONStreamDecoder(opts), nil
+}
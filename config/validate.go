@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationErrors lists the problems found in a config
+type ValidationErrors []error
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// supportedMethods are the methods the routers can register
+var supportedMethods = map[string]bool{
+	GET: true, POST: true, PUT: true, "PATCH": true, DELETE: true, "HEAD": true, "OPTIONS": true,
+}
+
+// Validate checks an initialized config for the problems the routers and the proxies would only
+// report, or ignore, when serving the requests: duplicated endpoints, unsupported methods, non GET
+// endpoints with several backends and no write fan-out, unknown encodings, backends without hosts and
+// backends with a timeout longer than the one of their endpoint. It returns the ValidationErrors found
+// or nil
+func (s *ServiceConfig) Validate() error {
+	var errs ValidationErrors
+	declared := map[string]int{}
+	for _, e := range s.Endpoints {
+		name := e.Method + " " + e.Endpoint
+		declared[name]++
+		if declared[name] == 2 {
+			errs = append(errs, fmt.Errorf("the endpoint [%s] is declared more than once: only the first one is registered", name))
+		}
+		if !supportedMethods[e.Method] {
+			errs = append(errs, fmt.Errorf("the endpoint [%s] has an unsupported method: use one of %s", name, methodList()))
+		}
+		if e.Method != GET && len(e.Backend) > 1 && e.WriteFanOut == nil {
+			errs = append(errs, fmt.Errorf("the endpoint [%s] has %d backends, but only the GET endpoints can merge several ones: split it, use a single backend or declare its write_fan_out", name, len(e.Backend)))
+		}
+		for _, b := range e.Backend {
+			if b.Encoding != "" {
+				if !backendEncodings[strings.ToLower(b.Encoding)] {
+					errs = append(errs, fmt.Errorf("the backend [%s] of the endpoint [%s] has the unknown encoding [%s]: use one of %s", b.URLPattern, name, b.Encoding, encodingList()))
+				}
+			}
+			if len(b.Host) == 0 {
+				errs = append(errs, fmt.Errorf("the backend [%s] of the endpoint [%s] has no hosts: declare them in the backend or in the host list of the service", b.URLPattern, name))
+			}
+			if b.Timeout > e.Timeout {
+				errs = append(errs, fmt.Errorf("the backend [%s] of the endpoint [%s] has a timeout of %s, but the endpoint gives up after %s: shorten it or extend the one of the endpoint", b.URLPattern, name, b.Timeout, e.Timeout))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func methodList() string {
+	methods := make([]string, 0, len(supportedMethods))
+	for m := range supportedMethods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+func encodingList() string {
+	names := make([]string, 0, len(backendEncodings))
+	for name := range backendEncodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
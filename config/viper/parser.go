@@ -2,8 +2,15 @@
 package viper
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/ph0m1/porta/config"
 	"github.com/spf13/viper"
 )
@@ -16,14 +23,47 @@ type parser struct {
 	viper *viper.Viper
 }
 
+// Parse reads the config file. The configFile can also be a directory, whose json, toml and yaml files
+// are loaded in lexical order, or a list of files joined by the os path list separator, loaded in the
+// listed order. The files are merged with mergeSettings
 func (p parser) Parse(configFile string) (config.ServiceConfig, error) {
-	p.viper.SetConfigFile(configFile)
-	p.viper.AutomaticEnv()
 	var cfg config.ServiceConfig
-	if err := p.viper.ReadInConfig(); err != nil {
+	files, err := configFiles(configFile)
+	if err != nil {
 		return cfg, fmt.Errorf("Fatal error config file: %s\n", err)
 	}
-	if err := p.viper.Unmarshal(&cfg); err != nil {
+	if len(files) == 1 {
+		p.viper.SetConfigFile(files[0])
+		if err := p.viper.ReadInConfig(); err != nil {
+			return cfg, fmt.Errorf("Fatal error config file: %s\n", err)
+		}
+	} else {
+		settings, err := readSettings(files)
+		if err != nil {
+			return cfg, fmt.Errorf("Fatal error config file: %s\n", err)
+		}
+		if err := p.viper.MergeConfigMap(settings); err != nil {
+			return cfg, fmt.Errorf("Fatal error config file: %s\n", err)
+		}
+	}
+	return decode(p.viper)
+}
+
+// ParseContent parses a config document in the received format (json, toml, yaml...), like the ones
+// stored in a remote config store
+func ParseContent(content []byte, format string) (config.ServiceConfig, error) {
+	v := viper.New()
+	v.SetConfigType(format)
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		return config.ServiceConfig{}, fmt.Errorf("Fatal error config file: %s\n", err)
+	}
+	return decode(v)
+}
+
+func decode(v *viper.Viper) (config.ServiceConfig, error) {
+	var cfg config.ServiceConfig
+	v.AutomaticEnv()
+	if err := v.Unmarshal(&cfg, viper.DecodeHook(decodeHook)); err != nil {
 		return cfg, fmt.Errorf("Fatal error unmarshalling config file: %s\n", err)
 	}
 	if err := cfg.Init(); err != nil {
@@ -31,3 +71,115 @@ func (p parser) Parse(configFile string) (config.ServiceConfig, error) {
 	}
 	return cfg, nil
 }
+
+// configExtensions are the extensions of the files loaded from a config directory
+var configExtensions = map[string]bool{".json": true, ".toml": true, ".yaml": true, ".yml": true}
+
+// configFiles returns the files to load for the received path
+func configFiles(path string) ([]string, error) {
+	if strings.ContainsRune(path, os.PathListSeparator) {
+		return filepath.SplitList(path), nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && configExtensions[strings.ToLower(filepath.Ext(e.Name()))] {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no config files in the directory %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readSettings reads the files and merges their settings in order
+func readSettings(files []string) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+	declared := map[string]string{}
+	for _, file := range files {
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		if err := mergeSettings(merged, v.AllSettings(), file, declared); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// mergeSettings deep merges the settings of a file into the ones of the previous files:
+//   - the objects are merged key by key
+//   - the endpoint lists are concatenated. An endpoint declared by two files, with the same path and
+//     method, is an error
+//   - any other value replaces the one of the previous files
+func mergeSettings(dst, src map[string]interface{}, file string, declared map[string]string) error {
+	for k, v := range src {
+		if k == "endpoints" {
+			endpoints, _ := v.([]interface{})
+			for _, e := range endpoints {
+				key := endpointKey(e)
+				if previous, ok := declared[key]; ok {
+					return fmt.Errorf("the endpoint [%s] of %s is already declared in %s", key, file, previous)
+				}
+				declared[key] = file
+			}
+			previous, _ := dst[k].([]interface{})
+			dst[k] = append(previous, endpoints...)
+			continue
+		}
+		dst[k] = mergeValue(dst[k], v)
+	}
+	return nil
+}
+
+// mergeValue merges the objects key by key. Any other value is replaced by the src one
+func mergeValue(dst, src interface{}) interface{} {
+	dstMap, dstIsMap := dst.(map[string]interface{})
+	srcMap, srcIsMap := src.(map[string]interface{})
+	if !dstIsMap || !srcIsMap {
+		return src
+	}
+	for k, v := range srcMap {
+		dstMap[k] = mergeValue(dstMap[k], v)
+	}
+	return dstMap
+}
+
+// endpointKey identifies an endpoint definition by its method and path
+func endpointKey(e interface{}) string {
+	m, _ := e.(map[string]interface{})
+	method, _ := m["method"].(string)
+	if method == "" {
+		method = config.GET
+	}
+	path, _ := m["endpoint"].(string)
+	return strings.ToUpper(method) + " " + path
+}
+
+// decodeHook extends the viper defaults resolving the placeholders of the values decoded into non
+// string fields, like the ports or the timeouts. The strings are resolved by the Init of the config
+var decodeHook = mapstructure.ComposeDecodeHookFunc(
+	placeholderHook,
+	config.DurationHook,
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+)
+
+func placeholderHook(_ reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	s, ok := data.(string)
+	if !ok || to.Kind() == reflect.String || to.Kind() == reflect.Interface {
+		return data, nil
+	}
+	return config.ResolvePlaceholders(s)
+}
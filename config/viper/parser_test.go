@@ -1,10 +1,13 @@
 package viper
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew_ok(t *testing.T) {
@@ -123,3 +126,200 @@ func TestNew_initError(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestNew_placeholders(t *testing.T) {
+	configPath := "/tmp/placeholders.json"
+	configContent := []byte(`{
+    "version": 1,
+    "port": "${PORTA_TEST_PORT}",
+    "timeout": "${PORTA_TEST_TIMEOUT}",
+    "host": ["${PORTA_TEST_HOST}"],
+    "endpoints": [
+        {
+            "endpoint": "/supu",
+            "backend": [{"url_pattern": "/tupu"}]
+        }
+    ]
+}`)
+	if err := ioutil.WriteFile(configPath, configContent, 0644); err != nil {
+		t.FailNow()
+	}
+	defer os.Remove(configPath)
+	t.Setenv("PORTA_TEST_PORT", "9090")
+	t.Setenv("PORTA_TEST_TIMEOUT", "2s")
+	t.Setenv("PORTA_TEST_HOST", "backend.local:8000")
+
+	serviceConfig, err := New().Parse(configPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if serviceConfig.Port != 9090 {
+		t.Errorf("unexpected port: %d", serviceConfig.Port)
+	}
+	if serviceConfig.Timeout.String() != "2s" {
+		t.Errorf("unexpected timeout: %s", serviceConfig.Timeout)
+	}
+	if serviceConfig.Host[0] != "http://backend.local:8000" {
+		t.Errorf("unexpected host: %s", serviceConfig.Host[0])
+	}
+}
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+}
+
+func TestNew_directory(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"00-service.json": `{"version": 1, "port": 8080, "timeout": "3s", "host": ["http://service.local"], "extra_config": {"a": {"b": 1, "c": 2}}}`,
+		"10-team-a.yaml":  "endpoints:\n  - endpoint: /a\n    backend:\n      - url_pattern: /a\n",
+		"20-team-b.yaml":  "port: 9090\nextra_config:\n  a:\n    c: 3\nendpoints:\n  - endpoint: /b\n    backend:\n      - url_pattern: /b\n",
+		"README.md":       "not a config file",
+	})
+
+	serviceConfig, err := New().Parse(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if serviceConfig.Port != 9090 {
+		t.Errorf("the later file should override the port: %d", serviceConfig.Port)
+	}
+	if len(serviceConfig.Endpoints) != 2 || serviceConfig.Endpoints[0].Endpoint != "/a" || serviceConfig.Endpoints[1].Endpoint != "/b" {
+		t.Errorf("unexpected endpoints: %v", serviceConfig.Endpoints)
+	}
+	if serviceConfig.Endpoints[1].Backend[0].Host[0] != "http://service.local" {
+		t.Errorf("unexpected host: %v", serviceConfig.Endpoints[1].Backend[0].Host)
+	}
+	a, ok := serviceConfig.ExtraConfig["a"].(map[string]interface{})
+	if !ok || fmt.Sprint(a["b"]) != "1" || fmt.Sprint(a["c"]) != "3" {
+		t.Errorf("unexpected extra config: %v", serviceConfig.ExtraConfig)
+	}
+}
+
+func TestNew_fileList(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"service.json":  `{"version": 1, "name": "first"}`,
+		"override.json": `{"name": "second", "endpoints": [{"endpoint": "/a", "backend": [{"host": ["http://a"], "url_pattern": "/"}]}]}`,
+	})
+	files := filepath.Join(dir, "override.json") + string(os.PathListSeparator) + filepath.Join(dir, "service.json")
+
+	serviceConfig, err := New().Parse(files)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if serviceConfig.Name != "first" {
+		t.Errorf("the files should be merged in the listed order: %s", serviceConfig.Name)
+	}
+}
+
+func TestNew_duplicatedEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"a.json": `{"version": 1, "endpoints": [{"endpoint": "/a", "backend": [{"host": ["http://a"], "url_pattern": "/"}]}]}`,
+		"b.json": `{"endpoints": [{"endpoint": "/a", "method": "get", "backend": [{"host": ["http://b"], "url_pattern": "/"}]}]}`,
+	})
+
+	_, err := New().Parse(dir)
+	if err == nil || !strings.Contains(err.Error(), "b.json is already declared in") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNew_emptyDirectory(t *testing.T) {
+	if _, err := New().Parse(t.TempDir()); err == nil || !strings.HasPrefix(err.Error(), "Fatal error config file:") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNew_durations(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "durations.yaml")
+	configContent := []byte(`version: 1
+host: ["http://127.0.0.1:8080"]
+timeout: 1500
+cache_ttl: "1h"
+endpoints:
+  - endpoint: /supu
+    timeout: "300ms"
+    backend:
+      - url_pattern: /a
+      - url_pattern: /b
+        timeout: 2.5
+  - endpoint: /tupu
+    backend:
+      - url_pattern: /c
+`)
+	if err := ioutil.WriteFile(configPath, configContent, 0644); err != nil {
+		t.FailNow()
+	}
+
+	serviceConfig, err := New().Parse(configPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if serviceConfig.Timeout != 1500*time.Millisecond || serviceConfig.CacheTTL != time.Hour {
+		t.Errorf("unexpected service durations: %s %s", serviceConfig.Timeout, serviceConfig.CacheTTL)
+	}
+	supu := serviceConfig.Endpoints[0]
+	if supu.Timeout != 300*time.Millisecond || supu.CacheTTL != time.Hour {
+		t.Errorf("unexpected endpoint durations: %s %s", supu.Timeout, supu.CacheTTL)
+	}
+	if supu.Backend[0].Timeout != 300*time.Millisecond || supu.Backend[1].Timeout != 2500*time.Microsecond {
+		t.Errorf("unexpected backend timeouts: %s %s", supu.Backend[0].Timeout, supu.Backend[1].Timeout)
+	}
+	if serviceConfig.Endpoints[1].Timeout != 1500*time.Millisecond {
+		t.Errorf("unexpected default timeout: %s", serviceConfig.Endpoints[1].Timeout)
+	}
+}
+
+func TestNew_backendTemplates(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "templates.yaml")
+	configContent := []byte(`version: 1
+timeout: 1s
+backend_templates:
+  UsersAPI:
+    host: ["http://users:8000"]
+    encoding: xml
+    timeout: 300ms
+    whitelist: ["id", "name"]
+endpoints:
+  - endpoint: /users/{id}
+    backend:
+      - template: UsersAPI
+        url_pattern: /users/{id}
+  - endpoint: /admins/{id}
+    backend:
+      - template: UsersAPI
+        url_pattern: /admins/{id}
+        whitelist: ["id"]
+        timeout: 2s
+`)
+	if err := ioutil.WriteFile(configPath, configContent, 0644); err != nil {
+		t.FailNow()
+	}
+
+	serviceConfig, err := New().Parse(configPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	users := serviceConfig.Endpoints[0].Backend[0]
+	if users.Host[0] != "http://users:8000" || users.Encoding != "xml" || users.Timeout != 300*time.Millisecond || len(users.Whitelist) != 2 {
+		t.Errorf("the template was not applied: %+v", users)
+	}
+	admins := serviceConfig.Endpoints[1].Backend[0]
+	if admins.URLPattern != "/admins/{{.Id}}" || admins.Timeout != 2*time.Second || len(admins.Whitelist) != 1 || admins.Encoding != "xml" {
+		t.Errorf("the backend should override the template: %+v", admins)
+	}
+
+	configContent = []byte("version: 1\nendpoints:\n  - endpoint: /a\n    backend:\n      - template: missing\n        url_pattern: /a\n")
+	if err := ioutil.WriteFile(configPath, configContent, 0644); err != nil {
+		t.FailNow()
+	}
+	if _, err := New().Parse(configPath); err == nil || !strings.Contains(err.Error(), "unknown backend template [missing]") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
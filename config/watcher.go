@@ -0,0 +1,68 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// DefaultWatchInterval is the interval between the checks of the watched config file
+const DefaultWatchInterval = 5 * time.Second
+
+// Watcher parses the config file again every time its modification time or its size change, so the
+// routers can reload it
+type Watcher struct {
+	Parser Parser
+	Path   string
+	// interval between the checks of the file. DefaultWatchInterval if zero
+	Interval time.Duration
+	// OnChange receives the config parsed after every change
+	OnChange func(ServiceConfig)
+	// OnError receives the errors reading or parsing the changed file, if not nil. The previous config
+	// is kept until the file is fixed
+	OnError func(error)
+}
+
+// Watch checks the file until the context is done. It returns an error if the file can not be read
+// when it starts
+func (w Watcher) Watch(ctx context.Context) error {
+	last, err := os.Stat(w.Path)
+	if err != nil {
+		return err
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		info, err := os.Stat(w.Path)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		if info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		cfg, err := w.Parser.Parse(w.Path)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		w.OnChange(cfg)
+	}
+}
+
+func (w Watcher) fail(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
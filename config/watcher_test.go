@@ -0,0 +1,83 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type parserFunc func(string) (ServiceConfig, error)
+
+func (f parserFunc) Parse(path string) (ServiceConfig, error) { return f(path) }
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "porta.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseErr := errors.New("parse")
+	calls := 0
+	changes := make(chan ServiceConfig, 1)
+	errs := make(chan error, 1)
+	w := Watcher{
+		Parser: parserFunc(func(string) (ServiceConfig, error) {
+			calls++
+			if calls == 1 {
+				return ServiceConfig{}, parseErr
+			}
+			return ServiceConfig{Name: "reloaded"}, nil
+		}),
+		Path:     path,
+		Interval: time.Millisecond,
+		OnChange: func(cfg ServiceConfig) { changes <- cfg },
+		OnError:  func(err error) { errs <- err },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Watch(ctx)
+
+	// the file is touched until the watcher, started in the background, notices it
+	offset := 0
+	touch := func() {
+		offset++
+		now := time.Now().Add(time.Duration(offset) * time.Second)
+		if err := os.Chtimes(path, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; ; i++ {
+		if i == 50 {
+			t.Fatal("the parse error was not reported")
+		}
+		touch()
+		select {
+		case err := <-errs:
+			if err != parseErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(20 * time.Millisecond):
+			continue
+		}
+		break
+	}
+
+	touch()
+	select {
+	case cfg := <-changes:
+		if cfg.Name != "reloaded" {
+			t.Errorf("unexpected config: %+v", cfg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the change was not reported")
+	}
+}
+
+func TestWatcher_missingFile(t *testing.T) {
+	w := Watcher{Path: filepath.Join(t.TempDir(), "missing.json")}
+	if err := w.Watch(context.Background()); err == nil {
+		t.Error("expecting an error")
+	}
+}
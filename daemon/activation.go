@@ -0,0 +1,89 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const listenFdsStart = 3
+
+var (
+	activated     []activatedListener
+	activatedErr  error
+	activatedOnce sync.Once
+)
+
+type activatedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// ActivatedListeners returns the sockets passed by systemd (LISTEN_FDS) in their declared order. The
+// environment is consumed on the first call, so the same listeners are returned on every call
+func ActivatedListeners() ([]net.Listener, error) {
+	activatedOnce.Do(loadActivated)
+	listeners := make([]net.Listener, len(activated))
+	for i, a := range activated {
+		listeners[i] = a.listener
+	}
+	return listeners, activatedErr
+}
+
+// ActivatedListener returns the socket passed by systemd with the received name (FileDescriptorName)
+// or index
+func ActivatedListener(name string) (net.Listener, error) {
+	activatedOnce.Do(loadActivated)
+	if activatedErr != nil {
+		return nil, activatedErr
+	}
+	for _, a := range activated {
+		if a.name == name {
+			return a.listener, nil
+		}
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(activated) {
+		return activated[i].listener, nil
+	}
+	return nil, ErrUnknownSocket
+}
+
+func loadActivated() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			activatedErr = err
+			return
+		}
+		activated = append(activated, activatedListener{name: name, listener: l})
+	}
+}
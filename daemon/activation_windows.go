@@ -0,0 +1,13 @@
+package daemon
+
+import "net"
+
+// ActivatedListeners returns no listeners, since socket activation is not available on windows
+func ActivatedListeners() ([]net.Listener, error) {
+	return nil, nil
+}
+
+// ActivatedListener always fails, since socket activation is not available on windows
+func ActivatedListener(_ string) (net.Listener, error) {
+	return nil, ErrUnknownSocket
+}
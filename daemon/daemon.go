@@ -0,0 +1,51 @@
+// Package daemon integrates the gateway with the service managers of the host: systemd socket
+// activation and readiness notifications, and the Windows service control manager
+package daemon
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// ErrUnknownSocket is returned when the activated sockets do not include the requested one
+var ErrUnknownSocket = errors.New("unknown activated socket")
+
+// Notify states understood by systemd
+const (
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+)
+
+// Notify sends the state to the service manager. It returns false without error when the process
+// was not started by a manager expecting notifications
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if socket[0] == '@' {
+		// abstract namespace
+		addr.Name = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ready notifies the service manager the gateway is serving requests
+func Ready() (bool, error) {
+	return Notify(StateReady)
+}
+
+// Stopping notifies the service manager the gateway is shutting down
+func Stopping() (bool, error) {
+	return Notify(StateStopping)
+}
@@ -0,0 +1,37 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unixgram sockets not available:", err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if ok, err := Ready(); !ok || err != nil {
+		t.Errorf("unexpected result: %v %v", ok, err)
+		return
+	}
+	buf := make([]byte, 64)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != StateReady {
+		t.Errorf("unexpected notification: %q %v", buf[:n], err)
+	}
+}
+
+func TestNotify_disabled(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if ok, err := Ready(); ok || err != nil {
+		t.Errorf("unexpected result: %v %v", ok, err)
+	}
+}
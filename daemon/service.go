@@ -0,0 +1,18 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Run runs the function until the process receives a SIGINT or a SIGTERM. On windows, it runs the
+// function as the named service when started by the service control manager
+func Run(_ string, run func(ctx context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return run(ctx)
+}
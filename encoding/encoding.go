@@ -2,5 +2,21 @@ package encoding
 
 import "io"
 
+// Names of the encodings
+const (
+	JSON = "json"
+	XML  = "xml"
+	YAML = "yaml"
+	TOML = "toml"
+	// STRING stores the body of the responses as text
+	STRING = "string"
+	// PROTOBUF decodes the binary protobuf messages described by a descriptor set
+	PROTOBUF = "protobuf"
+	// JSONCollection decodes the JSON arrays, storing them under a key of the response
+	JSONCollection = "json-collection"
+	// NOOP sends the content of the response as is
+	NOOP = "no-op"
+)
+
 // Read from r, into map of interfaces
 type Decoder func(r io.Reader, v *map[string]interface{}) error
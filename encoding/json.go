@@ -10,3 +10,28 @@ func JSONDecoder(r io.Reader, v *map[string]interface{}) error {
 	d.UseNumber()
 	return d.Decode(v)
 }
+
+// DefaultCollectionKey is the key of the arrays decoded by the collection decoders without one
+const DefaultCollectionKey = "collection"
+
+// NewJSONCollectionDecoder returns a decoder of the responses with an array at their root, storing it
+// under the key, so it can be merged and formatted like the objects. The objects are decoded as is
+func NewJSONCollectionDecoder(key string) Decoder {
+	if key == "" {
+		key = DefaultCollectionKey
+	}
+	return func(r io.Reader, v *map[string]interface{}) error {
+		var data interface{}
+		d := json.NewDecoder(r)
+		d.UseNumber()
+		if err := d.Decode(&data); err != nil {
+			return err
+		}
+		if m, ok := data.(map[string]interface{}); ok {
+			*v = m
+			return nil
+		}
+		*v = map[string]interface{}{key: data}
+		return nil
+	}
+}
@@ -0,0 +1,31 @@
+package encoding
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewJSONCollectionDecoder(t *testing.T) {
+	for _, tc := range []struct {
+		key, body, want string
+	}{
+		{"", `[{"id":1},{"id":2}]`, `{"collection":[{"id":1},{"id":2}]}`},
+		{"users", `[]`, `{"users":[]}`},
+		{"users", `{"id":1}`, `{"id":1}`},
+	} {
+		var data map[string]interface{}
+		if err := NewJSONCollectionDecoder(tc.key)(strings.NewReader(tc.body), &data); err != nil {
+			t.Error(err)
+			continue
+		}
+		if b, _ := json.Marshal(data); string(b) != tc.want {
+			t.Errorf("%s: want %s, have %s", tc.body, tc.want, b)
+		}
+	}
+
+	var data map[string]interface{}
+	if err := NewJSONCollectionDecoder("")(strings.NewReader(`[1,`), &data); err == nil {
+		t.Error("expecting an error for the truncated body")
+	}
+}
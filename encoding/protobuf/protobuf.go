@@ -0,0 +1,85 @@
+// Package protobuf decodes the binary protobuf responses of the backends into the generic maps merged and
+// formatted by the proxies. The messages are described by a descriptor set, like the ones generated by
+// protoc --include_imports --descriptor_set_out, so no generated code is needed
+package protobuf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/ph0m1/porta/encoding"
+)
+
+// marshaler keeps the names of the proto files and the fields with their default values, so the
+// responses have the same shape whatever the content of the messages
+var marshaler = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// NewDecoder returns a decoder of the messages of the type with the full name, like acme.users.User,
+// described in the descriptor set file
+func NewDecoder(descriptorSet, message string) (encoding.Decoder, error) {
+	files, err := LoadDescriptorSet(descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecoderFromFiles(files, message)
+}
+
+// LoadDescriptorSet reads the descriptor set file, which must include the imports of its files
+func LoadDescriptorSet(path string) (*protoregistry.Files, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("parsing the descriptor set %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("loading the descriptor set %s: %w", path, err)
+	}
+	return files, nil
+}
+
+// NewDecoderFromFiles returns a decoder of the messages of the type with the full name found in files
+func NewDecoderFromFiles(files *protoregistry.Files, message string) (encoding.Decoder, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("the protobuf message %s: %w", message, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a protobuf message", message)
+	}
+	// the types of the Any fields and of the extensions are looked up in the descriptor set too
+	resolver := dynamicpb.NewTypes(files)
+	unmarshaler := proto.UnmarshalOptions{Resolver: resolver}
+	marshaler := marshaler
+	marshaler.Resolver = resolver
+
+	return func(r io.Reader, v *map[string]interface{}) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		msg := dynamicpb.NewMessage(md)
+		if err := unmarshaler.Unmarshal(b, msg); err != nil {
+			return err
+		}
+		j, err := marshaler.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return encoding.JSONDecoder(bytes.NewReader(j), v)
+	}, nil
+}
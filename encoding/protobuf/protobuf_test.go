@@ -0,0 +1,109 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func testDescriptorSet(t *testing.T) string {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("users.proto"),
+		Package: proto.String("acme"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("full_name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("age", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+					field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+					field("address", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".acme.Address"),
+					field("active", 5, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+				},
+			},
+			{
+				Name:  proto.String("Address"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("city", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, "")},
+			},
+		},
+	}}}
+	b, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.pb")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewDecoder(t *testing.T) {
+	path := testDescriptorSet(t)
+	files, err := LoadDescriptorSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := files.FindDescriptorByName("acme.User")
+	md := d.(protoreflect.MessageDescriptor)
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("full_name"), protoreflect.ValueOfString("Supu Tupu"))
+	msg.Set(md.Fields().ByName("age"), protoreflect.ValueOfInt32(42))
+	tags := msg.Mutable(md.Fields().ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("a"))
+	tags.Append(protoreflect.ValueOfString("b"))
+	address := msg.Mutable(md.Fields().ByName("address")).Message()
+	address.Set(address.Descriptor().Fields().ByName("city"), protoreflect.ValueOfString("Madrid"))
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := NewDecoder(path, "acme.User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := decoder(bytes.NewReader(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(data)
+	if want := `{"active":false,"address":{"city":"Madrid"},"age":42,"full_name":"Supu Tupu","tags":["a","b"]}`; string(b) != want {
+		t.Errorf("want %s, have %s", want, b)
+	}
+
+	if err := decoder(bytes.NewReader([]byte{0xff}), &data); err == nil {
+		t.Error("expecting an error for the malformed message")
+	}
+}
+
+func TestNewDecoder_unknownMessage(t *testing.T) {
+	path := testDescriptorSet(t)
+	if _, err := NewDecoder(path, "acme.Order"); err == nil {
+		t.Error("expecting an error for the unknown message")
+	}
+	if _, err := NewDecoder(filepath.Join(t.TempDir(), "missing.pb"), "acme.User"); err == nil {
+		t.Error("expecting an error for the missing descriptor set")
+	}
+}
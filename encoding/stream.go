@@ -0,0 +1,188 @@
+package encoding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrMaxDepth is returned by the streaming decoders when the response nests too many objects or arrays
+	ErrMaxDepth = errors.New("the response exceeds the max depth")
+	// ErrMaxSize is returned by the streaming decoders when the response is too large
+	ErrMaxSize = errors.New("the response exceeds the max size")
+)
+
+const (
+	// StreamNamespace is the key of the backend extra config holding the guards of its streaming
+	// decoding, like {"max_depth": 32, "max_size": 10485760}
+	StreamNamespace = "streaming"
+	// DefaultStreamMaxDepth is the max nesting of the responses of the streaming backends without one
+	DefaultStreamMaxDepth = 64
+)
+
+// StreamOptions are the guards and the filters of the streaming JSON decoders. Only the guards are read
+// from the extra config of the backends
+type StreamOptions struct {
+	// max nesting of objects and arrays. Unlimited if zero
+	MaxDepth int `mapstructure:"max_depth"`
+	// max number of bytes read from the response. Unlimited if zero
+	MaxSize int64 `mapstructure:"max_size"`
+	// fields of the root object to decode. All of them if empty
+	Fields []string `mapstructure:"-"`
+	// fields of the root object to skip
+	Skip []string `mapstructure:"-"`
+	// key of the arrays at the root of the response, like the collection decoders. The arrays are an
+	// error if empty
+	CollectionKey string `mapstructure:"-"`
+}
+
+// NewJSONStreamDecoder returns a decoder reading the JSON responses token by token, so the fields
+// discarded by the options are skipped without being built, and the responses exceeding the limits
+// are dropped as soon as the limits are reached instead of after being buffered
+func NewJSONStreamDecoder(opts StreamOptions) Decoder {
+	keep := make(map[string]bool, len(opts.Fields))
+	for _, f := range opts.Fields {
+		keep[f] = true
+	}
+	skip := make(map[string]bool, len(opts.Skip))
+	for _, f := range opts.Skip {
+		skip[f] = true
+	}
+	wanted := func(key string) bool {
+		if skip[key] {
+			return false
+		}
+		return len(keep) == 0 || keep[key]
+	}
+
+	return func(r io.Reader, v *map[string]interface{}) error {
+		if opts.MaxSize > 0 {
+			r = &sizeGuard{r: r, left: opts.MaxSize}
+		}
+		s := &streamDecoder{d: json.NewDecoder(r), maxDepth: opts.MaxDepth}
+		s.d.UseNumber()
+
+		tok, err := s.d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			data, err := s.object(1, wanted)
+			if err != nil {
+				return err
+			}
+			*v = data
+			return nil
+		case json.Delim('['):
+			if opts.CollectionKey == "" {
+				return errors.New("the response is an array")
+			}
+			data, err := s.array(1, true)
+			if err != nil {
+				return err
+			}
+			*v = map[string]interface{}{opts.CollectionKey: data}
+			return nil
+		}
+		return fmt.Errorf("the response is not an object: %v", tok)
+	}
+}
+
+// sizeGuard fails the reads once the limit is exceeded. The bytes past the limit are not handed to the
+// decoder, as it consumes the bytes read before looking at the error
+type sizeGuard struct {
+	r    io.Reader
+	left int64
+}
+
+func (g *sizeGuard) Read(p []byte) (int, error) {
+	if int64(len(p)) > g.left+1 {
+		p = p[:g.left+1]
+	}
+	n, err := g.r.Read(p)
+	if int64(n) > g.left {
+		g.left = -1
+		return 0, ErrMaxSize
+	}
+	g.left -= int64(n)
+	return n, err
+}
+
+type streamDecoder struct {
+	d        *json.Decoder
+	maxDepth int
+}
+
+// object decodes the members of an object which opening delimiter has been read. The members not
+// wanted are read and dropped
+func (s *streamDecoder) object(depth int, wanted func(string) bool) (map[string]interface{}, error) {
+	if s.maxDepth > 0 && depth > s.maxDepth {
+		return nil, ErrMaxDepth
+	}
+	data := map[string]interface{}{}
+	for s.d.More() {
+		tok, err := s.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		keep := wanted == nil || wanted(key)
+		v, err := s.value(depth, keep)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			data[key] = v
+		}
+	}
+	// the closing delimiter
+	if _, err := s.d.Token(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *streamDecoder) array(depth int, keep bool) ([]interface{}, error) {
+	if s.maxDepth > 0 && depth > s.maxDepth {
+		return nil, ErrMaxDepth
+	}
+	var data []interface{}
+	if keep {
+		data = []interface{}{}
+	}
+	for s.d.More() {
+		v, err := s.value(depth, keep)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			data = append(data, v)
+		}
+	}
+	if _, err := s.d.Token(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// value decodes the next value, returning nil for the ones not kept
+func (s *streamDecoder) value(depth int, keep bool) (interface{}, error) {
+	tok, err := s.d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		if !keep {
+			_, err := s.object(depth+1, func(string) bool { return false })
+			return nil, err
+		}
+		return s.object(depth+1, nil)
+	case json.Delim('['):
+		return s.array(depth+1, keep)
+	}
+	return tok, nil
+}
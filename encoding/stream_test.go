@@ -0,0 +1,61 @@
+package encoding
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewJSONStreamDecoder(t *testing.T) {
+	body := `{"id":1,"name":"supu","tags":["a",{"b":[1,2.5]}],"blob":{"x":[[1],[2]]},"ok":true,"none":null}`
+	for _, tc := range []struct {
+		name string
+		opts StreamOptions
+		body string
+		want string
+	}{
+		{"all", StreamOptions{}, body, body},
+		{"fields", StreamOptions{Fields: []string{"id", "tags"}}, body, `{"id":1,"tags":["a",{"b":[1,2.5]}]}`},
+		{"skip", StreamOptions{Skip: []string{"blob", "tags"}}, body, `{"id":1,"name":"supu","none":null,"ok":true}`},
+		{"collection", StreamOptions{CollectionKey: "users"}, `[{"id":1},{"id":2}]`, `{"users":[{"id":1},{"id":2}]}`},
+		{"depth", StreamOptions{MaxDepth: 4}, body, body},
+		{"size", StreamOptions{MaxSize: int64(len(body))}, body, body},
+	} {
+		var data map[string]interface{}
+		if err := NewJSONStreamDecoder(tc.opts)(strings.NewReader(tc.body), &data); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		var want map[string]interface{}
+		json.Unmarshal([]byte(tc.want), &want)
+		w, _ := json.Marshal(want)
+		if b, _ := json.Marshal(data); string(b) != string(w) {
+			t.Errorf("%s: want %s, have %s", tc.name, w, b)
+		}
+	}
+}
+
+func TestNewJSONStreamDecoder_limits(t *testing.T) {
+	body := `{"a":{"b":{"c":[1]}}}`
+	for _, tc := range []struct {
+		name string
+		opts StreamOptions
+		want error
+	}{
+		{"depth", StreamOptions{MaxDepth: 3}, ErrMaxDepth},
+		{"skipped depth", StreamOptions{MaxDepth: 3, Skip: []string{"a"}}, ErrMaxDepth},
+		{"size", StreamOptions{MaxSize: 10}, ErrMaxSize},
+	} {
+		var data map[string]interface{}
+		if err := NewJSONStreamDecoder(tc.opts)(strings.NewReader(body), &data); err != tc.want {
+			t.Errorf("%s: want %v, have %v", tc.name, tc.want, err)
+		}
+	}
+
+	var data map[string]interface{}
+	for _, body := range []string{`[1]`, `"supu"`, `{"a":[1,`} {
+		if err := NewJSONStreamDecoder(StreamOptions{})(strings.NewReader(body), &data); err == nil {
+			t.Errorf("expecting an error for %s", body)
+		}
+	}
+}
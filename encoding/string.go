@@ -0,0 +1,23 @@
+package encoding
+
+import "io"
+
+// DefaultStringKey is the key of the bodies decoded by the string decoders without one. It is the
+// field sent by the string and no-op output encodings
+const DefaultStringKey = "content"
+
+// NewStringDecoder returns a decoder storing the body of the responses as a string under the key, so
+// the text and HTML backends can be merged with the rest instead of failing to decode
+func NewStringDecoder(key string) Decoder {
+	if key == "" {
+		key = DefaultStringKey
+	}
+	return func(r io.Reader, v *map[string]interface{}) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		*v = map[string]interface{}{key: string(b)}
+		return nil
+	}
+}
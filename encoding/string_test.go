@@ -0,0 +1,23 @@
+package encoding
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewStringDecoder(t *testing.T) {
+	for _, tc := range []struct {
+		key, want string
+	}{
+		{"", "content"},
+		{"html", "html"},
+	} {
+		var data map[string]interface{}
+		if err := NewStringDecoder(tc.key)(strings.NewReader("<p>supu</p>\n"), &data); err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 1 || data[tc.want] != "<p>supu</p>\n" {
+			t.Errorf("unexpected data for the key %q: %v", tc.key, data)
+		}
+	}
+}
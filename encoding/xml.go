@@ -2,9 +2,84 @@ package encoding
 
 import (
 	"encoding/xml"
+	"errors"
 	"io"
+	"strings"
 )
 
+// Keys of the XML attributes and character data in the decoded maps
+const (
+	// XMLAttributePrefix prefixes the names of the attributes of the elements
+	XMLAttributePrefix = "-"
+	// XMLTextKey holds the character data of the elements with attributes or children
+	XMLTextKey = "#text"
+)
+
+// XMLDecoder decodes the XML responses like mxj does: the root element is the only key of the map, the
+// attributes are stored with the - prefix, the repeated elements are stored as arrays and the character
+// data of the elements with attributes or children under #text. The elements with just text are stored
+// as strings. The namespace prefixes are dropped
 func XMLDecoder(r io.Reader, v *map[string]interface{}) error {
-	return xml.NewDecoder(r).Decode(v)
+	d := xml.NewDecoder(r)
+	var stack []*xmlNode
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return errors.New("the XML response has no root element")
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local, data: map[string]interface{}{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				n.data[XMLAttributePrefix+attr.Name.Local] = attr.Value
+			}
+			stack = append(stack, n)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				*v = map[string]interface{}{n.name: n.value()}
+				return nil
+			}
+			stack[len(stack)-1].add(n.name, n.value())
+		}
+	}
+}
+
+type xmlNode struct {
+	name string
+	data map[string]interface{}
+	text strings.Builder
+}
+
+func (n *xmlNode) add(name string, value interface{}) {
+	switch prev := n.data[name].(type) {
+	case nil:
+		n.data[name] = value
+	case []interface{}:
+		n.data[name] = append(prev, value)
+	default:
+		n.data[name] = []interface{}{prev, value}
+	}
+}
+
+func (n *xmlNode) value() interface{} {
+	text := strings.TrimSpace(n.text.String())
+	if len(n.data) == 0 {
+		return text
+	}
+	if text != "" {
+		n.data[XMLTextKey] = text
+	}
+	return n.data
 }
@@ -0,0 +1,48 @@
+package encoding
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestXMLDecoder(t *testing.T) {
+	for _, tc := range []struct {
+		body, want string
+	}{
+		{
+			`<?xml version="1.0"?><user id="1"><name>supu</name></user>`,
+			`{"user":{"-id":"1","name":"supu"}}`,
+		},
+		{
+			`<users xmlns="urn:acme"><user id="1"><name>supu</name><tag>a</tag><tag>b</tag><tag>c</tag></user><user id="2"/></users>`,
+			`{"users":{"user":[{"-id":"1","name":"supu","tag":["a","b","c"]},{"-id":"2"}]}}`,
+		},
+		{
+			"<price currency=\"EUR\">\n\t10.5\n</price>",
+			`{"price":{"#text":"10.5","-currency":"EUR"}}`,
+		},
+		{
+			`<a:doc xmlns:a="urn:a"><!-- comment --><a:empty></a:empty><a:mixed>x<b>y</b>z</a:mixed></a:doc>`,
+			`{"doc":{"empty":"","mixed":{"#text":"xz","b":"y"}}}`,
+		},
+	} {
+		var data map[string]interface{}
+		if err := XMLDecoder(strings.NewReader(tc.body), &data); err != nil {
+			t.Error(err)
+			continue
+		}
+		if b, _ := json.Marshal(data); string(b) != tc.want {
+			t.Errorf("%s: want %s, have %s", tc.body, tc.want, b)
+		}
+	}
+}
+
+func TestXMLDecoder_malformed(t *testing.T) {
+	for _, body := range []string{``, `<user><name>supu</user>`, `<user>`} {
+		var data map[string]interface{}
+		if err := XMLDecoder(strings.NewReader(body), &data); err == nil {
+			t.Errorf("expecting an error for %q", body)
+		}
+	}
+}
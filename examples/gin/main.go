@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -12,21 +14,49 @@ import (
 	"github.com/gin-gonic/contrib/cache"
 
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/config/policy"
+	"github.com/ph0m1/porta/config/remote"
 	"github.com/ph0m1/porta/config/viper"
+	"github.com/ph0m1/porta/lifecycle"
 	"github.com/ph0m1/porta/logging"
 	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/logging/syslog"
+	"github.com/ph0m1/porta/monitoring"
 	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router"
 	pgin "github.com/ph0m1/porta/router/gin"
+	"github.com/ph0m1/porta/run"
+	"github.com/ph0m1/porta/tracing"
 )
 
 func main() {
 	port := flag.Int("p", 0, "Port of the service")
-	logLevel := flag.String("l", "ERROR", "Logging level")
+	logLevel := flag.String("l", "", "Logging level. The one of the config or ERROR if empty")
 	debug := flag.Bool("d", true, "Enable the debug")
 	configFile := flag.String("c", "../etc/config.yaml", "Path to the configuration filename")
+	watch := flag.Bool("w", false, "Reload the endpoints when the configuration file changes")
+	profile := flag.String("profile", "", "Profile adjusting the defaults (dev or prod). $PORTA_PROFILE if empty")
+	store := flag.String("r", "", "Remote config store (consul://host:port or etcd://host:port). The -c flag is the key of the config")
+	policyFile := flag.String("policy", "", "Path to the file with the organizational rules the configs must follow")
 	flag.Parse()
 
-	parser := viper.New()
+	var parser config.Parser = viper.New()
+	var provider remote.Provider
+	if *store != "" {
+		var err error
+		if provider, err = remote.New(*store); err != nil {
+			log.Fatal("ERROR:", err.Error())
+		}
+		parser = remote.Parser{Provider: provider}
+	}
+	var rules *policy.Engine
+	if *policyFile != "" {
+		var err error
+		if rules, err = policy.ParseFile(*policyFile); err != nil {
+			log.Fatal("ERROR:", err.Error())
+		}
+		parser = policy.NewParser(parser, rules)
+	}
 	serviceConfig, err := parser.Parse(*configFile)
 	if err != nil {
 		log.Fatal("ERROR:", err.Error())
@@ -35,15 +65,47 @@ func main() {
 	if *port != 0 {
 		serviceConfig.Port = *port
 	}
+	if *profile != "" {
+		if err := serviceConfig.ApplyProfile(*profile); err != nil {
+			log.Fatal("ERROR:", err.Error())
+		}
+	}
 
-	logger, err := gologging.NewLogger(*logLevel, os.Stdout, "[X_X]")
+	level := *logLevel
+	if level == "" {
+		level = serviceConfig.LogLevel
+	}
+	if level == "" {
+		level = "ERROR"
+	}
+	var logOut io.Writer = os.Stdout
+	if serviceConfig.Syslog != nil {
+		w, err := syslog.New(*serviceConfig.Syslog)
+		if err != nil {
+			log.Fatal("ERROR:", err.Error())
+		}
+		defer w.Close()
+		logOut = w
+	}
+	logger, err := gologging.NewLogger(level, logOut, "[X_X]")
 	if err != nil {
 		log.Fatal("ERROR:", err.Error())
 	}
 
+	if serviceConfig.StartupProbe != nil {
+		report := monitoring.ProbeBackends(context.Background(), serviceConfig)
+		report.WriteTo(os.Stdout)
+		if err := report.Err(); err != nil {
+			if serviceConfig.StartupProbe.Strict {
+				log.Fatal("ERROR:", err.Error())
+			}
+			logger.Warning(err.Error())
+		}
+	}
+
 	//routerFactory := gin.DefaultFactory(proxy.DefaultFactory(logger), logger)
 
-	store := cache.NewInMemoryStore(time.Minute)
+	cacheStore := cache.NewInMemoryStore(time.Minute)
 
 	mws := []gin.HandlerFunc{
 		// 暂时注释掉 secure 中间件来测试
@@ -61,18 +123,104 @@ func main() {
 		// }),
 		limit.MaxAllowed(20),
 	}
+
+	// the background work is stopped along with the router
+	manager := run.New(0, 30*time.Second)
+	background := lifecycle.NewGroup(context.Background())
+	manager.Add("background", lifecycle.Funcs{
+		StartFunc: func(_ context.Context) error { return nil },
+		StopFunc:  background.Stop,
+	})
+	routerDeps := []string{"background"}
+
+	proxyFactory := proxy.DefaultFactory(logger)
+	tracer, err := tracing.New(serviceConfig, logger)
+	if err != nil {
+		log.Fatal("ERROR:", err.Error())
+	}
+	if tracer != nil {
+		manager.Add("tracer", lifecycle.Funcs{
+			StartFunc: func(_ context.Context) error { return nil },
+			StopFunc:  func(_ context.Context) error { return tracer.Close() },
+		})
+		routerDeps = append(routerDeps, "tracer")
+		mws = append([]gin.HandlerFunc{tracingMiddleware(tracer)}, mws...)
+		proxyFactory = proxy.NewDefaultFactory(proxy.HookedBackendFactory(proxy.CustomHTTPProxyFactory(proxy.NewHttpClient), tracer.BackendHook()), logger)
+	}
+	var metrics monitoring.Recorder
+	if tracker := monitoring.NewSLOTracker(serviceConfig, logger); tracker.Len() > 0 {
+		background.Go(tracker.Run)
+		recorder, err := monitoring.NewRecorder(serviceConfig)
+		if err != nil {
+			log.Fatal("ERROR:", err.Error())
+		}
+		metrics = tracker.Recorder(recorder)
+	}
 	// routerFactory := gin.DefaultFactory(proxy.DefaultFactory(logger), logger)
 	routerFactory := pgin.NewFactory(pgin.Config{
 		Engine:       gin.Default(),
-		ProxyFactory: customProxyFactory{logger, proxy.DefaultFactory(logger)},
+		ProxyFactory: customProxyFactory{logger, proxyFactory},
 		Middlewares:  mws,
 		Logger:       logger,
+		Metrics:      metrics,
 		HandlerFactory: func(configuration *config.EndpointConfig, proxy proxy.Proxy) gin.HandlerFunc {
-			return cache.CachePage(store, configuration.CacheTTL, pgin.EndpointHandler(configuration, proxy))
+			return cache.CachePage(cacheStore, configuration.CacheTTL, pgin.EndpointHandler(configuration, proxy))
 		},
+		NewEngine: func() *gin.Engine { return gin.Default() },
 	})
 
-	routerFactory.New().Run(serviceConfig)
+	r := routerFactory.New()
+	if *watch {
+		onChange := func(cfg config.ServiceConfig) {
+			// the remote watcher parses the documents by itself
+			if rules != nil {
+				if err := rules.Validate(&cfg); err != nil {
+					logger.Error("reloading the config:", err.Error())
+					return
+				}
+			}
+			cfg.Debug = cfg.Debug || *debug
+			if *profile != "" {
+				if err := cfg.ApplyProfile(*profile); err != nil {
+					logger.Error("reloading the config:", err.Error())
+					return
+				}
+			}
+			if err := router.Reload(r, cfg); err != nil {
+				logger.Error("reloading the config:", err.Error())
+			}
+		}
+		onError := func(err error) { logger.Error("parsing the config:", err.Error()) }
+		if provider != nil {
+			w := remote.Watcher{Provider: provider, Key: *configFile, OnChange: onChange, OnError: onError}
+			background.Go(func(ctx context.Context) { w.Watch(ctx) })
+		} else {
+			w := config.Watcher{Parser: parser, Path: *configFile, OnChange: onChange, OnError: onError}
+			background.Go(func(ctx context.Context) { w.Watch(ctx) })
+		}
+	}
+
+	if len(serviceConfig.SyntheticProbes) > 0 {
+		background.Go(monitoring.NewProber(serviceConfig, logger).Run)
+	}
+
+	manager.Add("router", run.Router(r, serviceConfig), routerDeps...)
+
+	ctx, stop := router.SignalContext()
+	defer stop()
+	if err := manager.Run(ctx); err != nil {
+		log.Fatal("ERROR:", err.Error())
+	}
+}
+
+// tracingMiddleware records the server span of the requests
+func tracingMiddleware(tracer *tracing.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, span := tracer.StartRequest(c.Request)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		tracing.FinishRequest(span, c.Writer.Status())
+	}
 }
 
 type customProxyFactory struct {
@@ -85,3 +233,10 @@ func (cf customProxyFactory) New(cfg *config.EndpointConfig) (p proxy.Proxy, err
 	p, err = cf.factory.New(cfg)
 	return
 }
+
+// WithGroup implements the proxy.GroupFactory interface, so the background work of the proxies ends with
+// the config they serve
+func (cf customProxyFactory) WithGroup(g *lifecycle.Group) proxy.Factory {
+	cf.factory = proxy.WithGroup(cf.factory, g)
+	return cf
+}
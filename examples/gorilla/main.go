@@ -10,6 +10,7 @@ import (
 
 	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/config/viper"
+	"github.com/ph0m1/porta/lifecycle"
 	"github.com/ph0m1/porta/logging"
 	"github.com/ph0m1/porta/logging/gologging"
 	"github.com/ph0m1/porta/proxy"
@@ -52,22 +53,37 @@ func main() {
 		ContentSecurityPolicy: "default-src 'self'",
 	})
 
-	cfg := gorilla.DefaultConfig(customProxyFactory{logger: logger, factory: proxy.DefaultFactory(logger)}, logger)
+	var limiter *proxy.LogLimiter
+	if l := serviceConfig.LogRateLimit; l != nil {
+		limiter = proxy.NewLogLimiter(l.Burst, l.Interval)
+	}
+	pf := customProxyFactory{logger: logger, factory: proxy.DefaultFactory(logger), limiter: limiter}
+	cfg := gorilla.DefaultConfig(pf, logger)
 	cfg.Middlewares = append(cfg.Middlewares, secureMiddleware)
 	routerFactory := mux.NewFactory(cfg)
-	routerFactory.New().Run(serviceConfig)
+	if err := routerFactory.New().Run(serviceConfig); err != nil {
+		log.Fatal("ERROR:", err.Error())
+	}
 }
 
 type customProxyFactory struct {
 	logger  logging.Logger
 	factory proxy.Factory
+	limiter *proxy.LogLimiter
 }
 
 // New implements the Factory interface
 func (cf customProxyFactory) New(cfg *config.EndpointConfig) (p proxy.Proxy, err error) {
 	p, err = cf.factory.New(cfg)
-	if err != nil {
-		p = proxy.NewLoggingMiddleware(cf.logger, cfg.Endpoint)(p)
+	if err == nil {
+		p = proxy.NewLimitedLoggingMiddleware(cf.logger, cfg.Endpoint, cf.limiter)(p)
 	}
 	return
 }
+
+// WithGroup implements the proxy.GroupFactory interface, so the background work of the proxies ends with
+// the config they serve
+func (cf customProxyFactory) WithGroup(g *lifecycle.Group) proxy.Factory {
+	cf.factory = proxy.WithGroup(cf.factory, g)
+	return cf
+}
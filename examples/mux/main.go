@@ -7,6 +7,7 @@ import (
 
 	"gopkg.in/unrolled/secure.v1"
 
+	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/config/viper"
 	"github.com/ph0m1/porta/logging/gologging"
 	"github.com/ph0m1/porta/proxy"
@@ -21,6 +22,7 @@ func main() {
 	flag.Parse()
 
 	parser := viper.New()
+	config.RoutingPattern = config.ServeMuxRouterPatternBuilder
 	serviceConfig, err := parser.Parse(*configFile)
 	if err != nil {
 		log.Fatal("ERROR:", err.Error())
@@ -57,5 +59,7 @@ func main() {
 		HandlerFactory: mux.EndpointHandler,
 	})
 
-	routerFactory.New().Run(serviceConfig)
+	if err := routerFactory.New().Run(serviceConfig); err != nil {
+		log.Fatal("ERROR:", err.Error())
+	}
 }
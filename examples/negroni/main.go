@@ -12,6 +12,7 @@ import (
 	"github.com/zbindenren/negroni-prometheus"
 	"gopkg.in/unrolled/secure.v1"
 
+	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/config/viper"
 	"github.com/ph0m1/porta/logging/gologging"
 	"github.com/ph0m1/porta/proxy"
@@ -26,6 +27,7 @@ func main() {
 	flag.Parse()
 
 	parser := viper.New()
+	config.RoutingPattern = config.ServeMuxRouterPatternBuilder
 	serviceConfig, err := parser.Parse(*configFile)
 	if err != nil {
 		log.Fatal("ERROR:", err.Error())
@@ -60,7 +62,9 @@ func main() {
 		Logger:         logger,
 		HandlerFactory: mux.EndpointHandler,
 	})
-	routerFactory.New().Run(serviceConfig)
+	if err := routerFactory.New().Run(serviceConfig); err != nil {
+		log.Fatal("ERROR:", err.Error())
+	}
 
 }
 
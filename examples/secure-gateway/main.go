@@ -8,15 +8,15 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
-	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/config/policy"
 	"github.com/ph0m1/porta/config/viper"
 	"github.com/ph0m1/porta/logging"
 	"github.com/ph0m1/porta/logging/gologging"
 	"github.com/ph0m1/porta/monitoring"
-	"github.com/ph0m1/porta/proxy"
-	"github.com/ph0m1/porta/router/gin"
+	"github.com/ph0m1/porta/router"
+	pgin "github.com/ph0m1/porta/router/gin"
+	"github.com/ph0m1/porta/run"
 	"github.com/ph0m1/porta/security"
 )
 
@@ -26,10 +26,19 @@ func main() {
 	debug := flag.Bool("d", false, "Enable debug mode")
 	configFile := flag.String("c", "../etc/config.yaml", "Path to the configuration filename")
 	securityFile := flag.String("s", "../etc/security.yaml", "Path to the security configuration filename")
+	policyFile := flag.String("policy", "", "Path to the file with the organizational rules the configs must follow")
 	flag.Parse()
 
-	// Parse main configuration
+	// Parse main configuration, following the policy if any
 	parser := viper.New()
+	var rules *policy.Engine
+	if *policyFile != "" {
+		var err error
+		if rules, err = policy.ParseFile(*policyFile); err != nil {
+			log.Fatal("ERROR:", err.Error())
+		}
+		parser = policy.NewParser(parser, rules)
+	}
 	serviceConfig, err := parser.Parse(*configFile)
 	if err != nil {
 		log.Fatal("ERROR:", err.Error())
@@ -45,6 +54,12 @@ func main() {
 		log.Printf("WARNING: Could not load security config: %v", err)
 		securityConfig = getDefaultSecurityConfig()
 	}
+	if rules != nil {
+		cors := &security.CORSConfig{AllowedOrigins: securityConfig.CORS.AllowedOrigins, AllowCredentials: securityConfig.CORS.AllowCredentials}
+		if err := rules.ValidateCORS(cors); err != nil {
+			log.Fatal("ERROR:", err.Error())
+		}
+	}
 
 	// Create logger
 	logger, err := gologging.NewLogger(*logLevel, os.Stdout, "[PORTA-SECURE]")
@@ -53,12 +68,13 @@ func main() {
 	}
 
 	// Initialize metrics
-	metrics := monitoring.NewMetrics()
+	metrics, err := monitoring.NewRecorder(serviceConfig)
+	if err != nil {
+		log.Fatal("ERROR:", err.Error())
+	}
 
-	// Initialize health checker
+	// Initialize health checker, started along with the router
 	healthChecker := monitoring.CreateDefaultHealthChecks(&serviceConfig)
-	healthChecker.Start()
-	defer healthChecker.Stop()
 
 	// Create Gin engine
 	if !serviceConfig.Debug {
@@ -67,28 +83,33 @@ func main() {
 	engine := gin.New()
 
 	// Add middleware stack
-	setupMiddleware(engine, securityConfig, metrics, logger, healthChecker)
-
-	// Create proxy factory with monitoring
-	proxyFactory := newMonitoredProxyFactory(proxy.DefaultFactory(logger), metrics, logger)
+	rateLimiter := setupMiddleware(engine, securityConfig, metrics, logger, healthChecker)
 
-	// Create router factory
+	// Create router factory, recording the requests and the backend calls
 	routerFactory := pgin.NewFactory(pgin.Config{
-		Engine:       engine,
-		ProxyFactory: proxyFactory,
-		Logger:       logger,
-		HandlerFactory: func(configuration *config.EndpointConfig, proxy proxy.Proxy) gin.HandlerFunc {
-			return newMonitoredHandler(configuration, proxy, metrics)
-		},
+		Engine:         engine,
+		ProxyFactory:   monitoring.ProxyFactory(metrics, logger),
+		Logger:         logger,
+		HandlerFactory: pgin.EndpointHandler,
+		Metrics:        metrics,
 	})
 
-	// Start the gateway
+	// Start the gateway with its health checker and stop them along with the rate limiter on shutdown
+	manager := run.New(0, 30*time.Second)
+	manager.Add("health", healthChecker.Component())
+	manager.Add("ratelimit", run.StopFunc(rateLimiter.Stop))
+	manager.Add("router", run.Router(routerFactory.New(), serviceConfig), "health", "ratelimit")
+
+	ctx, stop := router.SignalContext()
+	defer stop()
 	logger.Info("Starting Porta Gateway with enhanced security and monitoring...")
-	routerFactory.New().Run(serviceConfig)
+	if err := manager.Run(ctx); err != nil {
+		log.Fatal("ERROR:", err.Error())
+	}
 }
 
-// setupMiddleware configures all middleware
-func setupMiddleware(engine *gin.Engine, securityConfig *SecurityConfig, metrics *monitoring.Metrics, logger logging.Logger, healthChecker *monitoring.HealthChecker) {
+// setupMiddleware configures all middleware, returning the rate limiter to be stopped on shutdown
+func setupMiddleware(engine *gin.Engine, securityConfig *SecurityConfig, metrics monitoring.Recorder, logger logging.Logger, healthChecker *monitoring.HealthChecker) *security.TokenBucketLimiter {
 	// Recovery middleware
 	engine.Use(gin.Recovery())
 
@@ -143,20 +164,21 @@ func setupMiddleware(engine *gin.Engine, securityConfig *SecurityConfig, metrics
 	}
 
 	// Request logging middleware
-	engine.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return logger.Info(
-			"method", param.Method,
-			"path", param.Path,
-			"status", param.StatusCode,
-			"latency", param.Latency,
-			"ip", param.ClientIP,
-			"user_agent", param.Request.UserAgent(),
-		)
-		return ""
-	}))
+	engine.Use(func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		logger.With(
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+			"ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+		).Info("request")
+	})
 
 	// Add monitoring endpoints
-	engine.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	engine.GET("/metrics", gin.WrapH(metrics.Handler()))
 	engine.GET("/__health", gin.WrapH(healthChecker.HTTPHandler()))
 	engine.GET("/__ready", gin.WrapH(healthChecker.ReadinessHandler()))
 	engine.GET("/__live", gin.WrapH(monitoring.LivenessHandler()))
@@ -164,63 +186,10 @@ func setupMiddleware(engine *gin.Engine, securityConfig *SecurityConfig, metrics
 	// Add admin endpoints (if auth is enabled)
 	if securityConfig.Auth.Enabled {
 		adminGroup := engine.Group("/admin")
-		adminGroup.GET("/metrics", gin.WrapH(promhttp.Handler()))
+		adminGroup.GET("/metrics", gin.WrapH(metrics.Handler()))
 		adminGroup.GET("/health", gin.WrapH(healthChecker.HTTPHandler()))
 	}
-}
-
-// newMonitoredProxyFactory creates a proxy factory with monitoring
-func newMonitoredProxyFactory(factory proxy.Factory, metrics *monitoring.Metrics, logger logging.Logger) proxy.Factory {
-	return &monitoredProxyFactory{
-		factory: factory,
-		metrics: metrics,
-		logger:  logger,
-	}
-}
-
-type monitoredProxyFactory struct {
-	factory proxy.Factory
-	metrics *monitoring.Metrics
-	logger  logging.Logger
-}
-
-func (mpf *monitoredProxyFactory) New(cfg *config.EndpointConfig) (proxy.Proxy, error) {
-	p, err := mpf.factory.New(cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	// Wrap proxy with monitoring
-	return proxy.NewLoggingMiddleware(mpf.logger, cfg.Endpoint)(p), nil
-}
-
-// newMonitoredHandler creates a handler with monitoring
-func newMonitoredHandler(cfg *config.EndpointConfig, p proxy.Proxy, metrics *monitoring.Metrics) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		start := time.Now()
-
-		// Increment in-flight requests
-		metrics.IncRequestsInFlight(c.Request.Method, cfg.Endpoint)
-		defer metrics.DecRequestsInFlight(c.Request.Method, cfg.Endpoint)
-
-		// Call the original handler
-		pgin.EndpointHandler(cfg, p)(c)
-
-		// Record metrics
-		duration := time.Since(start)
-		statusCode := c.Writer.Status()
-		requestSize := c.Request.ContentLength
-		responseSize := int64(c.Writer.Size())
-
-		metrics.RecordRequest(
-			c.Request.Method,
-			cfg.Endpoint,
-			string(rune(statusCode)),
-			duration,
-			requestSize,
-			responseSize,
-		)
-	}
+	return rateLimiter
 }
 
 // SecurityConfig represents the security configuration structure
@@ -264,7 +233,6 @@ type SecurityConfig struct {
 
 // parseSecurityConfig parses the security configuration file
 func parseSecurityConfig(filename string) (*SecurityConfig, error) {
-	parser := viper.New()
 	// This is a simplified version - in reality you'd need to implement
 	// proper YAML parsing for the security config
 	return getDefaultSecurityConfig(), nil
@@ -0,0 +1,28 @@
+// Package leaktest integrates the goroutine leak detector in the tests of the gateway and of the
+// applications embedding it
+package leaktest
+
+import (
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+// Options returns the goleak options ignoring the goroutines that outlive the tests by design, like
+// the idle keep-alive connections of the shared http transports
+func Options(extra ...goleak.Option) []goleak.Option {
+	return append([]goleak.Option{
+		goleak.IgnoreAnyFunction("net/http.(*persistConn).readLoop"),
+		goleak.IgnoreAnyFunction("net/http.(*persistConn).writeLoop"),
+	}, extra...)
+}
+
+// VerifyTestMain runs the tests of the package and fails if any goroutine is still running once they finish
+func VerifyTestMain(m *testing.M, extra ...goleak.Option) {
+	goleak.VerifyTestMain(m, Options(extra...)...)
+}
+
+// VerifyNone fails the test if there are goroutines running other than the ones of the test runner
+func VerifyNone(t testing.TB, extra ...goleak.Option) {
+	goleak.VerifyNone(t, Options(extra...)...)
+}
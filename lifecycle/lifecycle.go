@@ -0,0 +1,87 @@
+// Package lifecycle provides the primitives used by the components running background goroutines, so
+// the applications embedding the gateway can shut all of them down cleanly
+package lifecycle
+
+import (
+	"context"
+	"sync"
+)
+
+// Component is a part of the gateway running in the background until it is stopped
+type Component interface {
+	// Start launches the background work of the component. The work ends when the context is done
+	// or when the component is stopped
+	Start(ctx context.Context) error
+	// Stop ends the background work and waits for it to finish or for the context to be done
+	Stop(ctx context.Context) error
+}
+
+// Funcs adapts a pair of functions to the Component interface
+type Funcs struct {
+	StartFunc func(ctx context.Context) error
+	StopFunc  func(ctx context.Context) error
+}
+
+// Start implements the Component interface
+func (f Funcs) Start(ctx context.Context) error { return f.StartFunc(ctx) }
+
+// Stop implements the Component interface
+func (f Funcs) Stop(ctx context.Context) error { return f.StopFunc(ctx) }
+
+// Group runs a set of goroutines bound to a context and waits for all of them when it is stopped
+type Group struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	stopped bool
+}
+
+// NewGroup returns a group whose goroutines are canceled when the parent context is done
+func NewGroup(parent context.Context) *Group {
+	ctx, cancel := context.WithCancel(parent)
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Go runs the function in a new goroutine of the group. The function must return once the received
+// context is done. The functions started after the group is stopped receive a done context and are not
+// waited for
+func (g *Group) Go(f func(ctx context.Context)) {
+	g.mu.Lock()
+	if g.stopped {
+		g.mu.Unlock()
+		go f(g.ctx)
+		return
+	}
+	g.wg.Add(1)
+	g.mu.Unlock()
+	go func() {
+		defer g.wg.Done()
+		f(g.ctx)
+	}()
+}
+
+// Context returns the context shared by the goroutines of the group
+func (g *Group) Context() context.Context {
+	return g.ctx
+}
+
+// Stop cancels the goroutines of the group and waits for them to return or for the context to be
+// done. It is safe to call it more than once
+func (g *Group) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+	g.cancel()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
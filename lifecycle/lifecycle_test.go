@@ -0,0 +1,67 @@
+package lifecycle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+)
+
+func TestGroup_Stop(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	g := NewGroup(context.Background())
+	for i := 0; i < 3; i++ {
+		g.Go(func(ctx context.Context) { <-ctx.Done() })
+	}
+	if err := g.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err := g.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGroup_Stop_timeout(t *testing.T) {
+	g := NewGroup(context.Background())
+	release := make(chan struct{})
+	g.Go(func(_ context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(release)
+	g.Stop(context.Background())
+}
+
+func TestGroup_parentCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := NewGroup(ctx)
+	done := make(chan struct{})
+	g.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(done)
+	})
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the goroutine was not canceled")
+	}
+}
+
+func TestGroup_Go_afterStop(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	g := NewGroup(context.Background())
+	g.Stop(context.Background())
+
+	done := make(chan error)
+	g.Go(func(ctx context.Context) { done <- ctx.Err() })
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
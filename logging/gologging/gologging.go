@@ -26,30 +26,49 @@ func NewLogger(level string, out io.Writer, prefix string) (logging.Logger, erro
 	}
 	backendLeveled.SetLevel(logLevel, module)
 	gologging.SetBackend(backendLeveled)
-	return Logger{log}, nil
+	return Logger{Logger: log}, nil
 }
 
-// Logger is a wrapper over a github.com/op/go-logging logger
+// Logger is a wrapper over a github.com/op/go-logging logger. Its fields are appended to the lines as
+// key=value pairs
 type Logger struct {
 	Logger *gologging.Logger
+	fields logging.Fields
 }
 
 func (l Logger) Debug(v ...interface{}) {
-	l.Logger.Debug(v)
+	l.Logger.Debug(l.args(v))
 }
 
 func (l Logger) Info(v ...interface{}) {
-	l.Logger.Info(v)
+	l.Logger.Info(l.args(v))
 }
 func (l Logger) Warning(v ...interface{}) {
-	l.Logger.Warning(v)
+	l.Logger.Warning(l.args(v))
 }
 func (l Logger) Error(v ...interface{}) {
-	l.Logger.Error(v)
+	l.Logger.Error(l.args(v))
 }
 func (l Logger) Critical(v ...interface{}) {
-	l.Logger.Critical(v)
+	l.Logger.Critical(l.args(v))
 }
 func (l Logger) Fatal(v ...interface{}) {
-	l.Logger.Fatal(v)
+	l.Logger.Fatal(l.args(v))
+}
+
+// WithFields implements the logging.Logger interface
+func (l Logger) WithFields(fields logging.Fields) logging.Logger {
+	return Logger{Logger: l.Logger, fields: l.fields.Merge(fields)}
+}
+
+// With implements the logging.Logger interface
+func (l Logger) With(keysAndValues ...interface{}) logging.Logger {
+	return l.WithFields(logging.KeyValues(keysAndValues...))
+}
+
+func (l Logger) args(v []interface{}) []interface{} {
+	if len(l.fields) == 0 {
+		return v
+	}
+	return append(v[:len(v):len(v)], l.fields.String())
 }
@@ -1,5 +1,11 @@
 package logging
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 type Logger interface {
 	Debug(v ...interface{})
 	Info(v ...interface{})
@@ -7,4 +13,58 @@ type Logger interface {
 	Error(v ...interface{})
 	Critical(v ...interface{})
 	Fatal(v ...interface{})
+	// WithFields returns a logger adding the fields to all its lines
+	WithFields(fields Fields) Logger
+	// With returns a logger adding the alternated keys and values to all its lines, like
+	// logger.With("backend", url, "status", 503)
+	With(keysAndValues ...interface{}) Logger
+}
+
+// Fields are the structured data of the log lines, by key
+type Fields map[string]interface{}
+
+// KeyValues returns the fields of the alternated keys and values. The keys are formatted as strings and
+// a trailing key without value is kept with a nil one
+func KeyValues(keysAndValues ...interface{}) Fields {
+	fields := make(Fields, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 < len(keysAndValues) {
+			fields[key] = keysAndValues[i+1]
+		} else {
+			fields[key] = nil
+		}
+	}
+	return fields
+}
+
+// Merge returns the fields of f overridden by the ones of other, leaving both untouched
+func (f Fields) Merge(other Fields) Fields {
+	res := make(Fields, len(f)+len(other))
+	for k, v := range f {
+		res[k] = v
+	}
+	for k, v := range other {
+		res[k] = v
+	}
+	return res
+}
+
+// String formats the fields as key=value pairs sorted by key, quoting the values with spaces, for the
+// loggers writing plain text lines
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		v := fmt.Sprint(f[k])
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		parts[i] = k + "=" + v
+	}
+	return strings.Join(parts, " ")
 }
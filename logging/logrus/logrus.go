@@ -0,0 +1,91 @@
+// Package logrus provides a logger implementation based on the github.com/sirupsen/logrus pkg
+package logrus
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ph0m1/porta/logging"
+)
+
+// NewLogger returns a logger writing text lines to out, skipping the ones below the level (DEBUG, INFO,
+// WARNING, ERROR or CRITICAL). The prefix is added to the lines as the logger field
+func NewLogger(level string, out io.Writer, prefix string) (logging.Logger, error) {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		fmt.Fprintln(out, "ERROR:", err.Error())
+		return nil, err
+	}
+	l := logrus.New()
+	l.SetOutput(out)
+	l.SetLevel(lvl)
+	l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	logger := NewFromLogrus(l)
+	if prefix != "" {
+		return logger.With("logger", prefix), nil
+	}
+	return logger, nil
+}
+
+// NewFromLogrus wraps a logrus logger configured by the application, keeping its formatter, output,
+// level and hooks
+func NewFromLogrus(l *logrus.Logger) Logger {
+	return Logger{Logger: logrus.NewEntry(l)}
+}
+
+// Logger is a wrapper over a logrus entry. The panic level of logrus panics, so the critical lines are
+// logged at the error one
+type Logger struct {
+	Logger *logrus.Entry
+}
+
+func (l Logger) Debug(v ...interface{}) {
+	l.Logger.Debugln(v...)
+}
+
+func (l Logger) Info(v ...interface{}) {
+	l.Logger.Infoln(v...)
+}
+
+func (l Logger) Warning(v ...interface{}) {
+	l.Logger.Warningln(v...)
+}
+
+func (l Logger) Error(v ...interface{}) {
+	l.Logger.Errorln(v...)
+}
+
+func (l Logger) Critical(v ...interface{}) {
+	l.Logger.Errorln(v...)
+}
+
+func (l Logger) Fatal(v ...interface{}) {
+	l.Logger.Fatalln(v...)
+}
+
+// WithFields implements the logging.Logger interface
+func (l Logger) WithFields(fields logging.Fields) logging.Logger {
+	return Logger{Logger: l.Logger.WithFields(logrus.Fields(fields))}
+}
+
+// With implements the logging.Logger interface
+func (l Logger) With(keysAndValues ...interface{}) logging.Logger {
+	return l.WithFields(logging.KeyValues(keysAndValues...))
+}
+
+func parseLevel(level string) (logrus.Level, error) {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return logrus.DebugLevel, nil
+	case "INFO":
+		return logrus.InfoLevel, nil
+	case "WARNING", "WARN":
+		return logrus.WarnLevel, nil
+	case "ERROR", "CRITICAL":
+		return logrus.ErrorLevel, nil
+	}
+	return logrus.InfoLevel, fmt.Errorf("unknown log level: %s", level)
+}
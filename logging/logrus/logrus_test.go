@@ -0,0 +1,50 @@
+package logrus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/ph0m1/porta/logging"
+)
+
+func TestNewFromLogrus(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logrus.WarnLevel)
+	logger := NewFromLogrus(l)
+
+	logger.Info("skipped")
+	logger.With("backend", "http://supu", "status", 503).Warning("unexpected", "status")
+	logger.WithFields(logging.Fields{"tenant": "acme"}).Critical("down")
+
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("want 2 entries, have %d", len(entries))
+	}
+	if e := entries[0]; e.Level != logrus.WarnLevel || e.Message != "unexpected status" ||
+		e.Data["backend"] != "http://supu" || e.Data["status"] != 503 {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if e := entries[1]; e.Level != logrus.ErrorLevel || e.Data["tenant"] != "acme" || e.Data["backend"] != nil {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := NewLogger("INFO", buf, "gw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("skipped")
+	logger.Info("started")
+	if out := buf.String(); !strings.Contains(out, "msg=started logger=gw") {
+		t.Errorf("unexpected output: %s", out)
+	}
+	if _, err := NewLogger("LOUD", buf, ""); err == nil {
+		t.Error("expecting an error for the unknown level")
+	}
+}
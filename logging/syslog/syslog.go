@@ -0,0 +1,171 @@
+// Package syslog provides a writer sending the lines of the logging backends to a syslog server as
+// RFC 5424 messages, so the gateways can ship their logs without a file tailing agent
+package syslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Severities of the messages
+const (
+	SeverityEmergency = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// Writer sends every line written to it as a syslog message. The severity of the messages is read from
+// the level of the lines of the gologging, zap and logrus backends, info if not found. The connection is
+// opened on the first write and reopened once if a write fails
+type Writer struct {
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+	pid      string
+
+	mu   sync.Mutex
+	conn net.Conn
+	// the stream transports frame the messages with their length
+	framed bool
+}
+
+// New returns the writer of the syslog of the config
+func New(cfg config.Syslog) (*Writer, error) {
+	facility, ok := facilities[cfg.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %s", cfg.Facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &Writer{
+		network:  cfg.Network,
+		address:  cfg.Address,
+		facility: facility,
+		tag:      cfg.Tag,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+		framed:   cfg.Network == "tcp" || cfg.Network == "tls",
+	}, nil
+}
+
+// Write implements the io.Writer interface, sending a message per line of p
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		msg := w.format(time.Now(), severity(line), line)
+		if err := w.send(msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to the server
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// format returns the RFC 5424 message of the line, without structured data nor message id
+func (w *Writer) format(now time.Time, sev int, line []byte) []byte {
+	header := fmt.Sprintf("<%d>1 %s %s %s %s - - ", w.facility*8+sev, now.UTC().Format("2006-01-02T15:04:05.000000Z"), w.hostname, w.tag, w.pid)
+	msg := append([]byte(header), line...)
+	if w.framed {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	return msg
+}
+
+func (w *Writer) send(msg []byte) error {
+	for attempt := 0; ; attempt++ {
+		if w.conn == nil {
+			conn, err := w.dial()
+			if err != nil {
+				return err
+			}
+			w.conn = conn
+		}
+		_, err := w.conn.Write(msg)
+		if err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (w *Writer) dial() (net.Conn, error) {
+	switch w.network {
+	case "":
+		// the local daemons listen on a datagram socket, some on a stream one
+		conn, err := net.Dial("unixgram", w.address)
+		if err != nil {
+			conn, err = net.Dial("unix", w.address)
+		}
+		return conn, err
+	case "tls":
+		return tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", w.address, &tls.Config{})
+	}
+	return net.DialTimeout(w.network, w.address, 5*time.Second)
+}
+
+// levels are the markers of the levels in the lines of the logging backends: gologging, zap and logrus
+var levels = []struct {
+	markers  []string
+	severity int
+}{
+	{[]string{"▶ CRIT", `"level":"dpanic"`, `"level":"panic"`, "level=panic"}, SeverityCritical},
+	{[]string{"▶ ERRO", `"level":"error"`, "level=error"}, SeverityError},
+	{[]string{"▶ WARN", `"level":"warn"`, "level=warning"}, SeverityWarning},
+	{[]string{"▶ INFO", `"level":"info"`, "level=info"}, SeverityInfo},
+	{[]string{"▶ DEBU", `"level":"debug"`, "level=debug"}, SeverityDebug},
+	{[]string{`"level":"fatal"`, "level=fatal"}, SeverityAlert},
+}
+
+func severity(line []byte) int {
+	for _, l := range levels {
+		for _, m := range l.markers {
+			if bytes.Contains(line, []byte(m)) {
+				return l.severity
+			}
+		}
+	}
+	return SeverityInfo
+}
@@ -0,0 +1,94 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestWriter_udp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w, err := New(config.Syslog{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local0", Tag: "porta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("2024/01/01 - 12:00:00.000 ▶ WARN [backend down]\n{\"level\":\"error\",\"msg\":\"x\"}\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	pattern := regexp.MustCompile(`^<(\d+)>1 \d{4}-\d\d-\d\dT[\d:.]+Z \S+ porta \d+ - - (.*)$`)
+	for _, want := range []struct{ pri, msg string }{
+		{"132", "2024/01/01 - 12:00:00.000 ▶ WARN [backend down]"},
+		{"131", `{"level":"error","msg":"x"}`},
+	} {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := pattern.FindStringSubmatch(string(buf[:n]))
+		if m == nil || m[1] != want.pri || m[2] != want.msg {
+			t.Errorf("unexpected message: %q", buf[:n])
+		}
+	}
+}
+
+func TestWriter_tcp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// the messages are framed by their length
+		r := bufio.NewReader(conn)
+		length, _ := r.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		msg := make([]byte, n)
+		io.ReadFull(r, msg)
+		received <- string(msg)
+	}()
+
+	w, err := New(config.Syslog{Network: "tcp", Address: l.Addr().String(), Facility: "user", Tag: "porta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write([]byte("time=now level=debug msg=supu\n"))
+	w.Write([]byte("\n"))
+
+	select {
+	case msg := <-received:
+		if !strings.HasPrefix(msg, "<15>1 ") || !strings.HasSuffix(msg, " - - time=now level=debug msg=supu") {
+			t.Errorf("unexpected message: %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("no message received")
+	}
+}
+
+func TestNew_unknownFacility(t *testing.T) {
+	if _, err := New(config.Syslog{Facility: "local9"}); err == nil {
+		t.Error("expecting an error for the unknown facility")
+	}
+}
@@ -0,0 +1,96 @@
+// Package zap provides a logger implementation based on the go.uber.org/zap pkg
+package zap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/ph0m1/porta/logging"
+)
+
+// NewLogger returns a logger writing JSON lines to out, skipping the ones below the level (DEBUG, INFO,
+// WARNING, ERROR or CRITICAL). The prefix is the name of the logger in the lines
+func NewLogger(level string, out io.Writer, prefix string) (logging.Logger, error) {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		fmt.Fprintln(out, "ERROR:", err.Error())
+		return nil, err
+	}
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderCfg), zapcore.AddSync(out), lvl)
+	l := zap.New(core)
+	if prefix != "" {
+		l = l.Named(prefix)
+	}
+	return NewFromZap(l), nil
+}
+
+// NewFromZap wraps a zap logger configured by the application, keeping its encoders, sinks and hooks
+func NewFromZap(l *zap.Logger) Logger {
+	return Logger{Logger: l.Sugar()}
+}
+
+// Logger is a wrapper over a zap sugared logger. zap has no critical level, so the critical lines are
+// logged at the dpanic one: the loggers built in development mode panic after writing them
+type Logger struct {
+	Logger *zap.SugaredLogger
+}
+
+func (l Logger) Debug(v ...interface{}) {
+	l.Logger.Debugln(v...)
+}
+
+func (l Logger) Info(v ...interface{}) {
+	l.Logger.Infoln(v...)
+}
+
+func (l Logger) Warning(v ...interface{}) {
+	l.Logger.Warnln(v...)
+}
+
+func (l Logger) Error(v ...interface{}) {
+	l.Logger.Errorln(v...)
+}
+
+func (l Logger) Critical(v ...interface{}) {
+	l.Logger.DPanicln(v...)
+}
+
+func (l Logger) Fatal(v ...interface{}) {
+	l.Logger.Fatalln(v...)
+}
+
+// WithFields implements the logging.Logger interface
+func (l Logger) WithFields(fields logging.Fields) logging.Logger {
+	args := make([]interface{}, 0, 2*len(fields))
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	return Logger{Logger: l.Logger.With(args...)}
+}
+
+// With implements the logging.Logger interface
+func (l Logger) With(keysAndValues ...interface{}) logging.Logger {
+	return l.WithFields(logging.KeyValues(keysAndValues...))
+}
+
+func parseLevel(level string) (zapcore.Level, error) {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return zapcore.DebugLevel, nil
+	case "INFO":
+		return zapcore.InfoLevel, nil
+	case "WARNING", "WARN":
+		return zapcore.WarnLevel, nil
+	case "ERROR":
+		return zapcore.ErrorLevel, nil
+	case "CRITICAL":
+		return zapcore.DPanicLevel, nil
+	}
+	return zapcore.InfoLevel, fmt.Errorf("unknown log level: %s", level)
+}
@@ -0,0 +1,46 @@
+package zap
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ph0m1/porta/logging"
+)
+
+func TestNewLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := NewLogger("WARNING", buf, "gw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("skipped")
+	logger.With("backend", "http://supu", "status", 503).Warning("unexpected", "status")
+	logger.WithFields(logging.Fields{"tenant": "acme"}).Critical("down")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, have %q", lines)
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["level"] != "warn" || line["msg"] != "unexpected status" || line["logger"] != "gw" ||
+		line["backend"] != "http://supu" || line["status"] != 503.0 {
+		t.Errorf("unexpected line: %v", line)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["level"] != "dpanic" || line["tenant"] != "acme" {
+		t.Errorf("unexpected line: %v", line)
+	}
+}
+
+func TestNewLogger_unknownLevel(t *testing.T) {
+	if _, err := NewLogger("LOUD", &bytes.Buffer{}, ""); err == nil {
+		t.Error("expecting an error for the unknown level")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/sd"
 )
 
 // HealthStatus represents the health status of a component
@@ -43,7 +45,9 @@ type HealthChecker struct {
 	mu       sync.RWMutex
 	interval time.Duration
 	timeout  time.Duration
-	stopCh   chan struct{}
+	group    *lifecycle.Group
+	// notified of every status change of the checks
+	notifiers []Notifier
 }
 
 // OverallHealth represents the overall health status
@@ -73,7 +77,6 @@ func NewHealthChecker(interval, timeout time.Duration) *HealthChecker {
 		checks:   make(map[string]*HealthCheck),
 		interval: interval,
 		timeout:  timeout,
-		stopCh:   make(chan struct{}),
 	}
 }
 
@@ -89,14 +92,54 @@ func (hc *HealthChecker) RegisterCheck(name string, checkFunc func(ctx context.C
 	}
 }
 
+// AddNotifier registers a notifier receiving the status transitions of the checks
+func (hc *HealthChecker) AddNotifier(n Notifier) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.notifiers = append(hc.notifiers, n)
+}
+
 // Start begins the health checking routine
 func (hc *HealthChecker) Start() {
-	go hc.runChecks()
+	hc.StartContext(context.Background())
+}
+
+// StartContext begins the health checking routine, running it until the context is done or the
+// checker is stopped
+func (hc *HealthChecker) StartContext(ctx context.Context) error {
+	hc.mu.Lock()
+	if hc.group == nil {
+		hc.group = lifecycle.NewGroup(ctx)
+		hc.group.Go(hc.runChecks)
+	}
+	hc.mu.Unlock()
+	return nil
 }
 
-// Stop stops the health checking routine
+// Stop stops the health checking routine and waits for the running checks. It is safe to call it
+// more than once
 func (hc *HealthChecker) Stop() {
-	close(hc.stopCh)
+	hc.StopContext(context.Background())
+}
+
+// StopContext stops the health checking routine and waits for the running checks until the context is done
+func (hc *HealthChecker) StopContext(ctx context.Context) error {
+	hc.mu.RLock()
+	group := hc.group
+	hc.mu.RUnlock()
+	if group == nil {
+		return nil
+	}
+	return group.Stop(ctx)
+}
+
+// Component returns the health checker as a lifecycle.Component. The checks run until the component is
+// stopped, as the context received by Start only bounds the startup
+func (hc *HealthChecker) Component() lifecycle.Component {
+	return lifecycle.Funcs{
+		StartFunc: func(_ context.Context) error { return hc.StartContext(context.Background()) },
+		StopFunc:  hc.StopContext,
+	}
 }
 
 // GetHealth returns the current health status
@@ -134,46 +177,81 @@ func (hc *HealthChecker) GetHealth() OverallHealth {
 	}
 }
 
-// runChecks runs all health checks periodically
-func (hc *HealthChecker) runChecks() {
+// runChecks runs all health checks periodically until the context is done
+func (hc *HealthChecker) runChecks(ctx context.Context) {
 	ticker := time.NewTicker(hc.interval)
 	defer ticker.Stop()
 
 	// Run initial checks
-	hc.executeChecks()
+	hc.executeChecks(ctx)
 
 	for {
 		select {
 		case <-ticker.C:
-			hc.executeChecks()
-		case <-hc.stopCh:
+			hc.executeChecks(ctx)
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-// executeChecks executes all registered health checks
-func (hc *HealthChecker) executeChecks() {
-	hc.mu.Lock()
-	defer hc.mu.Unlock()
-
+// executeChecks executes all registered health checks and waits for them
+func (hc *HealthChecker) executeChecks(ctx context.Context) {
+	hc.mu.RLock()
+	checks := make([]*HealthCheck, 0, len(hc.checks))
 	for _, check := range hc.checks {
-		go hc.executeCheck(check)
+		checks = append(checks, check)
+	}
+	hc.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check *HealthCheck) {
+			hc.executeCheck(ctx, check)
+			wg.Done()
+		}(check)
 	}
+	wg.Wait()
 }
 
 // executeCheck executes a single health check
-func (hc *HealthChecker) executeCheck(check *HealthCheck) {
+func (hc *HealthChecker) executeCheck(parent context.Context, check *HealthCheck) {
 	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+	ctx, cancel := context.WithTimeout(parent, hc.timeout)
 	defer cancel()
 
 	result := check.CheckFunc(ctx)
 
+	hc.mu.Lock()
+	transition := Transition{
+		Check:   check.Name,
+		From:    check.Status,
+		To:      result.Status,
+		Message: result.Message,
+		Time:    time.Now(),
+	}
 	check.Status = result.Status
 	check.Message = result.Message
-	check.LastChecked = time.Now()
+	check.LastChecked = transition.Time
 	check.Duration = time.Since(start)
+	notifiers := hc.notifiers
+	hc.mu.Unlock()
+
+	if transition.From != transition.To {
+		hc.notify(parent, notifiers, transition)
+	}
+}
+
+// notify sends the transition to every notifier, counting the failed deliveries
+func (hc *HealthChecker) notify(parent context.Context, notifiers []Notifier, t Transition) {
+	for _, n := range notifiers {
+		ctx, cancel := context.WithTimeout(parent, hc.timeout)
+		if err := n.Notify(ctx, t); err != nil {
+			notificationFailures.Add(1, t.Check)
+		}
+		cancel()
+	}
 }
 
 // HTTPHandler returns an HTTP handler for health checks
@@ -221,7 +299,8 @@ func LivenessHandler() http.HandlerFunc {
 	}
 }
 
-// CreateDefaultHealthChecks creates default health checks for the gateway
+// CreateDefaultHealthChecks creates default health checks for the gateway, including the checks of the
+// backend hosts feeding the balancers if the service declares them
 func CreateDefaultHealthChecks(serviceConfig *config.ServiceConfig) *HealthChecker {
 	hc := NewHealthChecker(30*time.Second, 5*time.Second)
 
@@ -298,6 +377,7 @@ func CreateDefaultHealthChecks(serviceConfig *config.ServiceConfig) *HealthCheck
 			})
 		}
 	}
+	RegisterHostChecks(hc, *serviceConfig, sd.DefaultHostHealth)
 
 	return hc
 }
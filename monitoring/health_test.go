@@ -0,0 +1,90 @@
+package monitoring
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+	"github.com/ph0m1/porta/sd"
+)
+
+func TestHealthChecker_Component(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	hc := NewHealthChecker(time.Millisecond, time.Second)
+	checked := make(chan struct{}, 1)
+	hc.RegisterCheck("supu", func(_ context.Context) HealthResult {
+		select {
+		case checked <- struct{}{}:
+		default:
+		}
+		return HealthResult{Status: StatusDegraded}
+	})
+
+	c := hc.Component()
+	startCtx, cancel := context.WithCancel(context.Background())
+	err := c.Start(startCtx)
+	// the checks keep running once the startup is over
+	cancel()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	<-checked
+	<-checked
+	if err := c.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if status := hc.GetHealth().Status; status != StatusDegraded {
+		t.Errorf("unexpected status: %s", status)
+	}
+}
+
+func TestRegisterHostChecks(t *testing.T) {
+	status := int32(http.StatusOK)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer backend.Close()
+
+	cfg := config.ServiceConfig{
+		HostChecks: &config.HostChecks{Path: "/healthz", UnhealthyThreshold: 2, HealthyThreshold: 1},
+		Endpoints: []*config.EndpointConfig{
+			{Backend: []*config.Backend{{Host: []string{backend.URL}}}},
+			{Backend: []*config.Backend{{Host: []string{backend.URL}}}},
+		},
+	}
+	hc := NewHealthChecker(time.Hour, time.Second)
+	health := sd.NewHostHealth(1, 1)
+	RegisterHostChecks(hc, cfg, health)
+	if n := len(hc.GetHealth().Checks); n != 1 {
+		t.Fatalf("the hosts shared by several backends must be checked once: %d checks", n)
+	}
+
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	hc.executeChecks(context.Background())
+	if !health.Healthy(backend.URL) {
+		t.Error("the host must stay healthy until the threshold")
+	}
+	hc.executeChecks(context.Background())
+	if health.Healthy(backend.URL) {
+		t.Error("the host must be unhealthy after 2 failed checks")
+	}
+	if s := hc.GetHealth().Status; s != StatusDegraded {
+		t.Errorf("unexpected status: %s", s)
+	}
+
+	atomic.StoreInt32(&status, http.StatusNoContent)
+	hc.executeChecks(context.Background())
+	if !health.Healthy(backend.URL) || hc.GetHealth().Status != StatusHealthy {
+		t.Error("the host must be healthy again")
+	}
+}
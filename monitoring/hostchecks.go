@@ -0,0 +1,69 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/sd"
+)
+
+// RegisterHostChecks registers a check per backend host of the service, feeding its results to the
+// health of the hosts used by the balancers. The checks of the unhealthy hosts are degraded rather
+// than unhealthy, so a backend host going down does not make the gateway itself unready
+func RegisterHostChecks(hc *HealthChecker, cfg config.ServiceConfig, health *sd.HostHealth) {
+	checks := cfg.HostChecks
+	if checks == nil {
+		return
+	}
+	health.SetThresholds(checks.UnhealthyThreshold, checks.HealthyThreshold)
+	client := &http.Client{Timeout: hc.timeout}
+	for _, host := range backendHosts(cfg) {
+		host := host
+		hc.RegisterCheck("host_"+host, func(ctx context.Context) HealthResult {
+			passed, message := checkHost(ctx, client, host+checks.Path)
+			health.Report(host, passed)
+			if !health.Healthy(host) {
+				return HealthResult{Status: StatusDegraded, Message: "skipped by the balancers: " + message}
+			}
+			return HealthResult{Status: StatusHealthy, Message: message}
+		})
+	}
+}
+
+// checkHost requests the health url of a host, passing on the 2xx responses
+func checkHost(ctx context.Context, client *http.Client, url string) (bool, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err.Error()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Sprintf("%s answered %d", url, resp.StatusCode)
+	}
+	return true, fmt.Sprintf("%s answered %d", url, resp.StatusCode)
+}
+
+// backendHosts returns the sorted set of the hosts of the backends of the service
+func backendHosts(cfg config.ServiceConfig) []string {
+	seen := map[string]bool{}
+	hosts := []string{}
+	for _, e := range cfg.Endpoints {
+		for _, b := range e.Backend {
+			for _, h := range b.Host {
+				if !seen[h] {
+					seen[h] = true
+					hosts = append(hosts, h)
+				}
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,137 @@
+package monitoring
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+var sizeBuckets = []float64{100, 1000, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9}
+
+// NewProviderRecorder returns a recorder creating the metrics of the gateway with the provider. Its
+// handler is the provider, if it implements http.Handler, or a not found handler. It fails if the
+// provider can not create the metrics
+func NewProviderRecorder(p metrics.Provider) (Recorder, error) {
+	handler, ok := p.(http.Handler)
+	if !ok {
+		handler = http.NotFoundHandler()
+	}
+	s := metrics.NewSet()
+	i := &instruments{
+		handler: handler,
+
+		requests:        s.Counter("porta_requests_total", "Total number of HTTP requests processed", "method", "endpoint", "status_code"),
+		requestDuration: s.Histogram("porta_request_duration_seconds", "HTTP request duration in seconds", nil, "method", "endpoint", "status_code"),
+		inFlight:        s.Gauge("porta_requests_in_flight", "Number of HTTP requests currently being processed", "method", "endpoint"),
+		requestSize:     s.Histogram("porta_request_size_bytes", "HTTP request size in bytes", sizeBuckets, "method", "endpoint"),
+		responseSize:    s.Histogram("porta_response_size_bytes", "HTTP response size in bytes", sizeBuckets, "method", "endpoint", "status_code"),
+
+		backendRequests:        s.Counter("porta_backend_requests_total", "Total number of requests sent to backends", "backend", "method", "status_code"),
+		backendRequestDuration: s.Histogram("porta_backend_request_duration_seconds", "Backend request duration in seconds", nil, "backend", "method", "status_code"),
+		backendInFlight:        s.Gauge("porta_backend_requests_in_flight", "Number of requests currently being sent to backends", "backend"),
+		backendErrors:          s.Counter("porta_backend_errors_total", "Total number of backend errors", "backend", "error_type"),
+
+		goroutines:  s.Gauge("porta_goroutines_count", "Number of goroutines currently running"),
+		memoryUsage: s.Gauge("porta_memory_usage_bytes", "Memory usage in bytes", "type"),
+		cpuUsage:    s.Gauge("porta_cpu_usage_percent", "CPU usage percentage"),
+
+		circuitState: s.Gauge("porta_circuit_breaker_state", "Circuit breaker state (0=closed, 1=open, 2=half-open)", "backend"),
+		circuitTrips: s.Counter("porta_circuit_breaker_trips_total", "Total number of circuit breaker trips", "backend"),
+
+		rateLimitHits:   s.Counter("porta_rate_limit_hits_total", "Total number of rate limit hits", "client_id", "endpoint"),
+		rateLimitBlocks: s.Counter("porta_rate_limit_blocks_total", "Total number of rate limit blocks", "client_id", "endpoint"),
+	}
+	return i, s.Use(p)
+}
+
+// NewExpvarMetrics returns a recorder keeping the metrics in expvar maps, for the deployments without
+// prometheus
+func NewExpvarMetrics() Recorder {
+	// the expvar provider creates any metric
+	r, _ := NewProviderRecorder(metrics.NewExpvar())
+	return r
+}
+
+type instruments struct {
+	handler http.Handler
+
+	requests        metrics.Counter
+	requestDuration metrics.Histogram
+	inFlight        metrics.Gauge
+	requestSize     metrics.Histogram
+	responseSize    metrics.Histogram
+
+	backendRequests        metrics.Counter
+	backendRequestDuration metrics.Histogram
+	backendInFlight        metrics.Gauge
+	backendErrors          metrics.Counter
+
+	goroutines  metrics.Gauge
+	memoryUsage metrics.Gauge
+	cpuUsage    metrics.Gauge
+
+	circuitState metrics.Gauge
+	circuitTrips metrics.Counter
+
+	rateLimitHits   metrics.Counter
+	rateLimitBlocks metrics.Counter
+}
+
+func (m *instruments) RecordRequest(method, endpoint, statusCode string, duration time.Duration, requestSize, responseSize int64) {
+	m.requests.Add(1, method, endpoint, statusCode)
+	m.requestDuration.Observe(duration.Seconds(), method, endpoint, statusCode)
+	m.requestSize.Observe(float64(requestSize), method, endpoint)
+	m.responseSize.Observe(float64(responseSize), method, endpoint, statusCode)
+}
+
+func (m *instruments) RecordBackendRequest(backend, method, statusCode string, duration time.Duration) {
+	m.backendRequests.Add(1, backend, method, statusCode)
+	m.backendRequestDuration.Observe(duration.Seconds(), backend, method, statusCode)
+}
+
+func (m *instruments) RecordBackendError(backend, errorType string) {
+	m.backendErrors.Add(1, backend, errorType)
+}
+
+func (m *instruments) IncRequestsInFlight(method, endpoint string) {
+	m.inFlight.Add(1, method, endpoint)
+}
+
+func (m *instruments) DecRequestsInFlight(method, endpoint string) {
+	m.inFlight.Add(-1, method, endpoint)
+}
+
+func (m *instruments) IncBackendRequestsInFlight(backend string) {
+	m.backendInFlight.Add(1, backend)
+}
+
+func (m *instruments) DecBackendRequestsInFlight(backend string) {
+	m.backendInFlight.Add(-1, backend)
+}
+
+func (m *instruments) SetCircuitBreakerState(backend string, state int) {
+	m.circuitState.Set(float64(state), backend)
+}
+
+func (m *instruments) RecordCircuitBreakerTrip(backend string) {
+	m.circuitTrips.Add(1, backend)
+}
+
+func (m *instruments) RecordRateLimit(clientID, endpoint string, blocked bool) {
+	m.rateLimitHits.Add(1, clientID, endpoint)
+	if blocked {
+		m.rateLimitBlocks.Add(1, clientID, endpoint)
+	}
+}
+
+func (m *instruments) UpdateSystemMetrics(goroutines int, memAlloc, memSys uint64, cpuPercent float64) {
+	m.goroutines.Set(float64(goroutines))
+	m.memoryUsage.Set(float64(memAlloc), "alloc")
+	m.memoryUsage.Set(float64(memSys), "sys")
+	m.cpuUsage.Set(cpuPercent)
+}
+
+func (m *instruments) Handler() http.Handler {
+	return m.handler
+}
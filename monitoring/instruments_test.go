@@ -0,0 +1,65 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	m, err := NewRecorder(config.ServiceConfig{Metrics: &config.Metrics{Backend: BackendExpvar}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RecordRequest("GET", "/supu", "200", time.Second, 10, 20)
+	m.RecordRequest("GET", "/supu", "200", time.Second, 10, 20)
+	m.SetCircuitBreakerState("tupu", 1)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/__metrics", nil))
+
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Error(err)
+		return
+	}
+	porta := map[string]map[string]float64{}
+	if err := json.Unmarshal(all["porta"], &porta); err != nil {
+		t.Error(err)
+		return
+	}
+	if v := porta["porta_requests_total"]["GET|/supu|200"]; v != 2 {
+		t.Errorf("want 2 requests, have %v", v)
+	}
+	if v := porta["porta_request_duration_seconds_sum"]["GET|/supu|200"]; v != 2 {
+		t.Errorf("want 2 seconds, have %v", v)
+	}
+	if v := porta["porta_circuit_breaker_state"]["tupu"]; v != 1 {
+		t.Errorf("want state 1, have %v", v)
+	}
+}
+
+func TestNewRecorder_declaredMetrics(t *testing.T) {
+	m, err := NewRecorder(config.ServiceConfig{Metrics: &config.Metrics{Backend: BackendExpvar}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notificationFailures.Add(1, "supu")
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/__metrics", nil))
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	porta := map[string]map[string]float64{}
+	if err := json.Unmarshal(all["porta"], &porta); err != nil {
+		t.Fatal(err)
+	}
+	if v := porta["porta_health_notification_failures_total"]["supu"]; v != 1 {
+		t.Errorf("want 1 failure, have %v", v)
+	}
+}
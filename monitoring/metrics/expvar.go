@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Expvar is a provider keeping the metrics in expvar maps, for the deployments without a metrics
+// system. Every metric is a map keyed by its label values joined with "|". The histograms are exported
+// as a _count and a _sum map. Its variables are not published in the expvar global registry, so
+// several providers can live in the same process
+type Expvar struct {
+	mu   sync.Mutex
+	root *expvar.Map
+}
+
+// NewExpvar returns an empty expvar provider
+func NewExpvar() *Expvar {
+	return &Expvar{root: new(expvar.Map).Init()}
+}
+
+// Counter implements the Provider interface
+func (e *Expvar) Counter(name, _ string, _ ...string) (Counter, error) {
+	return expvarMetric{e, name}, nil
+}
+
+// Gauge implements the Provider interface
+func (e *Expvar) Gauge(name, _ string, _ ...string) (Gauge, error) {
+	return expvarMetric{e, name}, nil
+}
+
+// Histogram implements the Provider interface
+func (e *Expvar) Histogram(name, _ string, _ []float64, _ ...string) (Histogram, error) {
+	return expvarMetric{e, name}, nil
+}
+
+// ServeHTTP writes the metrics as JSON under the porta key, along with the variables published in the
+// expvar registry, like the memstats, following the format of the expvar handler
+func (e *Expvar) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n%q: %s", "porta", e.root.String())
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, ",\n%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+func (e *Expvar) metric(name string) *expvar.Map {
+	if v, ok := e.root.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if v, ok := e.root.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map).Init()
+	e.root.Set(name, v)
+	return v
+}
+
+type expvarMetric struct {
+	e    *Expvar
+	name string
+}
+
+func (m expvarMetric) Add(delta float64, labelValues ...string) {
+	m.e.metric(m.name).AddFloat(strings.Join(labelValues, "|"), delta)
+}
+
+func (m expvarMetric) Set(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "|")
+	metric := m.e.metric(m.name)
+	if v, ok := metric.Get(key).(*expvar.Float); ok {
+		v.Set(value)
+		return
+	}
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+	v, ok := metric.Get(key).(*expvar.Float)
+	if !ok {
+		v = new(expvar.Float)
+		metric.Set(key, v)
+	}
+	v.Set(value)
+}
+
+func (m expvarMetric) Observe(value float64, labelValues ...string) {
+	expvarMetric{m.e, m.name + "_count"}.Add(1, labelValues...)
+	expvarMetric{m.e, m.name + "_sum"}.Add(value, labelValues...)
+}
@@ -0,0 +1,44 @@
+// Package metrics defines the primitives used to instrument the gateway, so the instrumentation does
+// not depend on any metrics system. The adapters of the systems implement the Provider interface
+package metrics
+
+// Provider creates the metrics of a system. The label values passed to the metrics must follow the
+// order of the label names declared on creation. Creating a metric fails if the system rejects it, like
+// when another metric with the same name and different labels already exists
+type Provider interface {
+	Counter(name, help string, labels ...string) (Counter, error)
+	Gauge(name, help string, labels ...string) (Gauge, error)
+	// Histogram creates a histogram with the received bucket bounds. Nil buckets select the defaults of
+	// the provider
+	Histogram(name, help string, buckets []float64, labels ...string) (Histogram, error)
+}
+
+// Counter is a monotonically increasing value
+type Counter interface {
+	Add(delta float64, labelValues ...string)
+}
+
+// Gauge is a value going up and down
+type Gauge interface {
+	Set(value float64, labelValues ...string)
+	Add(delta float64, labelValues ...string)
+}
+
+// Histogram samples observations
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// Noop returns a provider discarding all the metrics
+func Noop() Provider {
+	return noop{}
+}
+
+type noop struct{}
+
+func (noop) Counter(string, string, ...string) (Counter, error)                { return noop{}, nil }
+func (noop) Gauge(string, string, ...string) (Gauge, error)                    { return noop{}, nil }
+func (noop) Histogram(string, string, []float64, ...string) (Histogram, error) { return noop{}, nil }
+func (noop) Add(float64, ...string)                                            {}
+func (noop) Set(float64, ...string)                                            {}
+func (noop) Observe(float64, ...string)                                        {}
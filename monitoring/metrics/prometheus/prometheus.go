@@ -0,0 +1,120 @@
+// Package prometheus adapts a prometheus registry to the metrics.Provider interface
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// Provider registers the metrics in a prometheus registry
+type Provider struct {
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+}
+
+// New returns a provider registering the metrics in the received registry. The default prometheus
+// registry is used if nil. The metrics already registered with the same name and labels are reused
+func New(registry *prometheus.Registry) *Provider {
+	if registry == nil {
+		return &Provider{registerer: prometheus.DefaultRegisterer, gatherer: prometheus.DefaultGatherer}
+	}
+	return &Provider{registerer: registry, gatherer: registry}
+}
+
+// NewWithRegisterer returns a provider registering the metrics with the registerer and exposing the
+// ones of the gatherer, for the applications wrapping their registry, like with prometheus.WrapRegistererWith
+func NewWithRegisterer(registerer prometheus.Registerer, gatherer prometheus.Gatherer) *Provider {
+	return &Provider{registerer: registerer, gatherer: gatherer}
+}
+
+// Counter implements the metrics.Provider interface
+func (p *Provider) Counter(name, help string, labels ...string) (metrics.Counter, error) {
+	c, err := register(p.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels))
+	if err != nil {
+		return nil, err
+	}
+	vec, ok := c.(*prometheus.CounterVec)
+	if !ok {
+		return nil, alreadyRegistered(name, c)
+	}
+	return counter{vec}, nil
+}
+
+// Gauge implements the metrics.Provider interface
+func (p *Provider) Gauge(name, help string, labels ...string) (metrics.Gauge, error) {
+	c, err := register(p.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels))
+	if err != nil {
+		return nil, err
+	}
+	vec, ok := c.(*prometheus.GaugeVec)
+	if !ok {
+		return nil, alreadyRegistered(name, c)
+	}
+	return gauge{vec}, nil
+}
+
+// Histogram implements the metrics.Provider interface
+func (p *Provider) Histogram(name, help string, buckets []float64, labels ...string) (metrics.Histogram, error) {
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	c, err := register(p.registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels))
+	if err != nil {
+		return nil, err
+	}
+	vec, ok := c.(*prometheus.HistogramVec)
+	if !ok {
+		return nil, alreadyRegistered(name, c)
+	}
+	return histogram{vec}, nil
+}
+
+// ServeHTTP exposes the metrics of the registry to the prometheus scrapers
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// register adds the collector to the registry, returning the one already registered with the same
+// description if any. It fails if the registry rejects the collector
+func register(r prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := r.Register(c); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func alreadyRegistered(name string, existing prometheus.Collector) error {
+	return fmt.Errorf("the metric %s is already registered as a %T", name, existing)
+}
+
+type counter struct{ vec *prometheus.CounterVec }
+
+func (c counter) Add(delta float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+type gauge struct{ vec *prometheus.GaugeVec }
+
+func (g gauge) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+func (g gauge) Add(delta float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+type histogram struct{ vec *prometheus.HistogramVec }
+
+func (h histogram) Observe(value float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(value)
+}
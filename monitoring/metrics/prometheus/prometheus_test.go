@@ -0,0 +1,53 @@
+package prometheus
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProvider(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	p := New(registry)
+	c, err := p.Counter("supu_total", "help", "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add(1, "a")
+	// the metrics registered twice are shared
+	c, err = p.Counter("supu_total", "help", "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add(2, "a")
+	h, err := p.Histogram("tupu_seconds", "help", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Observe(0.5)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `supu_total{label="a"} 3`) {
+		t.Errorf("unexpected counter: %s", body)
+	}
+	if !strings.Contains(body, "tupu_seconds_count 1") {
+		t.Errorf("unexpected histogram: %s", body)
+	}
+}
+
+func TestProvider_conflicts(t *testing.T) {
+	p := New(prometheus.NewRegistry())
+	if _, err := p.Counter("supu_total", "help", "label"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Counter("supu_total", "help", "other"); err == nil {
+		t.Error("expecting an error registering the metric with other labels")
+	}
+	if _, err := p.Gauge("supu_total", "help", "label"); err == nil {
+		t.Error("expecting an error registering the metric with another type")
+	}
+}
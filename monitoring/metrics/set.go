@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Set declares metrics before their provider is known, so the packages can keep them in variables. The
+// metrics discard their values until the set selects a provider with Use
+type Set struct {
+	mu       sync.Mutex
+	declared []declaredMetric
+	provider Provider
+}
+
+// NewSet returns a set without provider
+func NewSet() *Set {
+	return &Set{provider: noop{}}
+}
+
+var defaultSet = NewSet()
+
+// NewCounter declares a counter in the set of the metrics of the gateway
+func NewCounter(name, help string, labels ...string) Counter {
+	return defaultSet.Counter(name, help, labels...)
+}
+
+// NewGauge declares a gauge in the set of the metrics of the gateway
+func NewGauge(name, help string, labels ...string) Gauge {
+	return defaultSet.Gauge(name, help, labels...)
+}
+
+// NewHistogram declares a histogram in the set of the metrics of the gateway
+func NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	return defaultSet.Histogram(name, help, buckets, labels...)
+}
+
+// Use selects the provider of the metrics of the gateway. See Set.Use
+func Use(p Provider) error {
+	return defaultSet.Use(p)
+}
+
+// Counter declares a counter in the set
+func (s *Set) Counter(name, help string, labels ...string) Counter {
+	c := &declaredCounter{}
+	s.declare(name, func(p Provider) error {
+		m, err := p.Counter(name, help, labels...)
+		if err == nil {
+			c.v.Store(&m)
+		}
+		return err
+	})
+	return c
+}
+
+// Gauge declares a gauge in the set
+func (s *Set) Gauge(name, help string, labels ...string) Gauge {
+	g := &declaredGauge{}
+	s.declare(name, func(p Provider) error {
+		m, err := p.Gauge(name, help, labels...)
+		if err == nil {
+			g.v.Store(&m)
+		}
+		return err
+	})
+	return g
+}
+
+// Histogram declares a histogram in the set
+func (s *Set) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+	h := &declaredHistogram{}
+	s.declare(name, func(p Provider) error {
+		m, err := p.Histogram(name, help, buckets, labels...)
+		if err == nil {
+			h.v.Store(&m)
+		}
+		return err
+	})
+	return h
+}
+
+// Use creates the declared metrics with the provider and sends them their next values. It returns the
+// errors of the provider creating them, whose metrics keep the previous provider. The metrics declared
+// afterwards are created with the provider as well, discarding their values if it fails
+func (s *Set) Use(p Provider) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.provider = p
+	var errs []error
+	for _, d := range s.declared {
+		if err := d.bind(p); err != nil {
+			errs = append(errs, fmt.Errorf("creating the metric %s: %w", d.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type declaredMetric struct {
+	name string
+	bind func(Provider) error
+}
+
+func (s *Set) declare(name string, bind func(Provider) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := bind(s.provider); err != nil {
+		bind(noop{})
+	}
+	s.declared = append(s.declared, declaredMetric{name, bind})
+}
+
+// declaredCounter keeps a pointer to the counter of the provider, so the stored values always have the
+// same type. The gauges and the histograms do the same
+type declaredCounter struct{ v atomic.Value }
+
+func (c *declaredCounter) Add(delta float64, labelValues ...string) {
+	(*c.v.Load().(*Counter)).Add(delta, labelValues...)
+}
+
+type declaredGauge struct{ v atomic.Value }
+
+func (g *declaredGauge) Set(value float64, labelValues ...string) {
+	(*g.v.Load().(*Gauge)).Set(value, labelValues...)
+}
+
+func (g *declaredGauge) Add(delta float64, labelValues ...string) {
+	(*g.v.Load().(*Gauge)).Add(delta, labelValues...)
+}
+
+type declaredHistogram struct{ v atomic.Value }
+
+func (h *declaredHistogram) Observe(value float64, labelValues ...string) {
+	(*h.v.Load().(*Histogram)).Observe(value, labelValues...)
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type failingProvider struct{ Provider }
+
+func (failingProvider) Counter(string, string, ...string) (Counter, error) {
+	return nil, errors.New("boom")
+}
+
+func TestSet_Use(t *testing.T) {
+	s := NewSet()
+	requests := s.Counter("requests_total", "help", "method")
+	inFlight := s.Gauge("in_flight", "help")
+	duration := s.Histogram("duration_seconds", "help", nil)
+
+	// the values recorded before selecting a provider are discarded
+	requests.Add(1, "GET")
+
+	e := NewExpvar()
+	if err := s.Use(e); err != nil {
+		t.Fatal(err)
+	}
+	requests.Add(2, "GET")
+	inFlight.Set(3)
+	duration.Observe(0.5)
+	// the metrics declared afterwards are created with the provider too
+	s.Counter("errors_total", "help").Add(1)
+
+	expected := `{"duration_seconds_count": {"": 1}, "duration_seconds_sum": {"": 0.5}, "errors_total": {"": 1}, "in_flight": {"": 3}, "requests_total": {"GET": 2}}`
+	if v := e.root.String(); v != expected {
+		t.Errorf("unexpected metrics: %s", v)
+	}
+}
+
+func TestSet_Use_errors(t *testing.T) {
+	s := NewSet()
+	requests := s.Counter("requests_total", "help")
+	s.Gauge("in_flight", "help")
+
+	err := s.Use(failingProvider{NewExpvar()})
+	if err == nil || !strings.Contains(err.Error(), "requests_total") || strings.Contains(err.Error(), "in_flight") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the metrics the provider failed to create keep discarding their values
+	requests.Add(1)
+}
@@ -0,0 +1,252 @@
+// Package statsd adapts a StatsD or DogStatsD server to the metrics.Provider interface
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// Provider pushes the metrics to a StatsD server over UDP. The counters and the gauges are aggregated
+// between flushes, so every flush sends a single line per label set. The histograms send all their
+// samples: the ones named _seconds as timers in milliseconds, the rest as histograms
+type Provider struct {
+	cfg   config.StatsD
+	group *lifecycle.Group
+
+	mu       sync.Mutex
+	conn     net.Conn
+	counters map[string]float64
+	gauges   map[string]*gaugeValue
+	samples  bytes.Buffer
+}
+
+type gaugeValue struct {
+	value float64
+	dirty bool
+}
+
+// New returns a provider sending the metrics to the server of the config every flush interval. The
+// address is resolved on the first flush, so the gateway starts even if the server is down
+func New(cfg config.StatsD) *Provider {
+	p := &Provider{
+		cfg:      cfg,
+		group:    lifecycle.NewGroup(context.Background()),
+		counters: map[string]float64{},
+		gauges:   map[string]*gaugeValue{},
+	}
+	p.group.Go(p.run)
+	return p
+}
+
+// Counter implements the metrics.Provider interface
+func (p *Provider) Counter(name, _ string, labels ...string) (metrics.Counter, error) {
+	return counter{p, p.cfg.Prefix + name, labels}, nil
+}
+
+// Gauge implements the metrics.Provider interface
+func (p *Provider) Gauge(name, _ string, labels ...string) (metrics.Gauge, error) {
+	return gauge{p, p.cfg.Prefix + name, labels}, nil
+}
+
+// Histogram implements the metrics.Provider interface. The buckets are computed by the server, so
+// they are ignored
+func (p *Provider) Histogram(name, _ string, _ []float64, labels ...string) (metrics.Histogram, error) {
+	h := histogram{p: p, name: p.cfg.Prefix + name, labels: labels, kind: "h", scale: 1}
+	if strings.HasSuffix(name, "_seconds") {
+		h.kind, h.scale = "ms", 1000
+	} else if p.cfg.Flavor == config.StatsDFlavorPlain {
+		// the plain servers only know the timers
+		h.kind = "ms"
+	}
+	return h, nil
+}
+
+// Close sends the pending metrics and stops the provider
+func (p *Provider) Close() error {
+	err := p.group.Stop(context.Background())
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	return err
+}
+
+func (p *Provider) run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.flush()
+		case <-ctx.Done():
+			p.flush()
+			return
+		}
+	}
+}
+
+// flush sends the aggregated counters and gauges and the pending samples
+func (p *Provider) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines := make([]string, 0, len(p.counters)+len(p.gauges))
+	for key, v := range p.counters {
+		lines = append(lines, line(key, v, "c"))
+	}
+	for key, g := range p.gauges {
+		if g.dirty {
+			lines = append(lines, line(key, g.value, "g"))
+			g.dirty = false
+		}
+	}
+	p.counters = map[string]float64{}
+	sort.Strings(lines)
+	for _, l := range lines {
+		p.write(l)
+	}
+	p.send()
+}
+
+// write adds the line to the pending packet, sending it first if the line does not fit. The caller
+// holds the lock
+func (p *Provider) write(l string) {
+	if p.samples.Len() > 0 && p.samples.Len()+1+len(l) > p.cfg.MaxPacketSize {
+		p.send()
+	}
+	if p.samples.Len() > 0 {
+		p.samples.WriteByte('\n')
+	}
+	p.samples.WriteString(l)
+}
+
+// send writes the pending packet to the server. The metrics are best effort, so the packets failing
+// to be sent are dropped. The caller holds the lock
+func (p *Provider) send() {
+	if p.samples.Len() == 0 {
+		return
+	}
+	defer p.samples.Reset()
+	if p.conn == nil {
+		conn, err := net.Dial("udp", p.cfg.Address)
+		if err != nil {
+			return
+		}
+		p.conn = conn
+	}
+	p.conn.Write(p.samples.Bytes())
+}
+
+// key returns the name of the metric with its labels in the format of the flavor: as DogStatsD tags,
+// separated from the name by a |, or as dot separated suffixes of the name
+func (p *Provider) key(name string, labels, values []string) string {
+	if p.cfg.Flavor == config.StatsDFlavorPlain {
+		parts := make([]string, 0, len(values)+1)
+		parts = append(parts, name)
+		for _, v := range values {
+			parts = append(parts, sanitize(v))
+		}
+		return strings.Join(parts, ".")
+	}
+	tags := make([]string, 0, len(p.cfg.Tags)+len(labels))
+	tags = append(tags, p.cfg.Tags...)
+	for i, l := range labels {
+		if i < len(values) {
+			tags = append(tags, l+":"+strings.NewReplacer(",", "_", "|", "_").Replace(values[i]))
+		}
+	}
+	if len(tags) == 0 {
+		return name
+	}
+	return name + "|#" + strings.Join(tags, ",")
+}
+
+// line formats the value of the metric, splitting the tags of the key to the end of the line
+func line(key string, value float64, kind string) string {
+	name, tags, _ := strings.Cut(key, "|")
+	l := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind
+	if tags != "" {
+		l += "|" + tags
+	}
+	return l
+}
+
+// sanitize replaces the characters with a meaning in the plain protocol or in the graphite paths
+func sanitize(v string) string {
+	if v == "" {
+		return "none"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', '/', ' ', '#', ',':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+type counter struct {
+	p      *Provider
+	name   string
+	labels []string
+}
+
+func (c counter) Add(delta float64, labelValues ...string) {
+	key := c.p.key(c.name, c.labels, labelValues)
+	c.p.mu.Lock()
+	c.p.counters[key] += delta
+	c.p.mu.Unlock()
+}
+
+// gauge keeps the value of the gauges, since the DogStatsD servers do not support the relative ones
+type gauge struct {
+	p      *Provider
+	name   string
+	labels []string
+}
+
+func (g gauge) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return value })
+}
+
+func (g gauge) Add(delta float64, labelValues ...string) {
+	g.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+func (g gauge) update(labelValues []string, f func(float64) float64) {
+	key := g.p.key(g.name, g.labels, labelValues)
+	g.p.mu.Lock()
+	v, ok := g.p.gauges[key]
+	if !ok {
+		v = &gaugeValue{}
+		g.p.gauges[key] = v
+	}
+	v.value, v.dirty = f(v.value), true
+	g.p.mu.Unlock()
+}
+
+type histogram struct {
+	p      *Provider
+	name   string
+	labels []string
+	kind   string
+	scale  float64
+}
+
+func (h histogram) Observe(value float64, labelValues ...string) {
+	l := line(h.p.key(h.name, h.labels, labelValues), value*h.scale, h.kind)
+	h.p.mu.Lock()
+	h.p.write(l)
+	h.p.mu.Unlock()
+}
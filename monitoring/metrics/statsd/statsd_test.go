@@ -0,0 +1,97 @@
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func listen(t *testing.T) (net.PacketConn, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func() []string {
+		lines := []string{}
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		}
+		sort.Strings(lines)
+		return lines
+	}
+	return conn, read
+}
+
+func TestProvider_dogstatsd(t *testing.T) {
+	conn, read := listen(t)
+	defer conn.Close()
+
+	p := New(config.StatsD{
+		Address:       conn.LocalAddr().String(),
+		Prefix:        "porta.",
+		Flavor:        config.StatsDFlavorDog,
+		Tags:          []string{"env:test"},
+		FlushInterval: time.Hour,
+		MaxPacketSize: 1432,
+	})
+	requests, _ := p.Counter("requests_total", "help", "method", "endpoint")
+	requests.Add(1, "GET", "/supu")
+	requests.Add(2, "GET", "/supu")
+	inFlight, _ := p.Gauge("in_flight", "help")
+	inFlight.Add(1)
+	inFlight.Add(1)
+	inFlight.Add(-1)
+	duration, _ := p.Histogram("duration_seconds", "help", nil, "method")
+	duration.Observe(0.25, "GET")
+	size, _ := p.Histogram("size_bytes", "help", nil)
+	size.Observe(100)
+	p.Close()
+
+	expected := []string{
+		"porta.duration_seconds:250|ms|#env:test,method:GET",
+		"porta.in_flight:1|g|#env:test",
+		"porta.requests_total:3|c|#env:test,method:GET,endpoint:/supu",
+		"porta.size_bytes:100|h|#env:test",
+	}
+	if lines := read(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+}
+
+func TestProvider_plain(t *testing.T) {
+	conn, read := listen(t)
+	defer conn.Close()
+
+	p := New(config.StatsD{
+		Address:       conn.LocalAddr().String(),
+		Flavor:        config.StatsDFlavorPlain,
+		Tags:          []string{"env:test"},
+		FlushInterval: time.Hour,
+		MaxPacketSize: 40,
+	})
+	errs, _ := p.Counter("errors_total", "help", "backend", "type")
+	errs.Add(1, "api.local:8080", "timeout")
+	errs.Add(1, "", "timeout")
+	size, _ := p.Histogram("size_bytes", "help", nil)
+	size.Observe(100)
+	p.Close()
+
+	expected := []string{
+		"errors_total.api_local_8080.timeout:1|c",
+		"errors_total.none.timeout:1|c",
+		"size_bytes:100|ms",
+	}
+	if lines := read(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+}
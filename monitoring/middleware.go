@@ -0,0 +1,153 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging"
+	"github.com/ph0m1/porta/proxy"
+)
+
+// Types of the backend errors
+const (
+	ErrorTimeout     = "timeout"
+	ErrorCanceled    = "canceled"
+	ErrorThrottled   = "throttled"
+	ErrorStatus      = "status"
+	ErrorHeaders     = "headers"
+	ErrorUnavailable = "unavailable"
+)
+
+// HandlerFactory wraps the net/http handler factory of the endpoints, like the one of the mux router,
+// so the handlers record the request metrics
+func HandlerFactory(r Recorder, hf func(*config.EndpointConfig, proxy.Proxy) http.HandlerFunc) func(*config.EndpointConfig, proxy.Proxy) http.HandlerFunc {
+	return func(cfg *config.EndpointConfig, p proxy.Proxy) http.HandlerFunc {
+		return NewHTTPMiddleware(r, cfg)(hf(cfg, p)).ServeHTTP
+	}
+}
+
+// NewHTTPMiddleware returns an HTTP middleware recording the requests to the endpoint: the ones in
+// flight and, once answered, their status, duration and sizes. The endpoint label is the pattern of the
+// endpoint, not the requested path, so the cardinality of the metrics is bounded
+func NewHTTPMiddleware(r Recorder, cfg *config.EndpointConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			r.IncRequestsInFlight(req.Method, cfg.Endpoint)
+			defer r.DecRequestsInFlight(req.Method, cfg.Endpoint)
+
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, req)
+
+			requestSize := req.ContentLength
+			if requestSize < 0 {
+				requestSize = 0
+			}
+			r.RecordRequest(req.Method, cfg.Endpoint, strconv.Itoa(sw.Status()), time.Since(start), requestSize, sw.size)
+		})
+	}
+}
+
+// NewBackendMiddleware creates a proxy middleware recording the calls to the backend: the ones in flight
+// and, once answered, their status and duration, or the type of their error. The backend label is its
+// url pattern
+func NewBackendMiddleware(r Recorder, backend *config.Backend) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if len(next) > 1 {
+			panic(proxy.ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			start := time.Now()
+			r.IncBackendRequestsInFlight(backend.URLPattern)
+			defer r.DecBackendRequestsInFlight(backend.URLPattern)
+
+			resp, err := next[0](ctx, request)
+
+			status := "error"
+			if err == nil {
+				status = "200"
+				if resp != nil && resp.Metadata.StatusCode != 0 {
+					status = strconv.Itoa(resp.Metadata.StatusCode)
+				}
+			} else {
+				var throttled proxy.ThrottledError
+				if errors.As(err, &throttled) {
+					status = strconv.Itoa(throttled.Status)
+				}
+				r.RecordBackendError(backend.URLPattern, errorType(err))
+			}
+			r.RecordBackendRequest(backend.URLPattern, request.Method, status, time.Since(start))
+			return resp, err
+		}
+	}
+}
+
+// BackendFactory wraps the backend factory, so every call to the backends is recorded
+func BackendFactory(r Recorder, bf proxy.BackendFactory) proxy.BackendFactory {
+	return func(backend *config.Backend) proxy.Proxy {
+		return NewBackendMiddleware(r, backend)(bf(backend))
+	}
+}
+
+// ProxyFactory returns the default proxy factory of the gateway, recording the calls to the backends
+func ProxyFactory(r Recorder, logger logging.Logger) proxy.Factory {
+	return proxy.NewDefaultFactory(BackendFactory(r, proxy.CustomHTTPProxyFactory(proxy.NewHttpClient)), logger)
+}
+
+// errorType classifies the error of a backend call
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.As(err, &proxy.ThrottledError{}):
+		return ErrorThrottled
+	case errors.Is(err, proxy.ErrInvalidStatusCode):
+		return ErrorStatus
+	case errors.Is(err, proxy.ErrHeadersTooLarge):
+		return ErrorHeaders
+	}
+	return ErrorUnavailable
+}
+
+// statusWriter keeps the status code and the size of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the status sent to the client, 200 if the handler did not write anything
+func (s *statusWriter) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
@@ -0,0 +1,80 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+func expvarValues(t *testing.T, r Recorder) map[string]map[string]float64 {
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/__metrics", nil))
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	porta := map[string]map[string]float64{}
+	if err := json.Unmarshal(all["porta"], &porta); err != nil {
+		t.Fatal(err)
+	}
+	return porta
+}
+
+func TestHandlerFactory(t *testing.T) {
+	r := NewExpvarMetrics()
+	hf := HandlerFactory(r, func(*config.EndpointConfig, proxy.Proxy) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("supu"))
+		}
+	})
+	h := hf(&config.EndpointConfig{Endpoint: "/users/:id"}, nil)
+	h(httptest.NewRecorder(), httptest.NewRequest("POST", "/users/1", strings.NewReader("tupu")))
+
+	values := expvarValues(t, r)
+	if v := values["porta_requests_total"]["POST|/users/:id|418"]; v != 1 {
+		t.Errorf("unexpected requests: %v", values["porta_requests_total"])
+	}
+	if v := values["porta_response_size_bytes_sum"]["POST|/users/:id|418"]; v != 4 {
+		t.Errorf("unexpected response size: %v", values["porta_response_size_bytes_sum"])
+	}
+	if v := values["porta_request_size_bytes_sum"]["POST|/users/:id"]; v != 4 {
+		t.Errorf("unexpected request size: %v", values["porta_request_size_bytes_sum"])
+	}
+	if v := values["porta_requests_in_flight"]["POST|/users/:id"]; v != 0 {
+		t.Errorf("unexpected requests in flight: %v", v)
+	}
+}
+
+func TestNewBackendMiddleware(t *testing.T) {
+	r := NewExpvarMetrics()
+	backend := &config.Backend{URLPattern: "/users/{{.Id}}"}
+	responses := []error{nil, context.DeadlineExceeded, proxy.ThrottledError{Status: 429}, proxy.ErrInvalidStatusCode}
+	p := NewBackendMiddleware(r, backend)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		err := responses[0]
+		responses = responses[1:]
+		if err != nil {
+			return nil, err
+		}
+		return &proxy.Response{Metadata: proxy.Metadata{StatusCode: 201}}, nil
+	})
+	for range responses {
+		p(context.Background(), &proxy.Request{Method: "POST"})
+	}
+
+	values := expvarValues(t, r)
+	requests := values["porta_backend_requests_total"]
+	if requests["/users/{{.Id}}|POST|201"] != 1 || requests["/users/{{.Id}}|POST|429"] != 1 || requests["/users/{{.Id}}|POST|error"] != 2 {
+		t.Errorf("unexpected backend requests: %v", requests)
+	}
+	errs := values["porta_backend_errors_total"]
+	if errs["/users/{{.Id}}|timeout"] != 1 || errs["/users/{{.Id}}|throttled"] != 1 || errs["/users/{{.Id}}|status"] != 1 {
+		t.Errorf("unexpected backend errors: %v", errs)
+	}
+}
@@ -0,0 +1,151 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+var notificationFailures = metrics.NewCounter("porta_health_notification_failures_total", "Total number of health status transitions that could not be delivered to a notifier", "check")
+
+// Transition is a change of the status of a health check
+type Transition struct {
+	Check   string       `json:"check"`
+	From    HealthStatus `json:"from"`
+	To      HealthStatus `json:"to"`
+	Message string       `json:"message,omitempty"`
+	Time    time.Time    `json:"time"`
+}
+
+// Notifier pushes the status transitions of the health checks to an external system
+type Notifier interface {
+	Notify(ctx context.Context, t Transition) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface
+type NotifierFunc func(ctx context.Context, t Transition) error
+
+// Notify implements the Notifier interface
+func (f NotifierFunc) Notify(ctx context.Context, t Transition) error { return f(ctx, t) }
+
+// WebhookNotifier posts every transition as JSON to a URL
+type WebhookNotifier struct {
+	URL string
+	// name of the external component of every check. The checks without entry use their own name.
+	// If it is not empty, only the mapped checks are notified
+	Components map[string]string
+	// extra headers of the requests, like the authorization ones
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// NewWebhookNotifier returns a notifier posting the transitions of all the checks to the URL
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+type webhookPayload struct {
+	Transition
+	Component string `json:"component"`
+}
+
+// Notify implements the Notifier interface
+func (w *WebhookNotifier) Notify(ctx context.Context, t Transition) error {
+	component := t.Check
+	if len(w.Components) > 0 {
+		c, ok := w.Components[t.Check]
+		if !ok {
+			return nil
+		}
+		component = c
+	}
+
+	body, err := json.Marshal(webhookPayload{Transition: t, Component: component})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	return send(w.Client, req)
+}
+
+// StatuspageNotifier updates the components of a Statuspage page with the status of the checks
+// mapped to them. The checks without component are ignored
+type StatuspageNotifier struct {
+	PageID string
+	APIKey string
+	// id of the Statuspage component of every check
+	Components map[string]string
+	// base URL of the API. https://api.statuspage.io/v1 if empty
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewStatuspageNotifier returns a notifier updating the components of the page
+func NewStatuspageNotifier(pageID, apiKey string, components map[string]string) *StatuspageNotifier {
+	return &StatuspageNotifier{
+		PageID:     pageID,
+		APIKey:     apiKey,
+		Components: components,
+		Client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+var statuspageStatuses = map[HealthStatus]string{
+	StatusHealthy:   "operational",
+	StatusDegraded:  "degraded_performance",
+	StatusUnhealthy: "major_outage",
+}
+
+// Notify implements the Notifier interface
+func (s *StatuspageNotifier) Notify(ctx context.Context, t Transition) error {
+	component, ok := s.Components[t.Check]
+	if !ok {
+		return nil
+	}
+	baseURL := s.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.statuspage.io/v1"
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"component": map[string]string{"status": statuspageStatuses[t.To]},
+	})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/pages/%s/components/%s", baseURL, s.PageID, component)
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "OAuth "+s.APIKey)
+	return send(s.Client, req)
+}
+
+func send(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notifying %s: unexpected status %d", req.URL.Host, resp.StatusCode)
+	}
+	return nil
+}
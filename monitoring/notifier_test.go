@@ -0,0 +1,82 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker_notifiesTransitions(t *testing.T) {
+	hc := NewHealthChecker(time.Hour, time.Second)
+	status := StatusHealthy
+	hc.RegisterCheck("supu", func(_ context.Context) HealthResult {
+		return HealthResult{Status: status}
+	})
+	var transitions []Transition
+	hc.AddNotifier(NotifierFunc(func(_ context.Context, t Transition) error {
+		transitions = append(transitions, t)
+		return nil
+	}))
+
+	hc.executeChecks(context.Background())
+	status = StatusUnhealthy
+	hc.executeChecks(context.Background())
+	hc.executeChecks(context.Background())
+
+	if len(transitions) != 1 {
+		t.Errorf("want 1 transition, have %d", len(transitions))
+		return
+	}
+	if tr := transitions[0]; tr.Check != "supu" || tr.From != StatusHealthy || tr.To != StatusUnhealthy {
+		t.Errorf("unexpected transition: %v", tr)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	payloads := make(chan map[string]interface{}, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		payload := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer s.Close()
+
+	n := NewWebhookNotifier(s.URL)
+	n.Components = map[string]string{"supu": "api"}
+	if err := n.Notify(context.Background(), Transition{Check: "tupu", To: StatusUnhealthy}); err != nil {
+		t.Error(err)
+	}
+	if err := n.Notify(context.Background(), Transition{Check: "supu", To: StatusUnhealthy}); err != nil {
+		t.Error(err)
+	}
+	payload := <-payloads
+	if payload["component"] != "api" || payload["to"] != string(StatusUnhealthy) {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+	if len(payloads) != 0 {
+		t.Error("the unmapped checks should not be notified")
+	}
+}
+
+func TestStatuspageNotifier(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" || r.URL.Path != "/pages/page/components/cmp" || r.Header.Get("Authorization") != "OAuth key" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		payload := map[string]map[string]string{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["component"]["status"] != "degraded_performance" {
+			t.Errorf("unexpected payload: %v", payload)
+		}
+	}))
+	defer s.Close()
+
+	n := NewStatuspageNotifier("page", "key", map[string]string{"supu": "cmp"})
+	n.BaseURL = s.URL
+	if err := n.Notify(context.Background(), Transition{Check: "supu", To: StatusDegraded}); err != nil {
+		t.Error(err)
+	}
+}
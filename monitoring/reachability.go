@@ -0,0 +1,205 @@
+package monitoring
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+const defaultProbeTimeout = 5 * time.Second
+
+var hostReachable = metrics.NewGauge("porta_backend_host_reachable", "Result of the startup probe of the backend hosts: 1 if reachable, 0 otherwise", "host")
+
+// Stages of the probe of a host, in order
+const (
+	StageDNS    = "dns"
+	StageTCP    = "tcp"
+	StageTLS    = "tls"
+	StageHealth = "health"
+)
+
+// HostReachability is the result of the probe of a backend host
+type HostReachability struct {
+	Host string `json:"host"`
+	// url patterns of the backends using the host
+	Backends  []string      `json:"backends"`
+	Reachable bool          `json:"reachable"`
+	Duration  time.Duration `json:"duration"`
+	// stage where the probe failed, empty if the host is reachable
+	FailedStage string `json:"failed_stage,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ReachabilityReport lists the results of the probe of all the backend hosts, sorted by host
+type ReachabilityReport []HostReachability
+
+// Unreachable returns the hosts that failed the probe
+func (r ReachabilityReport) Unreachable() ReachabilityReport {
+	res := ReachabilityReport{}
+	for _, h := range r {
+		if !h.Reachable {
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+// Err returns an error listing the unreachable hosts, or nil if all of them are reachable
+func (r ReachabilityReport) Err() error {
+	unreachable := r.Unreachable()
+	if len(unreachable) == 0 {
+		return nil
+	}
+	hosts := make([]string, len(unreachable))
+	for i, h := range unreachable {
+		hosts[i] = h.Host
+	}
+	return fmt.Errorf("%d of %d backend hosts are unreachable: %s", len(unreachable), len(r), strings.Join(hosts, ", "))
+}
+
+// WriteTo writes the report as a table, one line per host
+func (r ReachabilityReport) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, h := range r {
+		status := "ok"
+		if !h.Reachable {
+			status = fmt.Sprintf("FAILED at %s: %s", h.FailedStage, h.Error)
+		}
+		n, err := fmt.Fprintf(w, "%-40s %8s  %s  (%s)\n", h.Host, h.Duration.Round(time.Millisecond), status, strings.Join(h.Backends, ", "))
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ProbeBackends probes in parallel all the hosts of the backends of the config, so the misconfigured
+// hosts are reported at boot instead of as errors of the requests. The result of every host is exported
+// as the porta_backend_host_reachable gauge
+func ProbeBackends(ctx context.Context, cfg config.ServiceConfig) ReachabilityReport {
+	probe := cfg.StartupProbe
+	if probe == nil {
+		probe = &config.StartupProbe{}
+	}
+	timeout := probe.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	backends := map[string][]string{}
+	for _, e := range cfg.Endpoints {
+		for _, b := range e.Backend {
+			for _, h := range b.Host {
+				if !contains(backends[h], b.URLPattern) {
+					backends[h] = append(backends[h], b.URLPattern)
+				}
+			}
+		}
+	}
+
+	report := make(ReachabilityReport, 0, len(backends))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for host, patterns := range backends {
+		wg.Add(1)
+		go func(host string, patterns []string) {
+			defer wg.Done()
+			localCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			begin := time.Now()
+			stage, err := probeHost(localCtx, host, probe.HealthPath)
+			res := HostReachability{Host: host, Backends: patterns, Reachable: err == nil, Duration: time.Since(begin)}
+			reachable := 1.0
+			if err != nil {
+				res.FailedStage, res.Error = stage, err.Error()
+				reachable = 0
+			}
+			hostReachable.Set(reachable, host)
+
+			mu.Lock()
+			report = append(report, res)
+			mu.Unlock()
+		}(host, patterns)
+	}
+	wg.Wait()
+
+	sort.Slice(report, func(i, j int) bool { return report[i].Host < report[j].Host })
+	return report
+}
+
+// probeHost runs the stages of the probe of the host, returning the stage that failed
+func probeHost(ctx context.Context, host, healthPath string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return StageDNS, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	addrs := []string{u.Hostname()}
+	if net.ParseIP(u.Hostname()) == nil {
+		if addrs, err = net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+			return StageDNS, err
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		return StageTCP, err
+	}
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		err = tlsConn.HandshakeContext(ctx)
+		tlsConn.Close()
+		if err != nil {
+			return StageTLS, err
+		}
+	} else {
+		conn.Close()
+	}
+
+	if healthPath == "" {
+		return "", nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/"+strings.TrimPrefix(healthPath, "/"), nil)
+	if err != nil {
+		return StageHealth, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return StageHealth, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return StageHealth, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return "", nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
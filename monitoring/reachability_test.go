@@ -0,0 +1,58 @@
+package monitoring
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestProbeBackends(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer healthy.Close()
+	sick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer sick.Close()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := "http://" + l.Addr().String()
+	l.Close()
+
+	cfg := config.ServiceConfig{
+		StartupProbe: &config.StartupProbe{Timeout: time.Second, HealthPath: "/health"},
+		Endpoints: []*config.EndpointConfig{
+			{Backend: []*config.Backend{
+				{URLPattern: "/a", Host: []string{healthy.URL, sick.URL}},
+				{URLPattern: "/b", Host: []string{healthy.URL, closed, "http://supu.invalid"}},
+			}},
+		},
+	}
+	report := ProbeBackends(context.Background(), cfg)
+	if len(report) != 4 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	stages := map[string]string{}
+	for _, h := range report {
+		stages[h.Host] = h.FailedStage
+	}
+	for host, stage := range map[string]string{healthy.URL: "", sick.URL: StageHealth, closed: StageTCP, "http://supu.invalid": StageDNS} {
+		if stages[host] != stage {
+			t.Errorf("%s: want stage %q, have %q", host, stage, stages[host])
+		}
+	}
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "3 of 4") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var out strings.Builder
+	report.WriteTo(&out)
+	if strings.Count(out.String(), "\n") != 4 {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+}
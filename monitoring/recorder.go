@@ -0,0 +1,158 @@
+package monitoring
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/monitoring/metrics"
+	"github.com/ph0m1/porta/monitoring/metrics/statsd"
+)
+
+// Names of the metrics backends
+const (
+	BackendPrometheus = "prometheus"
+	BackendExpvar     = "expvar"
+	BackendStatsD     = "statsd"
+	BackendNoop       = "noop"
+)
+
+// ErrPrometheusNotLinked is returned when the config selects the prometheus backend in a binary built
+// with the noprometheus tag
+var ErrPrometheusNotLinked = errors.New("the prometheus metrics backend is not linked in the binary")
+
+// Recorder records the metrics of the gateway, whatever the system they are exported to
+type Recorder interface {
+	RecordRequest(method, endpoint, statusCode string, duration time.Duration, requestSize, responseSize int64)
+	RecordBackendRequest(backend, method, statusCode string, duration time.Duration)
+	RecordBackendError(backend, errorType string)
+	IncRequestsInFlight(method, endpoint string)
+	DecRequestsInFlight(method, endpoint string)
+	IncBackendRequestsInFlight(backend string)
+	DecBackendRequestsInFlight(backend string)
+	SetCircuitBreakerState(backend string, state int)
+	RecordCircuitBreakerTrip(backend string)
+	RecordRateLimit(clientID, endpoint string, blocked bool)
+	UpdateSystemMetrics(goroutines int, memAlloc, memSys uint64, cpuPercent float64)
+	// Handler exposes the recorded metrics
+	Handler() http.Handler
+}
+
+// NewRecorder returns the recorder of the metrics backend selected by the service config, or of the
+// DefaultBackend if it does not select any. If the config declares a StatsD server, the metrics of the
+// prometheus and the expvar backends are also pushed to it. The metrics declared by the packages of the
+// gateway are created in the selected backend. It fails if the backend can not create them or if it is
+// not linked in the binary
+func NewRecorder(cfg config.ServiceConfig) (Recorder, error) {
+	backend := DefaultBackend
+	if cfg.Metrics != nil && cfg.Metrics.Backend != "" {
+		backend = cfg.Metrics.Backend
+	}
+	var p metrics.Provider
+	var err error
+	switch backend {
+	case BackendExpvar:
+		p = metrics.NewExpvar()
+	case BackendNoop:
+		p = metrics.Noop()
+	case BackendStatsD:
+		p = statsd.New(*cfg.Metrics.StatsD)
+	case BackendPrometheus:
+		p, err = newPrometheusProvider()
+	default:
+		err = fmt.Errorf("unknown metrics backend %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewProviderRecorder(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := metrics.Use(p); err != nil {
+		return nil, err
+	}
+	if backend == BackendNoop || backend == BackendStatsD || cfg.Metrics == nil || cfg.Metrics.StatsD == nil {
+		return r, nil
+	}
+	pushed, err := NewProviderRecorder(statsd.New(*cfg.Metrics.StatsD))
+	if err != nil {
+		return nil, err
+	}
+	return teeRecorder{r, pushed}, nil
+}
+
+// teeRecorder records the metrics in all its recorders. Its handler is the one of the first recorder
+type teeRecorder []Recorder
+
+func (t teeRecorder) RecordRequest(method, endpoint, statusCode string, duration time.Duration, requestSize, responseSize int64) {
+	for _, r := range t {
+		r.RecordRequest(method, endpoint, statusCode, duration, requestSize, responseSize)
+	}
+}
+
+func (t teeRecorder) RecordBackendRequest(backend, method, statusCode string, duration time.Duration) {
+	for _, r := range t {
+		r.RecordBackendRequest(backend, method, statusCode, duration)
+	}
+}
+
+func (t teeRecorder) RecordBackendError(backend, errorType string) {
+	for _, r := range t {
+		r.RecordBackendError(backend, errorType)
+	}
+}
+
+func (t teeRecorder) IncRequestsInFlight(method, endpoint string) {
+	for _, r := range t {
+		r.IncRequestsInFlight(method, endpoint)
+	}
+}
+
+func (t teeRecorder) DecRequestsInFlight(method, endpoint string) {
+	for _, r := range t {
+		r.DecRequestsInFlight(method, endpoint)
+	}
+}
+
+func (t teeRecorder) IncBackendRequestsInFlight(backend string) {
+	for _, r := range t {
+		r.IncBackendRequestsInFlight(backend)
+	}
+}
+
+func (t teeRecorder) DecBackendRequestsInFlight(backend string) {
+	for _, r := range t {
+		r.DecBackendRequestsInFlight(backend)
+	}
+}
+
+func (t teeRecorder) SetCircuitBreakerState(backend string, state int) {
+	for _, r := range t {
+		r.SetCircuitBreakerState(backend, state)
+	}
+}
+
+func (t teeRecorder) RecordCircuitBreakerTrip(backend string) {
+	for _, r := range t {
+		r.RecordCircuitBreakerTrip(backend)
+	}
+}
+
+func (t teeRecorder) RecordRateLimit(clientID, endpoint string, blocked bool) {
+	for _, r := range t {
+		r.RecordRateLimit(clientID, endpoint, blocked)
+	}
+}
+
+func (t teeRecorder) UpdateSystemMetrics(goroutines int, memAlloc, memSys uint64, cpuPercent float64) {
+	for _, r := range t {
+		r.UpdateSystemMetrics(goroutines, memAlloc, memSys, cpuPercent)
+	}
+}
+
+func (t teeRecorder) Handler() http.Handler {
+	return t[0].Handler()
+}
@@ -0,0 +1,15 @@
+//go:build noprometheus
+
+package monitoring
+
+import (
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// DefaultBackend is the metrics backend of the configs not selecting any. The binaries built with the
+// noprometheus tag do not link the prometheus client, so they default to expvar
+const DefaultBackend = BackendExpvar
+
+func newPrometheusProvider() (metrics.Provider, error) {
+	return nil, ErrPrometheusNotLinked
+}
@@ -0,0 +1,20 @@
+//go:build noprometheus
+
+package monitoring
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewRecorder_noprometheus(t *testing.T) {
+	if _, err := NewRecorder(config.ServiceConfig{}); err != nil {
+		t.Errorf("the default backend: %s", err)
+	}
+	_, err := NewRecorder(config.ServiceConfig{Metrics: &config.Metrics{Backend: BackendPrometheus}})
+	if !errors.Is(err, ErrPrometheusNotLinked) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
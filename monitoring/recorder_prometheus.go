@@ -0,0 +1,15 @@
+//go:build !noprometheus
+
+package monitoring
+
+import (
+	"github.com/ph0m1/porta/monitoring/metrics"
+	promprovider "github.com/ph0m1/porta/monitoring/metrics/prometheus"
+)
+
+// DefaultBackend is the metrics backend of the configs not selecting any
+const DefaultBackend = BackendPrometheus
+
+func newPrometheusProvider() (metrics.Provider, error) {
+	return promprovider.New(nil), nil
+}
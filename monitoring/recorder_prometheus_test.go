@@ -0,0 +1,55 @@
+//go:build !noprometheus
+
+package monitoring
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ph0m1/porta/config"
+	promprovider "github.com/ph0m1/porta/monitoring/metrics/prometheus"
+)
+
+func TestNewProviderRecorder_prometheus(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewProviderRecorder(promprovider.New(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// several gateways can share the registry
+	if _, err := NewProviderRecorder(promprovider.New(registry)); err != nil {
+		t.Error(err)
+	}
+	m.RecordRequest("GET", "/supu", "200", time.Second, 10, 20)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if body := w.Body.String(); !strings.Contains(body, `porta_requests_total{endpoint="/supu",method="GET",status_code="200"} 1`) {
+		t.Errorf("unexpected metrics: %s", body)
+	}
+
+	conflicting := prometheus.NewRegistry()
+	conflicting.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "porta_requests_total", Help: "help"}))
+	if _, err := NewProviderRecorder(promprovider.New(conflicting)); err == nil {
+		t.Error("expecting an error registering the metrics in a registry with conflicting ones")
+	}
+}
+
+func TestNewRecorder_expvarDoesNotRegister(t *testing.T) {
+	if _, err := NewRecorder(config.ServiceConfig{Metrics: &config.Metrics{Backend: BackendExpvar}}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if strings.HasPrefix(f.GetName(), "porta_") {
+			t.Errorf("unexpected metric %s in the default registry", f.GetName())
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+var (
+	sloBurnRate             = metrics.NewGauge("porta_slo_burn_rate", "Burn rate of the error budget of the endpoint objectives over the windows of the alert rules", "method", "endpoint", "objective", "window")
+	sloNotificationFailures = metrics.NewCounter("porta_slo_notification_failures_total", "Total number of SLO alerts that could not be delivered to a notifier", "endpoint")
+)
+
+// Objectives of the SLOs and statuses of their alerts
+const (
+	SLOAvailability = "availability"
+	SLOLatency      = "latency"
+
+	SLOFiring   = "firing"
+	SLOResolved = "resolved"
+)
+
+// sloResolution is the width of the buckets of the rolling windows
+const sloResolution = time.Minute
+
+// SLOAlert is a burn rate rule of an endpoint objective starting or stopping to fire
+type SLOAlert struct {
+	Status    string `json:"status"`
+	Method    string `json:"method"`
+	Endpoint  string `json:"endpoint"`
+	Objective string `json:"objective"`
+	// percent of good requests of the objective
+	Target float64 `json:"target"`
+	Rule   string  `json:"rule"`
+	// burn rate over the window of the rule when the alert changed
+	BurnRate  float64       `json:"burn_rate"`
+	Threshold float64       `json:"threshold"`
+	Window    time.Duration `json:"window"`
+	Time      time.Time     `json:"time"`
+}
+
+// Summary describes the alert in a line
+func (a SLOAlert) Summary() string {
+	status := "FIRING"
+	if a.Status == SLOResolved {
+		status = "RESOLVED"
+	}
+	return fmt.Sprintf("[%s] %s %s %s SLO of %g%%: burn rate %.2f over %s (rule %s, threshold %g)",
+		status, a.Method, a.Endpoint, a.Objective, a.Target, a.BurnRate, a.Window, a.Rule, a.Threshold)
+}
+
+// SLONotifier pushes the SLO alerts to an external system
+type SLONotifier interface {
+	NotifySLO(ctx context.Context, a SLOAlert) error
+}
+
+// SLONotifierFunc adapts a function to the SLONotifier interface
+type SLONotifierFunc func(ctx context.Context, a SLOAlert) error
+
+// NotifySLO implements the SLONotifier interface
+func (f SLONotifierFunc) NotifySLO(ctx context.Context, a SLOAlert) error { return f(ctx, a) }
+
+// SLOTracker counts the good and bad requests of the endpoints with an SLO over rolling windows and
+// evaluates the burn rate rules of the alerts, notifying the alerts starting and stopping to fire
+type SLOTracker struct {
+	series    map[string]*sloSeries
+	rules     []config.BurnRateRule
+	interval  time.Duration
+	timeout   time.Duration
+	notifiers []SLONotifier
+	logger    logging.Logger
+
+	mu sync.Mutex
+	// burn rate of the firing alerts by series, objective and rule
+	firing map[string]float64
+}
+
+// NewSLOTracker creates the tracker of the SLOs of the endpoints of the config, notifying its alerts to
+// the webhooks of the config. The default burn rate rules are evaluated if the config has no alerts
+func NewSLOTracker(cfg config.ServiceConfig, logger logging.Logger) *SLOTracker {
+	t := &SLOTracker{
+		series:   map[string]*sloSeries{},
+		rules:    config.DefaultBurnRateRules,
+		interval: time.Minute,
+		timeout:  5 * time.Second,
+		logger:   logger,
+		firing:   map[string]float64{},
+	}
+	if a := cfg.SLOAlerts; a != nil {
+		t.rules = make([]config.BurnRateRule, len(a.Rules))
+		for i, r := range a.Rules {
+			t.rules[i] = *r
+		}
+		t.interval = a.Interval
+		for _, w := range a.Webhooks {
+			t.AddNotifier(NewSLOWebhook(w))
+		}
+	}
+
+	var longest time.Duration
+	for _, r := range t.rules {
+		if r.Window > longest {
+			longest = r.Window
+		}
+	}
+	size := int(longest/sloResolution) + 1
+	for _, e := range cfg.Endpoints {
+		if e.SLO == nil {
+			continue
+		}
+		t.series[sloKey(e.Method, e.Endpoint)] = &sloSeries{
+			method:   e.Method,
+			endpoint: e.Endpoint,
+			slo:      *e.SLO,
+			buckets:  make([]sloBucket, size),
+		}
+	}
+	return t
+}
+
+// AddNotifier registers a notifier receiving the alerts
+func (t *SLOTracker) AddNotifier(n SLONotifier) {
+	t.mu.Lock()
+	t.notifiers = append(t.notifiers, n)
+	t.mu.Unlock()
+}
+
+// Len returns the number of endpoints with an SLO
+func (t *SLOTracker) Len() int { return len(t.series) }
+
+// Record counts a request to the endpoint. The requests to the endpoints without SLO are ignored
+func (t *SLOTracker) Record(method, endpoint string, latency time.Duration, failed bool) {
+	t.record(time.Now(), method, endpoint, latency, failed)
+}
+
+func (t *SLOTracker) record(now time.Time, method, endpoint string, latency time.Duration, failed bool) {
+	if s, ok := t.series[sloKey(method, endpoint)]; ok {
+		s.record(now, latency, failed)
+	}
+}
+
+// Run evaluates the burn rate rules at the interval of the config until the context is canceled
+func (t *SLOTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.Evaluate(ctx, now)
+		}
+	}
+}
+
+// Evaluate computes the burn rates of the objectives at the given time, notifying the alerts changing
+// their status. It returns the alerts notified
+func (t *SLOTracker) Evaluate(ctx context.Context, now time.Time) []SLOAlert {
+	alerts := []SLOAlert{}
+	for _, s := range t.series {
+		for _, o := range s.objectives() {
+			for _, r := range t.rules {
+				long := s.burnRate(now, r.Window, o)
+				short := s.burnRate(now, r.ShortWindow, o)
+				sloBurnRate.Set(long, s.method, s.endpoint, o.name, r.Window.String())
+				sloBurnRate.Set(short, s.method, s.endpoint, o.name, r.ShortWindow.String())
+
+				key := s.method + " " + s.endpoint + " " + o.name + " " + r.Name
+				firing := long >= r.BurnRate && short >= r.BurnRate
+				t.mu.Lock()
+				_, wasFiring := t.firing[key]
+				if firing {
+					t.firing[key] = long
+				} else {
+					delete(t.firing, key)
+				}
+				t.mu.Unlock()
+				if firing == wasFiring {
+					continue
+				}
+				status := SLOResolved
+				if firing {
+					status = SLOFiring
+				}
+				alerts = append(alerts, SLOAlert{
+					Status:    status,
+					Method:    s.method,
+					Endpoint:  s.endpoint,
+					Objective: o.name,
+					Target:    o.target,
+					Rule:      r.Name,
+					BurnRate:  long,
+					Threshold: r.BurnRate,
+					Window:    r.Window,
+					Time:      now,
+				})
+			}
+		}
+	}
+	for _, a := range alerts {
+		t.notify(ctx, a)
+	}
+	return alerts
+}
+
+// Firing returns the number of alerts firing
+func (t *SLOTracker) Firing() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.firing)
+}
+
+func (t *SLOTracker) notify(parent context.Context, a SLOAlert) {
+	t.mu.Lock()
+	notifiers := t.notifiers
+	t.mu.Unlock()
+	if t.logger != nil {
+		t.logger.Warning("SLO alert", a.Summary())
+	}
+	for _, n := range notifiers {
+		ctx, cancel := context.WithTimeout(parent, t.timeout)
+		if err := n.NotifySLO(ctx, a); err != nil {
+			sloNotificationFailures.Add(1, a.Endpoint)
+			if t.logger != nil {
+				t.logger.Error("notifying the SLO alert:", err.Error())
+			}
+		}
+		cancel()
+	}
+}
+
+// Recorder returns a recorder feeding the tracker with the requests recorded by next, so the router
+// factories can track the SLOs through their metrics recorder. The 5xx responses are failures
+func (t *SLOTracker) Recorder(next Recorder) Recorder {
+	return sloRecorder{Recorder: next, tracker: t}
+}
+
+type sloRecorder struct {
+	Recorder
+	tracker *SLOTracker
+}
+
+func (r sloRecorder) RecordRequest(method, endpoint, statusCode string, duration time.Duration, requestSize, responseSize int64) {
+	r.Recorder.RecordRequest(method, endpoint, statusCode, duration, requestSize, responseSize)
+	status, _ := strconv.Atoi(statusCode)
+	r.tracker.Record(method, endpoint, duration, status >= http.StatusInternalServerError)
+}
+
+func sloKey(method, endpoint string) string {
+	if method == "" {
+		method = http.MethodGet
+	}
+	return method + " " + endpoint
+}
+
+// sloSeries keeps the requests of an endpoint in a ring of buckets covering the longest window
+type sloSeries struct {
+	method   string
+	endpoint string
+	slo      config.SLO
+
+	mu      sync.Mutex
+	buckets []sloBucket
+}
+
+type sloBucket struct {
+	// index of the period of the bucket since the epoch
+	period int64
+	total  uint64
+	failed uint64
+	slow   uint64
+}
+
+type sloObjective struct {
+	name   string
+	target float64
+	bad    func(sloBucket) uint64
+}
+
+func (s *sloSeries) objectives() []sloObjective {
+	res := []sloObjective{}
+	if s.slo.Availability > 0 {
+		res = append(res, sloObjective{SLOAvailability, s.slo.Availability, func(b sloBucket) uint64 { return b.failed }})
+	}
+	if s.slo.LatencyTarget > 0 {
+		res = append(res, sloObjective{SLOLatency, s.slo.LatencyTarget, func(b sloBucket) uint64 { return b.slow }})
+	}
+	return res
+}
+
+func (s *sloSeries) record(now time.Time, latency time.Duration, failed bool) {
+	period := now.UnixNano() / int64(sloResolution)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.buckets[period%int64(len(s.buckets))]
+	if b.period != period {
+		*b = sloBucket{period: period}
+	}
+	b.total++
+	if failed {
+		b.failed++
+	}
+	if s.slo.LatencyTarget > 0 && latency > s.slo.Latency {
+		b.slow++
+	}
+}
+
+// burnRate returns the ratio of bad requests of the objective over the window divided by the ratio
+// allowed by its target. The window covers the current bucket and the previous ones up to its width
+func (s *sloSeries) burnRate(now time.Time, window time.Duration, o sloObjective) float64 {
+	current := now.UnixNano() / int64(sloResolution)
+	oldest := current - int64(window/sloResolution)
+	if window%sloResolution == 0 {
+		oldest++
+	}
+	var total, bad uint64
+	s.mu.Lock()
+	for _, b := range s.buckets {
+		if b.period >= oldest && b.period <= current {
+			total += b.total
+			bad += o.bad(b)
+		}
+	}
+	s.mu.Unlock()
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - o.target/100)
+}
+
+// SLOWebhook posts the SLO alerts to an HTTP receiver, as the JSON of the alert, as a Slack message or
+// as a PagerDuty event
+type SLOWebhook struct {
+	URL    string
+	Format string
+	// integration key of the PagerDuty events
+	RoutingKey string
+	// extra headers of the requests, like the authorization ones
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// NewSLOWebhook returns the notifier of the webhook of the config
+func NewSLOWebhook(cfg *config.SLOWebhook) *SLOWebhook {
+	return &SLOWebhook{
+		URL:        cfg.URL,
+		Format:     cfg.Format,
+		RoutingKey: cfg.RoutingKey,
+		Headers:    cfg.Headers,
+		Client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// NotifySLO implements the SLONotifier interface
+func (w *SLOWebhook) NotifySLO(ctx context.Context, a SLOAlert) error {
+	var payload interface{}
+	switch w.Format {
+	case config.SLOWebhookSlack:
+		payload = map[string]string{"text": a.Summary()}
+	case config.SLOWebhookPagerDuty:
+		action := "trigger"
+		if a.Status == SLOResolved {
+			action = "resolve"
+		}
+		payload = map[string]interface{}{
+			"routing_key":  w.RoutingKey,
+			"event_action": action,
+			// the resolve event closes the incident opened by the trigger one
+			"dedup_key": a.Method + " " + a.Endpoint + " " + a.Objective + " " + a.Rule,
+			"payload": map[string]interface{}{
+				"summary":        a.Summary(),
+				"source":         a.Method + " " + a.Endpoint,
+				"severity":       "critical",
+				"custom_details": a,
+			},
+		}
+	default:
+		payload = a
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	return send(w.Client, req)
+}
@@ -0,0 +1,122 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func newTestSLOTracker() *SLOTracker {
+	return NewSLOTracker(config.ServiceConfig{
+		SLOAlerts: &config.SLOAlerts{
+			Interval: time.Minute,
+			Rules:    []*config.BurnRateRule{{Name: "page", BurnRate: 10, Window: time.Hour, ShortWindow: 5 * time.Minute}},
+		},
+		Endpoints: []*config.EndpointConfig{
+			{Method: "GET", Endpoint: "/users", SLO: &config.SLO{Availability: 99, LatencyTarget: 90, Latency: 100 * time.Millisecond}},
+			{Method: "GET", Endpoint: "/other"},
+		},
+	}, nil)
+}
+
+func TestSLOTracker_availability(t *testing.T) {
+	tracker := newTestSLOTracker()
+	var alerts []SLOAlert
+	tracker.AddNotifier(SLONotifierFunc(func(_ context.Context, a SLOAlert) error {
+		alerts = append(alerts, a)
+		return nil
+	}))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// 5% of errors burn a 1% budget at 5x: below the threshold
+	for i := 0; i < 100; i++ {
+		tracker.record(now, "GET", "/users", time.Millisecond, i < 5)
+		tracker.record(now, "GET", "/other", time.Millisecond, true)
+	}
+	if res := tracker.Evaluate(context.Background(), now); len(res) != 0 {
+		t.Errorf("unexpected alerts: %v", res)
+	}
+
+	// a third of errors in the last minutes burn it at 33x over the short window and 15.6x over the hour
+	now = now.Add(10 * time.Minute)
+	for i := 0; i < 60; i++ {
+		tracker.record(now, "GET", "/users", time.Millisecond, i < 20)
+	}
+	tracker.Evaluate(context.Background(), now)
+	if len(alerts) != 1 {
+		t.Fatalf("want 1 alert, have %v", alerts)
+	}
+	if a := alerts[0]; a.Status != SLOFiring || a.Objective != SLOAvailability || a.Rule != "page" || int(a.BurnRate) != 15 {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+	if tracker.Firing() != 1 {
+		t.Errorf("want 1 firing alert, have %d", tracker.Firing())
+	}
+
+	// once the errors leave the short window, the alert is resolved
+	now = now.Add(10 * time.Minute)
+	tracker.record(now, "GET", "/users", time.Millisecond, false)
+	tracker.Evaluate(context.Background(), now)
+	if len(alerts) != 2 || alerts[1].Status != SLOResolved {
+		t.Errorf("want the alert resolved, have %v", alerts)
+	}
+}
+
+func TestSLOTracker_latency(t *testing.T) {
+	tracker := newTestSLOTracker()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		tracker.record(now, "GET", "/users", time.Second, false)
+	}
+	alerts := tracker.Evaluate(context.Background(), now)
+	if len(alerts) != 1 || alerts[0].Objective != SLOLatency || alerts[0].BurnRate < 9.99 {
+		t.Errorf("unexpected alerts: %v", alerts)
+	}
+}
+
+func TestSLOTracker_Recorder(t *testing.T) {
+	tracker := newTestSLOTracker()
+	r := tracker.Recorder(NewExpvarMetrics())
+	for i := 0; i < 10; i++ {
+		r.RecordRequest("GET", "/users", "503", time.Millisecond, 0, 0)
+	}
+	alerts := tracker.Evaluate(context.Background(), time.Now())
+	if len(alerts) != 1 || alerts[0].Objective != SLOAvailability || alerts[0].BurnRate < 99.99 {
+		t.Errorf("unexpected alerts: %v", alerts)
+	}
+}
+
+func TestSLOWebhook(t *testing.T) {
+	payloads := make(chan map[string]interface{}, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer s.Close()
+
+	alert := SLOAlert{Status: SLOResolved, Method: "GET", Endpoint: "/users", Objective: SLOAvailability, Rule: "page"}
+	for _, tc := range []struct {
+		format string
+		key    string
+		want   interface{}
+	}{
+		{config.SLOWebhookGeneric, "status", SLOResolved},
+		{config.SLOWebhookSlack, "text", alert.Summary()},
+		{config.SLOWebhookPagerDuty, "event_action", "resolve"},
+	} {
+		w := NewSLOWebhook(&config.SLOWebhook{URL: s.URL, Format: tc.format, RoutingKey: "key"})
+		if err := w.NotifySLO(context.Background(), alert); err != nil {
+			t.Error(err)
+			continue
+		}
+		if payload := <-payloads; payload[tc.key] != tc.want {
+			t.Errorf("%s: unexpected payload %v", tc.format, payload)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/lifecycle"
+)
+
+// latencyBuckets are the upper bounds, in milliseconds, of the latency histogram of the endpoints.
+// The last bucket holds the slower requests
+var latencyBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// EndpointStats are the counters of an endpoint
+type EndpointStats struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+	// 99th percentile of the latency, in milliseconds, rounded up to the bound of its bucket
+	P99 float64 `json:"p99_ms"`
+	// requests per latency bucket
+	Buckets []uint64 `json:"buckets"`
+}
+
+// Stats keeps the counters of the endpoints. They can be saved to disk and loaded at startup, so they
+// survive the restarts of the gateway
+type Stats struct {
+	mu        sync.RWMutex
+	endpoints map[string]*EndpointStats
+}
+
+// NewStats returns an empty set of counters
+func NewStats() *Stats {
+	return &Stats{endpoints: map[string]*EndpointStats{}}
+}
+
+// Record adds a request to the counters of the endpoint
+func (s *Stats) Record(endpoint string, latency time.Duration, failed bool) {
+	ms := float64(latency) / float64(time.Millisecond)
+	bucket := sort.SearchFloat64s(latencyBuckets, ms)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.endpoints[endpoint]
+	if !ok {
+		e = &EndpointStats{Buckets: make([]uint64, len(latencyBuckets)+1)}
+		s.endpoints[endpoint] = e
+	}
+	e.Requests++
+	if failed {
+		e.Errors++
+	}
+	e.Buckets[bucket]++
+}
+
+// Snapshot returns a copy of the counters of every endpoint
+func (s *Stats) Snapshot() map[string]EndpointStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(map[string]EndpointStats, len(s.endpoints))
+	for name, e := range s.endpoints {
+		c := *e
+		c.Buckets = append([]uint64{}, e.Buckets...)
+		c.P99 = percentile(c.Buckets, c.Requests, 0.99)
+		snapshot[name] = c
+	}
+	return snapshot
+}
+
+// Handler returns a handler with the counters of the endpoints as JSON, for the /__stats view
+func (s *Stats) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Snapshot())
+	}
+}
+
+// Save writes the counters to the file, replacing it atomically
+func (s *Stats) Save(path string) error {
+	b, err := json.Marshal(s.Snapshot())
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load adds the counters saved in the file to the current ones. A missing file is not an error
+func (s *Stats) Load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	saved := map[string]EndpointStats{}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, e := range saved {
+		current, ok := s.endpoints[name]
+		if !ok {
+			current = &EndpointStats{Buckets: make([]uint64, len(latencyBuckets)+1)}
+			s.endpoints[name] = current
+		}
+		current.Requests += e.Requests
+		current.Errors += e.Errors
+		for i := 0; i < len(e.Buckets) && i < len(current.Buckets); i++ {
+			current.Buckets[i] += e.Buckets[i]
+		}
+	}
+	return nil
+}
+
+// Persister returns a component loading the counters from the file when it starts, saving them every
+// interval and once more when it stops
+func (s *Stats) Persister(path string, interval time.Duration) lifecycle.Component {
+	var group *lifecycle.Group
+	return lifecycle.Funcs{
+		StartFunc: func(ctx context.Context) error {
+			if err := s.Load(path); err != nil {
+				return err
+			}
+			group = lifecycle.NewGroup(context.Background())
+			group.Go(func(ctx context.Context) {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						s.Save(path)
+					case <-ctx.Done():
+						return
+					}
+				}
+			})
+			return nil
+		},
+		StopFunc: func(ctx context.Context) error {
+			if group != nil {
+				if err := group.Stop(ctx); err != nil {
+					return err
+				}
+			}
+			return s.Save(path)
+		},
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the received percentile
+func percentile(buckets []uint64, total uint64, p float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	target := uint64(float64(total)*p + 0.5)
+	if target == 0 {
+		target = 1
+	}
+	var acc uint64
+	for i, count := range buckets {
+		acc += count
+		if acc >= target {
+			if i < len(latencyBuckets) {
+				return latencyBuckets[i]
+			}
+			break
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
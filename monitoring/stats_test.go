@@ -0,0 +1,57 @@
+package monitoring
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStats_p99(t *testing.T) {
+	s := NewStats()
+	for i := 0; i < 98; i++ {
+		s.Record("GET /supu", 3*time.Millisecond, false)
+	}
+	s.Record("GET /supu", 200*time.Millisecond, true)
+	s.Record("GET /supu", 20*time.Second, true)
+
+	e := s.Snapshot()["GET /supu"]
+	if e.Requests != 100 || e.Errors != 2 {
+		t.Errorf("unexpected counters: %d requests, %d errors", e.Requests, e.Errors)
+	}
+	if e.P99 != 250 {
+		t.Errorf("want p99 250, have %v", e.P99)
+	}
+}
+
+func TestStats_Persister(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	s := NewStats()
+	p := s.Persister(path, time.Hour)
+	if err := p.Start(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	s.Record("GET /supu", time.Millisecond, false)
+	if err := p.Stop(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	restarted := NewStats()
+	p = restarted.Persister(path, time.Hour)
+	if err := p.Start(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	restarted.Record("GET /supu", time.Millisecond, true)
+	if err := p.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	e := restarted.Snapshot()["GET /supu"]
+	if e.Requests != 2 || e.Errors != 1 {
+		t.Errorf("unexpected counters after the restart: %d requests, %d errors", e.Requests, e.Errors)
+	}
+}
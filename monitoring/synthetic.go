@@ -0,0 +1,172 @@
+package monitoring
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+var (
+	syntheticDuration = metrics.NewHistogram("porta_synthetic_probe_duration_seconds", "End-to-end latency of the synthetic requests sent to the gateway", nil, "probe")
+	syntheticResults  = metrics.NewCounter("porta_synthetic_probe_total", "Synthetic requests sent to the gateway by outcome: success, slow or error", "probe", "outcome")
+)
+
+// Outcomes of the synthetic requests
+const (
+	SyntheticSuccess = "success"
+	SyntheticSlow    = "slow"
+	SyntheticError   = "error"
+)
+
+// SyntheticResult is the result of the last request of a synthetic probe
+type SyntheticResult struct {
+	Probe    string        `json:"probe"`
+	Outcome  string        `json:"outcome"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	At       time.Time     `json:"at"`
+}
+
+// Prober sends the synthetic requests of the config to the gateway through the loopback interface, so
+// they cross the whole pipeline of the real requests: the server, the middlewares and the proxies
+type Prober struct {
+	probes  []*config.SyntheticProbe
+	baseURL string
+	client  *http.Client
+	logger  logging.Logger
+
+	mu      sync.RWMutex
+	results map[string]SyntheticResult
+}
+
+// NewProber creates a prober of the synthetic probes of the config, sending them to the address of the
+// service
+func NewProber(cfg config.ServiceConfig, logger logging.Logger) *Prober {
+	return &Prober{
+		probes:  cfg.SyntheticProbes,
+		baseURL: loopbackURL(cfg),
+		// the certificate of the gateway is not issued for the loopback address
+		client:  &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+		logger:  logger,
+		results: map[string]SyntheticResult{},
+	}
+}
+
+// Run sends the requests of every probe at its interval until the context is canceled
+func (p *Prober) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, probe := range p.probes {
+		wg.Add(1)
+		go func(probe *config.SyntheticProbe) {
+			defer wg.Done()
+			ticker := time.NewTicker(probe.Interval)
+			defer ticker.Stop()
+			for {
+				p.Probe(ctx, probe)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(probe)
+	}
+	wg.Wait()
+}
+
+// Results returns the result of the last request of every probe
+func (p *Prober) Results() []SyntheticResult {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	res := make([]SyntheticResult, 0, len(p.results))
+	for _, probe := range p.probes {
+		if r, ok := p.results[probe.Name]; ok {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Probe sends the request of the probe, recording its latency and outcome
+func (p *Prober) Probe(ctx context.Context, probe *config.SyntheticProbe) SyntheticResult {
+	res := SyntheticResult{Probe: probe.Name, At: time.Now()}
+	status, err := p.send(ctx, probe)
+	res.Duration, res.Status = time.Since(res.At), status
+
+	switch {
+	case err != nil:
+		res.Outcome, res.Error = SyntheticError, err.Error()
+	case probe.LatencySLO > 0 && res.Duration > probe.LatencySLO:
+		res.Outcome = SyntheticSlow
+	default:
+		res.Outcome = SyntheticSuccess
+	}
+	syntheticDuration.Observe(res.Duration.Seconds(), probe.Name)
+	syntheticResults.Add(1, probe.Name, res.Outcome)
+	if res.Outcome != SyntheticSuccess && p.logger != nil {
+		p.logger.Warning("synthetic probe", probe.Name, res.Outcome, res.Duration, res.Error)
+	}
+
+	p.mu.Lock()
+	p.results[probe.Name] = res
+	p.mu.Unlock()
+	return res
+}
+
+func (p *Prober) send(ctx context.Context, probe *config.SyntheticProbe) (int, error) {
+	localCtx, cancel := context.WithTimeout(ctx, probe.Timeout)
+	defer cancel()
+
+	var body io.Reader
+	if probe.Body != "" {
+		body = strings.NewReader(probe.Body)
+	}
+	req, err := http.NewRequestWithContext(localCtx, probe.Method, p.baseURL+probe.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range probe.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != probe.ExpectedStatus {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// loopbackURL returns the base URL of the service through the loopback interface
+func loopbackURL(cfg config.ServiceConfig) string {
+	address := fmt.Sprintf(":%d", cfg.Port)
+	if cfg.Listen != nil && cfg.Listen.Address != "" {
+		address = cfg.Listen.Address
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "80"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if cfg.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,58 @@
+package monitoring
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestProber_Probe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(20 * time.Millisecond)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if r.Header.Get("X-Probe") != "synthetic" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.ServiceConfig{SyntheticProbes: []*config.SyntheticProbe{
+		{Name: "ok", URL: "/ok"},
+		{Name: "slow", URL: "/slow", LatencySLO: time.Millisecond},
+		{Name: "missing", URL: "/missing"},
+	}}
+	p := NewProber(cfg, nil)
+	p.baseURL = ts.URL
+
+	for i, outcome := range []string{SyntheticSuccess, SyntheticSlow, SyntheticError} {
+		probe := cfg.SyntheticProbes[i]
+		probe.Method, probe.Timeout, probe.ExpectedStatus = "GET", time.Second, http.StatusOK
+		probe.Headers = map[string]string{"X-Probe": "synthetic"}
+		if res := p.Probe(context.Background(), probe); res.Outcome != outcome {
+			t.Errorf("%s: unexpected result %+v", probe.Name, res)
+		}
+	}
+	if results := p.Results(); len(results) != 3 || results[2].Status != http.StatusNotFound {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestLoopbackURL(t *testing.T) {
+	for want, cfg := range map[string]config.ServiceConfig{
+		"http://127.0.0.1:8080":  {Port: 8080},
+		"https://127.0.0.1:8443": {Listen: &config.Listen{Address: ":8443"}, TLS: &config.TLS{}},
+		"http://10.0.0.1:9000":   {Listen: &config.Listen{Address: "10.0.0.1:9000"}},
+	} {
+		if got := loopbackURL(cfg); got != want {
+			t.Errorf("unexpected url %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"net/textproto"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// Headers added to the backend requests to attribute their cost
+const (
+	TeamHeader     = "X-Porta-Team"
+	EndpointHeader = "X-Porta-Endpoint"
+	PlanHeader     = "X-Porta-Plan"
+)
+
+// NewCostAttributionMiddleware creates a proxy middleware tagging the requests to the backends with the
+// team owning the endpoint, the endpoint itself and the plan of the client
+func NewCostAttributionMiddleware(cfg *config.EndpointConfig) Middleware {
+	endpoint := cfg.Method + " " + cfg.Endpoint
+	planHeader := ""
+	if cfg.CostAttribution.PlanHeader != "" {
+		planHeader = textproto.CanonicalMIMEHeaderKey(cfg.CostAttribution.PlanHeader)
+	}
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			headers := make(map[string][]string, len(request.Headers)+3)
+			for k, v := range request.Headers {
+				headers[k] = v
+			}
+			headers[EndpointHeader] = []string{endpoint}
+			if cfg.CostAttribution.Team != "" {
+				headers[TeamHeader] = []string{cfg.CostAttribution.Team}
+			}
+			if plan := request.Headers[planHeader]; planHeader != "" && len(plan) > 0 {
+				headers[PlanHeader] = plan[:1]
+			}
+
+			r := request.Clone()
+			r.Headers = headers
+			return next[0](ctx, &r)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewCostAttributionMiddleware(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Method:          "GET",
+		Endpoint:        "/users/:id",
+		CostAttribution: &config.CostAttribution{Team: "identity", PlanHeader: "x-client-plan"},
+	}
+	assertion := func(_ context.Context, r *Request) (*Response, error) {
+		for k, want := range map[string]string{
+			TeamHeader:     "identity",
+			EndpointHeader: "GET /users/:id",
+			PlanHeader:     "gold",
+		} {
+			if have := r.Headers[k]; len(have) != 1 || have[0] != want {
+				t.Errorf("%s: want %s, have %v", k, want, have)
+			}
+		}
+		return &Response{IsComplete: true}, nil
+	}
+
+	request := &Request{Headers: map[string][]string{"X-Client-Plan": {"gold"}}}
+	if _, err := NewCostAttributionMiddleware(cfg)(assertion)(context.Background(), request); err != nil {
+		t.Error(err)
+	}
+	if _, ok := request.Headers[TeamHeader]; ok {
+		t.Error("the original request was modified")
+	}
+}
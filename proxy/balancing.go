@@ -7,17 +7,27 @@ import (
 
 	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/sd"
+	"github.com/ph0m1/porta/security"
 )
 
+// NewRoundRobinLoadBalancedMiddleware balances the requests between the hosts of the backend, skipping
+// the ones marked as unhealthy in sd.DefaultHostHealth
 func NewRoundRobinLoadBalancedMiddleware(remote *config.Backend) Middleware {
-	return newLoadBalancedMiddleware(sd.NewRoundRobinLB(sd.FixedSubscriber(remote.Host)))
+	return newLoadBalancedMiddleware(remote.URLPattern, sd.NewRoundRobinLB(healthyHosts(remote)))
 }
 
+// NewRandomLoadBalancedMiddleware sends the requests to a random healthy host of the backend
 func NewRandomLoadBalancedMiddleware(remote *config.Backend) Middleware {
-	return newLoadBalancedMiddleware(sd.NewRandomLB(sd.FixedSubscriber(remote.Host), time.Now().UnixNano()))
+	return newLoadBalancedMiddleware(remote.URLPattern, sd.NewRandomLB(healthyHosts(remote), time.Now().UnixNano()))
 }
 
-func newLoadBalancedMiddleware(lb sd.Balancer) Middleware {
+func healthyHosts(remote *config.Backend) sd.Subscriber {
+	return sd.NewHealthySubscriber(sd.FixedSubscriber(remote.Host), sd.DefaultHostHealth)
+}
+
+// newLoadBalancedMiddleware sends the requests to the hosts selected by the balancer, recording the
+// duration and the outcome of the requests by host
+func newLoadBalancedMiddleware(backend string, lb sd.Balancer) Middleware {
 	return func(next ...Proxy) Proxy {
 		if len(next) > 1 {
 			panic(ErrTooManyProxies)
@@ -38,7 +48,11 @@ func newLoadBalancedMiddleware(lb sd.Balancer) Middleware {
 			}
 			r.URL.RawQuery = r.Query.Encode()
 
-			return next[0](ctx, &r)
+			security.RecordBackendHost(ctx, host)
+			begin := time.Now()
+			resp, err := next[0](ctx, &r)
+			recordHost(backend, host, time.Since(begin), err)
+			return resp, err
 		}
 	}
 }
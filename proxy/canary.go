@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+const defaultCanaryMaxSamples = 10
+
+var canaryComparisons = metrics.NewCounter("porta_canary_comparisons_total", "Total number of comparisons between the primary and the canary responses, by result", "endpoint", "result")
+
+// Difference is a field whose value differs between the primary and the canary responses. The value
+// of a missing field is nil
+type Difference struct {
+	Path    string      `json:"path"`
+	Primary interface{} `json:"primary"`
+	Canary  interface{} `json:"canary"`
+}
+
+// CanaryDiff is a sampled mismatch between the primary and the canary responses of an endpoint
+type CanaryDiff struct {
+	Endpoint    string       `json:"endpoint"`
+	Time        time.Time    `json:"time"`
+	Differences []Difference `json:"differences"`
+}
+
+var canarySamples = struct {
+	sync.Mutex
+	diffs map[string][]CanaryDiff
+}{diffs: map[string][]CanaryDiff{}}
+
+// CanaryDiffs returns the sampled mismatches of all the endpoints, the most recent last
+func CanaryDiffs() []CanaryDiff {
+	canarySamples.Lock()
+	defer canarySamples.Unlock()
+	diffs := []CanaryDiff{}
+	for _, d := range canarySamples.diffs {
+		diffs = append(diffs, d...)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Time.Before(diffs[j].Time) })
+	return diffs
+}
+
+// CanaryDiffHandler returns a handler listing the sampled mismatches as JSON. The values of the responses
+// may hold personal data, so the handler replaces them with their JSON type, like "string" or "number".
+// CanaryDiffs returns the raw values
+func CanaryDiffHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		diffs := CanaryDiffs()
+		for i, d := range diffs {
+			redacted := make([]Difference, len(d.Differences))
+			for j, diff := range d.Differences {
+				redacted[j] = Difference{Path: diff.Path, Primary: jsonType(diff.Primary), Canary: jsonType(diff.Canary)}
+			}
+			diffs[i].Differences = redacted
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diffs)
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return "number"
+	}
+}
+
+// NewCanaryMiddleware returns a middleware sending a copy of the sampled requests to the canary proxy,
+// the second one, and returning the response of the primary proxy, the first one. The canary runs in
+// the background, bounded by the timeout of the endpoint, and its response is discarded once compared.
+// The canary calls running when the group is stopped are canceled. The endpoints with unsafe methods
+// are not mirrored unless the canary opts in
+func NewCanaryMiddleware(cfg *config.EndpointConfig, g *lifecycle.Group) Middleware {
+	canary := cfg.Canary
+	maxSamples := canary.MaxSamples
+	if maxSamples <= 0 {
+		maxSamples = defaultCanaryMaxSamples
+	}
+	sampleRate := canary.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = 1
+	}
+	mirrored := canary.MirrorUnsafeMethods || isSafeMethod(cfg.Method)
+
+	return func(next ...Proxy) Proxy {
+		if len(next) < 2 {
+			panic(ErrNotEnoughProxies)
+		}
+		if len(next) > 2 {
+			panic(ErrTooManyProxies)
+		}
+		if !mirrored {
+			return next[0]
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if sampleRate < 1 && rand.Float64() >= sampleRate {
+				return next[0](ctx, request)
+			}
+			mirror := request.Clone()
+			mirror.Headers = cloneValues(request.Headers)
+			mirror.Query = cloneValues(request.Query)
+			mirror.Params = cloneParams(request.Params)
+			if request.URL != nil {
+				u := *request.URL
+				mirror.URL = &u
+			}
+			if request.Body != nil {
+				body, err := ioutil.ReadAll(request.Body)
+				request.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				request.Body = ioutil.NopCloser(bytes.NewReader(body))
+				mirror.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+
+			canaryResponses := make(chan *Response, 1)
+			g.Go(func(groupCtx context.Context) {
+				canaryCtx, cancel := context.WithTimeout(groupCtx, cfg.Timeout)
+				defer cancel()
+				response, err := next[1](canaryCtx, &mirror)
+				if err != nil {
+					response = nil
+				}
+				canaryResponses <- response
+			})
+
+			response, err := next[0](ctx, request)
+			if canary.Compare && err == nil && response != nil {
+				// the caller owns the response once returned, so the comparison reads a copy
+				primary := &Response{Data: copyData(response.Data)}
+				g.Go(func(_ context.Context) {
+					compareCanary(cfg.Endpoint, canary, maxSamples, primary, <-canaryResponses)
+				})
+			}
+			return response, err
+		}
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func cloneValues(values map[string][]string) map[string][]string {
+	if values == nil {
+		return nil
+	}
+	cp := make(map[string][]string, len(values))
+	for k, v := range values {
+		cp[k] = append([]string(nil), v...)
+	}
+	return cp
+}
+
+func cloneParams(params map[string]string) map[string]string {
+	if params == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(params))
+	for k, v := range params {
+		cp[k] = v
+	}
+	return cp
+}
+
+func compareCanary(endpoint string, canary *config.Canary, maxSamples int, primary, other *Response) {
+	if other == nil {
+		canaryComparisons.Add(1, endpoint, "error")
+		return
+	}
+	diffs := Diff(primary.Data, other.Data, canary.Tolerance, canary.Ignore)
+	if len(diffs) == 0 {
+		canaryComparisons.Add(1, endpoint, "match")
+		return
+	}
+	canaryComparisons.Add(1, endpoint, "mismatch")
+
+	canarySamples.Lock()
+	samples := append(canarySamples.diffs[endpoint], CanaryDiff{Endpoint: endpoint, Time: time.Now(), Differences: diffs})
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	canarySamples.diffs[endpoint] = samples
+	canarySamples.Unlock()
+}
+
+// Diff returns the fields with different values in both datasets, sorted by path. The numbers whose
+// relative difference is under the tolerance are equal and the ignored paths are not compared
+func Diff(primary, canary map[string]interface{}, tolerance float64, ignore []string) []Difference {
+	ignored := make(map[string]struct{}, len(ignore))
+	for _, path := range ignore {
+		ignored[path] = struct{}{}
+	}
+	diffs := []Difference{}
+	diffValues("", primary, canary, tolerance, ignored, &diffs)
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
+
+func diffValues(path string, a, b interface{}, tolerance float64, ignored map[string]struct{}, diffs *[]Difference) {
+	if _, ok := ignored[path]; ok {
+		return
+	}
+	switch ta := a.(type) {
+	case map[string]interface{}:
+		tb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		prefix := path
+		if prefix != "" {
+			prefix += "."
+		}
+		for k, v := range ta {
+			diffValues(prefix+k, v, tb[k], tolerance, ignored, diffs)
+		}
+		for k, v := range tb {
+			if _, ok := ta[k]; !ok {
+				diffValues(prefix+k, nil, v, tolerance, ignored, diffs)
+			}
+		}
+		return
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok || len(ta) != len(tb) {
+			break
+		}
+		for i := range ta {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), ta[i], tb[i], tolerance, ignored, diffs)
+		}
+		return
+	case float64:
+		if tb, ok := b.(float64); ok && closeEnough(ta, tb, tolerance) {
+			return
+		}
+	default:
+		if reflect.DeepEqual(a, b) {
+			return
+		}
+	}
+	*diffs = append(*diffs, Difference{Path: path, Primary: a, Canary: b})
+}
+
+func closeEnough(a, b, tolerance float64) bool {
+	if a == b {
+		return true
+	}
+	scale := math.Max(math.Abs(a), math.Abs(b))
+	return math.Abs(a-b) <= tolerance*scale
+}
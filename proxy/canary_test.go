@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestDiff(t *testing.T) {
+	primary := map[string]interface{}{
+		"id":    1.0,
+		"price": 100.0,
+		"user":  map[string]interface{}{"name": "supu", "ts": 1.0},
+		"tags":  []interface{}{"a", "b"},
+	}
+	canary := map[string]interface{}{
+		"id":    1.0,
+		"price": 100.5,
+		"user":  map[string]interface{}{"name": "tupu", "ts": 2.0},
+		"tags":  []interface{}{"a", "c"},
+		"extra": true,
+	}
+	diffs := Diff(primary, canary, 0.01, []string{"user.ts"})
+	expected := []Difference{
+		{Path: "extra", Primary: nil, Canary: true},
+		{Path: "tags[1]", Primary: "b", Canary: "c"},
+		{Path: "user.name", Primary: "supu", Canary: "tupu"},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("unexpected diffs: %v", diffs)
+	}
+}
+
+func TestNewCanaryMiddleware(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/canary",
+		Timeout:  time.Second,
+		Canary:   &config.Canary{Compare: true},
+	}
+	canaryCalls := make(chan struct{}, 1)
+	p := NewCanaryMiddleware(cfg, testGroup(t))(
+		func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{Data: map[string]interface{}{"supu": "primary"}, IsComplete: true}, nil
+		},
+		func(_ context.Context, _ *Request) (*Response, error) {
+			canaryCalls <- struct{}{}
+			return &Response{Data: map[string]interface{}{"supu": "canary"}, IsComplete: true}, nil
+		},
+	)
+
+	response, err := p(context.Background(), &Request{Method: "GET"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Data["supu"] != "primary" {
+		t.Errorf("unexpected response: %v", response.Data)
+	}
+	// the comparison does not see the changes of the caller
+	response.Data["supu"] = "changed"
+	<-canaryCalls
+
+	for i := 0; i < 100; i++ {
+		for _, d := range CanaryDiffs() {
+			if d.Endpoint == "/canary" {
+				if len(d.Differences) != 1 || d.Differences[0].Path != "supu" || d.Differences[0].Primary != "primary" {
+					t.Errorf("unexpected differences: %v", d.Differences)
+				}
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("the mismatch was not sampled")
+}
+
+func TestNewCanaryMiddleware_notMirrored(t *testing.T) {
+	for _, cfg := range []*config.EndpointConfig{
+		{Endpoint: "/post", Method: "POST", Timeout: time.Second, Canary: &config.Canary{SampleRate: 1}},
+		{Endpoint: "/sampled", Method: "GET", Timeout: time.Second, Canary: &config.Canary{SampleRate: 1e-9}},
+	} {
+		g := testGroup(t)
+		canaryCalls := 0
+		p := NewCanaryMiddleware(cfg, g)(
+			func(_ context.Context, _ *Request) (*Response, error) {
+				return &Response{IsComplete: true}, nil
+			},
+			func(_ context.Context, _ *Request) (*Response, error) {
+				canaryCalls++
+				return &Response{IsComplete: true}, nil
+			},
+		)
+		for i := 0; i < 100; i++ {
+			if _, err := p(context.Background(), &Request{Method: cfg.Method}); err != nil {
+				t.Error(err)
+			}
+		}
+		g.Stop(context.Background())
+		if canaryCalls != 0 {
+			t.Errorf("%s: unexpected canary calls: %d", cfg.Endpoint, canaryCalls)
+		}
+	}
+}
+
+func TestNewCanaryMiddleware_unsafeMethods(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/post",
+		Method:   "POST",
+		Timeout:  time.Second,
+		Canary:   &config.Canary{SampleRate: 1, MirrorUnsafeMethods: true},
+	}
+	g := testGroup(t)
+	canaryCalls := make(chan string, 1)
+	p := NewCanaryMiddleware(cfg, g)(
+		func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{IsComplete: true}, nil
+		},
+		func(_ context.Context, r *Request) (*Response, error) {
+			body, _ := ioutil.ReadAll(r.Body)
+			canaryCalls <- string(body)
+			return &Response{IsComplete: true}, nil
+		},
+	)
+	if _, err := p(context.Background(), &Request{Method: "POST", Body: ioutil.NopCloser(strings.NewReader("supu"))}); err != nil {
+		t.Error(err)
+	}
+	if body := <-canaryCalls; body != "supu" {
+		t.Errorf("unexpected body of the canary: %q", body)
+	}
+}
+
+func TestCanaryDiffHandler(t *testing.T) {
+	compareCanary("/redacted", &config.Canary{}, 1,
+		&Response{Data: map[string]interface{}{"email": "supu@example.com", "age": 30.0}},
+		&Response{Data: map[string]interface{}{"email": "tupu@example.com"}},
+	)
+	w := httptest.NewRecorder()
+	CanaryDiffHandler()(w, httptest.NewRequest("GET", "/__canary", nil))
+	body := w.Body.String()
+	if strings.Contains(body, "example.com") {
+		t.Errorf("the handler exposes the values: %s", body)
+	}
+	if !strings.Contains(body, `{"path":"email","primary":"string","canary":"string"}`) ||
+		!strings.Contains(body, `{"path":"age","primary":"number","canary":"null"}`) {
+		t.Errorf("unexpected diffs: %s", body)
+	}
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// ErrCircuitOpen is the error returned by the circuit breaker middleware when the backend is known to be down
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets all the requests reach the backend
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all the requests without calling the backend
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial request reach the backend
+	CircuitHalfOpen
+)
+
+// CircuitStateStore keeps the open circuits, so they can be shared between several
+// gateway replicas. Implementations backed by a shared storage (redis, gossip, etc)
+// let a newly started replica know which backends the rest of the fleet already
+// considers down.
+type CircuitStateStore interface {
+	// Load returns the state stored for the circuit and the moment it expires
+	Load(name string) (CircuitState, time.Time, error)
+	// Store saves the state of the circuit until the received expiration time
+	Store(name string, state CircuitState, expiration time.Time) error
+}
+
+// NewMemoryCircuitStateStore returns a CircuitStateStore local to the process
+func NewMemoryCircuitStateStore() CircuitStateStore {
+	return &memoryCircuitStateStore{states: map[string]storedCircuitState{}}
+}
+
+type storedCircuitState struct {
+	state      CircuitState
+	expiration time.Time
+}
+
+type memoryCircuitStateStore struct {
+	states map[string]storedCircuitState
+	mu     sync.RWMutex
+}
+
+func (m *memoryCircuitStateStore) Load(name string) (CircuitState, time.Time, error) {
+	m.mu.RLock()
+	s, ok := m.states[name]
+	m.mu.RUnlock()
+	if !ok || time.Now().After(s.expiration) {
+		return CircuitClosed, time.Time{}, nil
+	}
+	return s.state, s.expiration, nil
+}
+
+func (m *memoryCircuitStateStore) Store(name string, state CircuitState, expiration time.Time) error {
+	m.mu.Lock()
+	if state == CircuitClosed {
+		delete(m.states, name)
+	} else {
+		m.states[name] = storedCircuitState{state, expiration}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// circuitSyncTTL is the time the circuit breakers reuse the state loaded from the store, so the shared
+// stores are not queried on every request
+const circuitSyncTTL = 100 * time.Millisecond
+
+// CircuitBreaker tracks the failures of a backend and opens the circuit when they
+// exceed the configured threshold. The open state is published to the store.
+type CircuitBreaker struct {
+	name     string
+	cfg      config.CircuitBreaker
+	store    CircuitStateStore
+	mu       sync.Mutex
+	failures int
+	since    time.Time
+	state    CircuitState
+	openedAt time.Time
+	trial    bool
+	// last state loaded from the store
+	shared           CircuitState
+	sharedExpiration time.Time
+	sharedErr        error
+	loadedAt         time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker sharing its state through the received store
+func NewCircuitBreaker(name string, cfg config.CircuitBreaker, store CircuitStateStore) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:  name,
+		cfg:   cfg,
+		store: store,
+		since: time.Now(),
+	}
+}
+
+// Name returns the key used to share the state of the circuit
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State returns the current state of the circuit, syncing it with the store first
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.sync(time.Now())
+	return cb.state
+}
+
+// Allow returns ErrCircuitOpen if the request should not reach the backend
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.sync(time.Now())
+	switch cb.state {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if cb.trial {
+			return ErrCircuitOpen
+		}
+		cb.trial = true
+	}
+	return nil
+}
+
+// Success registers a successful call to the backend
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.publish(CircuitClosed, time.Now())
+	}
+	cb.state = CircuitClosed
+	cb.trial = false
+	cb.failures = 0
+}
+
+// Failure registers a failed call to the backend
+func (cb *CircuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	if cb.state == CircuitHalfOpen {
+		cb.open(now)
+		return
+	}
+	if now.Sub(cb.since) > cb.cfg.Interval {
+		cb.failures = 0
+		cb.since = now
+	}
+	cb.failures++
+	if cb.failures >= cb.cfg.MaxErrors {
+		cb.open(now)
+	}
+}
+
+func (cb *CircuitBreaker) open(now time.Time) {
+	cb.state = CircuitOpen
+	cb.openedAt = now
+	cb.trial = false
+	cb.failures = 0
+	cb.publish(CircuitOpen, now.Add(cb.cfg.Timeout))
+}
+
+// publish stores the state, keeping it as the last one loaded
+func (cb *CircuitBreaker) publish(state CircuitState, expiration time.Time) {
+	cb.sharedErr = cb.store.Store(cb.name, state, expiration)
+	cb.shared, cb.sharedExpiration, cb.loadedAt = state, expiration, time.Now()
+}
+
+// sync updates the local state with the one shared by the rest of the replicas, loading it at most once
+// every circuitSyncTTL
+func (cb *CircuitBreaker) sync(now time.Time) {
+	if now.Sub(cb.loadedAt) >= circuitSyncTTL {
+		cb.shared, cb.sharedExpiration, cb.sharedErr = cb.store.Load(cb.name)
+		cb.loadedAt = now
+	}
+	shared, expiration, err := cb.shared, cb.sharedExpiration, cb.sharedErr
+	if err != nil {
+		// the store is not available: rely on the local state
+		shared = CircuitClosed
+	}
+
+	switch {
+	case shared == CircuitOpen && now.Before(expiration):
+		if cb.state != CircuitOpen {
+			cb.state = CircuitOpen
+			cb.openedAt = now
+		}
+	case cb.state == CircuitOpen && now.Sub(cb.openedAt) >= cb.cfg.Timeout:
+		cb.state = CircuitHalfOpen
+		cb.trial = false
+	case cb.state == CircuitOpen && err == nil && shared == CircuitClosed:
+		// another replica closed the circuit
+		cb.state = CircuitClosed
+		cb.failures = 0
+	}
+}
+
+// NewCircuitBreakerMiddleware creates a proxy middleware rejecting the requests while the circuit is open
+func NewCircuitBreakerMiddleware(cb *CircuitBreaker) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if err := cb.Allow(); err != nil {
+				return nil, err
+			}
+			response, err := next[0](ctx, request)
+			if err != nil {
+				cb.Failure()
+				return response, err
+			}
+			cb.Success()
+			return response, nil
+		}
+	}
+}
+
+// circuitName returns the key identifying the backend in the shared store
+func circuitName(remote *config.Backend) string {
+	return remote.Method + " " + strings.Join(remote.Host, ",") + remote.URLPattern
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisCircuitPrefix is prepended to the names of the circuits to build their redis keys
+const RedisCircuitPrefix = "porta:circuit:"
+
+// NewRedisCircuitStateStore returns a CircuitStateStore keeping the open circuits in redis, so every
+// replica of the gateway connected to it skips the backends already considered down. The keys expire
+// along with the circuits
+func NewRedisCircuitStateStore(pool *redis.Pool) CircuitStateStore {
+	return redisCircuitStateStore{pool}
+}
+
+type redisCircuitStateStore struct {
+	pool *redis.Pool
+}
+
+func (s redisCircuitStateStore) Load(name string) (CircuitState, time.Time, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	v, err := redis.String(conn.Do("GET", RedisCircuitPrefix+name))
+	if err == redis.ErrNil {
+		return CircuitClosed, time.Time{}, nil
+	}
+	if err != nil {
+		return CircuitClosed, time.Time{}, err
+	}
+	var state CircuitState
+	var expiration int64
+	if _, err := fmt.Sscanf(v, "%d %d", &state, &expiration); err != nil {
+		return CircuitClosed, time.Time{}, fmt.Errorf("invalid state of the circuit %s: %q", name, v)
+	}
+	return state, time.Unix(0, expiration), nil
+}
+
+func (s redisCircuitStateStore) Store(name string, state CircuitState, expiration time.Time) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	ttl := time.Until(expiration)
+	if state == CircuitClosed || ttl < time.Millisecond {
+		_, err := conn.Do("DEL", RedisCircuitPrefix+name)
+		return err
+	}
+	v := fmt.Sprintf("%d %d", state, expiration.UnixNano())
+	_, err := conn.Do("SET", RedisCircuitPrefix+name, v, "PX", int64(ttl/time.Millisecond))
+	return err
+}
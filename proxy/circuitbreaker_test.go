@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestCircuitBreaker_sharedState(t *testing.T) {
+	store := NewMemoryCircuitStateStore()
+	cfg := config.CircuitBreaker{MaxErrors: 2, Interval: time.Minute, Timeout: time.Minute}
+
+	failing := func(_ context.Context, _ *Request) (*Response, error) {
+		return nil, errors.New("supu")
+	}
+	replica1 := NewCircuitBreakerMiddleware(NewCircuitBreaker("backend", cfg, store))(failing)
+	for i := 0; i < cfg.MaxErrors; i++ {
+		if _, err := replica1(context.Background(), &Request{}); err == nil || err == ErrCircuitOpen {
+			t.Errorf("unexpected error at iteration %d: %v", i, err)
+		}
+	}
+	if _, err := replica1(context.Background(), &Request{}); err != ErrCircuitOpen {
+		t.Errorf("the circuit should be open. have: %v", err)
+	}
+
+	replica2 := NewCircuitBreakerMiddleware(NewCircuitBreaker("backend", cfg, store))(explosiveProxy(t))
+	if _, err := replica2(context.Background(), &Request{}); err != ErrCircuitOpen {
+		t.Errorf("the new replica should see the shared open circuit. have: %v", err)
+	}
+}
+
+func TestCircuitBreaker_halfOpen(t *testing.T) {
+	store := NewMemoryCircuitStateStore()
+	cb := NewCircuitBreaker("backend", config.CircuitBreaker{MaxErrors: 1, Interval: time.Minute, Timeout: time.Millisecond}, store)
+
+	cb.Failure()
+	if s := cb.State(); s != CircuitOpen {
+		t.Errorf("unexpected state: %d", s)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if err := cb.Allow(); err != nil {
+		t.Errorf("the trial request should be allowed: %v", err)
+	}
+	if err := cb.Allow(); err != ErrCircuitOpen {
+		t.Errorf("only a trial request should be allowed: %v", err)
+	}
+	cb.Success()
+	if s := cb.State(); s != CircuitClosed {
+		t.Errorf("unexpected state: %d", s)
+	}
+	if s, _, _ := store.Load("backend"); s != CircuitClosed {
+		t.Errorf("unexpected shared state: %d", s)
+	}
+}
+
+type countingCircuitStore struct {
+	CircuitStateStore
+	loads int
+}
+
+func (c *countingCircuitStore) Load(name string) (CircuitState, time.Time, error) {
+	c.loads++
+	return c.CircuitStateStore.Load(name)
+}
+
+func TestCircuitBreaker_syncTTL(t *testing.T) {
+	store := &countingCircuitStore{CircuitStateStore: NewMemoryCircuitStateStore()}
+	cb := NewCircuitBreaker("backend", config.CircuitBreaker{MaxErrors: 1, Interval: time.Minute, Timeout: time.Minute}, store)
+
+	for i := 0; i < 10; i++ {
+		if err := cb.Allow(); err != nil {
+			t.Error(err)
+		}
+	}
+	if store.loads != 1 {
+		t.Errorf("unexpected loads of the store: %d", store.loads)
+	}
+
+	// the circuits opened by the breaker do not wait for the next load
+	cb.Failure()
+	if err := cb.Allow(); err != ErrCircuitOpen {
+		t.Errorf("the circuit should be open. have: %v", err)
+	}
+
+	// the circuits closed by other replicas are seen after the ttl
+	store.Store("backend", CircuitClosed, time.Now())
+	time.Sleep(circuitSyncTTL)
+	if err := cb.Allow(); err != nil {
+		t.Errorf("the circuit should be closed. have: %v", err)
+	}
+	if store.loads != 2 {
+		t.Errorf("unexpected loads of the store: %d", store.loads)
+	}
+}
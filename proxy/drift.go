@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+const defaultDriftInterval = time.Minute
+
+var driftFields = metrics.NewGauge("porta_contract_drift_fields", "Number of fields of the last sampled backend response diverging from the snapshot", "endpoint", "backend", "change")
+
+// DriftReport describes the differences between the last sampled response of a backend and its snapshot
+type DriftReport struct {
+	Endpoint string `json:"endpoint"`
+	Backend  string `json:"backend"`
+	// fields not present in the snapshot
+	Added []string `json:"added"`
+	// fields of the snapshot missing in the response
+	Removed []string `json:"removed"`
+	// fields whose type does not match the snapshot
+	Changed  []string  `json:"changed"`
+	Samples  int       `json:"samples"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Drifted returns true if the last sample does not match the snapshot
+func (r DriftReport) Drifted() bool {
+	return len(r.Added)+len(r.Removed)+len(r.Changed) > 0
+}
+
+type driftDetector struct {
+	mu       sync.Mutex
+	interval time.Duration
+	snapshot map[string]string
+	next     time.Time
+	report   DriftReport
+}
+
+var driftDetectors = struct {
+	sync.RWMutex
+	all []*driftDetector
+}{}
+
+// DriftReports returns the reports of all the sampled backends
+func DriftReports() []DriftReport {
+	driftDetectors.RLock()
+	defer driftDetectors.RUnlock()
+	reports := make([]DriftReport, 0, len(driftDetectors.all))
+	for _, d := range driftDetectors.all {
+		d.mu.Lock()
+		reports = append(reports, d.report)
+		d.mu.Unlock()
+	}
+	return reports
+}
+
+// DriftReportHandler returns a handler listing the reports of all the sampled backends as JSON
+func DriftReportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DriftReports())
+	}
+}
+
+// NewContractDriftMiddleware returns a middleware sampling the responses of the backend, at most once
+// per interval, and comparing their shape with the snapshot of the backend. The report of the backend
+// is listed until the group is stopped, so the reloaded configs do not duplicate it
+func NewContractDriftMiddleware(cfg *config.EndpointConfig, backend *config.Backend, g *lifecycle.Group) Middleware {
+	d := &driftDetector{
+		interval: cfg.ContractDrift.Interval,
+		snapshot: cfg.ContractDrift.Schemas[backend.URLPattern],
+		report:   DriftReport{Endpoint: cfg.Endpoint, Backend: backend.URLPattern},
+	}
+	if d.interval <= 0 {
+		d.interval = defaultDriftInterval
+	}
+	driftDetectors.Lock()
+	driftDetectors.all = append(driftDetectors.all, d)
+	driftDetectors.Unlock()
+	g.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		d.unregister()
+	})
+
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			response, err := next[0](ctx, request)
+			if err == nil && response != nil && response.IsComplete {
+				d.sample(response.Data)
+			}
+			return response, err
+		}
+	}
+}
+
+func (d *driftDetector) unregister() {
+	driftDetectors.Lock()
+	defer driftDetectors.Unlock()
+	for i, other := range driftDetectors.all {
+		if other == d {
+			driftDetectors.all = append(driftDetectors.all[:i], driftDetectors.all[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *driftDetector) sample(data map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if now.Before(d.next) {
+		return
+	}
+	d.next = now.Add(d.interval)
+
+	shape := Shape(data)
+	d.report.Samples++
+	d.report.LastSeen = now
+	if len(d.snapshot) == 0 {
+		d.snapshot = shape
+		return
+	}
+
+	d.report.Added, d.report.Removed, d.report.Changed = nil, nil, nil
+	for field, kind := range shape {
+		expected, ok := d.snapshot[field]
+		if !ok {
+			d.report.Added = append(d.report.Added, field)
+		} else if expected != kind && kind != "null" {
+			d.report.Changed = append(d.report.Changed, field)
+		}
+	}
+	for field := range d.snapshot {
+		if _, ok := shape[field]; !ok {
+			d.report.Removed = append(d.report.Removed, field)
+		}
+	}
+	sort.Strings(d.report.Added)
+	sort.Strings(d.report.Removed)
+	sort.Strings(d.report.Changed)
+
+	driftFields.Set(float64(len(d.report.Added)), d.report.Endpoint, d.report.Backend, "added")
+	driftFields.Set(float64(len(d.report.Removed)), d.report.Endpoint, d.report.Backend, "removed")
+	driftFields.Set(float64(len(d.report.Changed)), d.report.Endpoint, d.report.Backend, "changed")
+}
+
+// Shape returns the type of every field of the data, keyed by its path. The fields of the nested
+// objects are joined with dots and the items of the arrays share the path of the array plus "[]"
+func Shape(data map[string]interface{}) map[string]string {
+	shape := map[string]string{}
+	shapeOf("", data, shape)
+	return shape
+}
+
+func shapeOf(path string, v interface{}, shape map[string]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if path != "" {
+			shape[path] = "object"
+			path += "."
+		}
+		for k, item := range t {
+			shapeOf(path+k, item, shape)
+		}
+	case []interface{}:
+		shape[path] = "array"
+		for _, item := range t {
+			shapeOf(path+"[]", item, shape)
+		}
+	case string:
+		shape[path] = "string"
+	case bool:
+		shape[path] = "boolean"
+	case nil:
+		if _, ok := shape[path]; !ok {
+			shape[path] = "null"
+		}
+	default:
+		shape[path] = "number"
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+)
+
+func TestShape(t *testing.T) {
+	shape := Shape(map[string]interface{}{
+		"id":   1.0,
+		"name": "supu",
+		"user": map[string]interface{}{"admin": true, "email": nil},
+		"tags": []interface{}{"a", "b"},
+	})
+	expected := map[string]string{
+		"id":         "number",
+		"name":       "string",
+		"user":       "object",
+		"user.admin": "boolean",
+		"user.email": "null",
+		"tags":       "array",
+		"tags[]":     "string",
+	}
+	if !reflect.DeepEqual(shape, expected) {
+		t.Errorf("unexpected shape: %v", shape)
+	}
+}
+
+func TestNewContractDriftMiddleware(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/drift",
+		ContractDrift: &config.ContractDrift{
+			Interval: time.Nanosecond,
+			Schemas: map[string]map[string]string{
+				"/supu": {"id": "number", "name": "string"},
+			},
+		},
+	}
+	backend := &config.Backend{URLPattern: "/supu"}
+	g := lifecycle.NewGroup(context.Background())
+	defer func() {
+		g.Stop(context.Background())
+		for _, report := range DriftReports() {
+			if report.Endpoint == "/drift" {
+				t.Errorf("the report is listed after the group is stopped: %v", report)
+			}
+		}
+	}()
+	p := NewContractDriftMiddleware(cfg, backend, g)(func(_ context.Context, _ *Request) (*Response, error) {
+		return &Response{Data: map[string]interface{}{"id": "1", "email": "a@b.c"}, IsComplete: true}, nil
+	})
+	if _, err := p(context.Background(), &Request{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, report := range DriftReports() {
+		if report.Endpoint != "/drift" {
+			continue
+		}
+		if !report.Drifted() || report.Samples != 1 {
+			t.Errorf("unexpected report: %v", report)
+		}
+		if !reflect.DeepEqual(report.Added, []string{"email"}) {
+			t.Errorf("unexpected added fields: %v", report.Added)
+		}
+		if !reflect.DeepEqual(report.Removed, []string{"name"}) {
+			t.Errorf("unexpected removed fields: %v", report.Removed)
+		}
+		if !reflect.DeepEqual(report.Changed, []string{"id"}) {
+			t.Errorf("unexpected changed fields: %v", report.Changed)
+		}
+		return
+	}
+	t.Error("report not found")
+}
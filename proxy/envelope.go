@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context carrying the id of the client request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id of the client request carried by the context, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type envelopeTraceKey struct{}
+
+// envelopeTrace collects the calls to the backends of a request
+type envelopeTrace struct {
+	mu       sync.Mutex
+	backends []map[string]interface{}
+	errors   []interface{}
+}
+
+func (t *envelopeTrace) record(backend string, d time.Duration, err error) {
+	call := map[string]interface{}{"backend": backend, "latency": milliseconds(d)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		call["error"] = err.Error()
+		t.errors = append(t.errors, map[string]interface{}{"backend": backend, "message": err.Error()})
+	}
+	t.backends = append(t.backends, call)
+}
+
+// NewEnvelopeMiddleware creates a proxy middleware wrapping the response data in a document like
+// {"data": {...}, "meta": {"latency": 12.5, "backends": [...], "request_id": "..."}, "errors": [...]}.
+// The latencies are in milliseconds. The partial responses are sent with the failures of their backends
+// as errors instead of failing the request
+func NewEnvelopeMiddleware(_ *config.EndpointConfig) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			trace := &envelopeTrace{}
+			begin := time.Now()
+			resp, err := next[0](context.WithValue(ctx, envelopeTraceKey{}, trace), request)
+			latency := time.Since(begin)
+			if resp == nil {
+				return nil, err
+			}
+
+			trace.mu.Lock()
+			defer trace.mu.Unlock()
+			errs := trace.errors
+			if errs == nil {
+				errs = []interface{}{}
+			}
+			if err != nil && len(trace.errors) == 0 {
+				errs = append(errs, map[string]interface{}{"message": err.Error()})
+			}
+			sort.Slice(trace.backends, func(i, j int) bool {
+				return trace.backends[i]["backend"].(string) < trace.backends[j]["backend"].(string)
+			})
+			backends := make([]interface{}, len(trace.backends))
+			for i, b := range trace.backends {
+				backends[i] = b
+			}
+
+			meta := map[string]interface{}{
+				"latency":  milliseconds(latency),
+				"backends": backends,
+			}
+			if id := RequestID(ctx); id != "" {
+				meta["request_id"] = id
+			}
+			data := resp.Data
+			if data == nil {
+				data = map[string]interface{}{}
+			}
+			return &Response{
+				Data:       map[string]interface{}{"data": data, "meta": meta, "errors": errs},
+				IsComplete: resp.IsComplete && err == nil,
+				Metadata:   resp.Metadata,
+			}, nil
+		}
+	}
+}
+
+// newEnvelopeTracker creates a proxy middleware recording the calls to the backend in the envelope of
+// the request
+func newEnvelopeTracker(backend *config.Backend) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			trace, ok := ctx.Value(envelopeTraceKey{}).(*envelopeTrace)
+			if !ok {
+				return next[0](ctx, request)
+			}
+			begin := time.Now()
+			resp, err := next[0](ctx, request)
+			trace.record(backend.URLPattern, time.Since(begin), err)
+			return resp, err
+		}
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
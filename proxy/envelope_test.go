@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging/gologging"
+)
+
+func TestNewEnvelopeMiddleware(t *testing.T) {
+	logger, _ := gologging.NewLogger("ERROR", bytes.NewBuffer(nil), "pref")
+	backends := func(cfg *config.Backend) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			if cfg.URLPattern == "/b" {
+				return nil, errors.New("boom")
+			}
+			return &Response{Data: map[string]interface{}{"supu": 42}, IsComplete: true}, nil
+		}
+	}
+	cfg := &config.EndpointConfig{
+		Method:           "GET",
+		Timeout:          time.Second,
+		Backend:          []*config.Backend{{URLPattern: "/a", Host: []string{"http://a"}}, {URLPattern: "/b", Host: []string{"http://b"}}},
+		ResponseEnvelope: true,
+	}
+	p, err := NewDefaultFactory(backends, logger).New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := p(WithRequestID(context.Background(), "abc"), &Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.IsComplete {
+		t.Error("the partial responses must be incomplete")
+	}
+	if data, ok := resp.Data["data"].(map[string]interface{}); !ok || data["supu"] != 42 {
+		t.Errorf("unexpected data: %v", resp.Data["data"])
+	}
+	meta := resp.Data["meta"].(map[string]interface{})
+	if meta["request_id"] != "abc" {
+		t.Errorf("unexpected request id: %v", meta["request_id"])
+	}
+	calls := meta["backends"].([]interface{})
+	if len(calls) != 2 || calls[0].(map[string]interface{})["backend"] != "/a" || calls[1].(map[string]interface{})["error"] != "boom" {
+		t.Errorf("unexpected backends: %v", calls)
+	}
+	if errs := resp.Data["errors"].([]interface{}); len(errs) != 1 || errs[0].(map[string]interface{})["backend"] != "/b" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
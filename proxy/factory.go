@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"context"
+
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
 	"github.com/ph0m1/porta/logging"
 )
 
@@ -9,17 +12,60 @@ type Factory interface {
 	New(cfg *config.EndpointConfig) (Proxy, error)
 }
 
+// GroupFactory is a Factory whose proxies run background work, like the warm cache refreshes, the
+// canary mirrors or the sampling of the contract drift. The routers bind that work to the group of the
+// config they build, so it ends when the config is replaced or the gateway stops
+type GroupFactory interface {
+	Factory
+	// WithGroup returns a factory binding the background work of its proxies to the group
+	WithGroup(g *lifecycle.Group) Factory
+}
+
+// WithGroup returns the factory binding the background work of its proxies to the group, or the
+// received one if it is not a GroupFactory
+func WithGroup(f Factory, g *lifecycle.Group) Factory {
+	if gf, ok := f.(GroupFactory); ok {
+		return gf.WithGroup(g)
+	}
+	return f
+}
+
+// processGroup holds the background work of the proxies created by the factories not bound to a group,
+// which lasts as long as the process
+var processGroup = lifecycle.NewGroup(context.Background())
+
 func DefaultFactory(logger logging.Logger) Factory {
 	return NewDefaultFactory(httpProxy, logger)
 }
 
 func NewDefaultFactory(backendFactory BackendFactory, logger logging.Logger) Factory {
-	return defaultFactory{backendFactory, logger}
+	return NewSharedCircuitFactory(backendFactory, logger, NewMemoryCircuitStateStore())
+}
+
+// NewSharedCircuitFactory returns a default factory sharing the state of the circuit breakers through the
+// received store, so several replicas of the gateway can agree on the backends that are down
+func NewSharedCircuitFactory(backendFactory BackendFactory, logger logging.Logger, store CircuitStateStore) Factory {
+	return defaultFactory{backendFactory, logger, store, nil, processGroup}
+}
+
+// NewHealthAwareFactory returns a default factory whose multi-backend endpoints skip the backends
+// reported as unhealthy by the received health or by their open circuit breakers
+func NewHealthAwareFactory(backendFactory BackendFactory, logger logging.Logger, store CircuitStateStore, health BackendHealth) Factory {
+	return defaultFactory{backendFactory, logger, store, health, processGroup}
 }
 
 type defaultFactory struct {
 	backendFactory BackendFactory
 	logger         logging.Logger
+	circuitStates  CircuitStateStore
+	health         BackendHealth
+	group          *lifecycle.Group
+}
+
+// WithGroup implements the GroupFactory interface
+func (pf defaultFactory) WithGroup(g *lifecycle.Group) Factory {
+	pf.group = g
+	return pf
 }
 
 func (pf defaultFactory) New(cfg *config.EndpointConfig) (p Proxy, err error) {
@@ -31,6 +77,33 @@ func (pf defaultFactory) New(cfg *config.EndpointConfig) (p Proxy, err error) {
 	default:
 		p, err = pf.newMulti(cfg)
 	}
+	if err == nil && cfg.Canary != nil {
+		p = NewCanaryMiddleware(cfg, pf.group)(p, pf.newCanary(cfg))
+	}
+	if err == nil && cfg.CostAttribution != nil {
+		p = NewCostAttributionMiddleware(cfg)(p)
+	}
+	if err == nil && cfg.Fallback != nil {
+		p = NewFallbackMiddleware(cfg)(p)
+	}
+	if err == nil && cfg.WarmCache != nil && cfg.CacheTTL > 0 {
+		p = NewWarmCacheMiddleware(cfg, pf.group)(p)
+	}
+	// the variants reshape the cached responses too, since they are not part of the cache keys
+	if err == nil && cfg.ResponseVariants != nil {
+		p = NewResponseVariantsMiddleware(cfg)(p)
+	}
+	// the journal records the writes before the rest of the layers can reject them
+	if err == nil && cfg.Journal != nil {
+		p = NewJournalMiddleware(cfg, OpenJournal(*cfg.Journal))(p)
+	}
+	if err == nil && cfg.ResponseEnvelope {
+		p = NewEnvelopeMiddleware(cfg)(p)
+	}
+	// the invalid query strings are rejected before reaching any other layer
+	if err == nil && len(cfg.QueryTypes) > 0 {
+		p = NewQueryTypesMiddleware(cfg)(p)
+	}
 	return
 }
 
@@ -38,22 +111,74 @@ func (pf defaultFactory) newMulti(cfg *config.EndpointConfig) (p Proxy, err erro
 	backendProxy := make([]Proxy, len(cfg.Backend))
 
 	for i, backend := range cfg.Backend {
-		backendProxy[i] = pf.newStack(backend)
+		backendProxy[i] = pf.newBackend(cfg, backend)
+	}
+	if cfg.WriteFanOut != nil && cfg.Method != config.GET {
+		return NewWriteFanOutMiddleware(cfg)(backendProxy...), nil
+	}
+	if _, _, err := mergePolicy(cfg); err != nil {
+		return nil, err
 	}
-	p = NewMergeDataMiddleware(cfg)(backendProxy...)
+	health := CircuitHealth(pf.circuitStates)
+	if pf.health != nil {
+		health = AllHealthy(health, pf.health)
+	}
+	p = NewHealthAwareMergeDataMiddleware(cfg, health)(backendProxy...)
 	return
 }
 
 func (pf defaultFactory) newSingle(cfg *config.EndpointConfig) (p Proxy, err error) {
-	return pf.newStack(cfg.Backend[0]), nil
+	return pf.newBackend(cfg, cfg.Backend[0]), nil
+}
+
+// newCanary returns the proxy of the canary backends of the endpoint
+func (pf defaultFactory) newCanary(cfg *config.EndpointConfig) Proxy {
+	canaryCfg := *cfg
+	canaryCfg.Backend = cfg.Canary.Backend
+	canaryCfg.Canary = nil
+	canaryCfg.ContractDrift = nil
+	if len(canaryCfg.Backend) == 1 {
+		return pf.newStack(canaryCfg.Backend[0])
+	}
+	p, _ := pf.newMulti(&canaryCfg)
+	return p
+}
+
+// newBackend returns the stack of the backend, sampling its responses if the endpoint watches the
+// contract of its backends and tracking its calls if the endpoint wraps its responses in an envelope
+func (pf defaultFactory) newBackend(cfg *config.EndpointConfig, backend *config.Backend) Proxy {
+	p := pf.newStack(backend)
+	if cfg.ContractDrift != nil {
+		p = NewContractDriftMiddleware(cfg, backend, pf.group)(p)
+	}
+	if cfg.ResponseEnvelope {
+		p = newEnvelopeTracker(backend)(p)
+	}
+	return NewTenantBackendMiddleware(backend)(p)
 }
 
 func (pf defaultFactory) newStack(backend *config.Backend) (p Proxy) {
 	p = pf.backendFactory(backend)
+	// the calls timing out count as errors of the circuit breaker
+	p = NewBackendTimeoutMiddleware(backend)(p)
+	if backend.CircuitBreaker != nil {
+		cb := NewCircuitBreaker(circuitName(backend), *backend.CircuitBreaker, pf.circuitStates)
+		p = NewCircuitBreakerMiddleware(cb)(p)
+	}
+	// the requests rejected by the soft circuit do not count as errors of the circuit breaker
+	if backend.Throttling != nil {
+		p = NewThrottlingMiddleware(backend)(p)
+	}
 	p = NewRoundRobinLoadBalancedMiddleware(backend)(p)
 
 	if backend.ConcurrentCalls > 1 {
 		p = NewConcurrentMiddleware(backend)(p)
 	}
+	if backend.Federated {
+		p = NewFederationMiddleware(backend)(p)
+	}
+	if len(backend.HiddenHeaders) > 0 {
+		p = NewHiddenHeadersMiddleware(backend)(p)
+	}
 	return
 }
@@ -112,3 +112,49 @@ func TestNewDefautFactory_ok(t *testing.T) {
 		t.Errorf("The proxy middleware propagated an unexpected error: %v\n", response)
 	}
 }
+
+func TestDefaultFactory_backendTimeout(t *testing.T) {
+	buff := bytes.NewBuffer(make([]byte, 1024))
+	logger, err := gologging.NewLogger("ERROR", buff, "pref")
+	if err != nil {
+		t.Error("building the logger: ", err.Error())
+		return
+	}
+	slow := func(ctx context.Context, _ *Request) (*Response, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return &Response{IsComplete: true}, nil
+		}
+	}
+	factory := NewDefaultFactory(func(_ *config.Backend) Proxy { return slow }, logger)
+
+	endpoint := config.EndpointConfig{
+		Endpoint: "/slow",
+		Timeout:  time.Second,
+		Backend:  []*config.Backend{{URLPattern: "/slow", Timeout: 10 * time.Millisecond}},
+	}
+	serviceConfig := config.ServiceConfig{
+		Version:   1,
+		Endpoints: []*config.EndpointConfig{&endpoint},
+		Host:      []string{"http://example.com"},
+	}
+	if err := serviceConfig.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := factory.New(&endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), endpoint.Timeout)
+	defer cancel()
+	if _, err := p(ctx, &Request{Method: "GET", Path: "/slow", Body: newDummyReadCloser("")}); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the backend timeout was not enforced: %s", elapsed)
+	}
+}
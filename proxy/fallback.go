@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// FallbackHeader is the header flagging the responses served by the fallback of the endpoint. Its value
+// is the source of the response: static or last-good
+const FallbackHeader = "X-Fallback"
+
+const maxLastGoodEntries = 1000
+
+var fallbackResponses = metrics.NewCounter("porta_fallback_responses_total", "Total number of responses served by the fallback of the endpoints", "endpoint", "source")
+
+// NewFallbackMiddleware creates a proxy middleware serving the configured fallback response when all the
+// backends of the endpoint are unavailable: they time out, their circuits are open, they can not be reached
+// or they answer with a 5xx. The rest of the errors, like the rejected requests, are returned as is. The last
+// good responses are kept per tenant. The fallback responses are never complete
+func NewFallbackMiddleware(cfg *config.EndpointConfig) Middleware {
+	fallback := *cfg.Fallback
+	headers := cacheHeaders(cfg)
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		var mu sync.RWMutex
+		lastGood := map[string]map[string]interface{}{}
+
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			response, err := next[0](ctx, request)
+			if err == nil || (response != nil && len(response.Data) > 0) || !unavailable(err) {
+				if fallback.LastGood && err == nil && response != nil && response.IsComplete && response.Io == nil {
+					key := cacheKey(ctx, request, headers)
+					mu.Lock()
+					if _, ok := lastGood[key]; ok || len(lastGood) < maxLastGoodEntries {
+						lastGood[key] = copyData(response.Data)
+					}
+					mu.Unlock()
+				}
+				return response, err
+			}
+
+			source, data := "static", fallback.Data
+			if fallback.LastGood {
+				mu.RLock()
+				if d, ok := lastGood[cacheKey(ctx, request, headers)]; ok {
+					source, data = "last-good", d
+				}
+				mu.RUnlock()
+			}
+			if data == nil {
+				return response, err
+			}
+			fallbackResponses.Add(1, cfg.Endpoint, source)
+			return &Response{
+				Data:     copyData(data),
+				Metadata: Metadata{Headers: map[string][]string{FallbackHeader: {source}}},
+			}, nil
+		}
+	}
+}
+
+// unavailable tells if the error reports backends timing out, with an open circuit, out of reach or
+// answering with a 5xx
+func unavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBackendUnhealthy) {
+		return true
+	}
+	var throttled ThrottledError
+	if errors.As(err, &throttled) {
+		return true
+	}
+	var statusErr StatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewFallbackMiddleware_static(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		Fallback: &config.Fallback{Data: map[string]interface{}{"supu": "fallback"}},
+	}
+	p := NewFallbackMiddleware(cfg)(func(_ context.Context, _ *Request) (*Response, error) {
+		return nil, ErrCircuitOpen
+	})
+
+	response, err := p(context.Background(), &Request{Method: "GET"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.IsComplete || response.Data["supu"] != "fallback" {
+		t.Errorf("unexpected response: %v", response)
+	}
+	if h := response.Metadata.Headers[FallbackHeader]; len(h) != 1 || h[0] != "static" {
+		t.Errorf("unexpected fallback header: %v", h)
+	}
+}
+
+func TestNewFallbackMiddleware_lastGood(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		Fallback: &config.Fallback{LastGood: true},
+	}
+	fail := false
+	p := NewFallbackMiddleware(cfg)(func(_ context.Context, _ *Request) (*Response, error) {
+		if fail {
+			return &Response{Data: map[string]interface{}{}}, context.DeadlineExceeded
+		}
+		return &Response{Data: map[string]interface{}{"supu": 42}, IsComplete: true}, nil
+	})
+
+	request := &Request{Method: "GET", Params: map[string]string{"Id": "1"}}
+	if _, err := p(context.Background(), request); err != nil {
+		t.Error(err)
+		return
+	}
+	fail = true
+	response, err := p(context.Background(), request)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Data["supu"] != 42 || response.Metadata.Headers[FallbackHeader][0] != "last-good" {
+		t.Errorf("unexpected response: %v", response)
+	}
+
+	if _, err := p(context.Background(), &Request{Method: "GET"}); err == nil {
+		t.Error("the error of an unknown request should be returned")
+	}
+}
+
+func TestNewFallbackMiddleware_errors(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		Fallback: &config.Fallback{Data: map[string]interface{}{"supu": "fallback"}},
+	}
+	for _, tc := range []struct {
+		err      error
+		fallback bool
+	}{
+		{context.DeadlineExceeded, true},
+		{ErrCircuitOpen, true},
+		{ErrBackendUnhealthy, true},
+		{ThrottledError{Status: http.StatusServiceUnavailable}, true},
+		{StatusCodeError{Status: http.StatusBadGateway}, true},
+		{&url.Error{Op: "Get", URL: "http://supu", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{StatusCodeError{Status: http.StatusNotFound}, false},
+		{ValidationError{Fields: []FieldError{{Pointer: "/name"}}}, false},
+		{PartialWriteError{Failed: map[string]error{"/a": ErrCircuitOpen}}, false},
+		{ErrBackendNotAllowed, false},
+		{errors.New("supu"), false},
+	} {
+		err := tc.err
+		p := NewFallbackMiddleware(cfg)(func(_ context.Context, _ *Request) (*Response, error) {
+			return nil, err
+		})
+		response, err := p(context.Background(), &Request{Method: "GET"})
+		if tc.fallback && (err != nil || response.Data["supu"] != "fallback") {
+			t.Errorf("%v: unexpected response: %v %v", tc.err, response, err)
+		}
+		if !tc.fallback && (response != nil || err == nil || err.Error() != tc.err.Error()) {
+			t.Errorf("%v: the error was not returned: %v %v", tc.err, response, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// ErrLoopDetected is the error returned when a request already went through the gateway
+var ErrLoopDetected = errors.New("loop detected between federated gateways")
+
+// TimeoutHeader is the header propagating the remaining time of the request to the federated gateways
+const TimeoutHeader = "X-Porta-Timeout"
+
+// NewFederationMiddleware creates a proxy middleware for the backends that are other gateways. It adds the
+// gateway to the Via header, propagates the remaining time of the request and rejects the requests that
+// already went through this gateway
+func NewFederationMiddleware(remote *config.Backend) Middleware {
+	via := "1.1 " + remote.GatewayID
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			previous := request.Headers["Via"]
+			for _, v := range previous {
+				for _, hop := range strings.Split(v, ",") {
+					if strings.TrimSpace(hop) == via {
+						return nil, ErrLoopDetected
+					}
+				}
+			}
+
+			headers := make(map[string][]string, len(request.Headers)+2)
+			for k, v := range request.Headers {
+				headers[k] = v
+			}
+			if len(previous) > 0 {
+				headers["Via"] = []string{strings.Join(previous, ", ") + ", " + via}
+			} else {
+				headers["Via"] = []string{via}
+			}
+			if deadline, ok := ctx.Deadline(); ok {
+				headers[TimeoutHeader] = []string{time.Until(deadline).Round(time.Millisecond).String()}
+			}
+
+			r := request.Clone()
+			r.Headers = headers
+			return next[0](ctx, &r)
+		}
+	}
+}
+
+// RequestTimeout returns the timeout to apply to a request, honoring the remaining time propagated
+// by a federated gateway when it is shorter than the received one. The endpoints without a timeout,
+// not initialized by the config, get the config.DefaultTimeout instead of an expired deadline
+func RequestTimeout(header http.Header, timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		timeout = config.DefaultTimeout
+	}
+	v := header.Get(TimeoutHeader)
+	if v == "" {
+		return timeout
+	}
+	remaining, err := time.ParseDuration(v)
+	if err != nil || remaining <= 0 || remaining >= timeout {
+		return timeout
+	}
+	return remaining
+}
+
+// NewHiddenHeadersMiddleware creates a proxy middleware removing the hidden headers of the backend from
+// its requests. They are the headers captured for the federated backends of the same endpoint
+func NewHiddenHeadersMiddleware(remote *config.Backend) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			headers := make(map[string][]string, len(request.Headers))
+			for k, v := range request.Headers {
+				headers[k] = v
+			}
+			for _, h := range remote.HiddenHeaders {
+				delete(headers, http.CanonicalHeaderKey(h))
+			}
+			r := request.Clone()
+			r.Headers = headers
+			return next[0](ctx, &r)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging/gologging"
+)
+
+func TestNewFederationMiddleware(t *testing.T) {
+	remote := &config.Backend{GatewayID: "edge"}
+	assertion := func(_ context.Context, r *Request) (*Response, error) {
+		if via := r.Headers["Via"]; len(via) != 1 || via[0] != "1.1 internal, 1.1 edge" {
+			t.Errorf("unexpected Via header: %v", via)
+		}
+		if len(r.Headers[TimeoutHeader]) != 1 {
+			t.Errorf("the remaining time was not propagated: %v", r.Headers)
+		}
+		return &Response{IsComplete: true}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	request := &Request{Headers: map[string][]string{"Via": {"1.1 internal"}}}
+	if _, err := NewFederationMiddleware(remote)(assertion)(ctx, request); err != nil {
+		t.Error(err)
+	}
+	if via := request.Headers["Via"]; len(via) != 1 || via[0] != "1.1 internal" {
+		t.Errorf("the original request was modified: %v", via)
+	}
+}
+
+func TestNewFederationMiddleware_loop(t *testing.T) {
+	remote := &config.Backend{GatewayID: "edge"}
+	request := &Request{Headers: map[string][]string{"Via": {"1.1 edge, 1.1 internal"}}}
+	if _, err := NewFederationMiddleware(remote)(explosiveProxy(t))(context.Background(), request); err != ErrLoopDetected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", time.Second},
+		{"supu", time.Second},
+		{"2s", time.Second},
+		{"150ms", 150 * time.Millisecond},
+	} {
+		header := http.Header{}
+		if tc.header != "" {
+			header.Set(TimeoutHeader, tc.header)
+		}
+		if have := RequestTimeout(header, time.Second); have != tc.want {
+			t.Errorf("%s: want %s, have %s", tc.header, tc.want, have)
+		}
+	}
+}
+
+func TestDefaultFactory_federatedHeaders(t *testing.T) {
+	cfg := config.ServiceConfig{
+		Version: 1,
+		Host:    []string{"http://127.0.0.1:8080"},
+		Endpoints: []*config.EndpointConfig{{
+			Endpoint: "/mixed",
+			Backend: []*config.Backend{
+				{URLPattern: "/third-party", Group: "public"},
+				{URLPattern: "/edge", Group: "edge", Federated: true},
+			},
+		}},
+	}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	endpoint := cfg.Endpoints[0]
+	for _, h := range endpoint.HeadersToPass {
+		if h == "Authorization" {
+			t.Errorf("the endpoint passes the credentials to all its backends: %v", endpoint.HeadersToPass)
+		}
+	}
+
+	var mu sync.Mutex
+	received := map[string]http.Header{}
+	backendFactory := func(b *config.Backend) Proxy {
+		return func(_ context.Context, r *Request) (*Response, error) {
+			mu.Lock()
+			received[b.URLPattern] = r.Headers
+			mu.Unlock()
+			return &Response{Data: map[string]interface{}{}, IsComplete: true}, nil
+		}
+	}
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	p, err := NewDefaultFactory(backendFactory, logger).New(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://127.0.0.1:8080/")
+	request := &Request{
+		Method:  "GET",
+		URL:     u,
+		Body:    newDummyReadCloser(""),
+		Headers: map[string][]string{"Authorization": {"Bearer secret"}, "X-Request-Id": {"abc"}},
+	}
+	if _, err := p(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := received["/third-party"]; h == nil || h.Get("Authorization") != "" || h.Get("X-Request-Id") != "" {
+		t.Errorf("unexpected headers of the non federated backend: %v", h)
+	}
+	if h := received["/edge"]; h == nil || h.Get("Authorization") != "Bearer secret" || h.Get("X-Request-Id") != "abc" {
+		t.Errorf("unexpected headers of the federated backend: %v", h)
+	}
+	if request.Headers["Authorization"] == nil {
+		t.Error("the headers of the original request were modified")
+	}
+}
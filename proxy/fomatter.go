@@ -103,7 +103,7 @@ func newWhitelistingFilter(whitelist []string) propertyFilter {
 				}
 			}
 		}
-		*entity = Response{accumulator, entity.IsComplete}
+		*entity = Response{Data: accumulator, IsComplete: entity.IsComplete, Metadata: entity.Metadata}
 	}
 }
 
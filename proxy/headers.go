@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// ErrHeadersTooLarge is the error of the backend requests exceeding the header limits of the backend
+var ErrHeadersTooLarge = errors.New("the headers of the backend request exceed its limits")
+
+var limitedHeaders = metrics.NewCounter("porta_backend_header_limit_total", "Total number of backend requests exceeding the header limits, by the action taken", "backend", "action")
+
+// headerLineOverhead is the size of the separator and the line break of every header line
+const headerLineOverhead = len(": \r\n")
+
+// limitHeaders enforces the header limits of the backend. The trimmed headers are a new map, so the
+// headers of the request, shared with the rest of the backends, are never modified. The headers are
+// kept in the order of their names until the limits are reached
+func limitHeaders(remote *config.Backend, h http.Header) (http.Header, error) {
+	limits := remote.HeaderLimits
+	if limits == nil || fitHeaders(limits, h) {
+		return h, nil
+	}
+	limitedHeaders.Add(1, remote.URLPattern, limits.Action)
+	if limits.Action != config.HeaderLimitTrim {
+		return nil, ErrHeadersTooLarge
+	}
+
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	trimmed := make(http.Header, len(h))
+	count, size := 0, 0
+	for _, name := range names {
+		for _, value := range h[name] {
+			lineSize := len(name) + len(value) + headerLineOverhead
+			if (limits.MaxCount > 0 && count+1 > limits.MaxCount) || (limits.MaxBytes > 0 && size+lineSize > limits.MaxBytes) {
+				continue
+			}
+			count++
+			size += lineSize
+			trimmed[name] = append(trimmed[name], value)
+		}
+	}
+	return trimmed, nil
+}
+
+func fitHeaders(limits *config.HeaderLimits, h http.Header) bool {
+	count, size := 0, 0
+	for name, values := range h {
+		count += len(values)
+		for _, value := range values {
+			size += len(name) + len(value) + headerLineOverhead
+		}
+	}
+	return (limits.MaxCount == 0 || count <= limits.MaxCount) && (limits.MaxBytes == 0 || size <= limits.MaxBytes)
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestLimitHeaders(t *testing.T) {
+	h := http.Header{
+		"Authorization": {"Bearer supu"},
+		"Via":           {"1.1 porta", "1.1 other"},
+		"X-Request-Id":  {"tupu"},
+	}
+
+	remote := &config.Backend{URLPattern: "/supu", HeaderLimits: &config.HeaderLimits{MaxCount: 4, Action: config.HeaderLimitReject}}
+	if res, err := limitHeaders(remote, h); err != nil || len(res) != 3 {
+		t.Errorf("unexpected result within the limits: %v, %v", res, err)
+	}
+
+	remote.HeaderLimits.MaxCount = 3
+	if _, err := limitHeaders(remote, h); err != ErrHeadersTooLarge {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	remote.HeaderLimits.Action = config.HeaderLimitTrim
+	res, err := limitHeaders(remote, h)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res["Via"]) != 2 || len(res["X-Request-Id"]) != 0 {
+		t.Errorf("unexpected trimmed headers: %v", res)
+	}
+	if len(h["X-Request-Id"]) != 1 {
+		t.Error("the headers of the request were modified")
+	}
+
+	remote.HeaderLimits = &config.HeaderLimits{MaxBytes: len("Authorization: Bearer supu\r\n"), Action: config.HeaderLimitTrim}
+	if res, _ := limitHeaders(remote, h); len(res) != 1 || res.Get("Authorization") == "" {
+		t.Errorf("unexpected trimmed headers: %v", res)
+	}
+}
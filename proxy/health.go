@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"errors"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// ErrBackendUnhealthy is the error of the backends skipped by the merge middleware because they are
+// known to be down
+var ErrBackendUnhealthy = errors.New("backend skipped: known to be unhealthy")
+
+var skippedBackends = metrics.NewCounter("porta_backend_skipped_total", "Total number of backend calls skipped by the merge middleware because the backend was down", "endpoint", "backend")
+
+// BackendHealth tells if a backend is expected to answer, like its health checks or its circuit
+// breaker do
+type BackendHealth interface {
+	Healthy(backend *config.Backend) bool
+}
+
+// BackendHealthFunc is a function implementing the BackendHealth interface
+type BackendHealthFunc func(*config.Backend) bool
+
+// Healthy implements the BackendHealth interface
+func (f BackendHealthFunc) Healthy(backend *config.Backend) bool {
+	return f(backend)
+}
+
+// CircuitHealth returns a BackendHealth reporting as unhealthy the backends whose circuit is open in
+// the store. The half open circuits are healthy, so their trial requests are still sent
+func CircuitHealth(store CircuitStateStore) BackendHealth {
+	return BackendHealthFunc(func(backend *config.Backend) bool {
+		if backend.CircuitBreaker == nil {
+			return true
+		}
+		state, _, err := store.Load(circuitName(backend))
+		return err != nil || state != CircuitOpen
+	})
+}
+
+// AllHealthy returns a BackendHealth reporting a backend as healthy only if all the received ones do
+func AllHealthy(checks ...BackendHealth) BackendHealth {
+	return BackendHealthFunc(func(backend *config.Backend) bool {
+		for _, c := range checks {
+			if c != nil && !c.Healthy(backend) {
+				return false
+			}
+		}
+		return true
+	})
+}
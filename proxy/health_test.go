@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewHealthAwareMergeDataMiddleware(t *testing.T) {
+	endpoint := &config.EndpointConfig{
+		Endpoint: "/supu",
+		Timeout:  time.Second,
+		Backend: []*config.Backend{
+			{URLPattern: "/a"},
+			{URLPattern: "/b", CircuitBreaker: &config.CircuitBreaker{MaxErrors: 1, Interval: time.Minute, Timeout: time.Minute}},
+		},
+	}
+	store := NewMemoryCircuitStateStore()
+	store.Store(circuitName(endpoint.Backend[1]), CircuitOpen, time.Now().Add(time.Minute))
+
+	healthy := func(ctx context.Context, _ *Request) (*Response, error) {
+		return &Response{Data: map[string]interface{}{"a": 1}, IsComplete: true}, nil
+	}
+	p := NewHealthAwareMergeDataMiddleware(endpoint, CircuitHealth(store))(healthy, explosiveProxy(t))
+
+	resp, err := p(context.Background(), &Request{})
+	if err != ErrBackendUnhealthy {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if resp == nil || resp.IsComplete || resp.Data["a"] != 1 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	down := BackendHealthFunc(func(*config.Backend) bool { return false })
+	p = NewHealthAwareMergeDataMiddleware(endpoint, AllHealthy(CircuitHealth(store), down))(explosiveProxy(t), explosiveProxy(t))
+	resp, err = p(context.Background(), &Request{})
+	if err != ErrBackendUnhealthy || resp.IsComplete || len(resp.Data) != 0 {
+		t.Errorf("unexpected result: %+v %v", resp, err)
+	}
+}
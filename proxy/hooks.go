@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// BackendCall describes a call to a backend
+type BackendCall struct {
+	Backend *config.Backend
+	Request *Request
+	Start   time.Time
+}
+
+// BackendOutcome is the result of a call to a backend
+type BackendOutcome struct {
+	Response *Response
+	Err      error
+	Duration time.Duration
+}
+
+// Hook observes the calls to the backends, so the metrics, the tracing, the audit logs or the plugins
+// do not have to wrap the proxies by themselves
+type Hook interface {
+	// BeforeBackend is called before sending the request. The returned context is passed to the
+	// backend and to AfterBackend, so the hooks can carry their own values, like a span
+	BeforeBackend(ctx context.Context, call BackendCall) context.Context
+	// AfterBackend is called once the backend returns
+	AfterBackend(ctx context.Context, call BackendCall, outcome BackendOutcome)
+}
+
+// HookFuncs adapts a pair of functions to the Hook interface. Any of them can be nil
+type HookFuncs struct {
+	Before func(ctx context.Context, call BackendCall) context.Context
+	After  func(ctx context.Context, call BackendCall, outcome BackendOutcome)
+}
+
+// BeforeBackend implements the Hook interface
+func (h HookFuncs) BeforeBackend(ctx context.Context, call BackendCall) context.Context {
+	if h.Before == nil {
+		return ctx
+	}
+	return h.Before(ctx, call)
+}
+
+// AfterBackend implements the Hook interface
+func (h HookFuncs) AfterBackend(ctx context.Context, call BackendCall, outcome BackendOutcome) {
+	if h.After != nil {
+		h.After(ctx, call, outcome)
+	}
+}
+
+// NewHooksMiddleware returns a middleware invoking the hooks around every call to the backend. The
+// AfterBackend hooks run in reverse order, so the first hook wraps all the others
+func NewHooksMiddleware(backend *config.Backend, hooks ...Hook) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		if len(hooks) == 0 {
+			return next[0]
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			call := BackendCall{Backend: backend, Request: request, Start: time.Now()}
+			contexts := make([]context.Context, len(hooks))
+			for i, h := range hooks {
+				ctx = h.BeforeBackend(ctx, call)
+				contexts[i] = ctx
+			}
+
+			resp, err := next[0](ctx, request)
+
+			outcome := BackendOutcome{Response: resp, Err: err, Duration: time.Since(call.Start)}
+			for i := len(hooks) - 1; i >= 0; i-- {
+				hooks[i].AfterBackend(contexts[i], call, outcome)
+			}
+			return resp, err
+		}
+	}
+}
+
+// HookedBackendFactory returns a BackendFactory invoking the hooks around every call made by the
+// proxies of the received factory. Those calls are the innermost ones of the backend stacks, so every
+// retry, concurrent call or balanced host is reported
+func HookedBackendFactory(bf BackendFactory, hooks ...Hook) BackendFactory {
+	return func(backend *config.Backend) Proxy {
+		return NewHooksMiddleware(backend, hooks...)(bf(backend))
+	}
+}
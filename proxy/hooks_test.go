@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+type hookKey string
+
+func TestNewHooksMiddleware(t *testing.T) {
+	expectedErr := errors.New("supu")
+	backend := &config.Backend{URLPattern: "/tupu"}
+	calls := []string{}
+	hook := func(name string) Hook {
+		return HookFuncs{
+			Before: func(ctx context.Context, call BackendCall) context.Context {
+				calls = append(calls, "before "+name)
+				return context.WithValue(ctx, hookKey(name), name)
+			},
+			After: func(ctx context.Context, call BackendCall, outcome BackendOutcome) {
+				calls = append(calls, "after "+name)
+				if ctx.Value(hookKey(name)) != name {
+					t.Errorf("the hook %s did not receive its context", name)
+				}
+				if call.Backend != backend || call.Request.Path != "/tupu" {
+					t.Errorf("unexpected call: %+v", call)
+				}
+				if outcome.Err != expectedErr || outcome.Duration <= 0 {
+					t.Errorf("unexpected outcome: %+v", outcome)
+				}
+			},
+		}
+	}
+
+	p := NewHooksMiddleware(backend, hook("a"), hook("b"), HookFuncs{})(func(ctx context.Context, _ *Request) (*Response, error) {
+		if ctx.Value(hookKey("a")) != "a" || ctx.Value(hookKey("b")) != "b" {
+			t.Error("the backend did not receive the context of the hooks")
+		}
+		return nil, expectedErr
+	})
+	if _, err := p(context.Background(), &Request{Path: "/tupu"}); err != expectedErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	expected := []string{"before a", "before b", "after b", "after a"}
+	if len(calls) != len(expected) {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("want: %s, have: %s", expected[i], calls[i])
+		}
+	}
+}
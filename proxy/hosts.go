@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// The host label only takes the values returned by the balancers, so its cardinality is bounded by the
+// hosts declared in the config or known by the service discovery
+var backendHostDuration = metrics.NewHistogram("porta_backend_host_duration_seconds", "Duration of the backend requests by host of the balanced host list", nil, "backend", "host", "outcome")
+
+// Outcomes of the backend requests recorded by host
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+	outcomeTimeout = "timeout"
+)
+
+// HostStats summarizes the requests sent to a host of a backend since the gateway started
+type HostStats struct {
+	Backend   string  `json:"backend"`
+	Host      string  `json:"host"`
+	Requests  uint64  `json:"requests"`
+	Errors    uint64  `json:"errors"`
+	Timeouts  uint64  `json:"timeouts"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+type hostKey struct{ backend, host string }
+
+type hostCounters struct{ requests, errors, timeouts uint64 }
+
+var hostStats sync.Map
+
+// BackendHostStats returns the stats of every host selected by the balancers, sorted by backend and
+// host, so a single bad instance behind a host list stands out
+func BackendHostStats() []HostStats {
+	res := []HostStats{}
+	hostStats.Range(func(k, v interface{}) bool {
+		key, c := k.(hostKey), v.(*hostCounters)
+		s := HostStats{
+			Backend:  key.backend,
+			Host:     key.host,
+			Requests: atomic.LoadUint64(&c.requests),
+			Errors:   atomic.LoadUint64(&c.errors),
+			Timeouts: atomic.LoadUint64(&c.timeouts),
+		}
+		if s.Requests > 0 {
+			s.ErrorRate = float64(s.Errors+s.Timeouts) / float64(s.Requests)
+		}
+		res = append(res, s)
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Backend == res[j].Backend {
+			return res[i].Host < res[j].Host
+		}
+		return res[i].Backend < res[j].Backend
+	})
+	return res
+}
+
+func recordHost(backend, host string, d time.Duration, err error) {
+	v, ok := hostStats.Load(hostKey{backend, host})
+	if !ok {
+		v, _ = hostStats.LoadOrStore(hostKey{backend, host}, &hostCounters{})
+	}
+	c := v.(*hostCounters)
+	atomic.AddUint64(&c.requests, 1)
+
+	outcome := outcomeSuccess
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		outcome = outcomeTimeout
+		atomic.AddUint64(&c.timeouts, 1)
+	default:
+		outcome = outcomeError
+		atomic.AddUint64(&c.errors, 1)
+	}
+	backendHostDuration.Observe(d.Seconds(), backend, host, outcome)
+}
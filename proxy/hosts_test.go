@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewRoundRobinLoadBalancedMiddleware_hostStats(t *testing.T) {
+	remote := &config.Backend{URLPattern: "/hosts-stats", Host: []string{"http://a", "http://b"}}
+	p := NewRoundRobinLoadBalancedMiddleware(remote)(func(_ context.Context, r *Request) (*Response, error) {
+		if r.URL.Host == "b" {
+			return nil, errors.New("boom")
+		}
+		return &Response{IsComplete: true}, nil
+	})
+	for i := 0; i < 4; i++ {
+		p(context.Background(), &Request{Path: "/"})
+	}
+
+	var stats []HostStats
+	for _, s := range BackendHostStats() {
+		if s.Backend == "/hosts-stats" {
+			stats = append(stats, s)
+		}
+	}
+	if len(stats) != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats[0].Host != "http://a" || stats[0].Requests != 2 || stats[0].ErrorRate != 0 {
+		t.Errorf("unexpected stats of the healthy host: %+v", stats[0])
+	}
+	if stats[1].Host != "http://b" || stats[1].Errors != 2 || stats[1].ErrorRate != 1 {
+		t.Errorf("unexpected stats of the failing host: %+v", stats[1])
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/encoding"
@@ -14,29 +15,59 @@ import (
 // received status code of the response is not 200 or 201
 var ErrInvalidStatusCode = errors.New("Invalid status code")
 
+// StatusCodeError is the error returned when a backend answers with an invalid status code. It wraps
+// ErrInvalidStatusCode
+type StatusCodeError struct {
+	Backend string
+	// status code of the backend response
+	Status int
+}
+
+func (e StatusCodeError) Error() string {
+	return fmt.Sprintf("%s %d from the backend %s", ErrInvalidStatusCode, e.Status, e.Backend)
+}
+
+// Unwrap returns ErrInvalidStatusCode
+func (e StatusCodeError) Unwrap() error {
+	return ErrInvalidStatusCode
+}
+
 // creates http client based with the received context
 type HTTPClientFactory func(ctx context.Context) *http.Client
 
+// defaultTransport is shared by all the default clients, so the idle connections are reused instead
+// of leaking a pair of goroutines per request
+var defaultTransport = &http.Transport{
+	Proxy:               nil, // 禁用代理
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     90 * time.Second,
+}
+
 func NewHttpClient(_ context.Context) *http.Client {
 	// 创建一个不使用代理的 HTTP 客户端
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: nil, // 禁用代理
-		},
-	}
-	return client
+	return &http.Client{Transport: defaultTransport}
 }
 
 func httpProxy(backend *config.Backend) Proxy {
 	return NewHttpProxy(backend, NewHttpClient, backend.Decoder)
 }
 
+// CustomHTTPProxyFactory returns a BackendFactory creating http proxies with the clients of the received factory
+func CustomHTTPProxyFactory(clientFactory HTTPClientFactory) BackendFactory {
+	return func(backend *config.Backend) Proxy {
+		return NewHttpProxy(backend, clientFactory, backend.Decoder)
+	}
+}
+
 func NewRequestBuilderMiddleware(remote *config.Backend) Middleware {
 	return func(next ...Proxy) Proxy {
 		if len(next) > 1 {
 			panic(ErrTooManyProxies)
 		}
 		return func(ctx context.Context, request *Request) (*Response, error) {
+			if err := request.ValidatePathParams(); err != nil {
+				return nil, err
+			}
 			r := request.Clone()
 			r.GeneratePath(remote.URLPattern)
 			r.Method = remote.Method
@@ -53,7 +84,9 @@ func NewHttpProxy(remote *config.Backend, clientFactory HTTPClientFactory, decod
 		if err != nil {
 			return nil, err
 		}
-		requestToBackend.Header = request.Headers
+		if requestToBackend.Header, err = limitHeaders(remote, request.Headers); err != nil {
+			return nil, err
+		}
 
 		resp, err := clientFactory(ctx).Do(requestToBackend.WithContext(ctx))
 		requestToBackend.Body.Close()
@@ -70,9 +103,17 @@ func NewHttpProxy(remote *config.Backend, clientFactory HTTPClientFactory, decod
 		fmt.Printf("[DEBUG] Backend response status: %d\n", resp.StatusCode)
 		fmt.Printf("[DEBUG] Backend response headers: %v\n", resp.Header)
 
+		if remote.Encoding == encoding.NOOP {
+			return passthroughResponse(remote, resp)
+		}
+
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 			fmt.Printf("[DEBUG] Invalid status code: %d\n", resp.StatusCode)
-			return nil, ErrInvalidStatusCode
+			resp.Body.Close()
+			if err := throttledResponse(remote, resp); err != nil {
+				return nil, err
+			}
+			return nil, StatusCodeError{Backend: remote.URLPattern, Status: resp.StatusCode}
 		}
 		var data map[string]interface{}
 		err = decode(resp.Body, &data)
@@ -80,7 +121,7 @@ func NewHttpProxy(remote *config.Backend, clientFactory HTTPClientFactory, decod
 		if err != nil {
 			return nil, err
 		}
-		r := formatter.Format(Response{data, true})
+		r := formatter.Format(Response{Data: data, IsComplete: true})
 		return &r, nil
 	}
 }
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/backendtest"
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
+)
+
+func TestNewHttpProxy_ok(t *testing.T) {
+	backend := backendtest.NewServer()
+	defer backend.Close()
+	backend.Handle("GET", "/supu", backendtest.Response{
+		JSON: map[string]interface{}{"supu": 42, "tupu": true, "foo": "bar"},
+	})
+
+	URL, _ := url.Parse(backend.URL + "/supu")
+	remote := &config.Backend{Blacklist: []string{"foo"}}
+	p := NewHttpProxy(remote, NewHttpClient, encoding.JSONDecoder)
+
+	response, err := p(context.Background(), &Request{
+		Method:  "GET",
+		URL:     URL,
+		Body:    newDummyReadCloser(""),
+		Headers: http.Header{"X-Supu": []string{"tupu"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !response.IsComplete || len(response.Data) != 2 {
+		t.Errorf("unexpected response: %v", response)
+	}
+
+	requests := backend.Requests()
+	if len(requests) != 1 || requests[0].Header.Get("X-Supu") != "tupu" {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+}
+
+func TestNewHttpProxy_invalidStatusCode(t *testing.T) {
+	backend := backendtest.NewServer()
+	defer backend.Close()
+	backend.Handle("GET", "/supu", backendtest.Response{Status: http.StatusNotFound})
+
+	URL, _ := url.Parse(backend.URL + "/supu")
+	p := NewHttpProxy(&config.Backend{}, NewHttpClient, encoding.JSONDecoder)
+
+	_, err := p(context.Background(), &Request{Method: "GET", URL: URL, Body: newDummyReadCloser("")})
+	if statusErr, ok := err.(StatusCodeError); !ok || statusErr.Status != http.StatusNotFound || !errors.Is(err, ErrInvalidStatusCode) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewHttpProxy_timeout(t *testing.T) {
+	backend := backendtest.NewServer()
+	defer backend.Close()
+	backend.Handle("GET", "/supu", backendtest.Response{Failure: backendtest.Hang})
+
+	URL, _ := url.Parse(backend.URL + "/supu")
+	p := NewHttpProxy(&config.Backend{}, NewHttpClient, encoding.JSONDecoder)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p(ctx, &Request{Method: "GET", URL: URL, Body: newDummyReadCloser("")}); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewHttpProxy_rangePassthrough(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "supu.txt", time.Unix(0, 0), strings.NewReader("0123456789"))
+	}))
+	defer backend.Close()
+
+	URL, _ := url.Parse(backend.URL + "/supu.txt")
+	p := NewHttpProxy(&config.Backend{Encoding: encoding.NOOP}, NewHttpClient, encoding.JSONDecoder)
+	response, err := p(context.Background(), &Request{
+		Method:  "GET",
+		URL:     URL,
+		Body:    newDummyReadCloser(""),
+		Headers: http.Header{"Range": []string{"bytes=2-5"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Metadata.StatusCode != http.StatusPartialContent || response.IsComplete {
+		t.Errorf("unexpected partial response: %+v", response)
+	}
+	if c := response.Metadata.Headers["Content-Range"]; len(c) != 1 || c[0] != "bytes 2-5/10" {
+		t.Errorf("unexpected content range: %v", c)
+	}
+	content, err := io.ReadAll(response.Io)
+	response.Io.Close()
+	if err != nil || string(content) != "2345" {
+		t.Errorf("unexpected content: %q %v", content, err)
+	}
+}
+
+func TestNewHttpProxy_streamedPassthrough(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "supu")
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, "tupu")
+	}))
+	defer backend.Close()
+
+	URL, _ := url.Parse(backend.URL + "/supu.txt")
+	remote := &config.Backend{Encoding: encoding.NOOP, Timeout: time.Second}
+	p := NewBackendTimeoutMiddleware(remote)(NewHttpProxy(remote, NewHttpClient, encoding.JSONDecoder))
+	response, err := p(context.Background(), &Request{Method: "GET", URL: URL, Body: newDummyReadCloser("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the content is read after the call returns, so the timeout of the backend must not end it
+	content, err := io.ReadAll(response.Io)
+	response.Io.Close()
+	if err != nil || string(content) != "suputupu" {
+		t.Errorf("unexpected content: %q %v", content, err)
+	}
+}
+
+func TestNewRequestBuilderMiddleware_pathParams(t *testing.T) {
+	var path string
+	mw := NewRequestBuilderMiddleware(&config.Backend{URLPattern: "/files/{{.Path}}", Method: "GET"})
+	p := mw(func(_ context.Context, r *Request) (*Response, error) {
+		path = r.Path
+		return &Response{IsComplete: true}, nil
+	})
+
+	for _, param := range []string{"../admin", "a/./b", "a/%2e%2e/b", "%2E%2E", ".."} {
+		_, err := p(context.Background(), &Request{Params: map[string]string{"Path": param}})
+		verr, ok := err.(ValidationError)
+		if !ok || len(verr.Fields) != 1 || verr.Fields[0].Pointer != "/params/Path" {
+			t.Errorf("%s: unexpected error: %v", param, err)
+		}
+	}
+	if path != "" {
+		t.Errorf("the backend was called with %s", path)
+	}
+
+	if _, err := p(context.Background(), &Request{Params: map[string]string{"Path": "a b/c?d#e/..f"}}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/files/a%20b/c%3Fd%23e/..f" {
+		t.Errorf("unexpected path: %s", path)
+	}
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// Stages of the journal entries. Every write gets an accepted entry before reaching the backends and a
+// succeeded or failed one once they answer
+const (
+	JournalAccepted  = "accepted"
+	JournalSucceeded = "succeeded"
+	JournalFailed    = "failed"
+)
+
+// JournalEntry is a record of the journal
+type JournalEntry struct {
+	// id shared by the entries of the same request
+	ID             string    `json:"id"`
+	Time           time.Time `json:"time"`
+	Stage          string    `json:"stage"`
+	Endpoint       string    `json:"endpoint"`
+	Method         string    `json:"method"`
+	BodyHash       string    `json:"body_hash,omitempty"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Journal is an append-only log of the write requests
+type Journal interface {
+	Append(JournalEntry) error
+	Entries() ([]JournalEntry, error)
+}
+
+// DefaultJournalMaxSize is the size rotating the journals without one
+const DefaultJournalMaxSize = 64 << 20
+
+var journals sync.Map
+
+// OpenJournal returns the file journal of the path, shared by all the endpoints and the admin report
+// using it. The first config opening a path sets its max size
+func OpenJournal(cfg config.Journal) Journal {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultJournalMaxSize
+	}
+	j, _ := journals.LoadOrStore(cfg.Path, &fileJournal{path: cfg.Path, maxSize: maxSize})
+	return j.(Journal)
+}
+
+// fileJournal writes the entries to a file as JSON lines. The file is kept open and the appends
+// waiting for the disk share the same fsync. The file is rotated once it reaches the max size, so the
+// entries are read from the current file and the previous one
+type fileJournal struct {
+	path    string
+	maxSize int64
+
+	// mu guards the file and the counters of the written entries
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	written uint64
+
+	// syncMu serializes the fsyncs and the rotations, so the file is not closed while it is synced
+	syncMu sync.Mutex
+	synced uint64
+}
+
+func (j *fileJournal) Append(e JournalEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	seq, err := j.write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+
+	// the entry must be on disk before the write reaches the backends. The appends queued while a
+	// fsync runs are covered by the next one
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+	if j.synced >= seq {
+		return nil
+	}
+	j.mu.Lock()
+	f, target, full := j.f, j.written, j.size >= j.maxSize
+	j.mu.Unlock()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	j.synced = target
+	if full {
+		return j.rotate()
+	}
+	return nil
+}
+
+// write appends the line to the file, returning its sequence number
+func (j *fileJournal) write(line []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		f, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return 0, err
+		}
+		j.f, j.size = f, info.Size()
+	}
+	n, err := j.f.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	j.written++
+	return j.written, nil
+}
+
+// rotate renames the file with the .1 suffix and starts a new one. It is called holding the syncMu
+func (j *fileJournal) rotate() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.size < j.maxSize {
+		return nil
+	}
+	// the entries written since the last fsync must be on disk before the file is closed
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.synced = j.written
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	j.f = nil
+	return os.Rename(j.path, j.path+".1")
+}
+
+func (j *fileJournal) Entries() ([]JournalEntry, error) {
+	entries := []JournalEntry{}
+	// the rotations are serialized with the reads, so no file is skipped
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+	for _, path := range []string{j.path + ".1", j.path} {
+		var err error
+		if entries, err = readJournal(path, entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func readJournal(path string, entries []JournalEntry) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := JournalEntry{}
+		// a line truncated by a crash is skipped instead of hiding the rest of the journal
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// NewJournalMiddleware creates a proxy middleware recording the write requests in the journal before
+// proxying them, along with their outcome. The requests are rejected if they can not be recorded
+func NewJournalMiddleware(cfg *config.EndpointConfig, journal Journal) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if request.Method == "GET" || request.Method == "HEAD" {
+				return next[0](ctx, request)
+			}
+			var body []byte
+			if request.Body != nil {
+				var err error
+				body, err = io.ReadAll(request.Body)
+				request.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				request.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			sum := sha256.Sum256(body)
+			entry := JournalEntry{
+				ID:       journalID(),
+				Time:     time.Now(),
+				Stage:    JournalAccepted,
+				Endpoint: cfg.Endpoint,
+				Method:   request.Method,
+				BodyHash: hex.EncodeToString(sum[:]),
+			}
+			if key := request.Headers[config.JournalIdempotencyHeader]; len(key) > 0 {
+				entry.IdempotencyKey = key[0]
+			}
+			if err := journal.Append(entry); err != nil {
+				return nil, err
+			}
+
+			resp, err := next[0](ctx, request)
+
+			entry.Time, entry.Stage = time.Now(), JournalSucceeded
+			if err != nil {
+				entry.Stage, entry.Error = JournalFailed, err.Error()
+			}
+			journal.Append(entry)
+			return resp, err
+		}
+	}
+}
+
+func journalID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// JournalReport summarizes the journal, listing the writes to audit or replay
+type JournalReport struct {
+	Accepted  int `json:"accepted"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// writes without outcome: in flight or lost in a crash of the gateway
+	Pending int `json:"pending"`
+	// last entry of the failed and pending writes, sorted by time
+	Unreconciled []JournalEntry `json:"unreconciled"`
+}
+
+// Reconcile matches the accepted writes of the journal with their outcome
+func Reconcile(journal Journal) (JournalReport, error) {
+	entries, err := journal.Entries()
+	if err != nil {
+		return JournalReport{}, err
+	}
+	last := map[string]JournalEntry{}
+	for _, e := range entries {
+		if e.Stage == JournalAccepted {
+			if _, ok := last[e.ID]; ok {
+				continue
+			}
+		}
+		last[e.ID] = e
+	}
+
+	report := JournalReport{Accepted: len(last), Unreconciled: []JournalEntry{}}
+	for _, e := range last {
+		switch e.Stage {
+		case JournalSucceeded:
+			report.Succeeded++
+			continue
+		case JournalFailed:
+			report.Failed++
+		default:
+			report.Pending++
+		}
+		report.Unreconciled = append(report.Unreconciled, e)
+	}
+	sort.Slice(report.Unreconciled, func(i, j int) bool { return report.Unreconciled[i].Time.Before(report.Unreconciled[j].Time) })
+	return report, nil
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewJournalMiddleware(t *testing.T) {
+	journal := OpenJournal(config.Journal{Path: filepath.Join(t.TempDir(), "journal")})
+	cfg := &config.EndpointConfig{Endpoint: "/orders"}
+	var body string
+	p := NewJournalMiddleware(cfg, journal)(func(_ context.Context, r *Request) (*Response, error) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		if body == "fail" {
+			return nil, errors.New("backend down")
+		}
+		return &Response{IsComplete: true}, nil
+	})
+
+	send := func(method, b string) error {
+		_, err := p(context.Background(), &Request{
+			Method:  method,
+			Body:    newDummyReadCloser(b),
+			Headers: map[string][]string{config.JournalIdempotencyHeader: {"key-" + b}},
+		})
+		return err
+	}
+	if err := send("POST", "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if body != "ok" {
+		t.Errorf("the body must reach the backends: %q", body)
+	}
+	if err := send("POST", "fail"); err == nil {
+		t.Error("expecting the error of the backend")
+	}
+	send("GET", "read")
+
+	entries, err := journal.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("the reads must not be recorded: %d entries", len(entries))
+	}
+	if e := entries[0]; e.Stage != JournalAccepted || e.Endpoint != "/orders" || e.IdempotencyKey != "key-ok" || e.BodyHash == "" {
+		t.Errorf("unexpected accepted entry: %+v", e)
+	}
+	if e := entries[1]; e.Stage != JournalSucceeded || e.ID != entries[0].ID {
+		t.Errorf("unexpected outcome: %+v", e)
+	}
+	if e := entries[3]; e.Stage != JournalFailed || e.Error != "backend down" {
+		t.Errorf("unexpected outcome: %+v", e)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal := OpenJournal(config.Journal{Path: path})
+	if OpenJournal(config.Journal{Path: path}) != journal {
+		t.Error("the journal of a path must be shared")
+	}
+	for _, e := range []JournalEntry{
+		{ID: "1", Stage: JournalAccepted},
+		{ID: "2", Stage: JournalAccepted},
+		{ID: "3", Stage: JournalAccepted},
+		{ID: "1", Stage: JournalSucceeded},
+		{ID: "2", Stage: JournalFailed, Error: "timeout"},
+	} {
+		if err := journal.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Reconcile(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 3 || report.Succeeded != 1 || report.Failed != 1 || report.Pending != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	ids := []string{}
+	for _, e := range report.Unreconciled {
+		ids = append(ids, e.ID)
+	}
+	if strings.Join(ids, ",") != "2,3" && strings.Join(ids, ",") != "3,2" {
+		t.Errorf("unexpected unreconciled writes: %v", ids)
+	}
+}
+
+func TestFileJournal_concurrentAppends(t *testing.T) {
+	journal := OpenJournal(config.Journal{Path: filepath.Join(t.TempDir(), "journal")})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := journal.Append(JournalEntry{ID: strconv.Itoa(i), Stage: JournalAccepted}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := journal.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 50 {
+		t.Errorf("unexpected entries: %d", len(entries))
+	}
+}
+
+func TestFileJournal_rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal := OpenJournal(config.Journal{Path: path, MaxSize: 200})
+	for i := 0; i < 10; i++ {
+		if err := journal.Append(JournalEntry{ID: strconv.Itoa(i), Stage: JournalAccepted}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := journal.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || len(entries) == 10 {
+		t.Fatalf("the journal was not rotated: %d entries", len(entries))
+	}
+	// the entries of the previous file are read first
+	if last := entries[len(entries)-1]; last.ID != "9" {
+		t.Errorf("unexpected last entry: %+v", last)
+	}
+	for i := 1; i < len(entries); i++ {
+		prev, _ := strconv.Atoi(entries[i-1].ID)
+		if id, _ := strconv.Atoi(entries[i].ID); id != prev+1 {
+			t.Errorf("unexpected order of the entries: %+v", entries)
+			break
+		}
+	}
+	if info, err := os.Stat(path + ".1"); err != nil || info.Size() < 200 {
+		t.Errorf("unexpected rotated file: %v %v", info, err)
+	}
+}
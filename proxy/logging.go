@@ -2,17 +2,110 @@ package proxy
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/ph0m1/porta/logging"
 )
 
+type traceIDKey struct{}
+
+// WithTraceID returns a copy of the context carrying the id of the trace of the request
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceID returns the id of the trace of the request carried by the context, if any
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// RequestLogger returns a child of the logger adding the request id and the trace id carried by the
+// context to its lines, so they can be correlated with the access log of the request. The logger is
+// returned as is if the context carries none of them
+func RequestLogger(ctx context.Context, logger logging.Logger) logging.Logger {
+	fields := logging.Fields{}
+	if id := RequestID(ctx); id != "" {
+		fields["request_id"] = id
+	}
+	if id := TraceID(ctx); id != "" {
+		fields["trace_id"] = id
+	}
+	if len(fields) == 0 {
+		return logger
+	}
+	return logger.WithFields(fields)
+}
+
+// LogLimiter lets through the first occurrences of every repeated log line per interval, counting the
+// rest, so a backend down does not flood the logs with a warning per request
+type LogLimiter struct {
+	burst    int
+	interval time.Duration
+
+	mu    sync.Mutex
+	lines map[string]*limitedLine
+}
+
+type limitedLine struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+// NewLogLimiter returns a limiter letting through burst occurrences of every line per interval
+func NewLogLimiter(burst int, interval time.Duration) *LogLimiter {
+	return &LogLimiter{burst: burst, interval: interval, lines: map[string]*limitedLine{}}
+}
+
+// Allow tells if the line can be written. The lines allowed after the start of a new interval report the
+// occurrences suppressed in the previous ones
+func (l *LogLimiter) Allow(line string) (bool, int) {
+	return l.allow(time.Now(), line)
+}
+
+func (l *LogLimiter) allow(now time.Time, line string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.lines[line]
+	if !ok || now.Sub(s.start) >= l.interval {
+		if ok && s.suppressed == 0 {
+			// the lines without suppressed occurrences are forgotten, so the map does not grow forever
+			delete(l.lines, line)
+			ok = false
+		}
+		if !ok {
+			s = &limitedLine{}
+			l.lines[line] = s
+		}
+		s.start, s.count = now, 0
+	}
+	s.count++
+	if s.count > l.burst {
+		s.suppressed++
+		return false, 0
+	}
+	suppressed := s.suppressed
+	s.suppressed = 0
+	return true, suppressed
+}
+
 func NewLoggingMiddleware(logger logging.Logger, name string) Middleware {
+	return NewLimitedLoggingMiddleware(logger, name, nil)
+}
+
+// NewLimitedLoggingMiddleware logs the calls to the backend like NewLoggingMiddleware, writing the
+// warnings of the failed calls through the limiter. A limiter can be shared by the middlewares of all
+// the endpoints, as the lines are told apart by the name and the error. Every warning is written if
+// it is nil
+func NewLimitedLoggingMiddleware(logger logging.Logger, name string, limiter *LogLimiter) Middleware {
 	return func(next ...Proxy) Proxy {
 		if len(next) > 1 {
 			panic(ErrTooManyProxies)
 		}
 		return func(ctx context.Context, request *Request) (*Response, error) {
+			logger := RequestLogger(ctx, logger)
 			begin := time.Now()
 			logger.Info(name, "Calling backend")
 			logger.Debug("Request", request)
@@ -20,7 +113,17 @@ func NewLoggingMiddleware(logger logging.Logger, name string) Middleware {
 			result, err := next[0](ctx, request)
 
 			logger.Info(name, "Call to backend took", time.Since(begin).String())
-			if err != nil {
+			if err == nil {
+				return result, err
+			}
+			allowed, suppressed := true, 0
+			if limiter != nil {
+				allowed, suppressed = limiter.Allow(name + " " + err.Error())
+			}
+			if suppressed > 0 {
+				logger = logger.With("suppressed", suppressed)
+			}
+			if allowed {
 				logger.Warning(name, "Call to backend failed:", err.Error())
 			}
 			return result, err
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/logging/gologging"
+)
+
+func TestNewLoggingMiddleware_requestScoped(t *testing.T) {
+	buff := &bytes.Buffer{}
+	logger, err := gologging.NewLogger("INFO", buff, "pref")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewLoggingMiddleware(logger, "supu")(func(_ context.Context, _ *Request) (*Response, error) {
+		return nil, errors.New("boom")
+	})
+	ctx := WithTraceID(WithRequestID(context.Background(), "abc"), "0123456789abcdef0123456789abcdef")
+	p(ctx, &Request{})
+
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want 3 lines, have %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "request_id=abc trace_id=0123456789abcdef0123456789abcdef") {
+			t.Errorf("line without the ids: %s", line)
+		}
+	}
+}
+
+func TestRequestLogger_noIDs(t *testing.T) {
+	buff := &bytes.Buffer{}
+	logger, err := gologging.NewLogger("INFO", buff, "pref")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RequestLogger(context.Background(), logger).Info("supu")
+	if out := buff.String(); !strings.HasSuffix(out, "[supu]\n") {
+		t.Errorf("unexpected line: %s", out)
+	}
+}
+
+func TestLogLimiter(t *testing.T) {
+	limiter := NewLogLimiter(2, time.Minute)
+	now := time.Now()
+	for i, want := range []bool{true, true, false, false} {
+		if ok, _ := limiter.allow(now, "supu"); ok != want {
+			t.Errorf("#%d: want %v, have %v", i, want, ok)
+		}
+	}
+	if ok, _ := limiter.allow(now, "tupu"); !ok {
+		t.Error("the lines must be limited apart")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, suppressed := limiter.allow(now, "supu"); !ok || suppressed != 2 {
+		t.Errorf("unexpected summary: %v %d", ok, suppressed)
+	}
+	if ok, suppressed := limiter.allow(now, "supu"); !ok || suppressed != 0 {
+		t.Errorf("the summary must be reported once: %v %d", ok, suppressed)
+	}
+
+	limiter.allow(now.Add(time.Minute), "tupu")
+	if len(limiter.lines) != 2 {
+		t.Errorf("unexpected lines: %v", limiter.lines)
+	}
+}
+
+func TestNewLimitedLoggingMiddleware(t *testing.T) {
+	buff := &bytes.Buffer{}
+	logger, err := gologging.NewLogger("WARNING", buff, "pref")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewLimitedLoggingMiddleware(logger, "supu", NewLogLimiter(1, time.Hour))(func(_ context.Context, _ *Request) (*Response, error) {
+		return nil, errors.New("boom")
+	})
+	for i := 0; i < 5; i++ {
+		p(context.Background(), &Request{})
+	}
+	if lines := strings.Count(buff.String(), "\n"); lines != 1 {
+		t.Errorf("want 1 warning, have %d: %s", lines, buff.String())
+	}
+}
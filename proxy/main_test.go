@@ -0,0 +1,11 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+)
+
+func TestMain(m *testing.M) {
+	leaktest.VerifyTestMain(m)
+}
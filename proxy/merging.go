@@ -3,6 +3,7 @@ package proxy
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/ph0m1/porta/config"
@@ -11,8 +12,33 @@ import (
 // when return nil, it means that the backend is not available
 var errNullResult = errors.New("invalid response")
 
-// ErrNoBackends is returned when there are no backends
+// MergeNamespace is the key of the extra config holding the merge settings: the MergeConfig of the
+// endpoints, like {"timeout": "800ms", "fail_fast": true}, and the fatal flag of their backends, like
+// {"fatal": true}, whose failures fail the request instead of leaving it incomplete
+const MergeNamespace = "merge"
+
+// MergeConfig defines how long the responses of the backends of an endpoint are awaited
+type MergeConfig struct {
+	// max time waiting for the backends, shorter than the timeout of the endpoint. 85% of the timeout
+	// if zero
+	Timeout time.Duration `mapstructure:"timeout"`
+	// fail the request at the first failed backend, without waiting for the rest
+	FailFast bool `mapstructure:"fail_fast"`
+}
+
+// NewMergeDataMiddleware creates a proxy middleware calling all the backends of the endpoint and
+// merging their responses. It panics with ErrNoBackends if the endpoint has no backends and with the
+// error of its merge settings if they are not valid. The merge settings of the endpoint set its
+// deadline and which failures fail the request: by default the responses of the backends answering
+// within 85% of the timeout are merged and marked as incomplete
 func NewMergeDataMiddleware(endpointConfig *config.EndpointConfig) Middleware {
+	return NewHealthAwareMergeDataMiddleware(endpointConfig, nil)
+}
+
+// NewHealthAwareMergeDataMiddleware works like NewMergeDataMiddleware, but it does not call the
+// backends reported as unhealthy: they fail right away with ErrBackendUnhealthy, so the response is
+// marked as incomplete without waiting for them to time out
+func NewHealthAwareMergeDataMiddleware(endpointConfig *config.EndpointConfig, health BackendHealth) Middleware {
 	totalBackends := len(endpointConfig.Backend)
 	if totalBackends == 0 {
 		panic(ErrNoBackends)
@@ -20,7 +46,10 @@ func NewMergeDataMiddleware(endpointConfig *config.EndpointConfig) Middleware {
 	if totalBackends == 1 {
 		return EmptyMiddleware
 	}
-	serviceTimeout := time.Duration(85*endpointConfig.Timeout.Nanoseconds()/100) * time.Nanosecond
+	serviceTimeout, fatal, err := mergePolicy(endpointConfig)
+	if err != nil {
+		panic(err)
+	}
 
 	return func(next ...Proxy) Proxy {
 		if len(next) != totalBackends {
@@ -28,57 +57,103 @@ func NewMergeDataMiddleware(endpointConfig *config.EndpointConfig) Middleware {
 		}
 		return func(ctx context.Context, request *Request) (*Response, error) {
 			localCtx, cancel := context.WithTimeout(ctx, serviceTimeout)
+			defer cancel()
 
-			parts := make(chan *Response, len(next))
-			failed := make(chan error, len(next))
-
-			for _, n := range next {
-				go requestPart(localCtx, n, request, parts, failed)
+			parts := make(chan mergePart, len(next))
+			for i, n := range next {
+				if health != nil && !health.Healthy(endpointConfig.Backend[i]) {
+					skippedBackends.Add(1, endpointConfig.Endpoint, endpointConfig.Backend[i].URLPattern)
+					parts <- mergePart{index: i, err: ErrBackendUnhealthy}
+					continue
+				}
+				go requestPart(localCtx, i, n, request, parts)
 			}
 
 			var err error
 			responses := make([]*Response, len(next))
+			pending := len(next)
 			isEmpty := true
-			for i := 0; i < len(next); i++ {
-
+		collect:
+			for pending > 0 {
 				select {
-				case err = <-failed:
-				case responses[i] = <-parts:
+				case part := <-parts:
+					pending--
+					if part.err != nil {
+						err = part.err
+						if fatal[part.index] {
+							return nil, err
+						}
+						continue
+					}
+					responses[part.index] = part.response
 					isEmpty = false
+				case <-localCtx.Done():
+					// the backends still running missed the deadline
+					err = localCtx.Err()
+					for i, r := range responses {
+						if r == nil && fatal[i] {
+							return nil, err
+						}
+					}
+					break collect
 				}
 			}
 			if isEmpty {
-				cancel()
-				return &Response{make(map[string]interface{}, 0), false}, err
+				return &Response{Data: make(map[string]interface{}, 0), IsComplete: false}, err
 			}
-			result := combineData(localCtx, totalBackends, responses)
-			cancel()
-			return result, err
+			return combineData(localCtx, totalBackends, responses), err
 		}
 
 	}
 }
 
-func requestPart(ctx context.Context, next Proxy, request *Request, out chan<- *Response, failed chan<- error) {
+// mergePolicy returns the deadline of the merge of the endpoint and which of its backends fail the
+// request, as declared by the merge settings of their extra config
+func mergePolicy(cfg *config.EndpointConfig) (time.Duration, []bool, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = config.DefaultTimeout
+	}
+	var merge MergeConfig
+	if err := config.Decode(cfg.ExtraConfig[MergeNamespace], &merge); err != nil {
+		return 0, nil, fmt.Errorf("the merge settings of the endpoint [%s]: %s", cfg.Endpoint, err)
+	}
+	if merge.Timeout < 0 || merge.Timeout >= timeout {
+		return 0, nil, fmt.Errorf("the merge timeout %s of the endpoint [%s] must be positive and shorter than its timeout", merge.Timeout, cfg.Endpoint)
+	}
+	if merge.Timeout == 0 {
+		merge.Timeout = time.Duration(85*timeout.Nanoseconds()/100) * time.Nanosecond
+	}
+
+	fatal := make([]bool, len(cfg.Backend))
+	for i, b := range cfg.Backend {
+		var backend struct {
+			Fatal bool `mapstructure:"fatal"`
+		}
+		if err := config.Decode(b.ExtraConfig[MergeNamespace], &backend); err != nil {
+			return 0, nil, fmt.Errorf("the merge settings of the backend [%s]: %s", b.URLPattern, err)
+		}
+		fatal[i] = merge.FailFast || backend.Fatal
+	}
+	return merge.Timeout, fatal, nil
+}
+
+// mergePart is the outcome of the call to the backend with the index
+type mergePart struct {
+	index    int
+	response *Response
+	err      error
+}
+
+func requestPart(ctx context.Context, index int, next Proxy, request *Request, out chan<- mergePart) {
 	localCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	in, err := next(localCtx, request)
-	if err != nil {
-		failed <- err
-		cancel()
-		return
-	}
-	if in == nil {
-		failed <- errNullResult
-		cancel()
-		return
-	}
-	select {
-	case out <- in:
-	case <-ctx.Done():
-		failed <- ctx.Err()
+	if err == nil && in == nil {
+		err = errNullResult
 	}
-	cancel()
+	out <- mergePart{index: index, response: in, err: err}
 }
 
 func combineData(ctx context.Context, total int, parts []*Response) *Response {
@@ -95,5 +170,5 @@ func combineData(ctx context.Context, total int, parts []*Response) *Response {
 			isComplete = false
 		}
 	}
-	return &Response{composedData, isComplete}
+	return &Response{Data: composedData, IsComplete: isComplete}
 }
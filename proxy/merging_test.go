@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewMergeDataMiddleware(t *testing.T) {
+	errBackend := errors.New("backend down")
+	answer := func(key string) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{Data: map[string]interface{}{key: 1}, IsComplete: true}, nil
+		}
+	}
+	failing := func(_ context.Context, _ *Request) (*Response, error) {
+		return nil, errBackend
+	}
+	// stuck ignores the cancellation of its context, like a backend hanging on a slow read
+	release := make(chan struct{})
+	defer close(release)
+	stuck := func(_ context.Context, _ *Request) (*Response, error) {
+		<-release
+		return &Response{Data: map[string]interface{}{"late": 1}, IsComplete: true}, nil
+	}
+	endpoint := func(merge map[string]interface{}, fatal ...bool) *config.EndpointConfig {
+		e := &config.EndpointConfig{Endpoint: "/supu", Timeout: 2 * time.Second}
+		if merge != nil {
+			e.ExtraConfig = config.ExtraConfig{MergeNamespace: merge}
+		}
+		for _, f := range fatal {
+			e.Backend = append(e.Backend, &config.Backend{ExtraConfig: config.ExtraConfig{MergeNamespace: map[string]interface{}{"fatal": f}}})
+		}
+		return e
+	}
+
+	for _, tc := range []struct {
+		name     string
+		endpoint *config.EndpointConfig
+		backends []Proxy
+		err      error
+		keys     []string
+	}{
+		{"partial", endpoint(nil, false, false), []Proxy{answer("a"), failing}, errBackend, []string{"a"}},
+		{"fail fast", endpoint(map[string]interface{}{"fail_fast": true}, false, false), []Proxy{answer("a"), failing}, errBackend, nil},
+		{"fatal", endpoint(map[string]interface{}{}, false, true), []Proxy{answer("a"), failing}, errBackend, nil},
+		{"not fatal", endpoint(map[string]interface{}{}, true, false), []Proxy{answer("a"), failing}, errBackend, []string{"a"}},
+		{"deadline", endpoint(map[string]interface{}{"timeout": "50ms"}, false, false), []Proxy{answer("a"), stuck}, context.DeadlineExceeded, []string{"a"}},
+		{"fatal deadline", endpoint(map[string]interface{}{"timeout": 50}, false, true), []Proxy{answer("a"), stuck}, context.DeadlineExceeded, nil},
+		{"complete", endpoint(map[string]interface{}{"fail_fast": true}, true, true), []Proxy{answer("a"), answer("b")}, nil, []string{"a", "b"}},
+	} {
+		start := time.Now()
+		resp, err := NewMergeDataMiddleware(tc.endpoint)(tc.backends...)(context.Background(), &Request{})
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: the merge took %s", tc.name, elapsed)
+		}
+		if err != tc.err {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if tc.keys == nil {
+			if resp != nil {
+				t.Errorf("%s: unexpected response: %+v", tc.name, resp)
+			}
+			continue
+		}
+		if resp == nil || len(resp.Data) != len(tc.keys) || resp.IsComplete != (tc.err == nil) {
+			t.Errorf("%s: unexpected response: %+v", tc.name, resp)
+			continue
+		}
+		for _, k := range tc.keys {
+			if _, ok := resp.Data[k]; !ok {
+				t.Errorf("%s: missing key %s: %+v", tc.name, k, resp.Data)
+			}
+		}
+	}
+}
+
+func TestNewMergeDataMiddleware_invalid(t *testing.T) {
+	factory := NewDefaultFactory(func(_ *config.Backend) Proxy { return NoopProxy }, nil)
+	for _, tc := range []struct {
+		name    string
+		merge   interface{}
+		backend interface{}
+	}{
+		{"timeout too long", map[string]interface{}{"timeout": "2s"}, nil},
+		{"negative timeout", map[string]interface{}{"timeout": "-1ms"}, nil},
+		{"invalid settings", "fail_fast", nil},
+		{"invalid fatal flag", nil, map[string]interface{}{"fatal": "maybe"}},
+	} {
+		e := &config.EndpointConfig{
+			Endpoint:    "/supu",
+			Timeout:     2 * time.Second,
+			ExtraConfig: config.ExtraConfig{MergeNamespace: tc.merge},
+			Backend: []*config.Backend{
+				{URLPattern: "/a", ExtraConfig: config.ExtraConfig{MergeNamespace: tc.backend}},
+				{URLPattern: "/b"},
+			},
+		}
+		if _, err := factory.New(e); err == nil {
+			t.Errorf("%s: the factory accepted the invalid merge settings", tc.name)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: the middleware did not panic", tc.name)
+				}
+			}()
+			NewMergeDataMiddleware(e)
+		}()
+	}
+}
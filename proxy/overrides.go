@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/security"
+)
+
+// Headers of the trusted overrides
+const (
+	// TimeoutOverrideHeader requests a timeout, like 30s, replacing the one of the endpoint
+	TimeoutOverrideHeader = "X-Porta-Timeout-Override"
+	// CacheBypassHeader requests a response fetched from the backends when set to true
+	CacheBypassHeader = "X-Porta-Cache-Bypass"
+)
+
+// RequestOverrides returns the timeout of the request and whether it must skip the caches. The
+// override headers are honored only if the client authenticated in the context has the role of the
+// trusted overrides of the endpoint, and the timeout only up to their max timeout
+func RequestOverrides(ctx context.Context, cfg *config.EndpointConfig, header http.Header) (time.Duration, bool) {
+	overrides := cfg.TrustedOverrides
+	if overrides == nil || !trusted(ctx, overrides.Role) {
+		return cfg.Timeout, false
+	}
+
+	timeout := cfg.Timeout
+	if v := header.Get(TimeoutOverrideHeader); v != "" && overrides.MaxTimeout > 0 {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			timeout = d
+			if timeout > overrides.MaxTimeout {
+				timeout = overrides.MaxTimeout
+			}
+		}
+	}
+	bypass := false
+	if overrides.CacheBypass {
+		bypass, _ = strconv.ParseBool(header.Get(CacheBypassHeader))
+	}
+	return timeout, bypass
+}
+
+type cacheBypassKey struct{}
+
+// WithCacheBypass returns a copy of the context telling the caches to fetch the response again
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+// CacheBypassed reports if the caches must not serve the request
+func CacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+func trusted(ctx context.Context, role string) bool {
+	auth, ok := security.AuthContextFrom(ctx)
+	if !ok {
+		return false
+	}
+	for _, r := range auth.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/security"
+)
+
+func TestRequestOverrides(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Timeout:          time.Second,
+		TrustedOverrides: &config.TrustedOverrides{Role: "batch", MaxTimeout: time.Minute, CacheBypass: true},
+	}
+	header := http.Header{}
+	header.Set(TimeoutOverrideHeader, "30s")
+	header.Set(CacheBypassHeader, "true")
+
+	trusted := context.WithValue(context.Background(), "auth", &security.AuthContext{Roles: []string{"user", "batch"}})
+	if timeout, bypass := RequestOverrides(trusted, cfg, header); timeout != 30*time.Second || !bypass {
+		t.Errorf("unexpected overrides: %s, %v", timeout, bypass)
+	}
+
+	header.Set(TimeoutOverrideHeader, "1h")
+	if timeout, _ := RequestOverrides(trusted, cfg, header); timeout != time.Minute {
+		t.Errorf("the timeout was not bounded: %s", timeout)
+	}
+
+	untrusted := context.WithValue(context.Background(), "auth", &security.AuthContext{Roles: []string{"user"}})
+	for _, ctx := range []context.Context{untrusted, context.Background()} {
+		if timeout, bypass := RequestOverrides(ctx, cfg, header); timeout != time.Second || bypass {
+			t.Errorf("unexpected overrides of an untrusted client: %s, %v", timeout, bypass)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// RangeHeaders are the headers of the range and conditional requests forwarded to the backends of the
+// no-op endpoints, so the clients can resume the downloads through the gateway
+var RangeHeaders = []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"}
+
+// passthroughHeaders are the headers of the backend responses describing the content, sent to the
+// clients along with the content of the no-op backends
+var passthroughHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// passthroughStatus are the status codes of the no-op backends propagated to the clients
+var passthroughStatus = map[int]bool{
+	http.StatusOK:                           true,
+	http.StatusCreated:                      true,
+	http.StatusPartialContent:               true,
+	http.StatusNotModified:                  true,
+	http.StatusPreconditionFailed:           true,
+	http.StatusRequestedRangeNotSatisfiable: true,
+}
+
+// passthroughResponse streams the body of the response of a no-op backend, keeping its status code and
+// the headers describing the content. Only the 200 responses are complete, so the partial and not
+// modified ones are never cached by the gateway
+func passthroughResponse(remote *config.Backend, resp *http.Response) (*Response, error) {
+	if !passthroughStatus[resp.StatusCode] {
+		defer resp.Body.Close()
+		if err := throttledResponse(remote, resp); err != nil {
+			return nil, err
+		}
+		return nil, StatusCodeError{Backend: remote.URLPattern, Status: resp.StatusCode}
+	}
+
+	headers := make(map[string][]string, len(passthroughHeaders))
+	for _, k := range passthroughHeaders {
+		if v, ok := resp.Header[k]; ok {
+			headers[k] = v
+		}
+	}
+	return &Response{
+		Data:       map[string]interface{}{},
+		IsComplete: resp.StatusCode == http.StatusOK,
+		Metadata:   Metadata{Headers: headers, StatusCode: resp.StatusCode},
+		Io:         resp.Body,
+	}, nil
+}
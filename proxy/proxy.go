@@ -3,6 +3,7 @@ package proxy
 import (
 	"context"
 	"errors"
+	"io"
 
 	"github.com/ph0m1/porta/config"
 )
@@ -11,6 +12,17 @@ import (
 type Response struct {
 	Data       map[string]interface{}
 	IsComplete bool
+	Metadata   Metadata
+	// Io is the content streamed from a no-op backend, if any. The routers copy it to the client and
+	// close it, and the layers keeping the responses skip it
+	Io io.ReadCloser
+}
+
+// Metadata contains the details of the response to send to the client along with the data
+type Metadata struct {
+	Headers map[string][]string
+	// status code of the response. 200 if zero
+	StatusCode int
 }
 
 var (
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// NewQueryTypesMiddleware creates a proxy middleware validating the query string params against the
+// types declared by the endpoint and replacing them with their canonical form, so the backends always
+// get 42 for 042, true for 1 and the declared case of the enums. The requests with invalid params fail
+// with a ValidationError listing all of them
+func NewQueryTypesMiddleware(cfg *config.EndpointConfig) Middleware {
+	names := make([]string, 0, len(cfg.QueryTypes))
+	for name := range cfg.QueryTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			var fields []FieldError
+			query := make(url.Values, len(request.Query))
+			for k, v := range request.Query {
+				query[k] = v
+			}
+			for _, name := range names {
+				param := cfg.QueryTypes[name]
+				values, ok := query[name]
+				if !ok || len(values) == 0 {
+					if param.Required {
+						fields = append(fields, FieldError{Pointer: JSONPointer("query", name), Detail: "is required"})
+					}
+					continue
+				}
+				coerced := make([]string, len(values))
+				for i, v := range values {
+					c, err := coerceQueryParam(param, v)
+					if err != nil {
+						fields = append(fields, FieldError{Pointer: JSONPointer("query", name), Detail: err.Error()})
+						break
+					}
+					coerced[i] = c
+				}
+				query[name] = coerced
+			}
+			if len(fields) > 0 {
+				return nil, ValidationError{Fields: fields}
+			}
+			request.Query = query
+			return next[0](ctx, request)
+		}
+	}
+}
+
+// coerceQueryParam returns the canonical form of the value or the reason it does not match the type
+func coerceQueryParam(param *config.QueryParam, value string) (string, error) {
+	switch param.Type {
+	case config.QueryInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", value)
+		}
+		return strconv.FormatInt(i, 10), nil
+	case config.QueryBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a boolean", value)
+		}
+		return strconv.FormatBool(b), nil
+	case config.QueryDate:
+		t, err := time.Parse(param.Layout, value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a date with the layout %s", value, param.Layout)
+		}
+		return t.Format(param.Layout), nil
+	case config.QueryEnum:
+		for _, v := range param.Values {
+			if strings.EqualFold(v, value) {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", value, strings.Join(param.Values, ", "))
+	}
+	return value, nil
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewQueryTypesMiddleware(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/orders",
+		QueryTypes: map[string]*config.QueryParam{
+			"page":   {Type: config.QueryInt, Required: true},
+			"paid":   {Type: config.QueryBool},
+			"since":  {Type: config.QueryDate, Layout: "2006-01-02"},
+			"status": {Type: config.QueryEnum, Values: []string{"open", "closed"}},
+		},
+	}
+	var received url.Values
+	p := NewQueryTypesMiddleware(cfg)(func(_ context.Context, r *Request) (*Response, error) {
+		received = r.Query
+		return &Response{IsComplete: true}, nil
+	})
+
+	query := url.Values{"page": {"007"}, "paid": {"1"}, "since": {"2024-02-29"}, "status": {"OPEN"}, "other": {"x"}}
+	if _, err := p(context.Background(), &Request{Query: query}); err != nil {
+		t.Fatal(err)
+	}
+	if received.Encode() != "other=x&page=7&paid=true&since=2024-02-29&status=open" {
+		t.Errorf("unexpected coerced query: %s", received.Encode())
+	}
+
+	query = url.Values{"paid": {"maybe"}, "since": {"2023-02-29"}, "status": {"lost"}}
+	_, err := p(context.Background(), &Request{Query: query})
+	verr := ValidationError{}
+	if !errors.As(err, &verr) {
+		t.Fatalf("expecting a validation error: %v", err)
+	}
+	pointers := []string{}
+	for _, f := range verr.Fields {
+		pointers = append(pointers, f.Pointer)
+	}
+	if len(pointers) != 4 || pointers[0] != "/query/page" || pointers[3] != "/query/status" {
+		t.Errorf("unexpected fields: %+v", verr.Fields)
+	}
+	if received.Get("paid") != "true" {
+		t.Error("the invalid requests must not reach the backends")
+	}
+}
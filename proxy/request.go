@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"net/url"
+	"strings"
 )
 
 // Request represents a request to be proxied
@@ -17,7 +18,9 @@ type Request struct {
 	Headers map[string][]string
 }
 
-// GeneratePath takes a pattern and updates the path of the request
+// GeneratePath takes a pattern and updates the path of the request. The params are path escaped segment
+// by segment, so the slashes of the catch-all params are kept but their ? and # can not reach the query
+// or the fragment of the backend URL
 func (r *Request) GeneratePath(URLPattern string) {
 	if len(r.Params) == 0 {
 		r.Path = URLPattern
@@ -29,11 +32,46 @@ func (r *Request) GeneratePath(URLPattern string) {
 		key = append(key, "{{."...)
 		key = append(key, k...)
 		key = append(key, "}}"...)
-		buff = bytes.Replace(buff, key, []byte(v), -1)
+		buff = bytes.Replace(buff, key, []byte(escapePathParam(v)), -1)
 	}
 	r.Path = string(buff)
 }
 
+// ValidatePathParams returns a ValidationError if any param of the request has a . or .. segment, even
+// percent-encoded like %2e%2e, which would let the clients climb out of the path of the backend
+func (r *Request) ValidatePathParams() error {
+	var fields []FieldError
+	for k, v := range r.Params {
+		if hasDotSegment(v) {
+			fields = append(fields, FieldError{Pointer: JSONPointer("params", k), Detail: "dot segments are not allowed"})
+		}
+	}
+	if len(fields) > 0 {
+		return ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func hasDotSegment(v string) bool {
+	for _, s := range strings.Split(v, "/") {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		if s == "." || s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func escapePathParam(v string) string {
+	segments := strings.Split(v, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
 // Clone clones itself into a new request
 func (r *Request) Clone() Request {
 	return Request{
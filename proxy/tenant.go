@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/security"
+)
+
+// ErrBackendNotAllowed is returned to the tenants sending requests to a backend out of their policy
+var ErrBackendNotAllowed = errors.New("the backend is not allowed for the tenant")
+
+// NewTenantBackendMiddleware creates a proxy middleware rejecting the requests of the tenants not allowed
+// to reach the backend. The requests without tenant are always proxied
+func NewTenantBackendMiddleware(remote *config.Backend) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if t, ok := security.TenantFrom(ctx); ok && !t.AllowsBackend(remote.URLPattern) {
+				return nil, ErrBackendNotAllowed
+			}
+			return next[0](ctx, request)
+		}
+	}
+}
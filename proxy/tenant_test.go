@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/security"
+)
+
+func TestNewTenantBackendMiddleware(t *testing.T) {
+	p := NewTenantBackendMiddleware(&config.Backend{URLPattern: "/billing/invoices"})(func(_ context.Context, _ *Request) (*Response, error) {
+		return &Response{IsComplete: true}, nil
+	})
+
+	if _, err := p(context.Background(), &Request{}); err != nil {
+		t.Errorf("the requests without tenant must be proxied: %v", err)
+	}
+	allowed := security.WithTenant(context.Background(), &security.Tenant{ID: "acme", TenantPolicy: security.TenantPolicy{AllowedBackends: []string{"/billing/*"}}})
+	if _, err := p(allowed, &Request{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	denied := security.WithTenant(context.Background(), &security.Tenant{ID: "globex", TenantPolicy: security.TenantPolicy{AllowedBackends: []string{"/users"}}})
+	if _, err := p(denied, &Request{}); err != ErrBackendNotAllowed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDefaultFactory_tenants(t *testing.T) {
+	fail := false
+	backend := func(_ context.Context, _ *Request) (*Response, error) {
+		if fail {
+			return nil, StatusCodeError{Backend: "/acme/secret", Status: http.StatusBadGateway}
+		}
+		return &Response{Data: map[string]interface{}{"secret": 1}, IsComplete: true}, nil
+	}
+	logger, err := gologging.NewLogger("ERROR", io.Discard, "pref")
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := WithGroup(NewDefaultFactory(func(_ *config.Backend) Proxy { return backend }, logger), testGroup(t))
+
+	endpoint := config.EndpointConfig{
+		Endpoint:  "/secret",
+		CacheTTL:  time.Minute,
+		WarmCache: &config.WarmCache{MinHits: 10, MaxKeys: 10},
+		Fallback:  &config.Fallback{LastGood: true},
+		Backend:   []*config.Backend{{URLPattern: "/acme/secret"}},
+	}
+	serviceConfig := config.ServiceConfig{
+		Version:   1,
+		Endpoints: []*config.EndpointConfig{&endpoint},
+		Host:      []string{"http://example.com"},
+	}
+	if err := serviceConfig.Init(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := factory.New(&endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acme := security.WithTenant(context.Background(), &security.Tenant{ID: "acme", TenantPolicy: security.TenantPolicy{AllowedBackends: []string{"/acme/*"}}})
+	globex := security.WithTenant(context.Background(), &security.Tenant{ID: "globex", TenantPolicy: security.TenantPolicy{AllowedBackends: []string{"/globex/*"}}})
+	request := func() *Request { return &Request{Method: "GET", Path: "/secret", Body: newDummyReadCloser("")} }
+
+	if resp, err := p(acme, request()); err != nil || resp.Data["secret"] != 1 {
+		t.Fatalf("unexpected response: %v %v", resp, err)
+	}
+	// the response cached for acme is not served to globex
+	if resp, err := p(globex, request()); !errors.Is(err, ErrBackendNotAllowed) {
+		t.Errorf("unexpected response from the warm cache: %v %v", resp, err)
+	}
+
+	// neither is its last good response once the backend fails
+	fail = true
+	if resp, err := p(WithCacheBypass(acme), request()); err != nil || resp.Metadata.Headers[FallbackHeader][0] != "last-good" {
+		t.Errorf("unexpected response: %v %v", resp, err)
+	}
+	if resp, err := p(WithCacheBypass(globex), request()); !errors.Is(err, ErrBackendNotAllowed) {
+		t.Errorf("unexpected response from the fallback: %v %v", resp, err)
+	}
+}
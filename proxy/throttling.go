@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+var (
+	throttledResponses = metrics.NewCounter("porta_backend_throttled_total", "Responses of the backends asking the gateway to slow down, by status code", "backend", "status")
+	throttledRequests  = metrics.NewCounter("porta_backend_throttle_rejected_total", "Requests rejected without reaching the backend while it was asking the gateway to slow down", "backend")
+)
+
+// ThrottledError is the error returned when a backend answers 429 or 503. It wraps ErrInvalidStatusCode
+type ThrottledError struct {
+	Backend string
+	// status code of the backend response
+	Status int
+	// time the backend asked the gateway to wait. Zero if the response had no Retry-After header
+	RetryAfter time.Duration
+	// the status code and the retry delay must be sent to the client
+	Propagate bool
+}
+
+func (e ThrottledError) Error() string {
+	return fmt.Sprintf("the backend %s is throttling the requests (status %d, retry after %s)", e.Backend, e.Status, e.RetryAfter)
+}
+
+// Unwrap returns ErrInvalidStatusCode, so the throttled responses are still invalid status codes
+func (e ThrottledError) Unwrap() error {
+	return ErrInvalidStatusCode
+}
+
+// throttledResponse returns the ThrottledError of the 429 and 503 responses, or nil
+func throttledResponse(remote *config.Backend, resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	return ThrottledError{Backend: remote.URLPattern, Status: resp.StatusCode, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+}
+
+// retryAfter parses the Retry-After header, either in seconds or as an HTTP date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// NewThrottlingMiddleware creates a proxy middleware opening a soft circuit when the backend answers 429
+// or 503, for the time in its Retry-After header bounded by the max backoff of the config. The requests
+// received while the circuit is open fail right away with a ThrottledError
+func NewThrottlingMiddleware(remote *config.Backend) Middleware {
+	cfg := remote.Throttling
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		var (
+			mu         sync.Mutex
+			openUntil  time.Time
+			lastStatus int
+		)
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			mu.Lock()
+			wait, status := time.Until(openUntil), lastStatus
+			mu.Unlock()
+			if wait > 0 {
+				throttledRequests.Add(1, remote.URLPattern)
+				return nil, ThrottledError{Backend: remote.URLPattern, Status: status, RetryAfter: wait, Propagate: cfg.Propagate}
+			}
+
+			resp, err := next[0](ctx, request)
+			var throttled ThrottledError
+			if !errors.As(err, &throttled) {
+				return resp, err
+			}
+			throttledResponses.Add(1, remote.URLPattern, strconv.Itoa(throttled.Status))
+
+			backoff := throttled.RetryAfter
+			if backoff <= 0 {
+				backoff = cfg.DefaultBackoff
+			}
+			if backoff > cfg.MaxBackoff {
+				backoff = cfg.MaxBackoff
+			}
+			mu.Lock()
+			openUntil, lastStatus = time.Now().Add(backoff), throttled.Status
+			mu.Unlock()
+
+			throttled.RetryAfter, throttled.Propagate = backoff, cfg.Propagate
+			return resp, throttled
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
+)
+
+func TestNewThrottlingMiddleware(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer backend.Close()
+
+	remote := &config.Backend{
+		URLPattern: "/supu",
+		Throttling: &config.Throttling{DefaultBackoff: time.Second, MaxBackoff: 10 * time.Second, Propagate: true},
+	}
+	p := NewThrottlingMiddleware(remote)(NewHttpProxy(remote, NewHttpClient, encoding.JSONDecoder))
+	URL, _ := url.Parse(backend.URL + "/supu")
+	send := func() ThrottledError {
+		_, err := p(context.Background(), &Request{Method: "GET", URL: URL, Body: newDummyReadCloser("")})
+		var throttled ThrottledError
+		if !errors.As(err, &throttled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !errors.Is(err, ErrInvalidStatusCode) {
+			t.Error("the throttled responses must be invalid status codes")
+		}
+		return throttled
+	}
+
+	first := send()
+	if first.Status != http.StatusTooManyRequests || first.RetryAfter != 10*time.Second || !first.Propagate {
+		t.Errorf("the retry after must be bounded by the max backoff: %+v", first)
+	}
+	second := send()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("the soft circuit must reject the requests without calling the backend: %d calls", n)
+	}
+	if second.Status != http.StatusTooManyRequests || second.RetryAfter <= 0 || second.RetryAfter > 10*time.Second {
+		t.Errorf("unexpected error of the open circuit: %+v", second)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d := retryAfter("3"); d != 3*time.Second {
+		t.Errorf("unexpected delay in seconds: %s", d)
+	}
+	if d := retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); d < 58*time.Second || d > time.Minute {
+		t.Errorf("unexpected delay of the date: %s", d)
+	}
+	if d := retryAfter("soon"); d != 0 {
+		t.Errorf("unexpected delay of an invalid value: %s", d)
+	}
+}
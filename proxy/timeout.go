@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"context"
+	"io"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// NewBackendTimeoutMiddleware returns a middleware bounding the calls to the backend by its timeout. The
+// endpoint timeout still applies, so the shorter of both ends the call. The streamed responses keep the
+// call open until they are closed
+func NewBackendTimeoutMiddleware(remote *config.Backend) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		if remote.Timeout <= 0 {
+			return next[0]
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			localCtx, cancel := context.WithTimeout(ctx, remote.Timeout)
+			response, err := next[0](localCtx, request)
+			if response != nil && response.Io != nil {
+				response.Io = cancelOnClose{response.Io, cancel}
+				return response, err
+			}
+			cancel()
+			return response, err
+		}
+	}
+}
+
+// cancelOnClose cancels the context of a streamed response when it is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"strings"
+)
+
+// FieldError describes a problem of a field of the request, located by its JSON pointer (RFC 6901)
+type FieldError struct {
+	Pointer string `json:"pointer"`
+	Detail  string `json:"detail"`
+}
+
+// ValidationError is returned by the proxies rejecting a request. The routers answer it with a 400
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error implements the error interface
+func (v ValidationError) Error() string {
+	parts := make([]string, len(v.Fields))
+	for i, f := range v.Fields {
+		parts[i] = f.Pointer + ": " + f.Detail
+	}
+	return "invalid request: " + strings.Join(parts, "; ")
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JSONPointer returns the pointer to the field at the path, like /user/name for ["user", "name"]
+func JSONPointer(path ...string) string {
+	var b strings.Builder
+	for _, p := range path {
+		b.WriteByte('/')
+		b.WriteString(pointerEscaper.Replace(p))
+	}
+	return b.String()
+}
@@ -0,0 +1,16 @@
+package proxy
+
+import "testing"
+
+func TestValidationError(t *testing.T) {
+	err := ValidationError{Fields: []FieldError{
+		{Pointer: JSONPointer("user", "name"), Detail: "required"},
+		{Pointer: JSONPointer("tags", "a/b~c"), Detail: "too long"},
+	}}
+	if err.Fields[1].Pointer != "/tags/a~1b~0c" {
+		t.Errorf("unexpected pointer: %s", err.Fields[1].Pointer)
+	}
+	if err.Error() != "invalid request: /user/name: required; /tags/a~1b~0c: too long" {
+		t.Errorf("unexpected message: %s", err.Error())
+	}
+}
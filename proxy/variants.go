@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"net/textproto"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/monitoring/metrics"
+	"github.com/ph0m1/porta/security"
+)
+
+// VariantHeader is the header of the responses reshaped by a variant, holding its name
+const VariantHeader = "X-Response-Variant"
+
+var variantResponses = metrics.NewCounter("porta_response_variants_total", "Total number of responses reshaped by the variants of the endpoints", "endpoint", "variant")
+
+// NewResponseVariantsMiddleware creates a proxy middleware reshaping the responses with the variant
+// selected by the request header or the claim of the authenticated client. The responses of the next
+// proxy are copied before being reshaped, so the cached ones are not modified
+func NewResponseVariantsMiddleware(cfg *config.EndpointConfig) Middleware {
+	rv := *cfg.ResponseVariants
+	header := textproto.CanonicalMIMEHeaderKey(rv.Header)
+	formatters := make(map[string]EntityFormatter, len(rv.Variants))
+	for name, v := range rv.Variants {
+		formatters[name] = NewEntityFormatter(v.Target, v.Whitelist, v.Blacklist, v.Group, v.Mapping)
+	}
+
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			response, err := next[0](ctx, request)
+			if response == nil {
+				return response, err
+			}
+			name, ok := selectVariant(ctx, request, header, rv.Claim, formatters)
+			if !ok {
+				if header == "" {
+					return response, err
+				}
+				varied := *response
+				varied.Metadata.Headers = varyHeaders(response.Metadata.Headers, header)
+				return &varied, err
+			}
+			variantResponses.Add(1, cfg.Endpoint, name)
+
+			reshaped := *response
+			reshaped.Data = copyData(response.Data)
+			reshaped = formatters[name].Format(reshaped)
+			reshaped.Metadata.Headers = varyHeaders(response.Metadata.Headers, header)
+			reshaped.Metadata.Headers[VariantHeader] = []string{name}
+			return &reshaped, err
+		}
+	}
+}
+
+// varyHeaders returns a copy of the headers with the selector header appended to their Vary header, so
+// the caches keep a page per variant. The regular responses get it as well, since the header decides
+// whether they are served
+func varyHeaders(headers map[string][]string, header string) map[string][]string {
+	cp := make(map[string][]string, len(headers)+2)
+	for k, v := range headers {
+		cp[k] = v
+	}
+	if header != "" {
+		cp["Vary"] = append(append([]string{}, headers["Vary"]...), header)
+	}
+	return cp
+}
+
+// selectVariant returns the name of the variant matching the header of the request or, if it is not
+// set, the claim of the authenticated client
+func selectVariant(ctx context.Context, request *Request, header, claim string, formatters map[string]EntityFormatter) (string, bool) {
+	if header != "" {
+		if values := request.Headers[header]; len(values) > 0 {
+			_, ok := formatters[values[0]]
+			return values[0], ok
+		}
+	}
+	auth, ok := security.AuthContextFrom(ctx)
+	if claim == "" || !ok {
+		return "", false
+	}
+	var candidates []string
+	switch claim {
+	case "user_id":
+		candidates = []string{auth.UserID}
+	case "client_id":
+		candidates = []string{auth.ClientID}
+	case "roles":
+		candidates = auth.Roles
+	}
+	for _, c := range candidates {
+		if _, ok := formatters[c]; ok {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// copyData returns a deep copy of the nested objects and lists of the data
+func copyData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	cp := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		cp[k] = copyValue(v)
+	}
+	return cp
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return copyData(t)
+	case []interface{}:
+		cp := make([]interface{}, len(t))
+		for i := range t {
+			cp[i] = copyValue(t[i])
+		}
+		return cp
+	default:
+		return v
+	}
+}
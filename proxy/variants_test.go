@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/security"
+)
+
+func TestNewResponseVariantsMiddleware(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		ResponseVariants: &config.ResponseVariants{
+			Header: "x-variant",
+			Claim:  "roles",
+			Variants: map[string]config.ResponseVariant{
+				"lite": {Blacklist: []string{"details.internal"}},
+				"beta": {Mapping: map[string]string{"name": "title"}, Group: "v2"},
+			},
+		},
+	}
+	cached := &Response{
+		Data: map[string]interface{}{
+			"name":    "supu",
+			"details": map[string]interface{}{"internal": true, "public": 1},
+		},
+		IsComplete: true,
+		Metadata:   Metadata{Headers: map[string][]string{"Vary": {"Accept-Encoding"}}},
+	}
+	p := NewResponseVariantsMiddleware(cfg)(func(_ context.Context, _ *Request) (*Response, error) {
+		return cached, nil
+	})
+
+	resp, _ := p(context.Background(), &Request{Headers: map[string][]string{"X-Variant": {"lite"}}})
+	details := resp.Data["details"].(map[string]interface{})
+	if _, ok := details["internal"]; ok || details["public"] != 1 || !resp.IsComplete {
+		t.Errorf("unexpected lite response: %v", resp.Data)
+	}
+	if resp.Metadata.Headers[VariantHeader][0] != "lite" || !reflect.DeepEqual(resp.Metadata.Headers["Vary"], []string{"Accept-Encoding", "X-Variant"}) {
+		t.Errorf("unexpected headers: %v", resp.Metadata.Headers)
+	}
+	if len(cached.Metadata.Headers["Vary"]) != 1 {
+		t.Errorf("the cached headers were modified: %v", cached.Metadata.Headers)
+	}
+	if _, ok := cached.Data["details"].(map[string]interface{})["internal"]; !ok {
+		t.Error("the cached response was modified")
+	}
+
+	ctx := context.WithValue(context.Background(), "auth", &security.AuthContext{Roles: []string{"user", "beta"}})
+	resp, _ = p(ctx, &Request{})
+	v2, ok := resp.Data["v2"].(map[string]interface{})
+	if !ok || v2["title"] != "supu" {
+		t.Errorf("unexpected beta response: %v", resp.Data)
+	}
+
+	resp, _ = p(context.Background(), &Request{Headers: map[string][]string{"X-Variant": {"unknown"}}})
+	if resp.Data["name"] != "supu" || resp.Metadata.Headers[VariantHeader] != nil {
+		t.Errorf("the regular response was not served: %v", resp)
+	}
+	if !reflect.DeepEqual(resp.Metadata.Headers["Vary"], []string{"Accept-Encoding", "X-Variant"}) {
+		t.Errorf("unexpected headers: %v", resp.Metadata.Headers)
+	}
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/security"
+)
+
+// credentialHeaders are the headers carrying the credentials of the clients. The warm cache keys the
+// responses on them, like on the rest of the headers passed, but never keeps them to refresh the entries
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// NewWarmCacheMiddleware creates a proxy middleware caching the complete responses of the GET and HEAD
+// requests during the cache TTL of the endpoint. The responses are cached per tenant, params, query
+// string and values of the headers passed to the backends, so the responses of a client are never served
+// to another one. The entries reaching the configured number of hits are refreshed in the background
+// shortly before they expire, so the popular keys never go cold. The entries of the requests with
+// credentials are not refreshed, as the credentials are not kept. The refreshes end when the group is
+// stopped
+func NewWarmCacheMiddleware(cfg *config.EndpointConfig, g *lifecycle.Group) Middleware {
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		c := newWarmCache(cfg, next[0], g)
+		return c.proxy
+	}
+}
+
+type warmCache struct {
+	next    Proxy
+	ttl     time.Duration
+	timeout time.Duration
+	cfg     config.WarmCache
+	headers []string
+	mu      sync.Mutex
+	entries map[string]*warmCacheEntry
+	group   *lifecycle.Group
+	timers  map[*time.Timer]struct{}
+
+	now       func() time.Time
+	afterFunc func(time.Duration, func())
+}
+
+type warmCacheEntry struct {
+	// request replayed by the refreshes. Nil if the request had credentials
+	request *Request
+	// tenant of the request, whose policy applies to the refreshes too
+	tenant    *security.Tenant
+	response  *Response
+	expiresAt time.Time
+	hits      int
+	scheduled bool
+}
+
+func newWarmCache(cfg *config.EndpointConfig, next Proxy, g *lifecycle.Group) *warmCache {
+	c := &warmCache{
+		next:    next,
+		ttl:     cfg.CacheTTL,
+		timeout: cfg.Timeout,
+		cfg:     *cfg.WarmCache,
+		headers: cacheHeaders(cfg),
+		entries: map[string]*warmCacheEntry{},
+		group:   g,
+		timers:  map[*time.Timer]struct{}{},
+		now:     time.Now,
+	}
+	c.afterFunc = c.schedule
+	g.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		c.stopTimers()
+	})
+	return c
+}
+
+// schedule runs the refresh in the group after the delay. It must be called holding the lock
+func (c *warmCache) schedule(d time.Duration, refresh func()) {
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		c.mu.Lock()
+		delete(c.timers, t)
+		c.mu.Unlock()
+		c.group.Go(func(_ context.Context) { refresh() })
+	})
+	c.timers[t] = struct{}{}
+}
+
+func (c *warmCache) stopTimers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for t := range c.timers {
+		t.Stop()
+	}
+	c.timers = map[*time.Timer]struct{}{}
+}
+
+func (c *warmCache) proxy(ctx context.Context, request *Request) (*Response, error) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return c.next(ctx, request)
+	}
+	key := cacheKey(ctx, request, c.headers)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && c.now().Before(e.expiresAt) && !CacheBypassed(ctx) {
+		e.hits++
+		if e.hits >= c.cfg.MinHits && !e.scheduled && e.request != nil && c.group.Context().Err() == nil {
+			e.scheduled = true
+			c.afterFunc(e.expiresAt.Sub(c.now())-c.cfg.RefreshBefore, func() { c.refresh(key, e) })
+		}
+		// the callers get their own copy, as the formatters of the rest of the stack modify the data
+		response := copyResponse(e.response)
+		c.mu.Unlock()
+		return response, nil
+	}
+	c.mu.Unlock()
+
+	response, err := c.next(ctx, request)
+	if err == nil && response != nil && response.IsComplete && response.Io == nil {
+		tenant, _ := security.TenantFrom(ctx)
+		c.store(key, &warmCacheEntry{request: replayable(request), tenant: tenant, response: copyResponse(response), expiresAt: c.now().Add(c.ttl)})
+	}
+	return response, err
+}
+
+// replayable returns the request to replay in the refreshes of its entry, nil if it has credentials
+func replayable(request *Request) *Request {
+	for _, h := range credentialHeaders {
+		if len(request.Headers[h]) > 0 {
+			return nil
+		}
+	}
+	r := request.Clone()
+	r.Body = http.NoBody
+	return &r
+}
+
+// refresh fetches again the response of a popular entry, keeping the old one if the backends fail
+func (c *warmCache) refresh(key string, old *warmCacheEntry) {
+	ctx := c.group.Context()
+	if ctx.Err() != nil {
+		return
+	}
+	if old.tenant != nil {
+		ctx = security.WithTenant(ctx, old.tenant)
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	request := *old.request
+	response, err := c.next(ctx, &request)
+	if err != nil || response == nil || !response.IsComplete || response.Io != nil {
+		return
+	}
+	c.store(key, &warmCacheEntry{request: old.request, tenant: old.tenant, response: copyResponse(response), expiresAt: c.now().Add(c.ttl)})
+}
+
+func (c *warmCache) store(key string, e *warmCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.cfg.MaxKeys {
+		now := c.now()
+		for k, v := range c.entries {
+			if !now.Before(v.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.cfg.MaxKeys {
+			return
+		}
+	}
+	c.entries[key] = e
+}
+
+// cacheHeaders returns the canonical names of the headers passed to the backends of the endpoint, sorted
+func cacheHeaders(cfg *config.EndpointConfig) []string {
+	headers := make([]string, 0, len(cfg.HeadersToPass))
+	for _, h := range cfg.HeadersToPass {
+		headers = append(headers, http.CanonicalHeaderKey(h))
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+// cacheKey returns the key of the request: its tenant, method, params, query string and the values of
+// the headers, which change the responses of the backends
+func cacheKey(ctx context.Context, request *Request, headers []string) string {
+	var tenant string
+	if t, ok := security.TenantFrom(ctx); ok {
+		tenant = t.ID
+	}
+	params := make([]string, 0, len(request.Params))
+	for k, v := range request.Params {
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+	values := make(url.Values, len(headers))
+	for _, h := range headers {
+		if v, ok := request.Headers[h]; ok {
+			values[h] = v
+		}
+	}
+	return tenant + "?" + request.Method + "?" + strings.Join(params, "&") + "?" + request.Query.Encode() + "?" + values.Encode()
+}
+
+// copyResponse returns a copy of the response not sharing its data nor its headers
+func copyResponse(r *Response) *Response {
+	cp := &Response{Data: copyData(r.Data), IsComplete: r.IsComplete, Metadata: r.Metadata}
+	if r.Metadata.Headers != nil {
+		cp.Metadata.Headers = make(map[string][]string, len(r.Metadata.Headers))
+		for k, v := range r.Metadata.Headers {
+			cp.Metadata.Headers[k] = append([]string(nil), v...)
+		}
+	}
+	return cp
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+)
+
+// fakeWarmCache returns a warm cache driven by a fake clock, collecting the scheduled refreshes
+func fakeWarmCache(t *testing.T, cfg *config.EndpointConfig, next Proxy) (*warmCache, *time.Time, *[]func()) {
+	c := newWarmCache(cfg, next, testGroup(t))
+	now := time.Unix(1700000000, 0)
+	var refreshes []func()
+	c.now = func() time.Time { return now }
+	c.afterFunc = func(d time.Duration, f func()) {
+		if d != 50*time.Millisecond {
+			panic("unexpected delay of the refresh: " + d.String())
+		}
+		refreshes = append(refreshes, f)
+	}
+	return c, &now, &refreshes
+}
+
+// testGroup returns a group stopped at the end of the test
+func testGroup(t *testing.T) *lifecycle.Group {
+	g := lifecycle.NewGroup(context.Background())
+	t.Cleanup(func() { g.Stop(context.Background()) })
+	return g
+}
+
+func TestNewWarmCacheMiddleware(t *testing.T) {
+	var calls int64
+	backend := func(_ context.Context, _ *Request) (*Response, error) {
+		n := atomic.AddInt64(&calls, 1)
+		return &Response{Data: map[string]interface{}{"call": n}, IsComplete: true}, nil
+	}
+	cfg := &config.EndpointConfig{
+		CacheTTL:  100 * time.Millisecond,
+		WarmCache: &config.WarmCache{MinHits: 2, RefreshBefore: 50 * time.Millisecond, MaxKeys: 10},
+	}
+	c, now, refreshes := fakeWarmCache(t, cfg, backend)
+	request := &Request{Method: "GET", Params: map[string]string{"Id": "42"}}
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.proxy(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Data["call"] != int64(1) {
+			t.Errorf("unexpected response: %v", resp.Data)
+		}
+	}
+	if len(*refreshes) != 1 {
+		t.Fatalf("unexpected number of scheduled refreshes: %d", len(*refreshes))
+	}
+
+	*now = now.Add(50 * time.Millisecond)
+	(*refreshes)[0]()
+	if calls != 2 {
+		t.Errorf("the popular entry was not refreshed: %d calls", calls)
+	}
+	*now = now.Add(40 * time.Millisecond)
+	resp, _ := c.proxy(context.Background(), request)
+	if resp.Data["call"] != int64(2) {
+		t.Errorf("unexpected response after the refresh: %v", resp.Data)
+	}
+
+	*now = now.Add(time.Second)
+	c.proxy(context.Background(), request)
+	if calls != 3 {
+		t.Errorf("the expired entry was served: %d calls", calls)
+	}
+}
+
+func TestNewWarmCacheMiddleware_headers(t *testing.T) {
+	backend := func(_ context.Context, r *Request) (*Response, error) {
+		return &Response{Data: map[string]interface{}{"user": r.Headers["Authorization"][0]}, IsComplete: true}, nil
+	}
+	cfg := &config.EndpointConfig{
+		CacheTTL:      time.Minute,
+		HeadersToPass: []string{"authorization"},
+		WarmCache:     &config.WarmCache{MinHits: 1, RefreshBefore: 50 * time.Millisecond, MaxKeys: 10},
+	}
+	c, _, refreshes := fakeWarmCache(t, cfg, backend)
+
+	for i := 0; i < 2; i++ {
+		for _, user := range []string{"Bearer alice", "Bearer bob"} {
+			request := &Request{Method: "GET", Headers: map[string][]string{"Authorization": {user}}}
+			resp, err := c.proxy(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Data["user"] != user {
+				t.Errorf("the response of another client was served to %s: %v", user, resp.Data)
+			}
+		}
+	}
+	if len(*refreshes) != 0 {
+		t.Error("the entries of the requests with credentials were scheduled for a refresh")
+	}
+	for _, e := range c.entries {
+		if e.request != nil {
+			t.Errorf("the request with credentials was kept: %+v", e.request)
+		}
+	}
+}
+
+func TestNewWarmCacheMiddleware_copies(t *testing.T) {
+	backend := func(_ context.Context, _ *Request) (*Response, error) {
+		return &Response{Data: map[string]interface{}{"nested": map[string]interface{}{"a": 1}}, IsComplete: true}, nil
+	}
+	cfg := &config.EndpointConfig{
+		CacheTTL:  time.Minute,
+		WarmCache: &config.WarmCache{MinHits: 10, RefreshBefore: time.Second, MaxKeys: 10},
+	}
+	p := NewWarmCacheMiddleware(cfg, testGroup(t))(backend)
+	request := &Request{Method: "GET"}
+
+	first, _ := p(context.Background(), request)
+	first.Data["nested"].(map[string]interface{})["a"] = 2
+	second, _ := p(context.Background(), request)
+	second.Data["extra"] = true
+	third, _ := p(context.Background(), request)
+	if third.Data["nested"].(map[string]interface{})["a"] != 1 || third.Data["extra"] != nil {
+		t.Errorf("the cached response was modified by the callers: %v", third.Data)
+	}
+}
+
+func TestNewWarmCacheMiddleware_skipWrites(t *testing.T) {
+	var calls int64
+	backend := func(_ context.Context, _ *Request) (*Response, error) {
+		atomic.AddInt64(&calls, 1)
+		return &Response{IsComplete: true}, nil
+	}
+	cfg := &config.EndpointConfig{
+		CacheTTL:  time.Minute,
+		WarmCache: &config.WarmCache{MinHits: 2, RefreshBefore: time.Second, MaxKeys: 10},
+	}
+	p := NewWarmCacheMiddleware(cfg, testGroup(t))(backend)
+	for i := 0; i < 3; i++ {
+		p(context.Background(), &Request{Method: "POST"})
+	}
+	if calls != 3 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewWarmCacheMiddleware_stop(t *testing.T) {
+	backend := func(_ context.Context, _ *Request) (*Response, error) {
+		return &Response{IsComplete: true}, nil
+	}
+	cfg := &config.EndpointConfig{
+		CacheTTL:  time.Minute,
+		WarmCache: &config.WarmCache{MinHits: 1, RefreshBefore: time.Second, MaxKeys: 10},
+	}
+	g := lifecycle.NewGroup(context.Background())
+	c := newWarmCache(cfg, backend, g)
+	request := &Request{Method: "GET"}
+	c.proxy(context.Background(), request)
+	c.proxy(context.Background(), request)
+	if len(c.timers) != 1 {
+		t.Errorf("unexpected number of scheduled refreshes: %d", len(c.timers))
+	}
+
+	g.Stop(context.Background())
+	c.mu.Lock()
+	pending := len(c.timers)
+	c.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("the refreshes were not canceled: %d", pending)
+	}
+	c.proxy(context.Background(), &Request{Method: "GET", Query: map[string][]string{"a": {"1"}}})
+	c.proxy(context.Background(), &Request{Method: "GET", Query: map[string][]string{"a": {"1"}}})
+	if len(c.timers) != 0 {
+		t.Error("a refresh was scheduled after the group was stopped")
+	}
+}
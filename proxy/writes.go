@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// PartialWriteError is the error of the all_or_error write fan-outs when some backends fail. The
+// writes of the backends that succeeded are not rolled back
+type PartialWriteError struct {
+	// errors of the failed backends, keyed by their url pattern
+	Failed map[string]error
+	// url patterns of the backends that succeeded
+	Succeeded []string
+}
+
+func (e PartialWriteError) Error() string {
+	failed := make([]string, 0, len(e.Failed))
+	for backend, err := range e.Failed {
+		failed = append(failed, backend+": "+err.Error())
+	}
+	sort.Strings(failed)
+	return fmt.Sprintf("the write failed in %d of %d backends: %s", len(e.Failed), len(e.Failed)+len(e.Succeeded), strings.Join(failed, "; "))
+}
+
+// NewWriteFanOutMiddleware creates a proxy middleware sending the request, with a copy of its body, to
+// all the backends of a non GET endpoint and merging their responses following the consistency of the
+// write fan-out of the endpoint. The best_effort responses with failed backends are incomplete
+func NewWriteFanOutMiddleware(cfg *config.EndpointConfig) Middleware {
+	totalBackends := len(cfg.Backend)
+	if totalBackends == 0 {
+		panic(ErrNoBackends)
+	}
+	bestEffort := cfg.WriteFanOut != nil && cfg.WriteFanOut.Consistency == config.WriteConsistencyBestEffort
+
+	return func(next ...Proxy) Proxy {
+		if len(next) != totalBackends {
+			panic(ErrNotEnoughProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			var body []byte
+			if request.Body != nil {
+				var err error
+				body, err = io.ReadAll(request.Body)
+				request.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			type result struct {
+				i    int
+				resp *Response
+				err  error
+			}
+			results := make(chan result, len(next))
+			for i, n := range next {
+				r := request.Clone()
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				go func(i int, n Proxy, r *Request) {
+					resp, err := n(ctx, r)
+					if err == nil && resp == nil {
+						err = errNullResult
+					}
+					results <- result{i, resp, err}
+				}(i, n, &r)
+			}
+
+			failures := PartialWriteError{Failed: map[string]error{}}
+			responses := make([]*Response, len(next))
+			for range next {
+				res := <-results
+				backend := cfg.Backend[res.i].URLPattern
+				if res.err != nil {
+					failures.Failed[backend] = res.err
+					continue
+				}
+				failures.Succeeded = append(failures.Succeeded, backend)
+				responses[res.i] = res.resp
+			}
+
+			if len(failures.Failed) > 0 && (len(failures.Succeeded) == 0 || !bestEffort) {
+				sort.Strings(failures.Succeeded)
+				return nil, failures
+			}
+			return combineData(ctx, totalBackends, responses), nil
+		}
+	}
+}
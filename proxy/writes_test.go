@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewWriteFanOutMiddleware(t *testing.T) {
+	echo := func(key string) Proxy {
+		return func(_ context.Context, r *Request) (*Response, error) {
+			b, _ := io.ReadAll(r.Body)
+			return &Response{Data: map[string]interface{}{key: string(b)}, IsComplete: true}, nil
+		}
+	}
+	failing := func(_ context.Context, _ *Request) (*Response, error) {
+		return nil, errors.New("boom")
+	}
+	cfg := &config.EndpointConfig{
+		Method:      "POST",
+		Backend:     []*config.Backend{{URLPattern: "/a"}, {URLPattern: "/b"}},
+		WriteFanOut: &config.WriteFanOut{Consistency: config.WriteConsistencyAllOrError},
+	}
+	newRequest := func() *Request { return &Request{Body: io.NopCloser(strings.NewReader("supu"))} }
+
+	resp, err := NewWriteFanOutMiddleware(cfg)(echo("a"), echo("b"))(context.Background(), newRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsComplete || resp.Data["a"] != "supu" || resp.Data["b"] != "supu" {
+		t.Errorf("every backend must receive the body: %+v", resp)
+	}
+
+	_, err = NewWriteFanOutMiddleware(cfg)(echo("a"), failing)(context.Background(), newRequest())
+	partial := PartialWriteError{}
+	if !errors.As(err, &partial) || len(partial.Failed) != 1 || partial.Succeeded[0] != "/a" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.WriteFanOut.Consistency = config.WriteConsistencyBestEffort
+	resp, err = NewWriteFanOutMiddleware(cfg)(echo("a"), failing)(context.Background(), newRequest())
+	if err != nil || resp.IsComplete || resp.Data["a"] != "supu" {
+		t.Errorf("unexpected best effort result: %+v, %v", resp, err)
+	}
+	if _, err = NewWriteFanOutMiddleware(cfg)(failing, failing)(context.Background(), newRequest()); err == nil {
+		t.Error("expecting an error when all the backends fail")
+	}
+}
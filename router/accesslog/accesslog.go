@@ -0,0 +1,367 @@
+// Package accesslog writes a line per request served by the routers, in the common log format or as JSON
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router"
+	"github.com/ph0m1/porta/security"
+)
+
+// Entry is the record of a served request. The formatters decide how it is rendered, the JSON lines
+// having the fields of NewJSONFormat
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	User       string
+	Method     string
+	URI        string
+	Proto      string
+	Endpoint   string
+	Status     int
+	Bytes      int64
+	Latency    time.Duration
+	Backends   int
+	RequestID  string
+	Referer    string
+	UserAgent  string
+	Tenant     string
+	TraceID    string
+	// calls to the backends recorded by the BackendHook
+	Calls []BackendCall
+
+	// the calls recorded while the request is served, as the backends can be called concurrently
+	calls *backendCalls
+}
+
+// BackendCall is the record of a call to a backend made to serve the request
+type BackendCall struct {
+	Backend string
+	Latency time.Duration
+	Error   string
+}
+
+type backendCalls struct {
+	mu    sync.Mutex
+	calls []BackendCall
+}
+
+func (b *backendCalls) add(c BackendCall) {
+	b.mu.Lock()
+	b.calls = append(b.calls, c)
+	b.mu.Unlock()
+}
+
+func (b *backendCalls) list() []BackendCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BackendCall(nil), b.calls...)
+}
+
+// Formatter renders an entry as a line, including the line break
+type Formatter func(e *Entry) []byte
+
+// CommonLogFormat renders the entry in the combined log format, followed by the latency in
+// milliseconds, the number of backends and the request id
+func CommonLogFormat(e *Entry) []byte {
+	return []byte(fmt.Sprintf("%s - %s [%s] %q %d %d %q %q %.3f %d %q\n",
+		host(e.RemoteAddr),
+		dash(e.User),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Proto,
+		e.Status,
+		e.Bytes,
+		e.Referer,
+		e.UserAgent,
+		float64(e.Latency)/float64(time.Millisecond),
+		e.Backends,
+		e.RequestID,
+	))
+}
+
+// JSONFormat renders the entry as a JSON object with the DefaultJSONFields, like the formatter returned
+// by NewJSONFormat without fields
+func JSONFormat(e *Entry) []byte {
+	return formatJSON(e, DefaultJSONFields)
+}
+
+// Fields of the JSON lines of NewJSONFormat
+const (
+	FieldTimestamp        = "timestamp"
+	FieldMethod           = "method"
+	FieldEndpoint         = "endpoint"
+	FieldURI              = "uri"
+	FieldProto            = "proto"
+	FieldStatus           = "status"
+	FieldBytes            = "bytes"
+	FieldLatency          = "latency_ms"
+	FieldBackends         = "backends"
+	FieldBackendLatencies = "backend_latencies_ms"
+	FieldClient           = "client"
+	FieldUser             = "user"
+	FieldTenant           = "tenant"
+	FieldRequestID        = "request_id"
+	FieldTraceID          = "trace_id"
+	FieldReferer          = "referer"
+	FieldUserAgent        = "user_agent"
+)
+
+// DefaultJSONFields are the fields of the lines of NewJSONFormat if none is given
+var DefaultJSONFields = []string{
+	FieldTimestamp, FieldMethod, FieldEndpoint, FieldStatus, FieldLatency, FieldBackendLatencies,
+	FieldClient, FieldUser, FieldTenant, FieldRequestID, FieldTraceID,
+}
+
+var jsonFields = map[string]func(e *Entry) interface{}{
+	FieldTimestamp: func(e *Entry) interface{} { return e.Time.UTC().Format(time.RFC3339Nano) },
+	FieldMethod:    func(e *Entry) interface{} { return e.Method },
+	FieldEndpoint:  func(e *Entry) interface{} { return e.Endpoint },
+	FieldURI:       func(e *Entry) interface{} { return e.URI },
+	FieldProto:     func(e *Entry) interface{} { return e.Proto },
+	FieldStatus:    func(e *Entry) interface{} { return e.Status },
+	FieldBytes:     func(e *Entry) interface{} { return e.Bytes },
+	FieldLatency:   func(e *Entry) interface{} { return milliseconds(e.Latency) },
+	FieldBackends:  func(e *Entry) interface{} { return e.Backends },
+	FieldBackendLatencies: func(e *Entry) interface{} {
+		if len(e.Calls) == 0 {
+			return nil
+		}
+		latencies := make([]backendLatency, len(e.Calls))
+		for i, c := range e.Calls {
+			latencies[i] = backendLatency{Backend: c.Backend, Latency: milliseconds(c.Latency), Error: c.Error}
+		}
+		return latencies
+	},
+	FieldClient:    func(e *Entry) interface{} { return host(e.RemoteAddr) },
+	FieldUser:      func(e *Entry) interface{} { return e.User },
+	FieldTenant:    func(e *Entry) interface{} { return e.Tenant },
+	FieldRequestID: func(e *Entry) interface{} { return e.RequestID },
+	FieldTraceID:   func(e *Entry) interface{} { return e.TraceID },
+	FieldReferer:   func(e *Entry) interface{} { return e.Referer },
+	FieldUserAgent: func(e *Entry) interface{} { return e.UserAgent },
+}
+
+// backendLatency is the item of the backend latencies of the JSON lines. They are listed in the order of
+// the calls, as a backend can be called more than once to serve a request
+type backendLatency struct {
+	Backend string  `json:"backend"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// NewJSONFormat returns a formatter rendering the entries as flat JSON objects with the given fields, in
+// that order, for the log pipelines like ELK or Loki. The empty text fields and the backend latencies of
+// the requests without backend calls are left out. The default fields are used if none is given
+func NewJSONFormat(fields ...string) (Formatter, error) {
+	if len(fields) == 0 {
+		return JSONFormat, nil
+	}
+	for _, f := range fields {
+		if _, ok := jsonFields[f]; !ok {
+			return nil, fmt.Errorf("unknown access log field: %s", f)
+		}
+	}
+	return func(e *Entry) []byte {
+		return formatJSON(e, fields)
+	}, nil
+}
+
+func formatJSON(e *Entry, fields []string) []byte {
+	b := []byte{'{'}
+	for _, f := range fields {
+		field, ok := jsonFields[f]
+		if !ok {
+			continue
+		}
+		v := field(e)
+		if v == nil || v == "" || v == "-" {
+			continue
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		if len(b) > 1 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuote(b, f)
+		b = append(b, ':')
+		b = append(b, value...)
+	}
+	return append(b, '}', '\n')
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Logger writes the entries of the requests to its output
+type Logger struct {
+	mu       *sync.Mutex
+	out      io.Writer
+	format   Formatter
+	sampling float64
+}
+
+// New returns a logger writing the entries to the output with the formatter. The common log format is
+// used if the formatter is nil
+func New(out io.Writer, format Formatter) *Logger {
+	if format == nil {
+		format = CommonLogFormat
+	}
+	return &Logger{mu: new(sync.Mutex), out: out, format: format}
+}
+
+// Sampled returns a logger sharing the output of l and writing only the given fraction of the entries
+// of the successful requests. The entries of the responses with a status code of 400 or above are
+// always written
+func (l *Logger) Sampled(fraction float64) *Logger {
+	return &Logger{mu: l.mu, out: l.out, format: l.format, sampling: fraction}
+}
+
+type entryKey struct{}
+
+// Begin starts the entry of the request, returning the request carrying it so the endpoint handlers
+// can annotate it
+func (l *Logger) Begin(r *http.Request) (*http.Request, *Entry) {
+	e := &Entry{
+		Time:       time.Now(),
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		RequestID:  r.Header.Get(router.RequestIDHeader),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		calls:      &backendCalls{},
+	}
+	if e.URI == "" {
+		e.URI = r.URL.RequestURI()
+	}
+	if auth, ok := security.GetAuthContext(r); ok {
+		e.User = user(auth)
+	}
+	return r.WithContext(context.WithValue(r.Context(), entryKey{}, e)), e
+}
+
+// End completes the entry with the response sent to the client and writes it
+func (l *Logger) End(e *Entry, status int, bytes int64) {
+	e.Status = status
+	e.Bytes = bytes
+	e.Latency = time.Since(e.Time)
+	if e.calls != nil {
+		e.Calls = e.calls.list()
+	}
+	if l.sampling > 0 && status < http.StatusBadRequest && rand.Float64() >= l.sampling {
+		return
+	}
+	line := l.format(e)
+
+	l.mu.Lock()
+	l.out.Write(line)
+	l.mu.Unlock()
+}
+
+// Handler wraps the handler, logging every request it serves
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, e := l.Begin(r)
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		l.End(e, rw.status, rw.bytes)
+	})
+}
+
+// Annotate adds the details known by the endpoint handler to the entry of the request, if it is being
+// logged: the endpoint pattern, the number of backends and the identity set by the auth middlewares
+func Annotate(r *http.Request, endpoint string, backends int) {
+	e, ok := r.Context().Value(entryKey{}).(*Entry)
+	if !ok {
+		return
+	}
+	e.Endpoint = endpoint
+	e.Backends = backends
+	if auth, ok := security.GetAuthContext(r); ok {
+		e.User = user(auth)
+	}
+	if e.RequestID == "" {
+		e.RequestID = r.Header.Get(router.RequestIDHeader)
+	}
+	if t, ok := security.TenantFrom(r.Context()); ok {
+		e.Tenant = t.ID
+	}
+	e.TraceID = proxy.TraceID(r.Context())
+}
+
+// BackendHook returns a proxy hook recording the latency of the calls to the backends in the entry of
+// the request, if it is being logged
+func BackendHook() proxy.Hook {
+	return proxy.HookFuncs{
+		After: func(ctx context.Context, call proxy.BackendCall, outcome proxy.BackendOutcome) {
+			e, ok := ctx.Value(entryKey{}).(*Entry)
+			if !ok || e.calls == nil {
+				return
+			}
+			c := BackendCall{Backend: call.Backend.URLPattern, Latency: outcome.Duration}
+			if outcome.Err != nil {
+				c.Error = outcome.Err.Error()
+			}
+			e.calls.add(c)
+		},
+	}
+}
+
+func user(auth *security.AuthContext) string {
+	if auth.UserID != "" {
+		return auth.UserID
+	}
+	return auth.ClientID
+}
+
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return dash(addr)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// responseWriter keeps the status and the size of the response
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,130 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router"
+	"github.com/ph0m1/porta/security"
+)
+
+func TestLogger_Handler(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := New(out, JSONFormat)
+	endpoint := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Annotate(r, "/users/:id", 2)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), "auth", &security.AuthContext{UserID: "supu"})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+
+	req := httptest.NewRequest("POST", "/users/42?x=1", nil)
+	req.Header.Set(router.RequestIDHeader, "abc")
+	l.Handler(auth(endpoint)).ServeHTTP(httptest.NewRecorder(), req)
+
+	var e map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Error(err)
+		return
+	}
+	if e["status"] != 201.0 || e["endpoint"] != "/users/:id" || e["user"] != "supu" || e["request_id"] != "abc" {
+		t.Errorf("unexpected entry: %v", e)
+	}
+
+	out.Reset()
+	format, _ := NewJSONFormat(FieldURI, FieldBytes, FieldBackends)
+	New(out, format).Handler(auth(endpoint)).ServeHTTP(httptest.NewRecorder(), req)
+	if line := out.String(); line != "{\"uri\":\"/users/42?x=1\",\"bytes\":5,\"backends\":2}\n" {
+		t.Errorf("unexpected line: %s", line)
+	}
+}
+
+func TestCommonLogFormat(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := New(out, nil)
+	l.Handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/supu", nil))
+
+	line := out.String()
+	if !strings.HasPrefix(line, "192.0.2.1 - - [") || !strings.Contains(line, `"GET /supu HTTP/1.1" 404 19`) {
+		t.Errorf("unexpected line: %s", line)
+	}
+}
+
+func TestLogger_Sampled(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := New(out, JSONFormat).Sampled(1e-12)
+	for i := 0; i < 10; i++ {
+		_, e := l.Begin(httptest.NewRequest("GET", "/supu", nil))
+		l.End(e, http.StatusOK, 0)
+	}
+	_, e := l.Begin(httptest.NewRequest("GET", "/supu", nil))
+	l.End(e, http.StatusBadGateway, 0)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"status":502`) {
+		t.Errorf("unexpected entries: %v", lines)
+	}
+}
+
+func TestNewJSONFormat(t *testing.T) {
+	format, err := NewJSONFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	l := New(out, format)
+	endpoint := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := security.WithTenant(proxy.WithTraceID(r.Context(), "abc"), &security.Tenant{ID: "acme"})
+		r = r.WithContext(ctx)
+		Annotate(r, "/users/:id", 1)
+		call := proxy.BackendCall{Backend: &config.Backend{URLPattern: "/users/{id}"}}
+		BackendHook().AfterBackend(ctx, call, proxy.BackendOutcome{Duration: 1500 * time.Microsecond})
+		BackendHook().AfterBackend(ctx, call, proxy.BackendOutcome{Duration: time.Millisecond, Err: proxy.ErrInvalidStatusCode})
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	l.Handler(endpoint).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+
+	line := out.String()
+	if !strings.HasPrefix(line, `{"timestamp":"`) {
+		t.Errorf("unexpected line: %s", line)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["method"] != "GET" || entry["endpoint"] != "/users/:id" || entry["status"] != 502.0 ||
+		entry["client"] != "192.0.2.1" || entry["tenant"] != "acme" || entry["trace_id"] != "abc" {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+	latencies, _ := json.Marshal(entry["backend_latencies_ms"])
+	if string(latencies) != `[{"backend":"/users/{id}","latency_ms":1.5},{"backend":"/users/{id}","error":"`+proxy.ErrInvalidStatusCode.Error()+`","latency_ms":1}]` {
+		t.Errorf("unexpected backend latencies: %s", latencies)
+	}
+	if _, ok := entry["user"]; ok {
+		t.Errorf("the empty fields must be left out: %v", entry)
+	}
+
+	if line := string(JSONFormat(&Entry{Status: 200})); line != "{\"timestamp\":\"0001-01-01T00:00:00Z\",\"status\":200,\"latency_ms\":0}\n" {
+		t.Errorf("unexpected default line: %s", line)
+	}
+	format, _ = NewJSONFormat(FieldStatus, FieldURI)
+	if line := string(format(&Entry{Status: 200, URI: "/a"})); line != "{\"status\":200,\"uri\":\"/a\"}\n" {
+		t.Errorf("unexpected line: %s", line)
+	}
+	if _, err := NewJSONFormat("supu"); err == nil {
+		t.Error("expecting an error for the unknown field")
+	}
+}
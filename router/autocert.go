@@ -0,0 +1,29 @@
+package router
+
+import (
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// AutoCertCache stores the accounts and the certificates obtained through ACME. If nil, they are
+// stored in the cache_dir of the autocert config, or only in memory if it is empty
+var AutoCertCache autocert.Cache
+
+// NewAutoCertManager creates the manager obtaining and renewing the certificates of the allowed domains
+func NewAutoCertManager(cfg *config.AutoCert, cache autocert.Cache) *autocert.Manager {
+	if cache == nil && cfg.CacheDir != "" {
+		cache = autocert.DirCache(cfg.CacheDir)
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Cache:      cache,
+		Email:      cfg.Email,
+	}
+	if cfg.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	return m
+}
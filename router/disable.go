@@ -0,0 +1,231 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// DisabledPath is the path of the admin endpoint disabling and re-enabling the endpoints at runtime
+const DisabledPath = "/__disabled"
+
+// DisabledEndpoint is an endpoint disabled at runtime. Until is nil if it is disabled until enabled
+// again through the admin endpoint
+type DisabledEndpoint struct {
+	Method   string     `json:"method"`
+	Endpoint string     `json:"endpoint"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// DisabledEndpoints keeps the endpoints disabled during an incident, answering their requests with a
+// 503 without reaching the backends. The disabled endpoints survive the reloads of the config through
+// the Service of the router, but they are not persisted, so a restart enables all of them again
+type DisabledEndpoints struct {
+	endpoints []*config.EndpointConfig
+	body      string
+
+	mu    sync.RWMutex
+	until map[DisabledEndpoint]time.Time
+}
+
+// NewDisabledEndpoints returns the switch of the endpoints of the service. The disabled ones answer
+// with the disabled body of the admin settings
+func NewDisabledEndpoints(cfg config.ServiceConfig) *DisabledEndpoints {
+	d := &DisabledEndpoints{endpoints: cfg.Endpoints, until: map[DisabledEndpoint]time.Time{}}
+	if cfg.Admin != nil {
+		d.body = cfg.Admin.DisabledBody
+	}
+	return d
+}
+
+// Disable disables the endpoint for the ttl, or until enabled again if the ttl is zero. It returns
+// false if the service does not declare the endpoint
+func (d *DisabledEndpoints) Disable(method, endpoint string, ttl time.Duration) bool {
+	key := DisabledEndpoint{Method: strings.ToUpper(method), Endpoint: endpoint}
+	if !d.declares(key) {
+		return false
+	}
+	var until time.Time
+	if ttl > 0 {
+		until = time.Now().Add(ttl)
+	}
+	d.mu.Lock()
+	d.until[key] = until
+	d.mu.Unlock()
+	return true
+}
+
+// Enable enables the endpoint again. It returns false if the endpoint was not disabled
+func (d *DisabledEndpoints) Enable(method, endpoint string) bool {
+	key := DisabledEndpoint{Method: strings.ToUpper(method), Endpoint: endpoint}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.until[key]
+	delete(d.until, key)
+	return ok
+}
+
+// List returns the endpoints currently disabled
+func (d *DisabledEndpoints) List() []DisabledEndpoint {
+	now := time.Now()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	res := []DisabledEndpoint{}
+	for key, until := range d.until {
+		if expired(until, now) {
+			continue
+		}
+		if !until.IsZero() {
+			until := until
+			key.Until = &until
+		}
+		res = append(res, key)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Endpoint == res[j].Endpoint {
+			return res[i].Method < res[j].Method
+		}
+		return res[i].Endpoint < res[j].Endpoint
+	})
+	return res
+}
+
+// Middleware answers the requests of the disabled endpoints with a 503
+func (d *DisabledEndpoints) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.disabled(r.Method, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if d.body == "" {
+			http.Error(w, "endpoint temporarily disabled", http.StatusServiceUnavailable)
+			return
+		}
+		if json.Valid([]byte(d.body)) {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(d.body))
+	})
+}
+
+// Handler lists the disabled endpoints on GET, disables the endpoint of the JSON body, like
+// {"method":"GET","endpoint":"/users/:id","ttl":"15m"}, on POST and enables the one of the method and
+// endpoint query params on DELETE
+func (d *DisabledEndpoints) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			req := struct {
+				Method   string `json:"method"`
+				Endpoint string `json:"endpoint"`
+				TTL      string `json:"ttl"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var ttl time.Duration
+			if req.TTL != "" {
+				var err error
+				if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl < 0 {
+					http.Error(w, "invalid ttl: "+req.TTL, http.StatusBadRequest)
+					return
+				}
+			}
+			if req.Method == "" {
+				req.Method = http.MethodGet
+			}
+			if !d.Disable(req.Method, req.Endpoint, ttl) {
+				http.Error(w, "unknown endpoint: "+req.Method+" "+req.Endpoint, http.StatusNotFound)
+				return
+			}
+		case http.MethodDelete:
+			method := r.URL.Query().Get("method")
+			if method == "" {
+				method = http.MethodGet
+			}
+			if !d.Enable(method, r.URL.Query().Get("endpoint")) {
+				http.Error(w, "the endpoint is not disabled", http.StatusNotFound)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.List())
+	})
+}
+
+// keep disables again the endpoints disabled in the previous switch and still declared by the service
+func (d *DisabledEndpoints) keep(previous *DisabledEndpoints) {
+	now := time.Now()
+	previous.mu.RLock()
+	defer previous.mu.RUnlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, until := range previous.until {
+		if !expired(until, now) && d.declares(key) {
+			d.until[key] = until
+		}
+	}
+}
+
+func (d *DisabledEndpoints) declares(key DisabledEndpoint) bool {
+	for _, e := range d.endpoints {
+		if e.Method == key.Method && e.Endpoint == key.Endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// disabled reports if the request targets a disabled endpoint, enabling again the expired ones
+func (d *DisabledEndpoints) disabled(method, path string) bool {
+	d.mu.RLock()
+	if len(d.until) == 0 {
+		d.mu.RUnlock()
+		return false
+	}
+	now := time.Now()
+	var match, stale bool
+	for key, until := range d.until {
+		if expired(until, now) {
+			stale = true
+			continue
+		}
+		if key.Method != method {
+			continue
+		}
+		if _, ok := matchPath(key.Endpoint, path); ok {
+			match = true
+			break
+		}
+	}
+	d.mu.RUnlock()
+
+	if stale {
+		d.mu.Lock()
+		for key, until := range d.until {
+			if expired(until, now) {
+				delete(d.until, key)
+			}
+		}
+		d.mu.Unlock()
+	}
+	return match
+}
+
+func expired(until, now time.Time) bool {
+	return !until.IsZero() && !now.Before(until)
+}
@@ -0,0 +1,167 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ph0m1/porta/proxy"
+)
+
+// RequestIDHeader is the header identifying the requests
+const RequestIDHeader = "X-Request-Id"
+
+// ErrorHandler writes the response for an error returned by the proxy of an endpoint
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// ProblemContentType is the media type of the RFC 9457 problem details
+const ProblemContentType = "application/problem+json"
+
+// DefaultErrorHandler sends the request validation errors with ProblemErrorHandler and any other error
+// message as plain text with the status code mapped by ErrorStatus
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.As(err, &proxy.ValidationError{}) {
+		ProblemErrorHandler(w, r, err)
+		return
+	}
+	http.Error(w, err.Error(), ErrorStatus(err))
+}
+
+// PlainTextErrorHandler sends the error message as plain text, like the gateway did before the problem
+// details. The request validation errors get a 400 status code and any other error a 500
+func PlainTextErrorHandler(w http.ResponseWriter, _ *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if errors.As(err, &proxy.ValidationError{}) {
+		status = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// Problem is the body of an RFC 9457 problem details response
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors lists the invalid fields of the request, if any
+	Errors []proxy.FieldError `json:"errors,omitempty"`
+}
+
+// ProblemErrorHandler sends the error as a problem details document, with a pointer to every invalid
+// field of the request validation errors. The clients not accepting JSON get the plain text message
+func ProblemErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := ErrorStatus(err)
+	if !acceptsJSON(r.Header.Get("Accept")) {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+	var verr proxy.ValidationError
+	if errors.As(err, &verr) {
+		problem.Detail = "the request has invalid fields"
+		problem.Errors = verr.Fields
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// acceptsJSON tells if the Accept header allows a JSON body. No header accepts anything
+func acceptsJSON(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case ProblemContentType, "application/json", "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// JSONErrorHandler sends the error as a JSON object with the status code mapped by ErrorStatus and
+// the request ID, if any
+func JSONErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := ErrorStatus(err)
+	body := map[string]interface{}{
+		"error":  err.Error(),
+		"status": status,
+	}
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// ErrorStatus returns the status code matching the received proxy error
+func ErrorStatus(err error) int {
+	if errors.As(err, &proxy.ValidationError{}) {
+		return http.StatusBadRequest
+	}
+	if errors.As(err, &proxy.PartialWriteError{}) {
+		return http.StatusBadGateway
+	}
+	var throttled proxy.ThrottledError
+	if errors.As(err, &throttled) {
+		if throttled.Propagate {
+			return throttled.Status
+		}
+		return http.StatusBadGateway
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, proxy.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, proxy.ErrLoopDetected):
+		return http.StatusLoopDetected
+	case errors.Is(err, proxy.ErrInvalidStatusCode):
+		return http.StatusBadGateway
+	case errors.Is(err, proxy.ErrHeadersTooLarge):
+		return http.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, proxy.ErrBackendNotAllowed):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+type errorHandlerKey struct{}
+
+// WithErrorHandler returns a copy of the request carrying the error handler to be used by the endpoints
+func WithErrorHandler(r *http.Request, eh ErrorHandler) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), errorHandlerKey{}, eh))
+}
+
+// HandleError writes the error with the handler carried by the request or with the DefaultErrorHandler.
+// The throttling of the backends propagated to the clients gets their Retry-After header
+func HandleError(w http.ResponseWriter, r *http.Request, err error) {
+	if throttled, ok := propagatedThrottling(err); ok && throttled.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	}
+	if eh, ok := r.Context().Value(errorHandlerKey{}).(ErrorHandler); ok && eh != nil {
+		eh(w, r, err)
+		return
+	}
+	DefaultErrorHandler(w, r, err)
+}
+
+func propagatedThrottling(err error) (proxy.ThrottledError, bool) {
+	var throttled proxy.ThrottledError
+	return throttled, errors.As(err, &throttled) && throttled.Propagate
+}
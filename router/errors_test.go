@@ -0,0 +1,90 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/proxy"
+)
+
+func TestErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{proxy.ValidationError{Fields: []proxy.FieldError{{Pointer: "/name"}}}, http.StatusBadRequest},
+		{fmt.Errorf("wrapped: %w", proxy.ValidationError{}), http.StatusBadRequest},
+		{proxy.PartialWriteError{}, http.StatusBadGateway},
+		{proxy.ThrottledError{Status: http.StatusTooManyRequests, Propagate: true}, http.StatusTooManyRequests},
+		{proxy.ThrottledError{Status: http.StatusTooManyRequests}, http.StatusBadGateway},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{proxy.ErrCircuitOpen, http.StatusServiceUnavailable},
+		{proxy.ErrLoopDetected, http.StatusLoopDetected},
+		{proxy.ErrInvalidStatusCode, http.StatusBadGateway},
+		{proxy.ErrHeadersTooLarge, http.StatusRequestHeaderFieldsTooLarge},
+		{proxy.ErrBackendNotAllowed, http.StatusForbidden},
+		{fmt.Errorf("wrapped: %w", proxy.ErrBackendNotAllowed), http.StatusForbidden},
+		{errors.New("supu"), http.StatusInternalServerError},
+	} {
+		if status := ErrorStatus(tc.err); status != tc.status {
+			t.Errorf("%v: want %d, have %d", tc.err, tc.status, status)
+		}
+	}
+}
+
+func TestJSONErrorHandler(t *testing.T) {
+	r := httptest.NewRequest("GET", "/supu", nil)
+	r.Header.Set(RequestIDHeader, "abc")
+	w := httptest.NewRecorder()
+	JSONErrorHandler(w, r, proxy.ErrCircuitOpen)
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != proxy.ErrCircuitOpen.Error() || body["status"] != 503.0 || body["request_id"] != "abc" {
+		t.Errorf("unexpected body: %v", body)
+	}
+
+	w = httptest.NewRecorder()
+	JSONErrorHandler(w, httptest.NewRequest("GET", "/supu", nil), errors.New("supu"))
+	body = nil
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if _, ok := body["request_id"]; ok || w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected response: %d %v", w.Code, body)
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	w := httptest.NewRecorder()
+	HandleError(w, httptest.NewRequest("GET", "/supu", nil), errors.New("supu"))
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "supu\n" {
+		t.Errorf("unexpected default response: %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	HandleError(w, httptest.NewRequest("GET", "/supu", nil), proxy.ErrCircuitOpen)
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != proxy.ErrCircuitOpen.Error()+"\n" {
+		t.Errorf("unexpected default response: %d %s", w.Code, w.Body.String())
+	}
+
+	r := WithErrorHandler(httptest.NewRequest("GET", "/supu", nil), JSONErrorHandler)
+	w = httptest.NewRecorder()
+	throttled := proxy.ThrottledError{Status: http.StatusTooManyRequests, RetryAfter: 1500 * time.Millisecond, Propagate: true}
+	HandleError(w, r, throttled)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("unexpected Retry-After: %s", retryAfter)
+	}
+}
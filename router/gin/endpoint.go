@@ -4,53 +4,107 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/textproto"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/ph0m1/porta/cdn"
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
 	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router"
+	"github.com/ph0m1/porta/router/accesslog"
 )
 
 var ErrInternalError = errors.New("internal server error")
 
 type HandlerFactory func(endpointConfig *config.EndpointConfig, proxy2 proxy.Proxy) gin.HandlerFunc
 
-func EndpointHandler(cfg *config.EndpointConfig, proxy proxy.Proxy) gin.HandlerFunc {
-	endpointTimeout := time.Duration(cfg.Timeout) * time.Millisecond
-
+func EndpointHandler(cfg *config.EndpointConfig, p proxy.Proxy) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requestCtx, cancel := context.WithTimeout(c, endpointTimeout)
+		// the request context carries the values set by the http middlewares, like the auth context
+		ctx := c.Request.Context()
+		timeout, bypass := proxy.RequestOverrides(ctx, cfg, c.Request.Header)
+		if bypass {
+			ctx = proxy.WithCacheBypass(ctx)
+		}
+		if id := c.Request.Header.Get(router.RequestIDHeader); id != "" {
+			ctx = proxy.WithRequestID(ctx, id)
+		}
+		requestCtx, cancel := context.WithTimeout(ctx, proxy.RequestTimeout(c.Request.Header, timeout))
 
 		c.Header("X_X", "Version undefined")
+		accesslog.Annotate(c.Request, cfg.Endpoint, len(cfg.Backend))
+
+		request := NewRequest(c, cfg.QueryString)
+		passHeaders(request, c.Request.Header, cfg.HeadersToPass)
+		// only the federated backends receive them, the proxies of the rest drop them
+		passHeaders(request, c.Request.Header, cfg.FederatedHeaders)
+		if cfg.OutputEncoding == encoding.NOOP {
+			passHeaders(request, c.Request.Header, proxy.RangeHeaders)
+		}
 
-		response, err := proxy(requestCtx, NewRequest(c, cfg.QueryString))
+		response, err := p(requestCtx, request)
+		if response != nil && response.Io != nil {
+			defer response.Io.Close()
+		}
 		if err != nil {
 			// 添加详细的错误日志
 			fmt.Printf("[DEBUG] Proxy error: %v\n", err)
 			fmt.Printf("[DEBUG] Error type: %T\n", err)
 			fmt.Printf("[DEBUG] Error string: %s\n", err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
+			router.HandleError(c.Writer, c.Request, err)
 			cancel()
 			return
 		}
 
 		select {
 		case <-requestCtx.Done():
-			c.AbortWithError(http.StatusInternalServerError, ErrInternalError)
+			c.Abort()
+			router.HandleError(c.Writer, c.Request, ErrInternalError)
 			cancel()
+			return
 		default:
 		}
 
-		if cfg.CacheTTL.Seconds() != 0 && response != nil && response.IsComplete {
+		if cfg.CacheTTL.Seconds() != 0 && response != nil && response.IsComplete && !bypass {
 			c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cfg.CacheTTL.Seconds())))
 		}
+		if response != nil && response.IsComplete {
+			cdn.SetHeaders(c.Writer.Header(), cfg.EdgeCache, request.Params)
+		}
+		status := http.StatusOK
+		data := map[string]interface{}{}
 		if response != nil {
-			c.JSON(http.StatusOK, response.Data)
+			for k, v := range response.Metadata.Headers {
+				// the Vary of the response extends the one of the edge cache
+				if k == "Vary" {
+					v = append(c.Writer.Header().Values(k), v...)
+				}
+				c.Writer.Header()[k] = v
+			}
+			if response.Metadata.StatusCode != 0 {
+				status = response.Metadata.StatusCode
+			}
+			data = response.Data
+		}
+		contentType, body, err := router.Encode(cfg.OutputEncoding, data)
+		if err != nil {
+			router.HandleError(c.Writer, c.Request, err)
+			cancel()
+			return
+		}
+		if contentType != "" {
+			c.Header("Content-Type", contentType)
+		}
+		c.Status(status)
+		if response != nil && response.Io != nil {
+			io.Copy(c.Writer, response.Io)
 		} else {
-			c.JSON(http.StatusOK, gin.H{})
+			c.Writer.Write(body)
 		}
 		cancel()
 	}
@@ -61,10 +115,23 @@ var (
 	userAgentHeaderValue = []string{"X_X Version undefined"}
 )
 
+func passHeaders(request *proxy.Request, header http.Header, headersToPass []string) {
+	for _, k := range headersToPass {
+		if h, ok := header[textproto.CanonicalMIMEHeaderKey(k)]; ok {
+			request.Headers[textproto.CanonicalMIMEHeaderKey(k)] = h
+		}
+	}
+}
+
 func NewRequest(c *gin.Context, queryString []string) *proxy.Request {
 	params := make(map[string]string, len(c.Params))
 	for _, param := range c.Params {
-		params[strings.Title(param.Key)] = param.Value
+		value := param.Value
+		if strings.HasSuffix(c.FullPath(), "/*"+param.Key) {
+			// the catch-all params keep the leading slash in gin
+			value = strings.TrimPrefix(value, "/")
+		}
+		params[strings.Title(param.Key)] = value
 	}
 
 	headers := make(map[string][]string, 2+len(headersToSend))
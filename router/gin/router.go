@@ -1,14 +1,21 @@
 package gin
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
 	"github.com/ph0m1/porta/logging"
+	"github.com/ph0m1/porta/monitoring"
 	"github.com/ph0m1/porta/proxy"
 	"github.com/ph0m1/porta/router"
+	"github.com/ph0m1/porta/router/accesslog"
+	"github.com/ph0m1/porta/router/interceptor"
+	"github.com/ph0m1/porta/router/middleware"
 )
 
 type Config struct {
@@ -17,6 +24,21 @@ type Config struct {
 	HandlerFactory HandlerFactory
 	ProxyFactory   proxy.Factory
 	Logger         logging.Logger
+	// factories of the middlewares declared by the endpoints
+	EndpointMiddlewares middleware.Registry
+	// handler writing the errors returned by the proxies. router.DefaultErrorHandler if nil
+	ErrorHandler router.ErrorHandler
+	// handler of the requests not matching any endpoint. The gin default if nil
+	NotFoundHandler http.Handler
+	// logger of the served requests. No access log is written if nil
+	AccessLog *accesslog.Logger
+	// creates the engines of the reloaded configs. The router can not be reloaded if nil
+	NewEngine func() *gin.Engine
+	// factories of the interceptors declared by the endpoints
+	Interceptors interceptor.Registry
+	// recorder of the requests to the endpoints, including the ones rejected by their middlewares. The
+	// requests are not recorded if nil
+	Metrics monitoring.Recorder
 }
 
 func DefaultFactory(pf proxy.Factory, logger logging.Logger) router.Factory {
@@ -27,6 +49,10 @@ func DefaultFactory(pf proxy.Factory, logger logging.Logger) router.Factory {
 			HandlerFactory: EndpointHandler,
 			ProxyFactory:   pf,
 			Logger:         logger,
+
+			EndpointMiddlewares: middleware.DefaultRegistry(),
+			NewEngine:           func() *gin.Engine { return gin.Default() },
+			Interceptors:        interceptor.DefaultRegistry(),
 		},
 	}
 }
@@ -40,33 +66,116 @@ type factory struct {
 }
 
 func (rf factory) New() router.Router {
-	return ginRouter{rf.cfg}
+	return ginRouter{rf.cfg, new(router.SwapHandler), new(router.Service)}
 }
 
 type ginRouter struct {
-	cfg Config
+	cfg     Config
+	live    *router.SwapHandler
+	service *router.Service
+}
+
+// Run implements the router interface
+func (r ginRouter) Run(cfg config.ServiceConfig) error {
+	ctx, stop := router.SignalContext()
+	defer stop()
+	return r.RunWithContext(ctx, cfg)
 }
 
-func (r ginRouter) Run(cfg config.ServiceConfig) {
+// RunWithContext implements the router.ContextRunner interface
+func (r ginRouter) RunWithContext(ctx context.Context, cfg config.ServiceConfig) error {
 	if !cfg.Debug {
 		gin.SetMode(gin.ReleaseMode)
 	} else {
 		r.cfg.Logger.Debug("Debug enabled")
 	}
-	//engine := gin.Default()
+	handler, g, err := r.build(cfg)
+	if err != nil {
+		return err
+	}
+	r.live.Swap(handler, g)
+	defer r.live.Stop(context.Background())
 
+	server := router.NewServer(cfg, r.live)
+	if err := router.RunServer(ctx, server, cfg); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Reload implements the router.Reloader interface
+func (r ginRouter) Reload(cfg config.ServiceConfig) error {
+	if r.cfg.NewEngine == nil {
+		return router.ErrReloadNotSupported
+	}
+	next := r
+	next.cfg.Engine = r.cfg.NewEngine()
+	handler, g, err := next.build(cfg)
+	if err != nil {
+		return err
+	}
+	r.cfg.Logger.Info("config reloaded with", len(cfg.Endpoints), "endpoints")
+	return r.live.Swap(handler, g)
+}
+
+// build registers the middlewares and the endpoints of the config in the engine. It fails if the proxy,
+// the middlewares or the interceptors of an endpoint can not be built, as serving the endpoint without
+// them could leave it without its auth or its rate limits. The returned group runs the background work
+// of the built stacks
+func (r ginRouter) build(cfg config.ServiceConfig) (http.Handler, *lifecycle.Group, error) {
 	r.cfg.Engine.RedirectTrailingSlash = true
 	r.cfg.Engine.RedirectFixedPath = true
 	r.cfg.Engine.HandleMethodNotAllowed = true
 
+	if r.cfg.AccessLog != nil {
+		logger := r.cfg.AccessLog
+		if cfg.LogSampling > 0 {
+			logger = logger.Sampled(cfg.LogSampling)
+		}
+		r.cfg.Engine.Use(accessLogMiddleware(logger))
+	}
+	if r.cfg.ErrorHandler != nil {
+		r.cfg.Engine.Use(errorHandlerMiddleware(r.cfg.ErrorHandler))
+	}
+	if r.cfg.NotFoundHandler != nil {
+		r.cfg.Engine.NoRoute(gin.WrapH(r.cfg.NotFoundHandler))
+	}
 	r.cfg.Engine.Use(r.cfg.Middlewares...)
 
 	if cfg.Debug {
 		r.registerDebugEndpoints()
 	}
-	r.registerEndpoints(cfg.Endpoints)
+	g := lifecycle.NewGroup(context.Background())
+	if err := r.registerEndpoints(cfg.Endpoints, g); err != nil {
+		g.Stop(context.Background())
+		return nil, nil, err
+	}
+	h, err := r.service.Middlewares(cfg, r.cfg.Engine)
+	if err != nil {
+		g.Stop(context.Background())
+		return nil, nil, err
+	}
+	return h, g, nil
+}
+
+func errorHandlerMiddleware(eh router.ErrorHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = router.WithErrorHandler(c.Request, eh)
+		c.Next()
+	}
+}
 
-	r.cfg.Logger.Critical(r.cfg.Engine.Run(fmt.Sprintf(":%d", cfg.Port)))
+func accessLogMiddleware(l *accesslog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var entry *accesslog.Entry
+		c.Request, entry = l.Begin(c.Request)
+		c.Next()
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		l.End(entry, c.Writer.Status(), int64(size))
+	}
 }
 
 func (r ginRouter) registerDebugEndpoints() {
@@ -75,20 +184,39 @@ func (r ginRouter) registerDebugEndpoints() {
 	r.cfg.Engine.POST("/__debug/*param", handler)
 	r.cfg.Engine.PUT("/__debug/*param", handler)
 }
-func (r ginRouter) registerEndpoints(endpoints []*config.EndpointConfig) {
+func (r ginRouter) registerEndpoints(endpoints []*config.EndpointConfig, g *lifecycle.Group) error {
+	pf := proxy.WithGroup(r.cfg.ProxyFactory, g)
 	for _, c := range endpoints {
-		proxyStack, err := r.cfg.ProxyFactory.New(c)
+		proxyStack, err := pf.New(c)
 		if err != nil {
-			r.cfg.Logger.Error("calling the ProxyFactory", err.Error())
-			continue
+			return fmt.Errorf("building the proxy of the endpoint [%s]: %s", c.Endpoint, err.Error())
+		}
+		ic, err := r.cfg.Interceptors.Build(c)
+		if err != nil {
+			return err
+		}
+		if ic != nil {
+			proxyStack = interceptor.Proxy(ic, proxyStack)
 		}
-		r.registerEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend))
+		handler := r.cfg.HandlerFactory(c, proxyStack)
+		mw, err := r.cfg.EndpointMiddlewares.Build(c, g)
+		if err != nil {
+			return err
+		}
+		if mw != nil {
+			handler = wrapHandler(mw, handler)
+		}
+		if r.cfg.Metrics != nil {
+			handler = wrapHandler(monitoring.NewHTTPMiddleware(r.cfg.Metrics, c), handler)
+		}
+		r.registerEndpoint(c.Method, c.Endpoint, handler, len(c.Backend), c.WriteFanOut != nil)
 	}
+	return nil
 }
 
-func (r ginRouter) registerEndpoint(method, path string, handler gin.HandlerFunc, toBackends int) {
-	if method != "GET" && toBackends > 1 {
-		r.cfg.Logger.Error(method, "endpoints must have a single backend! Ignoring", path)
+func (r ginRouter) registerEndpoint(method, path string, handler gin.HandlerFunc, toBackends int, fanOut bool) {
+	if method != "GET" && toBackends > 1 && !fanOut {
+		r.cfg.Logger.Error(method, "endpoints must have a single backend or a write fan-out! Ignoring", path)
 		return
 	}
 	switch method {
@@ -102,8 +230,43 @@ func (r ginRouter) registerEndpoint(method, path string, handler gin.HandlerFunc
 		r.cfg.Engine.PATCH(path, handler)
 	case "DELETE":
 		r.cfg.Engine.DELETE(path, handler)
-
+	case "HEAD":
+		r.cfg.Engine.HEAD(path, handler)
+	case "OPTIONS":
+		r.cfg.Engine.OPTIONS(path, handler)
 	default:
 		r.cfg.Logger.Error("Unsupported method", method)
 	}
 }
+
+// wrapHandler runs the gin handler inside the http middleware, keeping the request and the response
+// writer passed down by the middleware
+func wrapHandler(mw middleware.Middleware, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		original := c.Writer
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.Request = r
+			if w != http.ResponseWriter(original) {
+				c.Writer = responseWriter{original, w}
+			}
+			handler(c)
+		})).ServeHTTP(original, c.Request)
+		c.Writer = original
+	}
+}
+
+// responseWriter sends the response to the writer of a middleware
+type responseWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter
+}
+
+func (rw responseWriter) Header() http.Header { return rw.w.Header() }
+
+func (rw responseWriter) WriteHeader(status int) { rw.w.WriteHeader(status) }
+
+func (rw responseWriter) WriteHeaderNow() {}
+
+func (rw responseWriter) Write(b []byte) (int, error) { return rw.w.Write(b) }
+
+func (rw responseWriter) WriteString(s string) (int, error) { return rw.w.Write([]byte(s)) }
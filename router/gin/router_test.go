@@ -0,0 +1,90 @@
+package gin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+type proxyFactoryFunc func(*config.EndpointConfig) (proxy.Proxy, error)
+
+func (f proxyFactoryFunc) New(cfg *config.EndpointConfig) (proxy.Proxy, error) { return f(cfg) }
+
+// newTestRouter returns a router serving the endpoints with the settings applied by the received
+// function. The proxies answer the method of their endpoint, but the ones of the /failing endpoints
+// return an open circuit error
+func newTestRouter(t *testing.T, configure func(*Config), endpoints ...*config.EndpointConfig) ginRouter {
+	t.Helper()
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	f := DefaultFactory(proxyFactoryFunc(func(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+		return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+			if cfg.Endpoint == "/failing" {
+				return nil, proxy.ErrCircuitOpen
+			}
+			return &proxy.Response{Data: map[string]interface{}{"endpoint": cfg.Method}, IsComplete: true}, nil
+		}, nil
+	}), logger).(factory)
+	f.cfg.NewEngine = func() *gin.Engine { return gin.New() }
+	configure(&f.cfg)
+	r := f.New().(ginRouter)
+	if err := router.Reload(r, config.ServiceConfig{Endpoints: endpoints}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.live.Stop(context.Background()) })
+	return r
+}
+
+func testEndpoint(method, path string) *config.EndpointConfig {
+	return &config.EndpointConfig{
+		Endpoint: path,
+		Method:   method,
+		Timeout:  time.Second,
+		Backend:  []*config.Backend{{URLPattern: "/tupu"}},
+	}
+}
+
+func TestRouter_errorHandlers(t *testing.T) {
+	r := newTestRouter(t, func(_ *Config) {}, testEndpoint("GET", "/failing"))
+	w := httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/failing", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected default error response: %d %s", w.Code, w.Body.String())
+	}
+
+	r = newTestRouter(t, func(cfg *Config) {
+		cfg.ErrorHandler = router.JSONErrorHandler
+		cfg.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}, testEndpoint("GET", "/failing"), testEndpoint("GET", "/users"))
+
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/failing", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected error response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("unexpected not found response: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"endpoint":"GET"}` {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/ph0m1/porta/logging"
 	"github.com/ph0m1/porta/proxy"
 	"github.com/ph0m1/porta/router"
+	"github.com/ph0m1/porta/router/interceptor"
+	"github.com/ph0m1/porta/router/middleware"
 	"github.com/ph0m1/porta/router/mux"
 )
 
@@ -24,6 +26,12 @@ func DefaultConfig(pf proxy.Factory, logger logging.Logger) mux.Config {
 		ProxyFactory:   pf,
 		Logger:         logger,
 		HandlerFactory: mux.EndpointHandler,
+
+		EndpointMiddlewares: middleware.DefaultRegistry(),
+		Interceptors:        interceptor.DefaultRegistry(),
+		NewEngine: func() mux.Engine {
+			return gorillaEngine{gorilla.NewRouter()}
+		},
 	}
 }
 
@@ -48,3 +56,8 @@ func (g gorillaEngine) Handle(pattern string, handler http.Handler) {
 func (g gorillaEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	g.r.ServeHTTP(w, r)
 }
+
+// NotFound implements the mux.NotFoundEngine interface
+func (g gorillaEngine) NotFound(handler http.Handler) {
+	g.r.NotFoundHandler = handler
+}
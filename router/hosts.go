@@ -0,0 +1,35 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ph0m1/porta/proxy"
+)
+
+// HostsPath is the path of the admin endpoint listing the error rates of the balanced hosts
+const HostsPath = "/__hosts"
+
+// HostsHandler returns the stats of the hosts selected by the balancers of the backends. The backend
+// query param limits them to the backends with that url pattern
+func HostsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		stats := proxy.BackendHostStats()
+		if backend := r.URL.Query().Get("backend"); backend != "" {
+			filtered := stats[:0]
+			for _, s := range stats {
+				if s.Backend == backend {
+					filtered = append(filtered, s)
+				}
+			}
+			stats = filtered
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+}
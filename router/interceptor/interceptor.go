@@ -0,0 +1,182 @@
+// Package interceptor builds the interceptors declared in the extra config of the endpoints, letting
+// the routers customize the requests sent to the proxies and the responses they return without
+// replacing the endpoint handlers
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+// Namespace is the key of the endpoint extra config holding the ordered list of interceptors. Every
+// item must have a name and the rest of its keys are passed as settings to the factory
+const Namespace = "interceptors"
+
+// Interceptor customizes the traffic of an endpoint around its proxy
+type Interceptor interface {
+	// Before mutates the request built by the router before it reaches the proxy. An error aborts
+	// the request and it is rendered as any other proxy error
+	Before(ctx context.Context, req *proxy.Request) error
+	// After mutates the response returned by the proxy before it is rendered. It is not called if the
+	// proxy fails
+	After(ctx context.Context, req *proxy.Request, resp *proxy.Response) error
+}
+
+// Funcs adapts a pair of functions to the Interceptor interface. Any of them can be nil
+type Funcs struct {
+	BeforeFunc func(ctx context.Context, req *proxy.Request) error
+	AfterFunc  func(ctx context.Context, req *proxy.Request, resp *proxy.Response) error
+}
+
+// Before implements the Interceptor interface
+func (f Funcs) Before(ctx context.Context, req *proxy.Request) error {
+	if f.BeforeFunc == nil {
+		return nil
+	}
+	return f.BeforeFunc(ctx, req)
+}
+
+// After implements the Interceptor interface
+func (f Funcs) After(ctx context.Context, req *proxy.Request, resp *proxy.Response) error {
+	if f.AfterFunc == nil {
+		return nil
+	}
+	return f.AfterFunc(ctx, req, resp)
+}
+
+// Factory creates an interceptor for the endpoint from its settings
+type Factory func(cfg *config.EndpointConfig, settings map[string]interface{}) (Interceptor, error)
+
+// Registry maps the names used in the configuration to their factories
+type Registry map[string]Factory
+
+// DefaultRegistry returns a registry with the request_headers and response_data interceptors
+func DefaultRegistry() Registry {
+	return Registry{
+		"request_headers": RequestHeadersFactory,
+		"response_data":   ResponseDataFactory,
+	}
+}
+
+// Build returns the interceptors declared by the endpoint as a single one. The Before hooks run in the
+// declared order and the After ones in the reverse order. It returns nil if the endpoint does not
+// declare any interceptor
+func (r Registry) Build(cfg *config.EndpointConfig) (Interceptor, error) {
+	raw, ok := cfg.ExtraConfig[Namespace]
+	if !ok {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the %s of the endpoint [%s] must be a list", Namespace, cfg.Endpoint)
+	}
+
+	chain := make(chain, 0, len(items))
+	for _, item := range items {
+		settings, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid interceptor definition in the endpoint [%s]: %v", cfg.Endpoint, item)
+		}
+		name, _ := settings["name"].(string)
+		factory, ok := r[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown interceptor [%s] in the endpoint [%s]", name, cfg.Endpoint)
+		}
+		i, err := factory(cfg, settings)
+		if err != nil {
+			return nil, fmt.Errorf("building the interceptor [%s] of the endpoint [%s]: %s", name, cfg.Endpoint, err.Error())
+		}
+		chain = append(chain, i)
+	}
+	return chain, nil
+}
+
+// Proxy returns a proxy running the interceptor around the received one. The interceptor receives a
+// copy of the response, since the proxies may share the responses they cache
+func Proxy(i Interceptor, next proxy.Proxy) proxy.Proxy {
+	return func(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+		if err := i.Before(ctx, req); err != nil {
+			return nil, err
+		}
+		resp, err := next(ctx, req)
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		intercepted := *resp
+		if err := i.After(ctx, req, &intercepted); err != nil {
+			return nil, err
+		}
+		return &intercepted, nil
+	}
+}
+
+type chain []Interceptor
+
+func (c chain) Before(ctx context.Context, req *proxy.Request) error {
+	for _, i := range c {
+		if err := i.Before(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chain) After(ctx context.Context, req *proxy.Request, resp *proxy.Response) error {
+	for i := len(c) - 1; i >= 0; i-- {
+		if err := c[i].After(ctx, req, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequestHeadersFactory creates an interceptor setting the headers of the "headers" object in the
+// requests sent to the backends
+func RequestHeadersFactory(_ *config.EndpointConfig, settings map[string]interface{}) (Interceptor, error) {
+	raw, ok := settings["headers"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the headers must be an object")
+	}
+	headers := make(map[string]string, len(raw))
+	for k, v := range raw {
+		value, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("the value of the header %s must be a string", k)
+		}
+		headers[http.CanonicalHeaderKey(k)] = value
+	}
+	return Funcs{BeforeFunc: func(_ context.Context, req *proxy.Request) error {
+		if req.Headers == nil {
+			req.Headers = make(map[string][]string, len(headers))
+		}
+		for k, v := range headers {
+			req.Headers[k] = []string{v}
+		}
+		return nil
+	}}, nil
+}
+
+// ResponseDataFactory creates an interceptor adding the fields of the "data" object to the responses
+// of the endpoint, replacing the ones returned by the backends with the same name. The data of the
+// backends is copied, not modified
+func ResponseDataFactory(_ *config.EndpointConfig, settings map[string]interface{}) (Interceptor, error) {
+	data, ok := settings["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the data must be an object")
+	}
+	return Funcs{AfterFunc: func(_ context.Context, _ *proxy.Request, resp *proxy.Response) error {
+		merged := make(map[string]interface{}, len(resp.Data)+len(data))
+		for k, v := range resp.Data {
+			merged[k] = v
+		}
+		for k, v := range data {
+			merged[k] = v
+		}
+		resp.Data = merged
+		return nil
+	}}, nil
+}
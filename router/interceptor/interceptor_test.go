@@ -0,0 +1,112 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+func TestRegistry_Build(t *testing.T) {
+	var order []string
+	tag := func(name string) Factory {
+		return func(_ *config.EndpointConfig, _ map[string]interface{}) (Interceptor, error) {
+			return Funcs{
+				BeforeFunc: func(_ context.Context, _ *proxy.Request) error {
+					order = append(order, "before "+name)
+					return nil
+				},
+				AfterFunc: func(_ context.Context, _ *proxy.Request, _ *proxy.Response) error {
+					order = append(order, "after "+name)
+					return nil
+				},
+			}, nil
+		}
+	}
+	registry := Registry{"first": tag("first"), "second": tag("second")}
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		ExtraConfig: config.ExtraConfig{
+			Namespace: []interface{}{
+				map[string]interface{}{"name": "first"},
+				map[string]interface{}{"name": "second"},
+			},
+		},
+	}
+
+	i, err := registry.Build(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Proxy(i, func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		order = append(order, "proxy")
+		return &proxy.Response{}, nil
+	})
+	if _, err := p(context.Background(), &proxy.Request{}); err != nil {
+		t.Error(err)
+	}
+	if have := strings.Join(order, ","); have != "before first,before second,proxy,after second,after first" {
+		t.Errorf("unexpected order: %s", have)
+	}
+}
+
+func TestRegistry_Build_unknown(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint:    "/supu",
+		ExtraConfig: config.ExtraConfig{Namespace: []interface{}{map[string]interface{}{"name": "tupu"}}},
+	}
+	if _, err := DefaultRegistry().Build(cfg); err == nil {
+		t.Error("expecting an error")
+	}
+	if i, err := DefaultRegistry().Build(&config.EndpointConfig{}); i != nil || err != nil {
+		t.Errorf("unexpected result: %v, %v", i, err)
+	}
+}
+
+func TestProxy_beforeError(t *testing.T) {
+	expected := errors.New("rejected")
+	i := Funcs{BeforeFunc: func(_ context.Context, _ *proxy.Request) error { return expected }}
+	p := Proxy(i, func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		t.Error("the proxy should not be called")
+		return nil, nil
+	})
+	if _, err := p(context.Background(), &proxy.Request{}); err != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		ExtraConfig: config.ExtraConfig{
+			Namespace: []interface{}{
+				map[string]interface{}{"name": "request_headers", "headers": map[string]interface{}{"x-tenant": "acme"}},
+				map[string]interface{}{"name": "response_data", "data": map[string]interface{}{"version": "v2"}},
+			},
+		},
+	}
+	i, err := DefaultRegistry().Build(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := &proxy.Response{Data: map[string]interface{}{"id": 1}, IsComplete: true}
+	p := Proxy(i, func(_ context.Context, req *proxy.Request) (*proxy.Response, error) {
+		if v := req.Headers["X-Tenant"]; len(v) != 1 || v[0] != "acme" {
+			t.Errorf("unexpected headers: %v", req.Headers)
+		}
+		return cached, nil
+	})
+	resp, err := p(context.Background(), &proxy.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["id"] != 1 || resp.Data["version"] != "v2" || !resp.IsComplete {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if _, ok := cached.Data["version"]; ok {
+		t.Error("the response of the proxy was modified")
+	}
+}
@@ -0,0 +1,30 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ph0m1/porta/proxy"
+)
+
+// JournalPath is the path of the admin endpoint reconciling the journal of the write requests
+const JournalPath = "/__journal"
+
+// JournalHandler returns the reconciliation report of the journal: the count of the writes by outcome
+// and the failed and pending ones
+func JournalHandler(journal proxy.Journal) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := proxy.Reconcile(journal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+}
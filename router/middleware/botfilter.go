@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/monitoring/metrics"
+	"github.com/ph0m1/porta/security"
+)
+
+// BotChallengeCookie is the cookie proving that the client answered the challenge
+const BotChallengeCookie = "porta_bot_challenge"
+
+const challengePage = `<!DOCTYPE html><html><head><meta http-equiv="refresh" content="1"></head><body>Checking your browser...</body></html>`
+
+var botBlocked = metrics.NewCounter("porta_bot_blocked_requests_total", "Requests stopped by the bot filters, by reason: user_agent, missing_header or challenge", "endpoint", "reason")
+
+// botFilter holds the rules of the bot filtering middleware
+type botFilter struct {
+	endpoint string
+	allow    []*regexp.Regexp
+	deny     []*regexp.Regexp
+	required []string
+	secret   []byte
+	ttl      time.Duration
+}
+
+// BotFilterFactory creates a middleware keeping the scripted clients off the backends. The user agents
+// matching the allow_user_agents patterns always pass and the ones matching the deny_user_agents
+// patterns are rejected. The requests missing any of the required_headers are rejected too or, if the
+// challenge setting declares a secret, answered with a page setting a signed cookie and reloading
+// itself, so only the clients keeping the cookies reach the backends. The cookies last for the
+// challenge ttl, a duration string defaulting to 1h
+func BotFilterFactory(cfg *config.EndpointConfig, settings map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+	s := struct {
+		AllowUserAgents []string `json:"allow_user_agents"`
+		DenyUserAgents  []string `json:"deny_user_agents"`
+		RequiredHeaders []string `json:"required_headers"`
+		Challenge       *struct {
+			Secret string `json:"secret"`
+			TTL    string `json:"ttl"`
+		} `json:"challenge"`
+	}{}
+	if err := decode(settings, &s); err != nil {
+		return nil, err
+	}
+
+	f := &botFilter{endpoint: cfg.Endpoint, ttl: time.Hour}
+	var err error
+	if f.allow, err = compilePatterns(s.AllowUserAgents); err != nil {
+		return nil, err
+	}
+	if f.deny, err = compilePatterns(s.DenyUserAgents); err != nil {
+		return nil, err
+	}
+	for _, h := range s.RequiredHeaders {
+		f.required = append(f.required, http.CanonicalHeaderKey(h))
+	}
+	if c := s.Challenge; c != nil {
+		if c.Secret == "" {
+			return nil, fmt.Errorf("the bot challenge requires a secret")
+		}
+		f.secret = []byte(c.Secret)
+		if c.TTL != "" {
+			if f.ttl, err = time.ParseDuration(c.TTL); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.middleware, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user agent pattern [%s]: %s", p, err.Error())
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func (f *botFilter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua := r.UserAgent()
+		if matchAny(f.allow, ua) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if matchAny(f.deny, ua) {
+			botBlocked.Add(1, f.endpoint, "user_agent")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if f.complete(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f.secret == nil {
+			botBlocked.Add(1, f.endpoint, "missing_header")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if c, err := r.Cookie(BotChallengeCookie); err == nil && f.validToken(r, c.Value) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		botBlocked.Add(1, f.endpoint, "challenge")
+		http.SetCookie(w, &http.Cookie{
+			Name:     BotChallengeCookie,
+			Value:    f.token(r, time.Now().Add(f.ttl)),
+			Path:     "/",
+			MaxAge:   int(f.ttl.Seconds()),
+			HttpOnly: true,
+		})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(challengePage))
+	})
+}
+
+// complete reports if the request has all the required headers
+func (f *botFilter) complete(r *http.Request) bool {
+	for _, h := range f.required {
+		if r.Header.Get(h) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// token signs the expiration of the challenge for the address and the user agent of the client
+func (f *botFilter) token(r *http.Request, expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(security.IPKeyFunc(r) + "\n" + r.UserAgent() + "\n" + exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f *botFilter) validToken(r *http.Request, token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(f.token(r, time.Unix(exp, 0))))
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
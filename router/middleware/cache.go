@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/cdn"
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/proxy"
+)
+
+const maxCachedPages = 1000
+
+// pageCaches are the caches of the endpoints being served, registered until their group stops
+var pageCaches = struct {
+	sync.Mutex
+	caches map[*pageCache]struct{}
+}{caches: map[*pageCache]struct{}{}}
+
+// CacheFactory creates a middleware caching the successful GET responses of the endpoint in memory.
+// The ttl setting accepts a duration string and defaults to the cache TTL of the endpoint. The pages are
+// shared by all the clients, so the requests with credentials and the responses setting cookies or
+// marked as private are never cached, and the pages are kept per value of the headers in their Vary
+// header and of the selector header of the response variants of the endpoint
+func CacheFactory(cfg *config.EndpointConfig, settings map[string]interface{}, g *lifecycle.Group) (Middleware, error) {
+	ttl := cfg.CacheTTL
+	if raw, ok := settings["ttl"].(string); ok {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, err
+		}
+		ttl = d
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("the cache requires a positive ttl")
+	}
+
+	c := &pageCache{cfg: cfg, ttl: ttl, pages: map[string]page{}, vary: map[string][]string{}}
+	if rv := cfg.ResponseVariants; rv != nil && rv.Header != "" {
+		c.variants = http.CanonicalHeaderKey(rv.Header)
+	}
+
+	pageCaches.Lock()
+	pageCaches.caches[c] = struct{}{}
+	pageCaches.Unlock()
+	if g != nil {
+		g.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			pageCaches.Lock()
+			delete(pageCaches.caches, c)
+			pageCaches.Unlock()
+		})
+	}
+	return c.middleware, nil
+}
+
+// CachePurger returns the purger of the pages kept by the cache middlewares of the served endpoints.
+// The pages are purged by their path, with or without the query string, and by the surrogate keys of
+// their edge cache settings
+func CachePurger() cdn.Purger {
+	return cachePurger{}
+}
+
+type cachePurger struct{}
+
+func (cachePurger) Purge(_ context.Context, r cdn.Request) error {
+	pageCaches.Lock()
+	defer pageCaches.Unlock()
+	for c := range pageCaches.caches {
+		c.purge(r)
+	}
+	return nil
+}
+
+type pageCache struct {
+	cfg *config.EndpointConfig
+	ttl time.Duration
+	// selector header of the response variants of the endpoint, if any
+	variants string
+	mu       sync.RWMutex
+	pages    map[string]page
+	// names of the headers in the Vary header of the last response of every URI
+	vary map[string][]string
+}
+
+type page struct {
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+func (c *pageCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || hasCredentials(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		uri := r.URL.RequestURI()
+
+		c.mu.RLock()
+		p, ok := c.pages[c.key(uri, c.vary[uri], r)]
+		c.mu.RUnlock()
+		if ok && time.Now().Before(p.expiresAt) && !bypassed(c.cfg, r) {
+			for k, v := range p.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(p.status)
+			w.Write(p.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK || !shareable(w.Header()) {
+			return
+		}
+		vary := varyHeaders(w.Header())
+		key := c.key(uri, vary, r)
+
+		c.mu.Lock()
+		c.vary[uri] = vary
+		if len(c.pages) >= maxCachedPages {
+			now := time.Now()
+			for k, p := range c.pages {
+				if !now.Before(p.expiresAt) {
+					delete(c.pages, k)
+				}
+			}
+		}
+		if _, ok := c.pages[key]; ok || len(c.pages) < maxCachedPages {
+			c.pages[key] = page{
+				status:    rec.status,
+				header:    w.Header().Clone(),
+				body:      rec.body.Bytes(),
+				expiresAt: time.Now().Add(c.ttl),
+			}
+		}
+		c.mu.Unlock()
+	})
+}
+
+// purge removes the pages of the paths and of the surrogate keys of the request
+func (c *pageCache) purge(r cdn.Request) {
+	paths := make(map[string]bool, len(r.Paths))
+	for _, p := range r.Paths {
+		paths[p] = true
+	}
+	keys := make(map[string]bool, len(r.Keys))
+	for _, k := range r.Keys {
+		keys[k] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, p := range c.pages {
+		uri, _, _ := strings.Cut(key, "\n")
+		path, _, _ := strings.Cut(uri, "?")
+		purged := paths[uri] || paths[path]
+		for _, k := range strings.Fields(p.header.Get("Surrogate-Key")) {
+			purged = purged || keys[k]
+		}
+		if purged {
+			delete(c.pages, key)
+		}
+	}
+}
+
+// key returns the key of the page of the URI for the values of the headers it varies on
+func (c *pageCache) key(uri string, vary []string, r *http.Request) string {
+	if c.variants != "" {
+		vary = append([]string{c.variants}, vary...)
+	}
+	if len(vary) == 0 {
+		return uri
+	}
+	var b strings.Builder
+	b.WriteString(uri)
+	for _, h := range vary {
+		b.WriteString("\n" + h + ":" + strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// hasCredentials reports if the request carries the credentials of a client
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// shareable reports if a response can be served to other clients: it sets no cookies, it is not
+// private and it does not vary on every header
+func shareable(h http.Header) bool {
+	if len(h.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(strings.SplitN(directive, "=", 2)[0])) {
+			case "private", "no-store":
+				return false
+			}
+		}
+	}
+	for _, v := range varyHeaders(h) {
+		if v == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// varyHeaders returns the canonical names of the headers in the Vary header of the response, sorted
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bypassed reports if the request comes from a trusted client asking to skip the caches. The fresh
+// response still replaces the cached one
+func bypassed(cfg *config.EndpointConfig, r *http.Request) bool {
+	_, bypass := proxy.RequestOverrides(r.Context(), cfg, r.Header)
+	return bypass
+}
+
+// recorder copies the response sent to the client
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,201 @@
+// Package middleware builds the handler middlewares declared in the extra config of the endpoints
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/security"
+)
+
+// Namespace is the key of the endpoint extra config holding the ordered list of middlewares. Every
+// item must have a name and the rest of its keys are passed as settings to the factory
+const Namespace = "middlewares"
+
+// Middleware decorates the handler of an endpoint
+type Middleware func(http.Handler) http.Handler
+
+// Factory creates a middleware for the endpoint from its settings. The background work of the
+// middleware must end when the group is stopped. A nil group binds it to the process
+type Factory func(cfg *config.EndpointConfig, settings map[string]interface{}, g *lifecycle.Group) (Middleware, error)
+
+// Registry maps the names used in the configuration to their factories
+type Registry map[string]Factory
+
+// DefaultRegistry returns a registry with the ratelimit, auth, oidc, signature, mtls, tenancy, cache,
+// shaping and botfilter middlewares
+func DefaultRegistry() Registry {
+	return Registry{
+		"ratelimit": RateLimitFactory,
+		"auth":      AuthFactory,
+		"oidc":      OIDCFactory,
+		"signature": SignatureFactory,
+		"mtls":      MTLSFactory,
+		"tenancy":   TenancyFactory,
+		"cache":     CacheFactory,
+		"shaping":   ShapingFactory,
+		"botfilter": BotFilterFactory,
+	}
+}
+
+// Build returns the middlewares declared by the endpoint, chained in the declared order, so the first
+// one is the outermost. It returns nil if the endpoint does not declare any middleware. Their background
+// work is bound to the group
+func (r Registry) Build(cfg *config.EndpointConfig, g *lifecycle.Group) (Middleware, error) {
+	raw, ok := cfg.ExtraConfig[Namespace]
+	if !ok {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the %s of the endpoint [%s] must be a list", Namespace, cfg.Endpoint)
+	}
+
+	mws := make([]Middleware, 0, len(items))
+	for _, item := range items {
+		settings, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid middleware definition in the endpoint [%s]: %v", cfg.Endpoint, item)
+		}
+		name, _ := settings["name"].(string)
+		factory, ok := r[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware [%s] in the endpoint [%s]", name, cfg.Endpoint)
+		}
+		mw, err := factory(cfg, settings, g)
+		if err != nil {
+			return nil, fmt.Errorf("building the middleware [%s] of the endpoint [%s]: %s", name, cfg.Endpoint, err.Error())
+		}
+		mws = append(mws, mw)
+	}
+
+	return func(h http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}, nil
+}
+
+// RateLimitFactory creates a token bucket rate limiter keyed by the client IP. The settings follow the
+// security.RateLimitConfig. The cleanup of the limiter stops with the group
+func RateLimitFactory(_ *config.EndpointConfig, settings map[string]interface{}, g *lifecycle.Group) (Middleware, error) {
+	cfg := security.RateLimitConfig{}
+	if err := decode(settings, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.RequestsPerSecond <= 0 {
+		return nil, fmt.Errorf("requests_per_second must be positive")
+	}
+	if cfg.BurstSize == 0 {
+		cfg.BurstSize = cfg.RequestsPerSecond
+	}
+	if cfg.WindowSize == 0 {
+		cfg.WindowSize = time.Second
+	}
+	if cfg.CleanupInterval == 0 {
+		cfg.CleanupInterval = time.Minute
+	}
+	limiter := security.NewTokenBucketLimiter(&cfg)
+	if g != nil {
+		g.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			limiter.Stop()
+		})
+	}
+	return security.NewRateLimitMiddleware(limiter, security.IPKeyFunc).HTTPMiddleware, nil
+}
+
+// AuthFactory creates an authentication middleware. The settings follow the security.AuthConfig, so
+// the JWTs are validated with the keys of the jwks_url and the API keys with the ones of the
+// api_keys_file if declared
+func AuthFactory(_ *config.EndpointConfig, settings map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+	cfg := security.AuthConfig{}
+	if err := decode(settings, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.APIKeysFile != "" {
+		keys, err := security.NewFileKeyStore(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.KeyStore = keys
+	}
+	return security.NewAuthMiddleware(&cfg).HTTPMiddleware, nil
+}
+
+// OIDCFactory creates a middleware validating the tokens of an OpenID provider. The settings follow
+// the security.OIDCConfig
+func OIDCFactory(_ *config.EndpointConfig, settings map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+	cfg := security.OIDCConfig{}
+	if err := decode(settings, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("the oidc middleware requires an issuer")
+	}
+	return security.NewOIDC(&cfg).HTTPMiddleware, nil
+}
+
+// SignatureFactory creates a middleware validating the HMAC signatures of the requests. The settings
+// hold the secrets of the clients, keyed by their id, and the address of the redis keeping the nonces
+// in nonce_redis. The nonces are kept in memory if the address is empty
+func SignatureFactory(_ *config.EndpointConfig, settings map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+	cfg := struct {
+		Secrets    map[string]string `json:"secrets"`
+		NonceRedis string            `json:"nonce_redis"`
+	}{}
+	if err := decode(settings, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Secrets) == 0 {
+		return nil, fmt.Errorf("the signature middleware requires the secrets of the clients")
+	}
+	if cfg.NonceRedis == "" {
+		return security.NewSignatureAuth(cfg.Secrets).HTTPMiddleware, nil
+	}
+	pool := &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", cfg.NonceRedis)
+		},
+	}
+	return security.NewSharedSignatureAuth(cfg.Secrets, security.NewRedisNonceStore(pool)).HTTPMiddleware, nil
+}
+
+// MTLSFactory creates a middleware authenticating the clients by their certificates. The settings follow
+// the security.MTLSConfig
+func MTLSFactory(_ *config.EndpointConfig, settings map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+	cfg := security.MTLSConfig{}
+	if err := decode(settings, &cfg); err != nil {
+		return nil, err
+	}
+	auth, err := security.NewMTLSAuth(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return auth.HTTPMiddleware, nil
+}
+
+// TenancyFactory creates a middleware resolving the tenant of the requests and applying its limits. The
+// settings follow the security.TenancyConfig. It must be declared after the auth middlewares
+func TenancyFactory(_ *config.EndpointConfig, settings map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+	cfg := security.TenancyConfig{}
+	if err := decode(settings, &cfg); err != nil {
+		return nil, err
+	}
+	return security.NewTenancyMiddleware(&cfg).HTTPMiddleware, nil
+}
+
+// decode copies the settings into the struct through its json tags. The durations follow the rules of
+// the config files, so "1m" is a minute and a bare 30 is 30ms
+func decode(settings map[string]interface{}, v interface{}) error {
+	return config.DecodeWithTag(settings, v, "json")
+}
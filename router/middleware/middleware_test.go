@@ -0,0 +1,393 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/ph0m1/porta/cdn"
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+	"github.com/ph0m1/porta/security"
+)
+
+func TestRegistry_Build(t *testing.T) {
+	var order []string
+	tag := func(name string) Factory {
+		return func(_ *config.EndpointConfig, _ map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					order = append(order, name)
+					next.ServeHTTP(w, r)
+				})
+			}, nil
+		}
+	}
+	registry := Registry{"first": tag("first"), "second": tag("second")}
+	cfg := &config.EndpointConfig{
+		Endpoint: "/supu",
+		ExtraConfig: config.ExtraConfig{
+			Namespace: []interface{}{
+				map[string]interface{}{"name": "first"},
+				map[string]interface{}{"name": "second"},
+			},
+		},
+	}
+
+	mw, err := registry.Build(cfg, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	mw(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		order = append(order, "handler")
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/supu", nil))
+
+	if strings.Join(order, ",") != "first,second,handler" {
+		t.Errorf("unexpected order: %v", order)
+	}
+}
+
+func TestRegistry_Build_unknown(t *testing.T) {
+	cfg := &config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{Namespace: []interface{}{map[string]interface{}{"name": "supu"}}},
+	}
+	if _, err := DefaultRegistry().Build(cfg, nil); err == nil {
+		t.Error("error expected")
+	}
+	if mw, err := DefaultRegistry().Build(&config.EndpointConfig{}, nil); mw != nil || err != nil {
+		t.Errorf("unexpected result: %v", err)
+	}
+}
+
+func TestRateLimitFactory_durations(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	g := lifecycle.NewGroup(context.Background())
+	defer g.Stop(context.Background())
+	for _, settings := range []map[string]interface{}{
+		{"requests_per_second": 10, "window_size": "1m", "cleanup_interval": 30},
+		{"requests_per_second": 10.0, "window_size": 60000.0, "cleanup_interval": "30ms"},
+	} {
+		cfg := security.RateLimitConfig{}
+		if err := decode(settings, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.RequestsPerSecond != 10 || cfg.WindowSize != time.Minute || cfg.CleanupInterval != 30*time.Millisecond {
+			t.Errorf("unexpected config: %+v", cfg)
+		}
+		if _, err := RateLimitFactory(&config.EndpointConfig{}, settings, g); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := RateLimitFactory(&config.EndpointConfig{}, map[string]interface{}{"requests_per_second": 10, "window_size": "a minute"}, g); err == nil {
+		t.Error("expecting an error for the malformed duration")
+	}
+}
+
+func TestAuthFactory_durations(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "supu",
+		"exp":     time.Now().Add(-10 * time.Second).Unix(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		skew   interface{}
+		status int
+	}{
+		{"30s", http.StatusOK},
+		{30000, http.StatusOK},
+		{"1s", http.StatusUnauthorized},
+		{1000, http.StatusUnauthorized},
+	} {
+		settings := map[string]interface{}{
+			"jwt_secret":            "secret",
+			"clock_skew":            tc.skew,
+			"jwks_refresh_interval": "5m",
+		}
+		cfg := security.AuthConfig{}
+		if err := decode(settings, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.JWKSRefreshInterval != 5*time.Minute {
+			t.Errorf("unexpected jwks refresh interval: %s", cfg.JWKSRefreshInterval)
+		}
+
+		mw, err := AuthFactory(&config.EndpointConfig{}, settings, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/supu", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})).ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("clock skew %v: unexpected status %d", tc.skew, w.Code)
+		}
+	}
+	if _, err := AuthFactory(&config.EndpointConfig{}, map[string]interface{}{"clock_skew": "half a minute"}, nil); err == nil {
+		t.Error("expecting an error for the malformed clock skew")
+	}
+}
+
+func TestCacheFactory(t *testing.T) {
+	mw, err := CacheFactory(&config.EndpointConfig{}, map[string]interface{}{"ttl": "1m"}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	calls := 0
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"supu":42}`))
+	}))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/supu?a=1", nil))
+		if w.Body.String() != `{"supu":42}` || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected response: %s %v", w.Body.String(), w.Header())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestCacheFactory_private(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		header  http.Header
+		request http.Header
+	}{
+		{"authorization", nil, http.Header{"Authorization": {"Bearer supu"}}},
+		{"cookie", nil, http.Header{"Cookie": {"session=supu"}}},
+		{"set-cookie", http.Header{"Set-Cookie": {"session=supu"}}, nil},
+		{"private", http.Header{"Cache-Control": {"max-age=60, private"}}, nil},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, nil},
+		{"vary all", http.Header{"Vary": {"*"}}, nil},
+	} {
+		mw, _ := CacheFactory(&config.EndpointConfig{}, map[string]interface{}{"ttl": "1m"}, nil)
+		calls := 0
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls++
+			for k, v := range tc.header {
+				w.Header()[k] = v
+			}
+			w.Write([]byte(`{}`))
+		}))
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest("GET", "/supu", nil)
+			for k, v := range tc.request {
+				r.Header[k] = v
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}
+		if calls != 2 {
+			t.Errorf("%s: the page was cached", tc.name)
+		}
+	}
+}
+
+func TestCacheFactory_vary(t *testing.T) {
+	cfg := &config.EndpointConfig{ResponseVariants: &config.ResponseVariants{Header: "x-view"}}
+	mw, _ := CacheFactory(cfg, map[string]interface{}{"ttl": "1m"}, nil)
+	calls := 0
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language") + "/" + r.Header.Get("X-View")))
+	}))
+
+	for i := 0; i < 2; i++ {
+		for _, want := range []string{"es/", "en/", "es/full", "en/full"} {
+			parts := strings.Split(want, "/")
+			r := httptest.NewRequest("GET", "/supu", nil)
+			r.Header.Set("Accept-Language", parts[0])
+			if parts[1] != "" {
+				r.Header.Set("X-View", parts[1])
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Body.String() != want {
+				t.Errorf("want %s, have %s", want, w.Body.String())
+			}
+		}
+	}
+	if calls != 4 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestCachePurger(t *testing.T) {
+	g := lifecycle.NewGroup(context.Background())
+	mw, err := CacheFactory(&config.EndpointConfig{}, map[string]interface{}{"ttl": "1m"}, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := map[string]int{}
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.RequestURI()]++
+		w.Header().Set("Surrogate-Key", "users "+strings.TrimPrefix(r.URL.Path, "/"))
+		w.Write([]byte(`{}`))
+	}))
+	get := func(uris ...string) {
+		for _, uri := range uris {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", uri, nil))
+		}
+	}
+
+	get("/supu", "/supu?a=1", "/tupu")
+	for _, tc := range []struct {
+		request cdn.Request
+		want    map[string]int
+	}{
+		{cdn.Request{Paths: []string{"/supu"}}, map[string]int{"/supu": 2, "/supu?a=1": 2, "/tupu": 1}},
+		{cdn.Request{Paths: []string{"/supu?a=1"}}, map[string]int{"/supu": 2, "/supu?a=1": 3, "/tupu": 1}},
+		{cdn.Request{Keys: []string{"tupu"}}, map[string]int{"/supu": 2, "/supu?a=1": 3, "/tupu": 2}},
+		{cdn.Request{Keys: []string{"users"}}, map[string]int{"/supu": 3, "/supu?a=1": 4, "/tupu": 3}},
+	} {
+		if err := CachePurger().Purge(context.Background(), tc.request); err != nil {
+			t.Error(err)
+		}
+		get("/supu", "/supu?a=1", "/tupu")
+		for uri, want := range tc.want {
+			if calls[uri] != want {
+				t.Errorf("%+v: want %d calls to %s, have %d", tc.request, want, uri, calls[uri])
+			}
+		}
+	}
+
+	pageCaches.Lock()
+	registered := len(pageCaches.caches)
+	pageCaches.Unlock()
+	if err := g.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+	pageCaches.Lock()
+	defer pageCaches.Unlock()
+	if len(pageCaches.caches) != registered-1 {
+		t.Error("the cache was not unregistered when its group stopped")
+	}
+}
+
+func TestShapingFactory(t *testing.T) {
+	if _, err := ShapingFactory(&config.EndpointConfig{Endpoint: "/supu"}, map[string]interface{}{"plan_header": "X-Plan"}, nil); err == nil {
+		t.Error("expecting an error with a plan header")
+	}
+	mw, err := ShapingFactory(&config.EndpointConfig{Endpoint: "/supu"}, map[string]interface{}{
+		"plans": map[string]interface{}{
+			"free": map[string]interface{}{"delay": "50ms", "bandwidth": 100},
+		},
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 20)))
+	}))
+
+	for _, tc := range []struct {
+		name    string
+		authCtx *security.AuthContext
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"free tier", &security.AuthContext{Tier: "free"}, 200 * time.Millisecond, time.Second},
+		{"free claim", &security.AuthContext{Claims: map[string]interface{}{"plan": "free"}}, 200 * time.Millisecond, time.Second},
+		{"gold", &security.AuthContext{Tier: "gold"}, 0, 50 * time.Millisecond},
+		{"anonymous", nil, 0, 50 * time.Millisecond},
+	} {
+		req := httptest.NewRequest("GET", "/supu", nil)
+		// the clients can not pick their plan
+		req.Header.Set("X-Plan", "gold")
+		if tc.authCtx != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "auth", tc.authCtx))
+		}
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(w, req)
+		elapsed := time.Since(start)
+		if elapsed < tc.min || elapsed > tc.max {
+			t.Errorf("%s: unexpected duration %s", tc.name, elapsed)
+		}
+		if w.Body.Len() != 20 {
+			t.Errorf("%s: unexpected body %s", tc.name, w.Body.String())
+		}
+	}
+}
+
+func TestBotFilterFactory(t *testing.T) {
+	mw, err := BotFilterFactory(&config.EndpointConfig{Endpoint: "/supu"}, map[string]interface{}{
+		"allow_user_agents": []interface{}{"googlebot"},
+		"deny_user_agents":  []interface{}{"^curl/"},
+		"required_headers":  []interface{}{"accept-language"},
+		"challenge":         map[string]interface{}{"secret": "s3cr3t"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	send := func(ua, lang string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/supu", nil)
+		req.Header.Set("User-Agent", ua)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("Mozilla/5.0 (compatible; Googlebot/2.1)", "", nil); w.Body.String() != "ok" {
+		t.Errorf("the allowed user agents must pass: %d", w.Code)
+	}
+	if w := send("curl/8.0", "en", nil); w.Code != http.StatusForbidden {
+		t.Errorf("the denied user agents must be rejected: %d", w.Code)
+	}
+	if w := send("Mozilla/5.0", "en", nil); w.Body.String() != "ok" {
+		t.Errorf("the complete requests must pass: %d", w.Code)
+	}
+
+	w := send("Mozilla/5.0", "", nil)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusForbidden || len(cookies) != 1 || cookies[0].Name != BotChallengeCookie {
+		t.Fatalf("expecting a challenge: %d %v", w.Code, cookies)
+	}
+	if w := send("Mozilla/5.0", "", cookies[0]); w.Body.String() != "ok" {
+		t.Errorf("the clients answering the challenge must pass: %d", w.Code)
+	}
+	if w := send("python-requests/2.31", "", cookies[0]); w.Code != http.StatusForbidden {
+		t.Errorf("the cookie must be bound to the user agent: %d", w.Code)
+	}
+}
+
+func TestBotFilterFactory_noChallenge(t *testing.T) {
+	mw, err := BotFilterFactory(&config.EndpointConfig{}, map[string]interface{}{"required_headers": []interface{}{"User-Agent"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mw(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/supu", nil))
+	if w.Code != http.StatusForbidden || len(w.Result().Cookies()) != 0 {
+		t.Errorf("the incomplete requests must be rejected: %d", w.Code)
+	}
+	if _, err := BotFilterFactory(&config.EndpointConfig{}, map[string]interface{}{"deny_user_agents": []interface{}{"("}}, nil); err == nil {
+		t.Error("expecting an error for the invalid pattern")
+	}
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/monitoring/metrics"
+	"github.com/ph0m1/porta/security"
+)
+
+var (
+	shapedRequests = metrics.NewCounter("porta_shaped_requests_total", "Total number of requests delayed or throttled by the shaping policies", "endpoint", "plan")
+	shapingDelay   = metrics.NewCounter("porta_shaping_delay_seconds_total", "Total time added to the responses by the shaping policies", "endpoint", "plan")
+)
+
+// shapingRule is the delay and the bandwidth applied to a plan
+type shapingRule struct {
+	delay time.Duration
+	// max bytes per second of the response body. Unlimited if zero
+	bandwidth int
+}
+
+// defaultShapingRule is the metrics label of the requests shaped by the default rule
+const defaultShapingRule = "default"
+
+// ShapingFactory creates a middleware delaying and throttling the responses depending on the plan of
+// the client. The plan comes from the auth context, so the middleware must be declared after the auth
+// ones: the tier of the API key or, if empty, the claim named by the plan_claim setting (plan if
+// empty). The plans setting maps every plan to its delay (a duration string) and bandwidth (bytes per
+// second). The plans without rule, including the anonymous requests, are not shaped, unless a default
+// rule is defined
+func ShapingFactory(cfg *config.EndpointConfig, settings map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+	if _, ok := settings["plan_header"]; ok {
+		return nil, fmt.Errorf("the plan_header is not supported, since the clients can set it: the plan comes from the auth context")
+	}
+	planClaim, _ := settings["plan_claim"].(string)
+	if planClaim == "" {
+		planClaim = "plan"
+	}
+	plans, _ := settings["plans"].(map[string]interface{})
+	rules := make(map[string]shapingRule, len(plans))
+	for plan, raw := range plans {
+		rule, err := parseShapingRule(raw)
+		if err != nil {
+			return nil, fmt.Errorf("plan [%s]: %s", plan, err.Error())
+		}
+		rules[plan] = rule
+	}
+	var fallback *shapingRule
+	if raw, ok := settings["default"]; ok {
+		rule, err := parseShapingRule(raw)
+		if err != nil {
+			return nil, fmt.Errorf("default plan: %s", err.Error())
+		}
+		fallback = &rule
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the label is the name of the matched rule, so its values are bounded by the config
+			plan := shapingPlan(r, planClaim)
+			rule, ok := rules[plan]
+			if !ok && fallback != nil {
+				rule, ok, plan = *fallback, true, defaultShapingRule
+			}
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			shapedRequests.Add(1, cfg.Endpoint, plan)
+			if rule.delay > 0 {
+				select {
+				case <-time.After(rule.delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			added := rule.delay
+			if rule.bandwidth > 0 {
+				tw := &throttledWriter{ResponseWriter: w, r: r, bandwidth: rule.bandwidth}
+				next.ServeHTTP(tw, r)
+				added += tw.waited
+			} else {
+				next.ServeHTTP(w, r)
+			}
+			shapingDelay.Add(added.Seconds(), cfg.Endpoint, plan)
+		})
+	}, nil
+}
+
+// shapingPlan returns the plan of the authenticated client of the request, if any
+func shapingPlan(r *http.Request, claim string) string {
+	authCtx, ok := security.GetAuthContext(r)
+	if !ok {
+		return ""
+	}
+	if authCtx.Tier != "" {
+		return authCtx.Tier
+	}
+	plan, _ := authCtx.Claims[claim].(string)
+	return plan
+}
+
+func parseShapingRule(raw interface{}) (shapingRule, error) {
+	settings, ok := raw.(map[string]interface{})
+	if !ok {
+		return shapingRule{}, fmt.Errorf("invalid rule: %v", raw)
+	}
+	rule := shapingRule{}
+	if d, ok := settings["delay"].(string); ok {
+		delay, err := time.ParseDuration(d)
+		if err != nil {
+			return rule, err
+		}
+		rule.delay = delay
+	}
+	switch b := settings["bandwidth"].(type) {
+	case int:
+		rule.bandwidth = b
+	case int64:
+		rule.bandwidth = int(b)
+	case float64:
+		rule.bandwidth = int(b)
+	}
+	return rule, nil
+}
+
+// throttledWriter writes the body in slices of a tenth of the bandwidth, pausing between them
+type throttledWriter struct {
+	http.ResponseWriter
+	r         *http.Request
+	bandwidth int
+	waited    time.Duration
+}
+
+func (t *throttledWriter) Write(b []byte) (int, error) {
+	chunk := t.bandwidth / 10
+	if chunk == 0 {
+		chunk = 1
+	}
+	written := 0
+	for len(b) > 0 {
+		n := chunk
+		if n > len(b) {
+			n = len(b)
+		}
+		m, err := t.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+		if f, ok := t.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		pause := time.Duration(n) * time.Second / time.Duration(t.bandwidth)
+		select {
+		case <-time.After(pause):
+			t.waited += pause
+		case <-t.r.Context().Done():
+			return written, t.r.Context().Err()
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/monitoring"
+)
+
+// StatsFactory returns a factory of middlewares recording the requests of the endpoints in the stats,
+// keyed by their method and path. The responses with a 5xx status count as errors
+func StatsFactory(stats *monitoring.Stats) Factory {
+	return func(cfg *config.EndpointConfig, _ map[string]interface{}, _ *lifecycle.Group) (Middleware, error) {
+		name := cfg.Method + " " + cfg.Endpoint
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start := time.Now()
+				sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(sw, r)
+				stats.Record(name, time.Since(start), sw.status >= http.StatusInternalServerError)
+			})
+		}, nil
+	}
+}
+
+// statusWriter keeps the status code sent to the client
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
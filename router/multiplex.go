@@ -0,0 +1,371 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/daemon"
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+var (
+	multiplexedConnections = metrics.NewCounter("porta_multiplex_connections_total", "Connections accepted by the multiplexed port, by protocol", "protocol")
+	multiplexedActive      = metrics.NewGauge("porta_multiplex_active_connections", "Open connections of the multiplexed port, by protocol", "protocol")
+)
+
+// maxSniffedFrames bounds the HTTP/2 frames read looking for the headers of the first request
+const maxSniffedFrames = 10
+
+// Matcher tells if a connection belongs to a protocol by its first bytes. The bytes read by the
+// matchers are replayed to the server of the protocol
+type Matcher func(r io.Reader) bool
+
+// AnyMatcher matches every connection
+func AnyMatcher() Matcher {
+	return func(io.Reader) bool { return true }
+}
+
+// HTTP1PathMatcher matches the HTTP/1 connections whose first request has a path with the prefix
+func HTTP1PathMatcher(prefix string) Matcher {
+	return func(r io.Reader) bool {
+		line, err := bufio.NewReader(r).ReadSlice('\n')
+		if err != nil {
+			return false
+		}
+		fields := strings.Fields(string(line))
+		return len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/1.") && strings.HasPrefix(fields[1], prefix)
+	}
+}
+
+// GRPCMatcher matches the HTTP/2 connections with prior knowledge whose first request has a gRPC
+// content type. The clients must send it without waiting for the settings of the server, as the gRPC
+// clients do
+func GRPCMatcher() Matcher {
+	return func(r io.Reader) bool {
+		preface := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(r, preface); err != nil || string(preface) != http2.ClientPreface {
+			return false
+		}
+		matched := false
+		decoder := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+			if f.Name == "content-type" && strings.HasPrefix(f.Value, "application/grpc") {
+				matched = true
+			}
+		})
+		framer := http2.NewFramer(io.Discard, r)
+		for i := 0; i < maxSniffedFrames; i++ {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return false
+			}
+			switch f := frame.(type) {
+			case *http2.HeadersFrame:
+				if _, err := decoder.Write(f.HeaderBlockFragment()); err != nil || f.HeadersEnded() {
+					return matched
+				}
+			case *http2.ContinuationFrame:
+				if _, err := decoder.Write(f.HeaderBlockFragment()); err != nil || f.HeadersEnded() {
+					return matched
+				}
+			}
+		}
+		return false
+	}
+}
+
+// Multiplexer splits the connections of a listener between several protocols, giving every connection
+// to the first protocol matching its first bytes. The connections matching no protocol are closed
+type Multiplexer struct {
+	root         net.Listener
+	sniffTimeout time.Duration
+	protocols    []*protocolListener
+}
+
+// NewMultiplexer creates a multiplexer of the listener. The connections not sending enough bytes to
+// be matched during the sniff timeout are given to the protocols matching without them
+func NewMultiplexer(l net.Listener, sniffTimeout time.Duration) *Multiplexer {
+	return &Multiplexer{root: l, sniffTimeout: sniffTimeout}
+}
+
+// Match returns the listener of the connections of the protocol. The matchers are tried in the order of
+// registration
+func (m *Multiplexer) Match(protocol string, matcher Matcher) net.Listener {
+	l := &protocolListener{
+		protocol: protocol,
+		matcher:  matcher,
+		addr:     m.root.Addr(),
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	m.protocols = append(m.protocols, l)
+	return l
+}
+
+// Serve accepts the connections until the listener is closed, closing then the listeners of the
+// protocols
+func (m *Multiplexer) Serve() error {
+	defer func() {
+		for _, p := range m.protocols {
+			p.Close()
+		}
+	}()
+	for {
+		c, err := m.root.Accept()
+		if err != nil {
+			return err
+		}
+		go m.dispatch(c)
+	}
+}
+
+// Close closes the listener
+func (m *Multiplexer) Close() error {
+	return m.root.Close()
+}
+
+func (m *Multiplexer) dispatch(c net.Conn) {
+	if m.sniffTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(m.sniffTimeout))
+	}
+	sniffed := &bytes.Buffer{}
+	for _, p := range m.protocols {
+		if !p.matcher(io.MultiReader(bytes.NewReader(sniffed.Bytes()), io.TeeReader(c, sniffed))) {
+			continue
+		}
+		c.SetReadDeadline(time.Time{})
+		p.deliver(&sniffedConn{Conn: c, r: io.MultiReader(bytes.NewReader(sniffed.Bytes()), c), protocol: p.protocol})
+		return
+	}
+	c.Close()
+}
+
+// protocolListener is the listener of the connections of a protocol. Closing it does not close the
+// listener of the multiplexer, so every protocol can be shut down on its own
+type protocolListener struct {
+	protocol string
+	matcher  Matcher
+	addr     net.Addr
+	conns    chan net.Conn
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (l *protocolListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *protocolListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *protocolListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *protocolListener) deliver(c *sniffedConn) {
+	select {
+	case <-l.done:
+		c.Conn.Close()
+		return
+	default:
+	}
+	multiplexedConnections.Add(1, l.protocol)
+	multiplexedActive.Add(1, l.protocol)
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// sniffedConn replays the bytes read by the matchers before the rest of the connection
+type sniffedConn struct {
+	net.Conn
+	r        io.Reader
+	protocol string
+	once     sync.Once
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *sniffedConn) Close() error {
+	c.once.Do(func() { multiplexedActive.Add(-1, c.protocol) })
+	return c.Conn.Close()
+}
+
+// tunnel forwards the connections of a listener to a remote address
+type tunnel struct {
+	address  string
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+func (t *tunnel) Serve(l net.Listener) error {
+	t.listener = l
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		t.wg.Add(1)
+		go t.forward(c)
+	}
+}
+
+func (t *tunnel) forward(c net.Conn) {
+	defer t.wg.Done()
+	remote, err := net.Dial("tcp", t.address)
+	if err != nil {
+		c.Close()
+		return
+	}
+	t.track(c, remote)
+	done := make(chan struct{})
+	go func() {
+		io.Copy(remote, c)
+		remote.Close()
+		close(done)
+	}()
+	io.Copy(c, remote)
+	c.Close()
+	<-done
+	t.untrack(c, remote)
+}
+
+func (t *tunnel) track(conns ...net.Conn) {
+	t.mu.Lock()
+	for _, c := range conns {
+		t.conns[c] = struct{}{}
+	}
+	t.mu.Unlock()
+}
+
+func (t *tunnel) untrack(conns ...net.Conn) {
+	t.mu.Lock()
+	for _, c := range conns {
+		delete(t.conns, c)
+	}
+	t.mu.Unlock()
+}
+
+// Shutdown stops accepting connections and waits for the forwarded ones to end, closing them once the
+// context is done
+func (t *tunnel) Shutdown(ctx context.Context) error {
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	for c := range t.conns {
+		c.Close()
+	}
+	t.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// runMultiplexed serves the HTTP endpoints, the gRPC server and the admin API of the multiplex settings
+// on the port of the service. Once the context is done, the protocols are shut down in the configured
+// order, each one with the grace period of the service
+func runMultiplexed(ctx context.Context, server *http.Server, cfg config.ServiceConfig) error {
+	l, err := net.Listen("tcp", serviceAddress(cfg))
+	if err != nil {
+		return err
+	}
+	m := NewMultiplexer(l, cfg.ReadHeaderTimeout)
+	defer m.Close()
+
+	serve := []func() error{}
+	shutdown := map[string]func(context.Context) error{}
+	if cfg.Multiplex.GRPC != "" {
+		grpcListener := m.Match(config.ProtocolGRPC, GRPCMatcher())
+		t := &tunnel{address: cfg.Multiplex.GRPC, conns: map[net.Conn]struct{}{}}
+		serve = append(serve, func() error { return t.Serve(grpcListener) })
+		shutdown[config.ProtocolGRPC] = t.Shutdown
+	}
+	if cfg.Admin != nil {
+		adminListener := m.Match(config.ProtocolAdmin, HTTP1PathMatcher(cfg.Multiplex.AdminPrefix))
+		// the handler of the service mounts the admin endpoints
+		admin := &http.Server{
+			Handler:           server.Handler,
+			ReadTimeout:       server.ReadTimeout,
+			ReadHeaderTimeout: server.ReadHeaderTimeout,
+			WriteTimeout:      server.WriteTimeout,
+			IdleTimeout:       server.IdleTimeout,
+		}
+		serve = append(serve, func() error { return admin.Serve(adminListener) })
+		shutdown[config.ProtocolAdmin] = admin.Shutdown
+	}
+	if profiling := newProfilingServer(cfg); profiling != nil {
+		profilingServe, err := bind([]*http.Server{profiling})
+		if err != nil {
+			return err
+		}
+		serve = append(serve, profilingServe...)
+		defer profiling.Close()
+	}
+	httpListener := m.Match(config.ProtocolHTTP, AnyMatcher())
+	serve = append(serve, func() error { return server.Serve(httpListener) }, m.Serve)
+	shutdown[config.ProtocolHTTP] = server.Shutdown
+
+	done := make(chan error, len(serve))
+	for _, s := range serve {
+		go func(s func() error) {
+			done <- s()
+		}(s)
+	}
+
+	daemon.Ready()
+
+	select {
+	case err := <-done:
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	daemon.Stopping()
+	var firstErr error
+	for _, protocol := range cfg.Multiplex.ShutdownOrder {
+		s, ok := shutdown[protocol]
+		if !ok {
+			continue
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.GracePeriod)
+		if err := s(shutdownCtx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		cancel()
+	}
+	return firstErr
+}
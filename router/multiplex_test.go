@@ -0,0 +1,213 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// http2Request returns the preface and the frames of an HTTP/2 request with prior knowledge
+func http2Request(t *testing.T, contentType string) []byte {
+	t.Helper()
+	headers := &bytes.Buffer{}
+	encoder := hpack.NewEncoder(headers)
+	for _, f := range []hpack.HeaderField{
+		{Name: ":method", Value: "POST"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/helloworld.Greeter/SayHello"},
+		{Name: ":authority", Value: "localhost"},
+		{Name: "content-type", Value: contentType},
+	} {
+		encoder.WriteField(f)
+	}
+	block := headers.Bytes()
+
+	buf := bytes.NewBufferString(http2.ClientPreface)
+	framer := http2.NewFramer(buf, nil)
+	if err := framer.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	// split the header block, so the matcher has to follow the continuation frames
+	if err := framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block[:len(block)/2]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := framer.WriteContinuation(1, true, block[len(block)/2:]); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHTTP1PathMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		request string
+		match   bool
+	}{
+		{"GET /__admin/routes HTTP/1.1\r\nHost: localhost\r\n\r\n", true},
+		{"POST /__admin HTTP/1.0\r\n\r\n", true},
+		{"GET /users HTTP/1.1\r\nHost: localhost\r\n\r\n", false},
+		{"GET /__admin/routes HTTP/2.0\r\n\r\n", false},
+		{"GET /__admin/routes", false},
+		{http2.ClientPreface, false},
+	} {
+		if match := HTTP1PathMatcher("/__admin")(strings.NewReader(tc.request)); match != tc.match {
+			t.Errorf("%q: want match %v, have %v", tc.request, tc.match, match)
+		}
+	}
+}
+
+func TestGRPCMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		request []byte
+		match   bool
+	}{
+		{"grpc", http2Request(t, "application/grpc"), true},
+		{"grpc+proto", http2Request(t, "application/grpc+proto"), true},
+		{"json", http2Request(t, "application/json"), false},
+		{"preface only", []byte(http2.ClientPreface), false},
+		{"http1", []byte("GET / HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/grpc\r\n\r\n"), false},
+	} {
+		if match := GRPCMatcher()(bytes.NewReader(tc.request)); match != tc.match {
+			t.Errorf("%s: want match %v, have %v", tc.name, tc.match, match)
+		}
+	}
+}
+
+func TestMultiplexer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMultiplexer(l, 100*time.Millisecond)
+	grpcListener := m.Match("grpc", GRPCMatcher())
+	adminListener := m.Match("admin", HTTP1PathMatcher("/__admin"))
+	httpListener := m.Match("http", AnyMatcher())
+
+	serverFor := func(name string) *http.Server {
+		return &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			io.WriteString(w, name)
+		})}
+	}
+	admin, public := serverFor("admin"), serverFor("http")
+	go admin.Serve(adminListener)
+	go public.Serve(httpListener)
+	defer admin.Close()
+	defer public.Close()
+
+	served := make(chan error, 1)
+	go func() { served <- m.Serve() }()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for path, want := range map[string]string{
+		"/__admin/routes": "admin",
+		"/users":          "http",
+	} {
+		resp, err := client.Get("http://" + l.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("%s: want the %s protocol, have %s", path, want, body)
+		}
+	}
+
+	// the bytes read by the matchers are replayed to the protocol
+	request := http2Request(t, "application/grpc")
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	grpcConn, err := grpcListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, len(request))
+	if _, err := io.ReadFull(grpcConn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, request) {
+		t.Error("the sniffed bytes were not replayed")
+	}
+	grpcConn.Close()
+
+	if err := m.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := <-served; err == nil {
+		t.Error("the multiplexer served after closing its listener")
+	}
+	if _, err := grpcListener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("the listeners of the protocols were not closed: %v", err)
+	}
+}
+
+func TestMultiplexer_sniffTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMultiplexer(l, 50*time.Millisecond)
+	m.Match("grpc", GRPCMatcher())
+	fallback := m.Match("http", AnyMatcher())
+	go m.Serve()
+	defer m.Close()
+
+	// a silent client is given to the protocol matching without bytes once the sniff timeout expires
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := fallback.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the deadline of the sniffing is cleared for the protocol
+	if _, err := c.Write([]byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, 4)
+	if _, err := io.ReadFull(conn, received); err != nil || string(received) != "late" {
+		t.Errorf("unexpected read: %q %v", received, err)
+	}
+}
+
+func TestMultiplexer_unmatched(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMultiplexer(l, time.Second)
+	m.Match("admin", HTTP1PathMatcher("/__admin"))
+	go m.Serve()
+	defer m.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "GET /users HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("the connection matching no protocol was not closed: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package mux
+
+import "net/http"
+
+// HandlerMiddlewareFunc adapts a function to the HandlerMiddleware interface
+type HandlerMiddlewareFunc func(http.Handler) http.Handler
+
+// Handler implements the HandlerMiddleware interface
+func (f HandlerMiddlewareFunc) Handler(h http.Handler) http.Handler { return f(h) }
+
+// Chain is an immutable ordered list of middlewares. The first middleware of the chain is the outermost
+// one, so it is the first to see the requests and the last to see the responses
+type Chain struct {
+	middlewares []HandlerMiddleware
+}
+
+// NewChain returns a chain with the middlewares in the received order
+func NewChain(middlewares ...HandlerMiddleware) Chain {
+	return Chain{append([]HandlerMiddleware{}, middlewares...)}
+}
+
+// Append returns a new chain with the middlewares added after the ones of the chain
+func (c Chain) Append(middlewares ...HandlerMiddleware) Chain {
+	mws := make([]HandlerMiddleware, 0, len(c.middlewares)+len(middlewares))
+	mws = append(mws, c.middlewares...)
+	return Chain{append(mws, middlewares...)}
+}
+
+// Extend returns a new chain with the middlewares of the other chain added after the ones of the chain
+func (c Chain) Extend(other Chain) Chain {
+	return c.Append(other.middlewares...)
+}
+
+// Then decorates the handler with the middlewares of the chain. The http.DefaultServeMux is used if
+// the handler is nil
+func (c Chain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i].Handler(h)
+	}
+	return h
+}
+
+// ThenFunc decorates the handler function with the middlewares of the chain
+func (c Chain) ThenFunc(f http.HandlerFunc) http.Handler {
+	if f == nil {
+		return c.Then(nil)
+	}
+	return c.Then(f)
+}
@@ -2,14 +2,19 @@ package mux
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"time"
+	"net/textproto"
+	"strings"
 
+	"github.com/ph0m1/porta/cdn"
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
 	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router"
+	"github.com/ph0m1/porta/router/accesslog"
 )
 
 var ErrInternalError = errors.New("internal server error")
@@ -21,46 +26,87 @@ type HandlerFactory func(*config.EndpointConfig, proxy.Proxy) http.HandlerFunc
 var EndpointHandler = CustomEndpointHandler(NewRequest)
 
 func CustomEndpointHandler(rb RequestBuilder) HandlerFactory {
-	return func(configuration *config.EndpointConfig, proxy proxy.Proxy) http.HandlerFunc {
-		endpointTimeout := time.Duration(configuration.Timeout) * time.Millisecond
-
+	return func(configuration *config.EndpointConfig, p proxy.Proxy) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != configuration.Method {
+			if r.Method != configuration.Method && !(r.Method == http.MethodHead && configuration.Method == http.MethodGet) {
+				w.Header().Set("Allow", configuration.Method)
 				http.Error(w, "", http.StatusMethodNotAllowed)
 				return
 			}
-			requestCtx, cancel := context.WithTimeout(context.Background(), endpointTimeout)
+			ctx := r.Context()
+			timeout, bypass := proxy.RequestOverrides(ctx, configuration, r.Header)
+			if bypass {
+				ctx = proxy.WithCacheBypass(ctx)
+			}
+			if id := r.Header.Get(router.RequestIDHeader); id != "" {
+				ctx = proxy.WithRequestID(ctx, id)
+			}
+			requestCtx, cancel := context.WithTimeout(ctx, proxy.RequestTimeout(r.Header, timeout))
 
 			w.Header().Set("X_X", "Version undefined")
+			accesslog.Annotate(r, configuration.Endpoint, len(configuration.Backend))
+
+			request := rb(r, configuration.QueryString)
+			passHeaders(request, r.Header, configuration.HeadersToPass)
+			// only the federated backends receive them, the proxies of the rest drop them
+			passHeaders(request, r.Header, configuration.FederatedHeaders)
+			if configuration.OutputEncoding == encoding.NOOP {
+				passHeaders(request, r.Header, proxy.RangeHeaders)
+			}
 
-			response, err := proxy(requestCtx, rb(r, configuration.QueryString))
+			response, err := p(requestCtx, request)
+			if response != nil && response.Io != nil {
+				defer response.Io.Close()
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				router.HandleError(w, r, err)
 				cancel()
 				return
 			}
 			select {
 			case <-requestCtx.Done():
-				http.Error(w, ErrInternalError.Error(), http.StatusInternalServerError)
+				router.HandleError(w, r, ErrInternalError)
 				cancel()
 				return
 			default:
 			}
 
-			var js []byte
+			data := map[string]interface{}{}
 			if response != nil {
-				js, err = json.Marshal(response.Data)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					cancel()
-					return
-				}
-				if configuration.CacheTTL.Seconds() != 0 && response.IsComplete {
+				data = response.Data
+			}
+			contentType, body, err := router.Encode(configuration.OutputEncoding, data)
+			if err != nil {
+				router.HandleError(w, r, err)
+				cancel()
+				return
+			}
+			if response != nil {
+				if configuration.CacheTTL.Seconds() != 0 && response.IsComplete && !bypass {
 					w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(configuration.CacheTTL.Seconds())))
 				}
+				if response.IsComplete {
+					cdn.SetHeaders(w.Header(), configuration.EdgeCache, request.Params)
+				}
+				for k, v := range response.Metadata.Headers {
+					// the Vary of the response extends the one of the edge cache
+					if k == "Vary" {
+						v = append(w.Header().Values(k), v...)
+					}
+					w.Header()[k] = v
+				}
+			}
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			if response != nil && response.Metadata.StatusCode != 0 {
+				w.WriteHeader(response.Metadata.StatusCode)
+			}
+			if response != nil && response.Io != nil {
+				io.Copy(w, response.Io)
+			} else {
+				w.Write(body)
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(js)
 			cancel()
 		}
 	}
@@ -72,17 +118,37 @@ type RequestBuilder func(*http.Request, []string) *proxy.Request
 // ParamExtractor is a function that extracts the params from the received uri
 type ParamExtractor func(*http.Request) map[string]string
 
-// NewRequest is a RequestBuilder that creates a proxy request from the received http request withoutAdd commentMore actions
-// processing the uri params
-var NewRequest = NewRequestBuilder(func(r *http.Request) map[string]string {
-	return map[string]string{}
-})
+// NewRequest is a RequestBuilder that creates a proxy request from the received http request, with the
+// wildcards of the http.ServeMux pattern matching it as params
+var NewRequest = NewRequestBuilder(ServeMuxParamsExtractor)
+
+// ServeMuxParamsExtractor extracts the wildcards of the http.ServeMux pattern matching the request. The
+// requests routed by other engines have no params
+func ServeMuxParamsExtractor(r *http.Request) map[string]string {
+	params := map[string]string{}
+	for _, segment := range strings.Split(r.Pattern, "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") || segment == "{$}" {
+			continue
+		}
+		name := strings.TrimSuffix(segment[1:len(segment)-1], "...")
+		params[strings.Title(name)] = r.PathValue(name)
+	}
+	return params
+}
 
 var (
 	headersToSend        = []string{"Content-Type"}
 	userAgentHeaderValue = []string{"X_X Version undefined"}
 )
 
+func passHeaders(request *proxy.Request, header http.Header, headersToPass []string) {
+	for _, k := range headersToPass {
+		if h, ok := header[textproto.CanonicalMIMEHeaderKey(k)]; ok {
+			request.Headers[textproto.CanonicalMIMEHeaderKey(k)] = h
+		}
+	}
+}
+
 // NewRequestBuilder gets a RequestBuilder with the received ParamExtractor as a query paramAdd commentMore actions
 // extraction mecanism
 func NewRequestBuilder(paramExtractor ParamExtractor) RequestBuilder {
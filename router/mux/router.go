@@ -1,12 +1,20 @@
 package mux
 
 import (
+	"context"
 	"fmt"
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
 	"github.com/ph0m1/porta/logging"
+	"github.com/ph0m1/porta/monitoring"
 	"github.com/ph0m1/porta/proxy"
 	"github.com/ph0m1/porta/router"
+	"github.com/ph0m1/porta/router/accesslog"
+	"github.com/ph0m1/porta/router/interceptor"
+	"github.com/ph0m1/porta/router/middleware"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 const DefaultDebugPattern = "/__debug/"
@@ -34,6 +42,34 @@ type Config struct {
 	ProxyFactory   proxy.Factory
 	Logger         logging.Logger
 	DebugPattern   string
+	// middlewares wrapping the engine inside the Middlewares, whose last item is the outermost one.
+	// The first middleware of the chain is the outermost
+	Chain Chain
+	// middlewares wrapping the endpoints, keyed by their path. The first one is the outermost and all
+	// of them wrap the middlewares declared in the extra config of the endpoints
+	RouteChains map[string]Chain
+	// factories of the middlewares declared by the endpoints
+	EndpointMiddlewares middleware.Registry
+	// handler writing the errors returned by the proxies. router.DefaultErrorHandler if nil
+	ErrorHandler router.ErrorHandler
+	// handler of the requests not matching any endpoint. The engine default if nil
+	NotFoundHandler http.Handler
+	// logger of the served requests, wrapping all the middlewares. No access log is written if nil
+	AccessLog *accesslog.Logger
+	// creates the engines of the reloaded configs. The *http.ServeMux engines are replaced by a new
+	// one if nil, while the routers with other engines can not be reloaded
+	NewEngine func() Engine
+	// factories of the interceptors declared by the endpoints
+	Interceptors interceptor.Registry
+	// recorder of the requests to the endpoints, including the ones rejected by their middlewares and
+	// route chains. The requests are not recorded if nil
+	Metrics monitoring.Recorder
+}
+
+// NotFoundEngine is an Engine accepting a custom handler for the requests not matching any route
+type NotFoundEngine interface {
+	Engine
+	NotFound(handler http.Handler)
 }
 
 // HandlerMiddleware is the interface for rhe decorators over the http.Handler
@@ -50,6 +86,9 @@ func DefaultFactory(pf proxy.Factory, logger logging.Logger) router.Factory {
 		ProxyFactory:   pf,
 		Logger:         logger,
 		DebugPattern:   DefaultDebugPattern,
+
+		EndpointMiddlewares: middleware.DefaultRegistry(),
+		Interceptors:        interceptor.DefaultRegistry(),
 	}}
 }
 
@@ -62,62 +101,225 @@ func NewFactory(cfg Config) router.Factory {
 }
 
 func (rf factory) New() router.Router {
-	return httpRouter{rf.cfg}
+	return httpRouter{rf.cfg, new(router.SwapHandler), new(router.Service)}
 }
 
 type httpRouter struct {
-	cfg Config
+	cfg     Config
+	live    *router.SwapHandler
+	service *router.Service
+}
+
+// Run implements the router interface
+func (r httpRouter) Run(cfg config.ServiceConfig) error {
+	ctx, stop := router.SignalContext()
+	defer stop()
+	return r.RunWithContext(ctx, cfg)
+}
+
+// RunWithContext implements the router.ContextRunner interface
+func (r httpRouter) RunWithContext(ctx context.Context, cfg config.ServiceConfig) error {
+	handler, g, err := r.build(cfg)
+	if err != nil {
+		return err
+	}
+	r.live.Swap(handler, g)
+	defer r.live.Stop(context.Background())
+
+	server := router.NewServer(cfg, r.live)
+	if err := router.RunServer(ctx, server, cfg); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Reload implements the router.Reloader interface
+func (r httpRouter) Reload(cfg config.ServiceConfig) error {
+	var engine Engine = DefaultEngine()
+	if r.cfg.NewEngine != nil {
+		engine = r.cfg.NewEngine()
+	} else if _, ok := r.cfg.Engine.(*http.ServeMux); !ok {
+		return router.ErrReloadNotSupported
+	}
+	next := r
+	next.cfg.Engine = engine
+	handler, g, err := next.build(cfg)
+	if err != nil {
+		return err
+	}
+	r.cfg.Logger.Info("config reloaded with", len(cfg.Endpoints), "endpoints")
+	return r.live.Swap(handler, g)
 }
 
-func (r httpRouter) Run(cfg config.ServiceConfig) {
+// build registers the endpoints of the config in the engine and returns the handler serving them and
+// the group running the background work of their stacks. It fails if the proxy, the middlewares or the
+// interceptors of an endpoint can not be built
+func (r httpRouter) build(cfg config.ServiceConfig) (http.Handler, *lifecycle.Group, error) {
+	if r.cfg.AccessLog != nil && cfg.LogSampling > 0 {
+		r.cfg.AccessLog = r.cfg.AccessLog.Sampled(cfg.LogSampling)
+	}
 	if cfg.Debug {
 		r.cfg.Engine.Handle(r.cfg.DebugPattern, DebugHandler(r.cfg.Logger))
 	}
-	r.registerEndpoints(cfg.Endpoints)
-
-	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: r.handler(),
+	g := lifecycle.NewGroup(context.Background())
+	if err := r.registerEndpoints(cfg.Endpoints, g); err != nil {
+		g.Stop(context.Background())
+		return nil, nil, err
+	}
+	h, err := r.service.Middlewares(cfg, r.handler())
+	if err != nil {
+		g.Stop(context.Background())
+		return nil, nil, err
 	}
-	r.cfg.Logger.Critical(server.ListenAndServe())
+	return h, g, nil
 }
 
-func (r httpRouter) registerEndpoints(endpoints []*config.EndpointConfig) {
+func (r httpRouter) registerEndpoints(endpoints []*config.EndpointConfig, g *lifecycle.Group) error {
+	paths := []string{}
+	handlers := map[string]methodHandlers{}
+	pf := proxy.WithGroup(r.cfg.ProxyFactory, g)
 	for _, c := range endpoints {
-		proxyStack, err := r.cfg.ProxyFactory.New(c)
+		proxyStack, err := pf.New(c)
+
+		if err != nil {
+			return fmt.Errorf("building the proxy of the endpoint [%s]: %s", c.Endpoint, err.Error())
+		}
+		ic, err := r.cfg.Interceptors.Build(c)
+		if err != nil {
+			return err
+		}
+		if ic != nil {
+			proxyStack = interceptor.Proxy(ic, proxyStack)
+		}
 
+		var handler http.Handler = r.cfg.HandlerFactory(c, proxyStack)
+		mw, err := r.cfg.EndpointMiddlewares.Build(c, g)
 		if err != nil {
-			r.cfg.Logger.Error("calling the ProxyFactory", err.Error())
-			continue
+			return err
+		}
+		if mw != nil {
+			handler = mw(handler)
+		}
+		if chain, ok := r.cfg.RouteChains[c.Endpoint]; ok {
+			handler = chain.Then(handler)
+		}
+		if r.cfg.Metrics != nil {
+			handler = monitoring.NewHTTPMiddleware(r.cfg.Metrics, c)(handler)
 		}
 
-		r.registerEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend))
+		if _, ok := handlers[c.Endpoint]; !ok {
+			paths = append(paths, c.Endpoint)
+			handlers[c.Endpoint] = methodHandlers{}
+		}
+		r.registerEndpoint(handlers[c.Endpoint], c.Method, c.Endpoint, handler, len(c.Backend), c.WriteFanOut != nil)
 	}
+
+	for _, path := range paths {
+		if len(handlers[path]) > 0 {
+			r.cfg.Engine.Handle(path, handlers[path])
+		}
+	}
+	return nil
 }
 
-func (r httpRouter) registerEndpoint(method, path string, handler http.HandlerFunc, toBackends int) {
-	if method != "GET" && toBackends > 1 {
-		r.cfg.Logger.Error(method, "endpoints must have a single backend! Ignoring", path)
+func (r httpRouter) registerEndpoint(handlers methodHandlers, method, path string, handler http.Handler, toBackends int, fanOut bool) {
+	if method != "GET" && toBackends > 1 && !fanOut {
+		r.cfg.Logger.Error(method, "endpoints must have a single backend or a write fan-out! Ignoring", path)
 		return
 	}
 	switch method {
-	case "GET":
-	case "POST":
-	case "PUT":
+	case "GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS":
 	default:
 		r.cfg.Logger.Error("Unsupported method", method)
 		return
 	}
+	if _, ok := handlers[method]; ok {
+		r.cfg.Logger.Error("duplicated endpoint! Ignoring", method, path)
+		return
+	}
 	r.cfg.Logger.Debug("registering the endpoint", method, path)
-	r.cfg.Engine.Handle(path, handler)
+	handlers[method] = handler
+}
+
+// methodHandlers dispatches the requests to a path to the handler registered for their method. The
+// HEAD requests fall back to the GET handler and the OPTIONS ones are answered with the allowed methods
+// when there is no handler for them
+type methodHandlers map[string]http.Handler
+
+// ServeHTTP implements the http.Handler interface
+func (m methodHandlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := m[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = m[http.MethodGet]
+	}
+	if ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", m.allow())
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Error(w, "", http.StatusMethodNotAllowed)
+}
+
+// allow returns the sorted list of methods accepted by the path
+func (m methodHandlers) allow() string {
+	methods := make([]string, 0, len(m)+2)
+	for method := range m {
+		methods = append(methods, method)
+	}
+	if _, ok := m[http.MethodGet]; ok {
+		if _, ok := m[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	if _, ok := m[http.MethodOptions]; !ok {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
 }
 
 func (r httpRouter) handler() http.Handler {
 	var handler http.Handler
 	handler = r.cfg.Engine
+	if r.cfg.NotFoundHandler != nil {
+		handler = r.notFound(r.cfg.NotFoundHandler)
+	}
+	handler = r.cfg.Chain.Then(handler)
 	for _, middleware := range r.cfg.Middlewares {
 		r.cfg.Logger.Debug("Adding the middleware", middleware)
 		handler = middleware.Handler(handler)
 	}
+	if eh := r.cfg.ErrorHandler; eh != nil {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, router.WithErrorHandler(req, eh))
+		})
+	}
+	if r.cfg.AccessLog != nil {
+		handler = r.cfg.AccessLog.Handler(handler)
+	}
 	return handler
 }
+
+func (r httpRouter) notFound(notFound http.Handler) http.Handler {
+	switch engine := r.cfg.Engine.(type) {
+	case NotFoundEngine:
+		engine.NotFound(notFound)
+	case *http.ServeMux:
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if _, pattern := engine.Handler(req); pattern == "" {
+				notFound.ServeHTTP(w, req)
+				return
+			}
+			engine.ServeHTTP(w, req)
+		})
+	default:
+		r.cfg.Logger.Warning("the engine does not support custom not found handlers")
+	}
+	return r.cfg.Engine
+}
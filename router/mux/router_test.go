@@ -0,0 +1,265 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router"
+)
+
+type proxyFactoryFunc func(*config.EndpointConfig) (proxy.Proxy, error)
+
+func (f proxyFactoryFunc) New(cfg *config.EndpointConfig) (proxy.Proxy, error) { return f(cfg) }
+
+func noopProxy(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+	return &proxy.Response{IsComplete: true}, nil
+}
+
+func rateLimitedConfig() config.ServiceConfig {
+	return config.ServiceConfig{Endpoints: []*config.EndpointConfig{{
+		Endpoint: "/supu",
+		Method:   "GET",
+		Backend:  []*config.Backend{{URLPattern: "/tupu"}},
+		ExtraConfig: config.ExtraConfig{"middlewares": []interface{}{
+			map[string]interface{}{"name": "ratelimit", "requests_per_second": 10},
+		}},
+	}}}
+}
+
+func TestRouter_Reload(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := DefaultFactory(proxyFactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+		return noopProxy, nil
+	}), logger).New().(httpRouter)
+
+	// every reload starts the cleanup of a new rate limiter and stops the replaced one
+	for i := 0; i < 3; i++ {
+		if err := router.Reload(r, rateLimitedConfig()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.live.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRouter_Reload_proxyError(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := DefaultFactory(proxyFactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+		return nil, errors.New("boom")
+	}), logger).New()
+
+	if err := router.Reload(r, rateLimitedConfig()); err == nil {
+		t.Error("expecting an error building the proxy")
+	}
+}
+
+// newTestRouter returns a router serving the endpoints with proxies answering the method of their endpoint
+func newTestRouter(t *testing.T, endpoints ...*config.EndpointConfig) httpRouter {
+	return newConfiguredTestRouter(t, func(_ *Config) {}, endpoints...)
+}
+
+// newConfiguredTestRouter returns a test router with the settings applied by the received function. The
+// proxies of the /failing endpoints return an open circuit error
+func newConfiguredTestRouter(t *testing.T, configure func(*Config), endpoints ...*config.EndpointConfig) httpRouter {
+	t.Helper()
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	f := DefaultFactory(proxyFactoryFunc(func(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+		return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+			if cfg.Endpoint == "/failing" {
+				return nil, proxy.ErrCircuitOpen
+			}
+			return &proxy.Response{Data: map[string]interface{}{"endpoint": cfg.Method}, IsComplete: true}, nil
+		}, nil
+	}), logger).(factory)
+	configure(&f.cfg)
+	r := f.New().(httpRouter)
+	if err := router.Reload(r, config.ServiceConfig{Endpoints: endpoints}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.live.Stop(context.Background()) })
+	return r
+}
+
+func testEndpoint(method, path string) *config.EndpointConfig {
+	return &config.EndpointConfig{
+		Endpoint: path,
+		Method:   method,
+		Timeout:  time.Second,
+		Backend:  []*config.Backend{{URLPattern: "/tupu"}},
+	}
+}
+
+func TestRouter_methods(t *testing.T) {
+	r := newTestRouter(t,
+		testEndpoint("GET", "/users"),
+		testEndpoint("POST", "/users"),
+		testEndpoint("PUT", "/users"),
+		testEndpoint("PATCH", "/users"),
+		testEndpoint("DELETE", "/users"),
+		testEndpoint("GET", "/items"),
+		testEndpoint("TRACE", "/trace"),
+	)
+
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		w := httptest.NewRecorder()
+		r.live.ServeHTTP(w, httptest.NewRequest(method, "/users", nil))
+		if w.Code != http.StatusOK || w.Body.String() != `{"endpoint":"`+method+`"}` {
+			t.Errorf("%s: unexpected response: %d %s", method, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"endpoint":"GET"}` {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	// the endpoints with unsupported methods are not registered
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("TRACE", "/trace", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestRouter_catchAll(t *testing.T) {
+	config.RoutingPattern = config.ServeMuxRouterPatternBuilder
+	defer func() { config.RoutingPattern = config.ColonRouterPatternBuilder }()
+
+	cfg := config.ServiceConfig{
+		Version: 1,
+		Host:    []string{"http://files"},
+		Endpoints: []*config.EndpointConfig{
+			{Endpoint: "/files/{bucket}/*path", Method: "GET", Backend: []*config.Backend{{URLPattern: "/v2/"}}},
+		},
+	}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if e := cfg.Endpoints[0].Endpoint; e != "/files/{bucket}/{path...}" {
+		t.Fatalf("unexpected endpoint: %s", e)
+	}
+
+	var params map[string]string
+	r := newConfiguredTestRouter(t, func(c *Config) {
+		c.ProxyFactory = proxyFactoryFunc(func(_ *config.EndpointConfig) (proxy.Proxy, error) {
+			return func(_ context.Context, r *proxy.Request) (*proxy.Response, error) {
+				params = r.Params
+				return &proxy.Response{IsComplete: true}, nil
+			}, nil
+		})
+	}, cfg.Endpoints...)
+
+	w := httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/files/docs/2024/report.pdf", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if params["Bucket"] != "docs" || params["Path"] != "2024/report.pdf" {
+		t.Errorf("unexpected params: %v", params)
+	}
+}
+
+func TestRouter_errorHandlers(t *testing.T) {
+	r := newTestRouter(t, testEndpoint("GET", "/failing"))
+	w := httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/failing", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected default error response: %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected default not found response: %d", w.Code)
+	}
+
+	r = newConfiguredTestRouter(t, func(cfg *Config) {
+		cfg.ErrorHandler = router.JSONErrorHandler
+		cfg.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}, testEndpoint("GET", "/failing"), testEndpoint("GET", "/users"))
+
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/failing", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected error response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("unexpected not found response: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected response: %d", w.Code)
+	}
+}
+
+func TestRouter_methodNotAllowed(t *testing.T) {
+	r := newTestRouter(t,
+		testEndpoint("GET", "/users"),
+		testEndpoint("POST", "/users"),
+		testEndpoint("DELETE", "/items"),
+		testEndpoint("OPTIONS", "/custom"),
+	)
+
+	w := httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("PUT", "/users", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("unexpected response: %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "DELETE, OPTIONS" {
+		t.Errorf("unexpected response: %d %v", w.Code, w.Header())
+	}
+
+	// the paths without an OPTIONS endpoint answer the allowed methods
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/users", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" || w.Body.Len() != 0 {
+		t.Errorf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.live.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/custom", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"endpoint":"OPTIONS"}` {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	// the HEAD requests are served by the GET endpoints
+	server := httptest.NewServer(r.live)
+	defer server.Close()
+	resp, err := http.Head(server.URL + "/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") == "" {
+		t.Errorf("unexpected response: %d %v", resp.StatusCode, resp.Header)
+	}
+	resp, err = http.Head(server.URL + "/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+}
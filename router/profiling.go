@@ -0,0 +1,61 @@
+package router
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// Paths of the profiling endpoints
+const (
+	PprofPath  = "/debug/pprof/"
+	ExpvarPath = "/debug/vars"
+)
+
+// ProfilingHandler returns the handler of the pprof profiles and of the expvar variables. It is not
+// registered in the default mux, so importing the router does not expose them
+func ProfilingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PprofPath, pprof.Index)
+	mux.HandleFunc(PprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPath+"trace", pprof.Trace)
+	mux.Handle(ExpvarPath, expvar.Handler())
+	return mux
+}
+
+// isProfilingPath tells if the path belongs to the profiling endpoints
+func isProfilingPath(path string) bool {
+	return path == ExpvarPath || strings.HasPrefix(path, PprofPath)
+}
+
+// mountProfiling serves the profiling endpoints, behind the admin token, and the rest of the requests
+// with next
+func mountProfiling(admin *config.Admin, next http.Handler) http.Handler {
+	profiling := AdminAuth(admin.Token, ProfilingHandler())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isProfilingPath(r.URL.Path) {
+			profiling.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newProfilingServer returns the server of the profiling endpoints when the admin settings move them
+// to a listener of their own. It has no write timeout, so the CPU profiles and the traces can last as
+// long as requested
+func newProfilingServer(cfg config.ServiceConfig) *http.Server {
+	if cfg.Admin == nil || !cfg.Admin.Profiling || cfg.Admin.ProfilingAddress == "" {
+		return nil
+	}
+	return &http.Server{
+		Addr:              cfg.Admin.ProfilingAddress,
+		Handler:           AdminAuth(cfg.Admin.Token, ProfilingHandler()),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+	}
+}
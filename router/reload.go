@@ -0,0 +1,82 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+)
+
+// ErrReloadNotSupported is returned by the routers unable to build a new route table
+var ErrReloadNotSupported = errors.New("the router does not support reloading its config")
+
+// Reloader is a Router able to replace its endpoints while running
+type Reloader interface {
+	// Reload builds the route table and the proxy stacks of the endpoints of the received config and
+	// swaps them with the served ones. The in-flight requests and the open connections are not
+	// affected. The settings of the server, like the port or the TLS config, are not reloaded. The
+	// background work of the replaced stacks is stopped once they are swapped
+	Reload(cfg config.ServiceConfig) error
+}
+
+// Reload reloads the config of the router if it is a Reloader
+func Reload(r Router, cfg config.ServiceConfig) error {
+	reloader, ok := r.(Reloader)
+	if !ok {
+		return ErrReloadNotSupported
+	}
+	return reloader.Reload(cfg)
+}
+
+// SwapHandler is an http.Handler delegating to the last handler stored, so the served routes can be
+// replaced atomically
+type SwapHandler struct {
+	handler atomic.Value
+	mu      sync.Mutex
+	group   *lifecycle.Group
+}
+
+// Store replaces the handler serving the next requests
+func (s *SwapHandler) Store(h http.Handler) {
+	s.handler.Store(&h)
+}
+
+// Swap replaces the handler serving the next requests and stops the group running the background work
+// of the replaced one, waiting for it to end
+func (s *SwapHandler) Swap(h http.Handler, g *lifecycle.Group) error {
+	s.mu.Lock()
+	previous := s.group
+	s.group = g
+	s.handler.Store(&h)
+	s.mu.Unlock()
+	if previous == nil {
+		return nil
+	}
+	return previous.Stop(context.Background())
+}
+
+// Stop stops the group running the background work of the served handler. It is safe to call it more
+// than once
+func (s *SwapHandler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	g := s.group
+	s.mu.Unlock()
+	if g == nil {
+		return nil
+	}
+	return g.Stop(ctx)
+}
+
+// ServeHTTP implements the http.Handler interface. It responds 404 until a handler is stored
+func (s *SwapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := s.handler.Load().(*http.Handler)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	(*h).ServeHTTP(w, r)
+}
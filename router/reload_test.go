@@ -0,0 +1,51 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+)
+
+func TestSwapHandler_Swap(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	s := new(SwapHandler)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("want status 404 before storing a handler, have %d", w.Code)
+	}
+
+	first := lifecycle.NewGroup(context.Background())
+	first.Go(func(ctx context.Context) { <-ctx.Done() })
+	s.Swap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }), first)
+
+	second := lifecycle.NewGroup(context.Background())
+	second.Go(func(ctx context.Context) { <-ctx.Done() })
+	if err := s.Swap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusTeapot) }), second); err != nil {
+		t.Error(err)
+	}
+	if first.Context().Err() == nil {
+		t.Error("the group of the replaced handler was not stopped")
+	}
+	if second.Context().Err() != nil {
+		t.Error("the group of the served handler was stopped")
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+
+	"github.com/go-yaml/yaml"
+
+	"github.com/ph0m1/porta/encoding"
+)
+
+// Encoder serializes the data of a response, returning its content type
+type Encoder func(data map[string]interface{}) (contentType string, body []byte, err error)
+
+// Encoders are the encoders of the output encodings of the endpoints
+var Encoders = map[string]Encoder{
+	encoding.JSON:   encodeJSON,
+	encoding.XML:    encodeXML,
+	encoding.YAML:   encodeYAML,
+	encoding.STRING: encodeString,
+	encoding.NOOP:   encodeNoop,
+}
+
+// Encode serializes the data with the output encoding of an endpoint. The unknown encodings are
+// encoded as JSON
+func Encode(outputEncoding string, data map[string]interface{}) (string, []byte, error) {
+	if e, ok := Encoders[outputEncoding]; ok {
+		return e(data)
+	}
+	return encodeJSON(data)
+}
+
+func encodeJSON(data map[string]interface{}) (string, []byte, error) {
+	b, err := json.Marshal(data)
+	return "application/json; charset=utf-8", b, err
+}
+
+func encodeYAML(data map[string]interface{}) (string, []byte, error) {
+	b, err := yaml.Marshal(data)
+	return "application/x-yaml; charset=utf-8", b, err
+}
+
+// encodeString sends the content field of the response, the one filled by the string decoder, as text
+func encodeString(data map[string]interface{}) (string, []byte, error) {
+	return "text/plain; charset=utf-8", content(data), nil
+}
+
+// encodeNoop sends the content field of the response as is. The content type is the one set in the
+// headers of the response, if any
+func encodeNoop(data map[string]interface{}) (string, []byte, error) {
+	return "", content(data), nil
+}
+
+func content(data map[string]interface{}) []byte {
+	switch c := data["content"].(type) {
+	case nil:
+		return nil
+	case []byte:
+		return c
+	case string:
+		return []byte(c)
+	default:
+		return []byte(fmt.Sprint(c))
+	}
+}
+
+// encodeXML writes the data inside a response element. The keys are sorted, the lists repeat the
+// element of their key and the rest of the values are written as text
+func encodeXML(data map[string]interface{}) (string, []byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	if err := writeXMLElement(buf, "response", data); err != nil {
+		return "", nil, err
+	}
+	return "application/xml; charset=utf-8", buf.Bytes(), nil
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, v interface{}) error {
+	switch value := v.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if err := writeXMLElement(buf, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		fmt.Fprintf(buf, "<%s/>", name)
+		return nil
+	}
+
+	fmt.Fprintf(buf, "<%s>", name)
+	if m, ok := v.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeXMLElement(buf, k, m[k]); err != nil {
+				return err
+			}
+		}
+	} else if err := xml.EscapeText(buf, []byte(fmt.Sprint(v))); err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "</%s>", name)
+	return nil
+}
@@ -1,9 +1,28 @@
 package router
 
-import "github.com/ph0m1/porta/config"
+import (
+	"context"
+	"errors"
 
+	"github.com/ph0m1/porta/config"
+)
+
+// Router sets up the public layer exposed to the users
 type Router interface {
-	Run(cfg config.ServiceConfig)
+	// Run starts the router and blocks until the process receives a SIGINT or a SIGTERM. It returns
+	// the error preventing the server from starting or from shutting down cleanly
+	Run(cfg config.ServiceConfig) error
+}
+
+// ErrRunWithContextNotSupported is returned by the routers that can only be stopped by the signals
+var ErrRunWithContextNotSupported = errors.New("the router does not support running with a context")
+
+// ContextRunner is a Router able to be stopped by a context, so it can be shut down along with the
+// other components of the application
+type ContextRunner interface {
+	// RunWithContext starts the router and blocks until the context is done, draining the in-flight
+	// requests before returning
+	RunWithContext(ctx context.Context, cfg config.ServiceConfig) error
 }
 
 type Factory interface {
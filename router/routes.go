@@ -0,0 +1,292 @@
+package router
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router/interceptor"
+	"github.com/ph0m1/porta/router/middleware"
+)
+
+// RoutesPath is the path of the route debugger
+const RoutesPath = "/__routes"
+
+// RouteQuery is the request to route. The route debugger receives it as the method, path and header
+// query params, like header=X-Variant:beta, or as a JSON body
+type RouteQuery struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+}
+
+// RouteReport describes how the gateway would handle a request, without calling any backend
+type RouteReport struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Matched bool   `json:"matched"`
+	// pattern of the matching endpoint
+	Endpoint string            `json:"endpoint,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	// effective timeout of the endpoint, inherited from the service if not declared
+	Timeout string `json:"timeout,omitempty"`
+	// http middlewares run before the proxy, from the outermost one
+	Middlewares []string `json:"middlewares,omitempty"`
+	// proxy layers wrapping the backend calls, from the outermost one
+	Proxy     []string               `json:"proxy,omitempty"`
+	RateLimit map[string]interface{} `json:"rate_limit,omitempty"`
+	// auth settings of the endpoint, without their secrets
+	Auth map[string]interface{} `json:"auth,omitempty"`
+	// response variant selected by the headers of the request, if any
+	Variant  string         `json:"variant,omitempty"`
+	Backends []BackendRoute `json:"backends,omitempty"`
+}
+
+// BackendRoute is the call the gateway would send to a backend
+type BackendRoute struct {
+	Method   string   `json:"method"`
+	URL      string   `json:"url"`
+	Hosts    []string `json:"hosts"`
+	Encoding string   `json:"encoding,omitempty"`
+	Timeout  string   `json:"timeout"`
+}
+
+// Routes reports how the service would handle the request
+func Routes(cfg config.ServiceConfig, q RouteQuery) RouteReport {
+	report := RouteReport{Method: strings.ToUpper(q.Method), Path: q.Path}
+	if report.Method == "" {
+		report.Method = http.MethodGet
+	}
+	for _, e := range cfg.Endpoints {
+		if e.Method != report.Method {
+			continue
+		}
+		params, ok := matchPath(e.Endpoint, q.Path)
+		if !ok {
+			continue
+		}
+		report.Matched = true
+		report.Endpoint = e.Endpoint
+		report.Params = params
+		report.Timeout = e.Timeout.String()
+		report.Middlewares = middlewareNames(cfg, e)
+		report.Proxy = proxyLayers(e)
+		report.RateLimit, report.Auth = policies(e)
+		report.Variant = headerVariant(e, q.Headers)
+		report.Backends = backendRoutes(e, params)
+		return report
+	}
+	return report
+}
+
+// RoutesHandler returns the handler of the route debugger
+func RoutesHandler(cfg config.ServiceConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var q RouteQuery
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			q = RouteQuery{Method: query.Get("method"), Path: query.Get("path"), Headers: map[string]string{}}
+			for _, h := range query["header"] {
+				if parts := strings.SplitN(h, ":", 2); len(parts) == 2 {
+					q.Headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		if q.Path == "" {
+			http.Error(w, "the path of the request to route is required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Routes(cfg, q))
+	})
+}
+
+// AdminAuth rejects the requests without the bearer token of the admin endpoints
+func AdminAuth(token string, h http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="porta admin"`)
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// matchPath matches the path against an endpoint pattern in the colon, the brackets or the http.ServeMux
+// format
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	params := map[string]string{}
+	for i, p := range patternSegments {
+		if name, ok := catchAllName(p); ok {
+			params[name] = strings.Join(pathSegments[i:], "/")
+			return params, true
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(p, ":"):
+			params[p[1:]] = pathSegments[i]
+		case strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}"):
+			params[p[1:len(p)-1]] = pathSegments[i]
+		case p != pathSegments[i]:
+			return nil, false
+		}
+	}
+	return params, len(patternSegments) == len(pathSegments)
+}
+
+func catchAllName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "*") {
+		return segment[1:], true
+	}
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, ":.*}") {
+		return segment[1 : len(segment)-4], true
+	}
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "...}") {
+		return segment[1 : len(segment)-4], true
+	}
+	return "", false
+}
+
+func middlewareNames(cfg config.ServiceConfig, e *config.EndpointConfig) []string {
+	names := []string{}
+	if cfg.SecurityHeaders {
+		names = append(names, "security_headers")
+	}
+	if cfg.CORS != nil {
+		names = append(names, "cors")
+	}
+	for _, item := range extraList(e, middleware.Namespace) {
+		if name, ok := item["name"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	for _, item := range extraList(e, interceptor.Namespace) {
+		if name, ok := item["name"].(string); ok {
+			names = append(names, "interceptor:"+name)
+		}
+	}
+	return names
+}
+
+func proxyLayers(e *config.EndpointConfig) []string {
+	layers := []string{}
+	if len(e.QueryTypes) > 0 {
+		layers = append(layers, "query_types")
+	}
+	if e.Journal != nil {
+		layers = append(layers, "journal")
+	}
+	if e.ResponseVariants != nil {
+		layers = append(layers, "response_variants")
+	}
+	if e.WarmCache != nil && e.CacheTTL > 0 {
+		layers = append(layers, "warm_cache")
+	}
+	if e.Fallback != nil {
+		layers = append(layers, "fallback")
+	}
+	if e.CostAttribution != nil {
+		layers = append(layers, "cost_attribution")
+	}
+	if e.Canary != nil {
+		layers = append(layers, "canary")
+	}
+	if len(e.Backend) > 1 {
+		layers = append(layers, "merge")
+	}
+	return layers
+}
+
+// policies returns the settings of the rate limit and of the auth of the endpoint
+func policies(e *config.EndpointConfig) (map[string]interface{}, map[string]interface{}) {
+	var rateLimit, auth map[string]interface{}
+	for _, item := range extraList(e, middleware.Namespace) {
+		switch item["name"] {
+		case "ratelimit":
+			rateLimit = item
+		case "auth":
+			auth = redact(item)
+		}
+	}
+	return rateLimit, auth
+}
+
+// redact replaces the values of the secret settings, like the signing keys or the api keys
+func redact(settings map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		lower := strings.ToLower(k)
+		if strings.Contains(lower, "secret") || strings.Contains(lower, "key") || strings.Contains(lower, "password") || strings.Contains(lower, "token") {
+			v = "[redacted]"
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+func headerVariant(e *config.EndpointConfig, headers map[string]string) string {
+	rv := e.ResponseVariants
+	if rv == nil || rv.Header == "" {
+		return ""
+	}
+	for k, v := range headers {
+		if _, ok := rv.Variants[v]; ok && strings.EqualFold(k, rv.Header) {
+			return v
+		}
+	}
+	return ""
+}
+
+func backendRoutes(e *config.EndpointConfig, params map[string]string) []BackendRoute {
+	titled := make(map[string]string, len(params))
+	for k, v := range params {
+		titled[strings.Title(k)] = v
+	}
+	routes := make([]BackendRoute, len(e.Backend))
+	for i, b := range e.Backend {
+		request := proxy.Request{Params: titled}
+		request.GeneratePath(b.URLPattern)
+		url := request.Path
+		if len(b.Host) > 0 {
+			url = b.Host[0] + request.Path
+		}
+		routes[i] = BackendRoute{
+			Method:   b.Method,
+			URL:      url,
+			Hosts:    b.Host,
+			Encoding: b.Encoding,
+			Timeout:  b.Timeout.String(),
+		}
+	}
+	return routes
+}
+
+func extraList(e *config.EndpointConfig, namespace string) []map[string]interface{} {
+	raw, _ := e.ExtraConfig[namespace].([]interface{})
+	items := make([]map[string]interface{}, 0, len(raw))
+	for _, item := range raw {
+		if m, ok := item.(map[string]interface{}); ok {
+			items = append(items, m)
+		}
+	}
+	return items
+}
@@ -0,0 +1,211 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/router/middleware"
+)
+
+func newRoutesConfig() config.ServiceConfig {
+	return config.ServiceConfig{
+		Admin:           &config.Admin{Token: "s3cr3t"},
+		SecurityHeaders: true,
+		Endpoints: []*config.EndpointConfig{
+			{
+				Endpoint: "/users/:id",
+				Method:   http.MethodGet,
+				Timeout:  time.Second,
+				ExtraConfig: config.ExtraConfig{
+					middleware.Namespace: []interface{}{
+						map[string]interface{}{"name": "auth", "secret_key": "hmac", "issuer": "porta"},
+						map[string]interface{}{"name": "ratelimit", "max_rate": 10},
+					},
+				},
+				ResponseVariants: &config.ResponseVariants{
+					Header:   "X-Variant",
+					Variants: map[string]config.ResponseVariant{"beta": {}},
+				},
+				Backend: []*config.Backend{
+					{Method: http.MethodGet, Host: []string{"http://users"}, URLPattern: "/users/{{.Id}}", Timeout: time.Second},
+					{Method: http.MethodGet, Host: []string{"http://orders"}, URLPattern: "/orders?user={{.Id}}", Timeout: time.Second},
+				},
+			},
+			{
+				Endpoint: "/files/{path:.*}",
+				Method:   http.MethodPost,
+				Timeout:  2 * time.Second,
+				Backend: []*config.Backend{
+					{Method: http.MethodPut, Host: []string{"http://files"}, URLPattern: "/{{.Path}}", Encoding: "no-op", Timeout: 2 * time.Second},
+				},
+			},
+		},
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	cfg := newRoutesConfig()
+
+	report := Routes(cfg, RouteQuery{Path: "/users/42", Headers: map[string]string{"x-variant": "beta"}})
+	if !report.Matched || report.Endpoint != "/users/:id" || report.Method != http.MethodGet {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if !reflect.DeepEqual(report.Params, map[string]string{"id": "42"}) {
+		t.Errorf("unexpected params: %v", report.Params)
+	}
+	if report.Timeout != "1s" || report.Variant != "beta" {
+		t.Errorf("unexpected timeout or variant: %s %s", report.Timeout, report.Variant)
+	}
+	if want := []string{"security_headers", "auth", "ratelimit"}; !reflect.DeepEqual(report.Middlewares, want) {
+		t.Errorf("unexpected middlewares: %v", report.Middlewares)
+	}
+	if want := []string{"response_variants", "merge"}; !reflect.DeepEqual(report.Proxy, want) {
+		t.Errorf("unexpected proxy layers: %v", report.Proxy)
+	}
+	if report.RateLimit["max_rate"] != 10 {
+		t.Errorf("unexpected rate limit: %v", report.RateLimit)
+	}
+	if report.Auth["secret_key"] != "[redacted]" || report.Auth["issuer"] != "porta" {
+		t.Errorf("the auth settings were not redacted: %v", report.Auth)
+	}
+	if len(report.Backends) != 2 || report.Backends[0].URL != "http://users/users/42" || report.Backends[1].URL != "http://orders/orders?user=42" {
+		t.Errorf("unexpected backends: %+v", report.Backends)
+	}
+
+	report = Routes(cfg, RouteQuery{Method: "post", Path: "/files/docs/a.txt"})
+	if !report.Matched || report.Endpoint != "/files/{path:.*}" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.Params["path"] != "docs/a.txt" || report.Backends[0].URL != "http://files/docs/a.txt" || report.Backends[0].Encoding != "no-op" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	for _, q := range []RouteQuery{
+		{Path: "/users"},
+		{Path: "/users/42/orders"},
+		{Method: http.MethodDelete, Path: "/users/42"},
+	} {
+		if report := Routes(cfg, q); report.Matched || len(report.Backends) > 0 {
+			t.Errorf("%s %s: unexpected match: %+v", q.Method, q.Path, report)
+		}
+	}
+}
+
+func TestRoutesHandler(t *testing.T) {
+	h := RoutesHandler(newRoutesConfig())
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, RoutesPath+"?path=/users/42&header=X-Variant:%20beta", nil),
+		httptest.NewRequest(http.MethodPost, RoutesPath, bytes.NewBufferString(`{"method":"GET","path":"/users/42","headers":{"X-Variant":"beta"}}`)),
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status: %d", r.Method, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: unexpected content type: %s", r.Method, ct)
+		}
+		var report RouteReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !report.Matched || report.Endpoint != "/users/:id" || report.Variant != "beta" || len(report.Backends) != 2 {
+			t.Errorf("%s: unexpected report: %+v", r.Method, report)
+		}
+	}
+
+	for _, tc := range []struct {
+		r      *http.Request
+		status int
+	}{
+		{httptest.NewRequest(http.MethodGet, RoutesPath, nil), http.StatusBadRequest},
+		{httptest.NewRequest(http.MethodPost, RoutesPath, bytes.NewBufferString("{")), http.StatusBadRequest},
+		{httptest.NewRequest(http.MethodDelete, RoutesPath, nil), http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tc.r)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: want status %d, have %d", tc.r.Method, tc.r.URL, tc.status, w.Code)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	h := AdminAuth("s3cr3t", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusTeapot) }))
+
+	for _, authorization := range []string{"", "Bearer wrong", "s3cr3t", "Basic s3cr3t"} {
+		r := httptest.NewRequest(http.MethodGet, RoutesPath, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: unexpected status: %d", authorization, w.Code)
+		}
+		if a := w.Header().Get("WWW-Authenticate"); a != `Bearer realm="porta admin"` {
+			t.Errorf("%q: unexpected WWW-Authenticate header: %s", authorization, a)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, RoutesPath, nil)
+	r.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestServiceMiddlewares_routes(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	h, err := ServiceMiddlewares(newRoutesConfig(), next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path          string
+		authorization string
+		status        int
+	}{
+		{RoutesPath + "?path=/users/42", "", http.StatusUnauthorized},
+		{RoutesPath + "?path=/users/42", "Bearer s3cr3t", http.StatusOK},
+		{"/users/42", "", http.StatusTeapot},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+tc.path, nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: want status %d, have %d", tc.path, tc.status, resp.StatusCode)
+		}
+	}
+
+	cfg := newRoutesConfig()
+	cfg.Admin = nil
+	if h, err = ServiceMiddlewares(cfg, next); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutesPath+"?path=/users/42", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("the route debugger was mounted without the admin settings: %d", w.Code)
+	}
+}
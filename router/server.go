@@ -0,0 +1,290 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/daemon"
+)
+
+// SignalContext returns a context canceled when the process receives a SIGINT or a SIGTERM
+func SignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// NewServer creates a server for the received handler, applying the address and the timeouts of the service
+func NewServer(cfg config.ServiceConfig, handler http.Handler) *http.Server {
+	if cfg.HTTP2 != nil && cfg.HTTP2.H2C && !cfg.HTTP2.Disabled {
+		handler = h2c.NewHandler(handler, newHTTP2Server(cfg))
+	}
+	return &http.Server{
+		Addr:              serviceAddress(cfg),
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// ListenAndServe starts the received server, over TLS if the service config defines it
+func ListenAndServe(server *http.Server, cfg config.ServiceConfig) error {
+	return listenAndServe(server, cfg, newAutoCert(server, cfg))
+}
+
+func listenAndServe(server *http.Server, cfg config.ServiceConfig, m *autocert.Manager) error {
+	if err := setupTLS(server, cfg, m); err != nil {
+		return err
+	}
+	if server.TLSConfig == nil {
+		return server.ListenAndServe()
+	}
+	return server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+}
+
+// setupTLS sets the TLS config of the service in the server, taking the certificates from the ACME
+// manager if any
+func setupTLS(server *http.Server, cfg config.ServiceConfig, m *autocert.Manager) error {
+	tlsConfig, err := NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil && m != nil {
+		tlsConfig.GetCertificate = m.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	}
+	server.TLSConfig = tlsConfig
+	return configureHTTP2(server, cfg)
+}
+
+// newAutoCert returns the ACME manager of the service and makes the server answer the HTTP-01
+// challenges. It returns nil if the service does not use autocert
+func newAutoCert(server *http.Server, cfg config.ServiceConfig) *autocert.Manager {
+	if cfg.TLS == nil || cfg.TLS.AutoCert == nil {
+		return nil
+	}
+	m := NewAutoCertManager(cfg.TLS.AutoCert, AutoCertCache)
+	server.Handler = m.HTTPHandler(server.Handler)
+	return m
+}
+
+// configureHTTP2 applies the HTTP/2 settings of the service to the TLS connections of the server
+func configureHTTP2(server *http.Server, cfg config.ServiceConfig) error {
+	if cfg.HTTP2 != nil && cfg.HTTP2.Disabled {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	if server.TLSConfig == nil {
+		return nil
+	}
+	return http2.ConfigureServer(server, newHTTP2Server(cfg))
+}
+
+func newHTTP2Server(cfg config.ServiceConfig) *http2.Server {
+	h2 := &http2.Server{}
+	if cfg.HTTP2 != nil {
+		h2.MaxConcurrentStreams = cfg.HTTP2.MaxConcurrentStreams
+	}
+	return h2
+}
+
+// RunServer starts the received server and, once the context is done, shuts it down gracefully,
+// letting the in-flight requests finish during the grace period of the service. The multiplexed
+// services share their port with the gRPC server and the admin API. The service manager is notified
+// the gateway is ready once all the listeners are open
+func RunServer(ctx context.Context, server *http.Server, cfg config.ServiceConfig) error {
+	if cfg.Multiplex != nil {
+		return runMultiplexed(ctx, server, cfg)
+	}
+	m := newAutoCert(server, cfg)
+	if err := setupTLS(server, cfg, m); err != nil {
+		return err
+	}
+	var listeners []net.Listener
+	var useTLS []bool
+	if len(cfg.Listeners) == 0 {
+		l, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+		listeners, useTLS = []net.Listener{l}, []bool{server.TLSConfig != nil}
+	} else {
+		var err error
+		if listeners, err = Listen(cfg); err != nil {
+			return err
+		}
+		for _, lc := range cfg.Listeners {
+			useTLS = append(useTLS, lc.TLS)
+		}
+	}
+	var serve []func() error
+	for i, l := range listeners {
+		l, useTLS := l, useTLS[i]
+		serve = append(serve, func() error {
+			if useTLS {
+				return server.ServeTLS(l, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			}
+			return server.Serve(l)
+		})
+	}
+
+	var extra []*http.Server
+	if redirect := newRedirectServer(cfg, m); redirect != nil {
+		extra = append(extra, redirect)
+	}
+	if profiling := newProfilingServer(cfg); profiling != nil {
+		extra = append(extra, profiling)
+	}
+	if m != nil && cfg.TLS.AutoCert.ChallengeAddress != "" && !redirectsFrom(cfg, cfg.TLS.AutoCert.ChallengeAddress) {
+		extra = append(extra, &http.Server{
+			Addr:              cfg.TLS.AutoCert.ChallengeAddress,
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		})
+	}
+	extraServe, err := bind(extra)
+	if err != nil {
+		closeAll(listeners)
+		return err
+	}
+	serve = append(serve, extraServe...)
+	for _, s := range extra {
+		defer s.Close()
+	}
+
+	done := make(chan error, len(serve))
+	for _, s := range serve {
+		go func(s func() error) {
+			done <- s()
+		}(s)
+	}
+
+	daemon.Ready()
+
+	select {
+	case err := <-done:
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	daemon.Stopping()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.GracePeriod)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// bind opens the listeners of the servers, returning the functions serving them. No listener is left
+// open if any of them fails
+func bind(servers []*http.Server) ([]func() error, error) {
+	var listeners []net.Listener
+	serve := make([]func() error, 0, len(servers))
+	for _, s := range servers {
+		l, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		s := s
+		serve = append(serve, func() error { return s.Serve(l) })
+	}
+	return serve, nil
+}
+
+// serviceAddress returns the address of the listen settings or the one of the port
+func serviceAddress(cfg config.ServiceConfig) string {
+	if cfg.Listen != nil && cfg.Listen.Address != "" {
+		return cfg.Listen.Address
+	}
+	return fmt.Sprintf(":%d", cfg.Port)
+}
+
+func redirectsFrom(cfg config.ServiceConfig, address string) bool {
+	return cfg.Listen != nil && cfg.Listen.RedirectHTTP == address
+}
+
+// newRedirectServer returns the plain HTTP server redirecting to the service, answering the ACME
+// challenges too if the service uses autocert. It returns nil if the redirection is not enabled
+func newRedirectServer(cfg config.ServiceConfig, m *autocert.Manager) *http.Server {
+	if cfg.Listen == nil || cfg.Listen.RedirectHTTP == "" || cfg.TLS == nil {
+		return nil
+	}
+	var handler http.Handler = RedirectHandler(serviceAddress(cfg))
+	if m != nil {
+		handler = m.HTTPHandler(handler)
+	}
+	return &http.Server{
+		Addr:              cfg.Listen.RedirectHTTP,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+	}
+}
+
+// RedirectHandler redirects the requests to the same URL over HTTPS, on the port of the HTTPS address
+func RedirectHandler(httpsAddress string) http.Handler {
+	port := ""
+	if _, p, err := net.SplitHostPort(httpsAddress); err == nil && p != "443" && p != "" {
+		port = ":" + p
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		http.Redirect(w, r, "https://"+host+port+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// Listen opens the listeners of the service in the declared order. The addresses prefixed with unix:
+// are unix domain sockets, replacing any stale socket file, and the ones prefixed with systemd: are
+// the sockets passed by systemd, selected by their name or index
+func Listen(cfg config.ServiceConfig) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(cfg.Listeners))
+	for _, lc := range cfg.Listeners {
+		if strings.HasPrefix(lc.Address, "systemd:") {
+			l, err := daemon.ActivatedListener(strings.TrimPrefix(lc.Address, "systemd:"))
+			if err != nil {
+				closeAll(listeners)
+				return nil, fmt.Errorf("listener [%s]: %s", lc.Address, err.Error())
+			}
+			listeners = append(listeners, l)
+			continue
+		}
+		network, address := "tcp", lc.Address
+		if strings.HasPrefix(address, "unix:") {
+			network = "unix"
+			address = strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+			if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+				os.Remove(address)
+			}
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
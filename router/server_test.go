@@ -0,0 +1,130 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// unixClient returns a client sending all its requests to the unix socket
+func unixClient(socket string, tlsConfig *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+		TLSClientConfig: tlsConfig,
+	}}
+}
+
+// listenNotifications makes the daemon notifications of the test reach the returned channel
+func listenNotifications(t *testing.T) <-chan string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	states := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(states)
+				return
+			}
+			states <- string(buf[:n])
+		}
+	}()
+	return states
+}
+
+func TestRunServer_listeners(t *testing.T) {
+	states := listenNotifications(t)
+	certFile, keyFile := writeTestCert(t)
+	dir := t.TempDir()
+	plain, secure := filepath.Join(dir, "plain.sock"), filepath.Join(dir, "secure.sock")
+
+	// a stale socket file left by a previous run is replaced
+	stale, err := net.Listen("unix", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := config.ServiceConfig{
+		GracePeriod: time.Second,
+		TLS:         &config.TLS{CertFile: certFile, KeyFile: keyFile},
+		Listeners:   []config.Listener{{Address: "unix:" + plain}, {Address: "unix://" + secure, TLS: true}},
+	}
+	server := NewServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			io.WriteString(w, "secure")
+			return
+		}
+		io.WriteString(w, "plain")
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- RunServer(ctx, server, cfg) }()
+
+	if state := <-states; state != "READY=1" {
+		t.Fatalf("unexpected state: %s", state)
+	}
+	// the listeners accept the connections once the gateway is reported as ready
+	for _, tc := range []struct {
+		client *http.Client
+		url    string
+		body   string
+	}{
+		{unixClient(plain, nil), "http://gateway/", "plain"},
+		{unixClient(secure, &tls.Config{InsecureSkipVerify: true}), "https://gateway/", "secure"},
+	} {
+		resp, err := tc.client.Get(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		tc.client.CloseIdleConnections()
+		if string(body) != tc.body {
+			t.Errorf("%s: unexpected body: %s", tc.url, body)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if state := <-states; state != "STOPPING=1" {
+		t.Errorf("unexpected state: %s", state)
+	}
+}
+
+func TestRunServer_listenError(t *testing.T) {
+	cfg := config.ServiceConfig{Listeners: []config.Listener{{Address: "unix:" + filepath.Join(t.TempDir(), "missing", "porta.sock")}}}
+	if err := RunServer(context.Background(), NewServer(cfg, http.NotFoundHandler()), cfg); err == nil {
+		t.Error("expecting an error for the socket in a missing directory")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cfg = config.ServiceConfig{Listen: &config.Listen{Address: l.Addr().String()}}
+	if err := RunServer(context.Background(), NewServer(cfg, http.NotFoundHandler()), cfg); err == nil {
+		t.Error("expecting an error for the address in use")
+	}
+}
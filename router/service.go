@@ -0,0 +1,152 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ph0m1/porta/cdn"
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/router/middleware"
+	"github.com/ph0m1/porta/router/static"
+	"github.com/ph0m1/porta/security"
+)
+
+// ServiceMiddlewares wraps the handler with the middlewares enabled by the service config: the
+// security headers, the CORS policy, which answers the preflight requests by itself, and the legacy
+// plain text errors. The ErrorHandler of the router, if any, takes precedence over the error format.
+// The requests under the prefix of the static assets are served from their directory and the admin
+// endpoints, like the route debugger, the stats of the balanced hosts, the switch of the endpoints
+// disabled at runtime, the purge of the cached responses, the journal report and the profiles, are
+// registered when the admin settings are present. The decision headers wrap everything else, so they
+// see the decisions of all the middlewares. It fails if the settings of the CDN purgers are not valid.
+// The state of the returned handler, like the endpoints disabled at runtime, is not kept across the
+// reloads: the routers use the Middlewares of their Service instead
+func ServiceMiddlewares(cfg config.ServiceConfig, h http.Handler) (http.Handler, error) {
+	return new(Service).Middlewares(cfg, h)
+}
+
+// Service holds the state of the service middlewares kept across the reloads of the config, like the
+// endpoints disabled at runtime. The routers build the handler of every config with the same Service
+type Service struct {
+	mu       sync.Mutex
+	disabled *DisabledEndpoints
+}
+
+// Middlewares wraps the handler like ServiceMiddlewares, disabling again the endpoints disabled in the
+// previous handler built, if the config still declares them
+func (s *Service) Middlewares(cfg config.ServiceConfig, h http.Handler) (http.Handler, error) {
+	for _, assets := range cfg.Static {
+		h = mountStatic(assets, h)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg.Admin != nil {
+		disabled := NewDisabledEndpoints(cfg)
+		if s.disabled != nil {
+			disabled.keep(s.disabled)
+		}
+		h = disabled.Middleware(h)
+		h = mount(DisabledPath, AdminAuth(cfg.Admin.Token, disabled.Handler()), h)
+		h = mount(RoutesPath, AdminAuth(cfg.Admin.Token, RoutesHandler(cfg)), h)
+		h = mount(HostsPath, AdminAuth(cfg.Admin.Token, HostsHandler()), h)
+		purger, err := cachePurger(cfg)
+		if err != nil {
+			return nil, err
+		}
+		h = mount(CachePath, AdminAuth(cfg.Admin.Token, cdn.NewPurgeHandler(purger)), h)
+		if cfg.Journal != nil && cfg.Journal.Path != "" {
+			h = mount(JournalPath, AdminAuth(cfg.Admin.Token, JournalHandler(proxy.OpenJournal(*cfg.Journal))), h)
+		}
+		if cfg.Admin.Profiling && cfg.Admin.ProfilingAddress == "" {
+			h = mountProfiling(cfg.Admin, h)
+		}
+		s.disabled = disabled
+	}
+	if cfg.ErrorFormat == config.ErrorFormatPlainText {
+		next := h
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, WithErrorHandler(r, PlainTextErrorHandler))
+		})
+	}
+	if cfg.CORS != nil {
+		h = security.NewCORSMiddleware(&security.CORSConfig{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   cfg.CORS.ExposedHeaders,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           int(cfg.CORS.MaxAge.Seconds()),
+		}).HTTPMiddleware(h)
+	}
+	if cfg.SecurityHeaders {
+		h = security.NewSecurityHeadersMiddleware(nil).HTTPMiddleware(h)
+	}
+	if cfg.TLS != nil && cfg.TLS.HSTS != nil {
+		h = hsts(cfg.TLS.HSTS, h)
+	}
+	if cfg.DebugHeaders != nil {
+		h = security.NewDebugHeadersMiddleware(cfg.DebugHeaders.Role, cfg.DebugHeaders.AllowFlag).HTTPMiddleware(h)
+	}
+	return h, nil
+}
+
+// CachePath is the path of the admin endpoint purging the cached responses of the JSON body, like
+// {"paths":["/users/42"],"keys":["user-42"]}
+const CachePath = "/__cache"
+
+// cachePurger returns the purger of the responses cached by the gateway and, afterwards, by the CDNs
+// declared in the extra config of the service, so the CDNs do not fetch the stale responses again
+func cachePurger(cfg config.ServiceConfig) (cdn.Purger, error) {
+	purgers := cdn.Purgers{middleware.CachePurger()}
+	p, err := cdn.NewPurger(cfg.ExtraConfig)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		purgers = append(purgers, p)
+	}
+	return purgers, nil
+}
+
+// hsts adds the Strict-Transport-Security header to the responses sent over TLS, directly or through
+// a proxy terminating it
+func hsts(cfg *config.HSTS, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	if cfg.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if cfg.Preload {
+		value += "; preload"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func mountStatic(assets *config.StaticAssets, next http.Handler) http.Handler {
+	files := static.Handler(assets.Prefix, assets.Dir, assets.MaxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, assets.Prefix) {
+			files.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mount serves the path with the handler and the rest of the requests with next
+func mount(path string, h, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ph0m1/porta/cdn"
+	"github.com/ph0m1/porta/config"
+)
+
+func TestServiceMiddlewares_cache(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	cfg := config.ServiceConfig{Admin: &config.Admin{Token: "s3cr3t"}}
+	h, err := ServiceMiddlewares(cfg, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		authorization string
+		status        int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer s3cr3t", http.StatusAccepted},
+	} {
+		r := httptest.NewRequest(http.MethodPost, CachePath, strings.NewReader(`{"paths":["/users/42"],"keys":["user-42"]}`))
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%q: want status %d, have %d", tc.authorization, tc.status, w.Code)
+		}
+	}
+
+	cfg.ExtraConfig = config.ExtraConfig{cdn.Namespace: map[string]interface{}{
+		"fastly": map[string]interface{}{"service_id": "svc"},
+	}}
+	if _, err := ServiceMiddlewares(cfg, next); err == nil {
+		t.Error("the invalid cdn settings were accepted")
+	}
+}
+
+func TestService_Middlewares_disabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	cfg := config.ServiceConfig{
+		Admin: &config.Admin{Token: "s3cr3t"},
+		Endpoints: []*config.EndpointConfig{
+			{Endpoint: "/users/:id", Method: http.MethodGet},
+			{Endpoint: "/orders", Method: http.MethodGet},
+		},
+	}
+	s := new(Service)
+	h, err := s.Middlewares(cfg, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range []string{"/users/:id", "/orders"} {
+		r := httptest.NewRequest(http.MethodPost, DisabledPath, strings.NewReader(`{"endpoint":"`+endpoint+`"}`))
+		r.Header.Set("Authorization", "Bearer s3cr3t")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status: %d", endpoint, w.Code)
+		}
+	}
+
+	// the reloaded config keeps the endpoints disabled, unless it drops them
+	cfg.Endpoints = cfg.Endpoints[:1]
+	if h, err = s.Middlewares(cfg, next); err != nil {
+		t.Fatal(err)
+	}
+	for path, status := range map[string]int{"/users/42": http.StatusServiceUnavailable, "/orders": http.StatusTeapot} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status {
+			t.Errorf("%s: want status %d, have %d", path, status, w.Code)
+		}
+	}
+
+	if h, err = ServiceMiddlewares(cfg, next); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("the disabled endpoints leaked into another service: %d", w.Code)
+	}
+}
@@ -0,0 +1,123 @@
+// Package static serves directories of static assets, like the docs and the UI of a developer portal,
+// with their precompressed variants, ETags and cache headers
+package static
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodings are the precompressed variants looked for, in order of preference
+var encodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Handler serves the files of the dir under the prefix. A request for a file is answered with its
+// .br or .gz sibling, if it exists and the client accepts the encoding. The directories are served
+// with their index.html
+func Handler(prefix, dir string, maxAge time.Duration) http.Handler {
+	root := http.Dir(dir)
+	cacheControl := "no-cache"
+	if maxAge > 0 {
+		cacheControl = "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+		if strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+		}
+		info, err := stat(root, name)
+		if err == nil && info.IsDir() {
+			name = path.Join(name, "index.html")
+			info, err = stat(root, name)
+		}
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("Cache-Control", cacheControl)
+		h.Add("Vary", "Accept-Encoding")
+
+		served, encoding := name, ""
+		for _, e := range encodings {
+			if !accepts(r.Header.Get("Accept-Encoding"), e.name) {
+				continue
+			}
+			if variant, err := stat(root, name+e.ext); err == nil && !variant.IsDir() {
+				served, encoding, info = name+e.ext, e.name, variant
+				break
+			}
+		}
+		f, err := root.Open(served)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		if encoding != "" {
+			h.Set("Content-Encoding", encoding)
+		}
+		h.Set("ETag", etag(info, encoding))
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	})
+}
+
+func stat(root http.FileSystem, name string) (os.FileInfo, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// etag identifies the content by its size and modification time. The encoding is part of it, since
+// every variant has its own bytes
+func etag(info os.FileInfo, encoding string) string {
+	tag := fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return `"` + tag + `"`
+}
+
+// accepts tells if the Accept-Encoding header allows the encoding
+func accepts(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.TrimSpace(fields[0])
+		if name != encoding && name != "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("plain"), 0o644)
+	os.WriteFile(filepath.Join(dir, "app.js.gz"), []byte("gzipped"), 0o644)
+	os.WriteFile(filepath.Join(dir, "app.js.br"), []byte("brotli"), 0o644)
+	h := Handler("/docs/", dir, time.Hour)
+
+	for _, tc := range []struct {
+		path, acceptEncoding, body, encoding string
+	}{
+		{"/docs/app.js", "", "plain", ""},
+		{"/docs/app.js", "gzip, deflate", "gzipped", "gzip"},
+		{"/docs/app.js", "gzip, br", "brotli", "br"},
+		{"/docs/app.js", "br;q=0, gzip", "gzipped", "gzip"},
+		{"/docs/", "gzip", "<html></html>", ""},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != tc.body {
+			t.Errorf("%s %s: unexpected response %d %s", tc.path, tc.acceptEncoding, w.Code, w.Body.String())
+		}
+		if enc := w.Header().Get("Content-Encoding"); enc != tc.encoding {
+			t.Errorf("%s %s: unexpected encoding %s", tc.path, tc.acceptEncoding, enc)
+		}
+		if w.Header().Get("Cache-Control") != "public, max-age=3600" || w.Header().Get("ETag") == "" {
+			t.Errorf("%s: unexpected cache headers %v", tc.path, w.Header())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/docs/app.js", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); ct != "text/javascript; charset=utf-8" && ct != "application/javascript" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("unexpected status for a matching etag: %d", w.Code)
+	}
+
+	for _, path := range []string{"/docs/missing.js", "/docs/../../etc/passwd"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: unexpected status %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// writeTestCert writes a self-signed certificate for localhost and its key in the temp dir of the test
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	if tlsConfig, err := NewTLSConfig(nil); tlsConfig != nil || err != nil {
+		t.Errorf("unexpected result: %v, %v", tlsConfig, err)
+	}
+
+	tlsConfig, err := NewTLSConfig(&config.TLS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 || tlsConfig.CipherSuites != nil || tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("unexpected defaults: %+v", tlsConfig)
+	}
+
+	tlsConfig, err = NewTLSConfig(&config.TLS{
+		MinVersion:   "TLS13",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 || len(tlsConfig.CipherSuites) != 2 ||
+		tlsConfig.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 || tlsConfig.CipherSuites[1] != tls.TLS_RSA_WITH_RC4_128_SHA {
+		t.Errorf("unexpected config: %+v", tlsConfig)
+	}
+
+	for _, cfg := range []*config.TLS{
+		{MinVersion: "SSL3"},
+		{CipherSuites: []string{"supu"}},
+		{ClientCA: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := NewTLSConfig(cfg); err == nil {
+			t.Errorf("expecting an error for %+v", cfg)
+		}
+	}
+}
+
+func TestNewTLSConfig_clientCA(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	tlsConfig, err := NewTLSConfig(&config.TLS{ClientCA: certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Errorf("unexpected client auth: %+v", tlsConfig)
+	}
+
+	tlsConfig, err = NewTLSConfig(&config.TLS{ClientCA: certFile, ClientCertOptional: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("unexpected client auth: %v", tlsConfig.ClientAuth)
+	}
+
+	// the key is not a certificate
+	if _, err := NewTLSConfig(&config.TLS{ClientCA: keyFile}); err != ErrInvalidClientCA {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewTLSConfig_mutualTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := NewTLSConfig(&config.TLS{ClientCA: certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certs}}}
+		defer client.CloseIdleConnections()
+		return client.Get(server.URL)
+	}
+
+	if _, err := get(); err == nil {
+		t.Error("expecting an error for the client without certificate")
+	}
+	resp, err := get(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+}
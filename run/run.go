@@ -0,0 +1,267 @@
+// Package run manages the ordered startup and shutdown of the components of a gateway
+package run
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/router"
+)
+
+// Errors aggregates the errors returned by several components
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Failer is implemented by the components that can fail after being started, like the routers
+type Failer interface {
+	// Failed returns a channel receiving the error that ended the component
+	Failed() <-chan error
+}
+
+// Manager starts the components after their dependencies and stops them in the reverse order
+type Manager struct {
+	// max time given to every component to start. Disabled if zero
+	StartTimeout time.Duration
+	// max time given to every component to stop. Disabled if zero
+	StopTimeout time.Duration
+
+	mu      sync.Mutex
+	nodes   map[string]*node
+	names   []string
+	started []*node
+}
+
+type node struct {
+	name      string
+	component lifecycle.Component
+	deps      []string
+}
+
+// New returns a manager applying the received timeouts to every component
+func New(startTimeout, stopTimeout time.Duration) *Manager {
+	return &Manager{
+		StartTimeout: startTimeout,
+		StopTimeout:  stopTimeout,
+		nodes:        map[string]*node{},
+	}
+}
+
+// Add registers a component, started once all the components it depends on are started
+func (m *Manager) Add(name string, c lifecycle.Component, dependsOn ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodes[name]; ok {
+		return fmt.Errorf("component [%s] already registered", name)
+	}
+	m.nodes[name] = &node{name: name, component: c, deps: dependsOn}
+	m.names = append(m.names, name)
+	return nil
+}
+
+// Start starts the components in dependency order. If a component fails to start, the already
+// started ones are stopped and all the errors are returned
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, err := m.order()
+	if err != nil {
+		return err
+	}
+	for _, n := range order {
+		startCtx, cancel := m.withTimeout(ctx, m.StartTimeout)
+		err := n.component.Start(startCtx)
+		cancel()
+		if err != nil {
+			errs := Errors{fmt.Errorf("starting [%s]: %s", n.name, err.Error())}
+			if stopErr := m.stop(context.Background()); stopErr != nil {
+				errs = append(errs, stopErr.(Errors)...)
+			}
+			return errs
+		}
+		m.started = append(m.started, n)
+	}
+	return nil
+}
+
+// Stop stops the started components in the reverse order, returning the aggregated errors
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stop(ctx)
+}
+
+// Run starts the components and blocks until the context is done or a started component fails.
+// Then it stops all of them and returns the aggregated errors
+func (m *Manager) Run(ctx context.Context) error {
+	if err := m.Start(ctx); err != nil {
+		return err
+	}
+
+	failed := make(chan error, len(m.started))
+	done := make(chan struct{})
+	defer close(done)
+	for _, n := range m.started {
+		if f, ok := n.component.(Failer); ok {
+			go func(name string, ch <-chan error) {
+				select {
+				case err := <-ch:
+					if err != nil {
+						failed <- fmt.Errorf("running [%s]: %s", name, err.Error())
+					}
+				case <-done:
+				}
+			}(n.name, f.Failed())
+		}
+	}
+
+	var errs Errors
+	select {
+	case <-ctx.Done():
+	case err := <-failed:
+		errs = append(errs, err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (m *Manager) stop(ctx context.Context) error {
+	var errs Errors
+	for i := len(m.started) - 1; i >= 0; i-- {
+		n := m.started[i]
+		stopCtx, cancel := m.withTimeout(ctx, m.StopTimeout)
+		if err := n.component.Stop(stopCtx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping [%s]: %s", n.name, err.Error()))
+		}
+		cancel()
+	}
+	m.started = nil
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// order sorts the components so every one comes after its dependencies, keeping the registration
+// order among the independent ones
+func (m *Manager) order() ([]*node, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	order := make([]*node, 0, len(m.nodes))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		n, ok := m.nodes[name]
+		if !ok {
+			return fmt.Errorf("unknown dependency [%s] of [%s]", name, path[len(path)-1])
+		}
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		for _, dep := range n.deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, n)
+		return nil
+	}
+
+	for _, name := range m.names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func (m *Manager) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Router adapts a router to the Component interface. The router serves until the component is stopped,
+// so it must be a router.ContextRunner or the component fails to start
+func Router(r router.Router, cfg config.ServiceConfig) lifecycle.Component {
+	return &routerComponent{router: r, cfg: cfg, failed: make(chan error, 1)}
+}
+
+type routerComponent struct {
+	router router.Router
+	cfg    config.ServiceConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+	failed chan error
+}
+
+func (r *routerComponent) Start(_ context.Context) error {
+	runner, ok := r.router.(router.ContextRunner)
+	if !ok {
+		return router.ErrRunWithContextNotSupported
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		if err := runner.RunWithContext(ctx, r.cfg); err != nil {
+			r.failed <- err
+		}
+		close(r.done)
+	}()
+	return nil
+}
+
+func (r *routerComponent) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *routerComponent) Failed() <-chan error {
+	return r.failed
+}
+
+// StopFunc adapts a component started on creation, like the rate limiters, to the Component interface
+func StopFunc(stop func()) lifecycle.Component {
+	return lifecycle.Funcs{
+		StartFunc: func(_ context.Context) error { return nil },
+		StopFunc: func(_ context.Context) error {
+			stop()
+			return nil
+		},
+	}
+}
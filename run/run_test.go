@@ -0,0 +1,137 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/router"
+)
+
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) component(name string, startErr, stopErr error) lifecycle.Component {
+	return lifecycle.Funcs{
+		StartFunc: func(_ context.Context) error {
+			r.events = append(r.events, "start "+name)
+			return startErr
+		},
+		StopFunc: func(_ context.Context) error {
+			r.events = append(r.events, "stop "+name)
+			return stopErr
+		},
+	}
+}
+
+func TestManager_order(t *testing.T) {
+	r := &recorder{}
+	m := New(0, 0)
+	m.Add("router", r.component("router", nil, nil), "health", "limiter")
+	m.Add("health", r.component("health", nil, nil))
+	m.Add("limiter", r.component("limiter", nil, nil), "health")
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	want := "start health,start limiter,start router,stop router,stop limiter,stop health"
+	if have := strings.Join(r.events, ","); have != want {
+		t.Errorf("want: %s, have: %s", want, have)
+	}
+}
+
+func TestManager_startFailure(t *testing.T) {
+	r := &recorder{}
+	m := New(0, 0)
+	m.Add("health", r.component("health", nil, errors.New("stop error")))
+	m.Add("router", r.component("router", errors.New("start error"), nil), "health")
+
+	err := m.Start(context.Background())
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 2 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := "start health,start router,stop health"
+	if have := strings.Join(r.events, ","); have != want {
+		t.Errorf("want: %s, have: %s", want, have)
+	}
+}
+
+func TestManager_cycle(t *testing.T) {
+	r := &recorder{}
+	m := New(0, 0)
+	m.Add("a", r.component("a", nil, nil), "b")
+	m.Add("b", r.component("b", nil, nil), "a")
+	if err := m.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(r.events) != 0 {
+		t.Errorf("unexpected events: %v", r.events)
+	}
+}
+
+type failingComponent struct {
+	lifecycle.Component
+	failed chan error
+}
+
+func (f failingComponent) Failed() <-chan error { return f.failed }
+
+func TestManager_Run_failure(t *testing.T) {
+	r := &recorder{}
+	failed := make(chan error, 1)
+	m := New(0, 0)
+	m.Add("router", failingComponent{r.component("router", nil, nil), failed})
+	failed <- errors.New("address in use")
+
+	if err := m.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "address in use") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if strings.Join(r.events, ",") != "start router,stop router" {
+		t.Errorf("unexpected events: %v", r.events)
+	}
+}
+
+type signalRouter struct{}
+
+func (signalRouter) Run(_ config.ServiceConfig) error { return nil }
+
+type contextRouter struct {
+	signalRouter
+	stopped chan struct{}
+}
+
+func (c contextRouter) RunWithContext(ctx context.Context, _ config.ServiceConfig) error {
+	<-ctx.Done()
+	close(c.stopped)
+	return nil
+}
+
+func TestRouter(t *testing.T) {
+	if err := Router(signalRouter{}, config.ServiceConfig{}).Start(context.Background()); err != router.ErrRunWithContextNotSupported {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	r := contextRouter{stopped: make(chan struct{})}
+	c := Router(r, config.ServiceConfig{})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-r.stopped:
+	default:
+		t.Error("the router was not stopped")
+	}
+}
@@ -0,0 +1,102 @@
+package sd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/ph0m1/porta/lifecycle"
+)
+
+// NewCachedSubscriber returns a subscriber caching the hosts returned by the received one during the ttl.
+// Once the ttl expires, the cached hosts are still returned while a single background refresh is in
+// progress, so the discovery backend is not queried on every request. The concurrent calls loading the
+// first hosts share a single query as well. The background refreshes run in the group, so stopping it
+// waits for the refresh in progress. Once the group is stopped, the callers load the expired hosts
+// by themselves
+func NewCachedSubscriber(subscriber Subscriber, ttl time.Duration, g *lifecycle.Group) Subscriber {
+	return &cachedSubscriber{
+		subscriber: subscriber,
+		ttl:        ttl,
+		now:        time.Now,
+		background: g,
+	}
+}
+
+type cachedSubscriber struct {
+	subscriber Subscriber
+	ttl        time.Duration
+	// clock of the expirations, replaced by the tests
+	now func() time.Time
+	// group sharing the in-flight query between the callers
+	group singleflight.Group
+	// group running the background refreshes
+	background *lifecycle.Group
+
+	mu         sync.RWMutex
+	hosts      []string
+	expiration time.Time
+	loaded     bool
+	refreshing bool
+}
+
+// Hosts implements the Subscriber interface
+func (c *cachedSubscriber) Hosts() ([]string, error) {
+	c.mu.RLock()
+	hosts, loaded, expired := c.hosts, c.loaded, c.now().After(c.expiration)
+	c.mu.RUnlock()
+
+	if !loaded || (expired && c.background.Context().Err() != nil) {
+		return c.refresh()
+	}
+	if expired {
+		c.mu.Lock()
+		if !c.refreshing {
+			c.refreshing = true
+			c.background.Go(func(_ context.Context) { c.refresh() })
+		}
+		c.mu.Unlock()
+	}
+	return hosts, nil
+}
+
+// refresh queries the subscriber, joining the query in progress if any
+func (c *cachedSubscriber) refresh() ([]string, error) {
+	v, err, _ := c.group.Do("hosts", func() (interface{}, error) {
+		return c.load()
+	})
+	hosts, _ := v.([]string)
+	return hosts, err
+}
+
+func (c *cachedSubscriber) load() ([]string, error) {
+	c.mu.Lock()
+	if c.loaded && !c.now().After(c.expiration) {
+		// the hosts were loaded by a query ended since the caller checked them
+		c.refreshing = false
+		hosts := c.hosts
+		c.mu.Unlock()
+		return hosts, nil
+	}
+	c.mu.Unlock()
+
+	hosts, err := c.subscriber.Hosts()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		// keep serving the last known hosts and retry after the ttl
+		if c.loaded {
+			c.expiration = c.now().Add(c.ttl)
+			return c.hosts, nil
+		}
+		return hosts, err
+	}
+	c.hosts = hosts
+	c.loaded = true
+	c.expiration = c.now().Add(c.ttl)
+	return hosts, nil
+}
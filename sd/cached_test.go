@@ -0,0 +1,163 @@
+package sd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+)
+
+type countingSubscriber struct {
+	calls uint64
+	hosts []string
+	err   error
+	// if not nil, the queries wait for it to be closed after signaling the called channel
+	release chan struct{}
+	called  chan struct{}
+}
+
+func (s *countingSubscriber) Hosts() ([]string, error) {
+	atomic.AddUint64(&s.calls, 1)
+	if s.release != nil {
+		s.called <- struct{}{}
+		<-s.release
+	}
+	return s.hosts, s.err
+}
+
+// fakeClock is the clock of the cached subscribers of the tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// newTestCachedSubscriber returns a cached subscriber whose background refreshes end with the test
+func newTestCachedSubscriber(t *testing.T, subscriber Subscriber, ttl time.Duration) (*cachedSubscriber, *fakeClock) {
+	g := lifecycle.NewGroup(context.Background())
+	t.Cleanup(func() { g.Stop(context.Background()) })
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cached := NewCachedSubscriber(subscriber, ttl, g).(*cachedSubscriber)
+	cached.now = clock.Now
+	return cached, clock
+}
+
+func TestCachedSubscriber(t *testing.T) {
+	subscriber := &countingSubscriber{hosts: []string{"a", "b"}}
+	cached, clock := newTestCachedSubscriber(t, subscriber, 10*time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		hosts, err := cached.Hosts()
+		if err != nil {
+			t.Error(err)
+		}
+		if len(hosts) != 2 {
+			t.Errorf("unexpected hosts: %v", hosts)
+		}
+	}
+	if calls := atomic.LoadUint64(&subscriber.calls); calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+
+	clock.Add(20 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if hosts, err := cached.Hosts(); err != nil || len(hosts) != 2 {
+			t.Errorf("unexpected result: %v, %v", hosts, err)
+		}
+	}
+	// stopping the group waits for the refresh in progress
+	cached.background.Stop(context.Background())
+	if calls := atomic.LoadUint64(&subscriber.calls); calls != 2 {
+		t.Errorf("the cache should have been refreshed once. calls: %d", calls)
+	}
+}
+
+func TestCachedSubscriber_concurrentLoad(t *testing.T) {
+	subscriber := &countingSubscriber{hosts: []string{"a"}, release: make(chan struct{}), called: make(chan struct{}, 10)}
+	cached, _ := newTestCachedSubscriber(t, subscriber, time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if hosts, err := cached.Hosts(); err != nil || len(hosts) != 1 {
+				t.Errorf("unexpected result: %v, %v", hosts, err)
+			}
+		}()
+	}
+	<-subscriber.called
+	close(subscriber.release)
+	wg.Wait()
+
+	if calls := atomic.LoadUint64(&subscriber.calls); calls != 1 {
+		t.Errorf("the first hosts should have been loaded once. calls: %d", calls)
+	}
+}
+
+func TestCachedSubscriber_keepsLastHosts(t *testing.T) {
+	subscriber := &countingSubscriber{hosts: []string{"a"}}
+	cached, clock := newTestCachedSubscriber(t, subscriber, time.Millisecond)
+	cached.Hosts()
+
+	subscriber.err = errors.New("supu")
+	subscriber.hosts = nil
+
+	clock.Add(5 * time.Millisecond)
+	if _, err := cached.refresh(); err != nil {
+		t.Error(err)
+	}
+	hosts, err := cached.Hosts()
+	if err != nil || len(hosts) != 1 || hosts[0] != "a" {
+		t.Errorf("unexpected result: %v, %v", hosts, err)
+	}
+	if calls := atomic.LoadUint64(&subscriber.calls); calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestCachedSubscriber_erroredSubscriber(t *testing.T) {
+	want := "supu"
+	cached, _ := newTestCachedSubscriber(t, erroredSubscriber(want), time.Second)
+	if _, err := cached.Hosts(); err == nil || err.Error() != want {
+		t.Errorf("want %s, have %v", want, err)
+	}
+}
+
+func TestCachedSubscriber_stop(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	subscriber := &countingSubscriber{hosts: []string{"a"}}
+	cached, clock := newTestCachedSubscriber(t, subscriber, time.Millisecond)
+	cached.Hosts()
+	if err := cached.background.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the expired hosts are loaded by the callers once the group is stopped
+	subscriber.hosts = []string{"b"}
+	clock.Add(5 * time.Millisecond)
+	hosts, err := cached.Hosts()
+	if err != nil || len(hosts) != 1 || hosts[0] != "b" {
+		t.Errorf("unexpected result: %v, %v", hosts, err)
+	}
+	if calls := atomic.LoadUint64(&subscriber.calls); calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
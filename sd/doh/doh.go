@@ -0,0 +1,227 @@
+// Package doh provides a DNS-over-HTTPS (RFC 8484) resolver for the hostnames of the backends
+package doh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+// ErrNoAddresses is returned when the resolver gets an answer without addresses
+var ErrNoAddresses = errors.New("no addresses found")
+
+var (
+	resolutionDuration = metrics.NewHistogram("porta_dns_resolution_duration_seconds", "Duration of the resolutions of the backend hostnames in seconds", nil, "source")
+	resolutionFailures = metrics.NewCounter("porta_dns_resolution_failures_total", "Total number of failed resolutions of the backend hostnames", "source")
+)
+
+// Resolver resolves hostnames with a DoH server, caching the answers during their TTL and falling
+// back to the system DNS when the DoH server fails. The zero value of the fields not set by New is
+// valid, so the resolver can be declared as a literal
+type Resolver struct {
+	// URL of the DoH endpoint, like https://cloudflare-dns.com/dns-query
+	URL string
+	// client used to query the DoH server. It must not depend on the resolver itself. The
+	// http.DefaultClient if nil
+	Client *http.Client
+	// resolver used when the DoH server fails. Nil disables the fallback
+	Fallback *net.Resolver
+	// min time to cache an answer, overriding shorter TTLs
+	MinTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+type entry struct {
+	addrs     []string
+	expiresAt time.Time
+}
+
+// New returns a resolver querying the DoH endpoint and falling back to the system DNS
+func New(url string) *Resolver {
+	return &Resolver{
+		URL:      url,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+		Fallback: net.DefaultResolver,
+		MinTTL:   time.Second,
+	}
+}
+
+// LookupHost returns the addresses of the host
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+
+	r.mu.Lock()
+	e, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(e.expiresAt) {
+		return e.addrs, nil
+	}
+
+	start := time.Now()
+	addrs, ttl, err := r.query(ctx, host)
+	resolutionDuration.Observe(time.Since(start).Seconds(), "doh")
+	if err == nil {
+		if ttl < r.MinTTL {
+			ttl = r.MinTTL
+		}
+		r.mu.Lock()
+		if r.cache == nil {
+			r.cache = map[string]entry{}
+		}
+		r.cache[host] = entry{addrs: addrs, expiresAt: time.Now().Add(ttl)}
+		r.mu.Unlock()
+		return addrs, nil
+	}
+	resolutionFailures.Add(1, "doh")
+
+	if r.Fallback == nil {
+		return nil, err
+	}
+	start = time.Now()
+	addrs, err = r.Fallback.LookupHost(ctx, host)
+	resolutionDuration.Observe(time.Since(start).Seconds(), "system")
+	if err != nil {
+		resolutionFailures.Add(1, "system")
+	}
+	return addrs, err
+}
+
+// DialContext resolves the host of the address with the resolver and connects to the first reachable
+// address. It can be used as the DialContext of an http.Transport
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// NewHTTPClient returns a client resolving the hostnames with the received resolver
+func NewHTTPClient(r *Resolver) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:       nil,
+			DialContext: r.DialContext,
+		},
+	}
+}
+
+func (r *Resolver) query(ctx context.Context, host string) ([]string, time.Duration, error) {
+	name, err := dnsmessage.NewName(dnsFQDN(host))
+	if err != nil {
+		return nil, 0, err
+	}
+	var addrs []string
+	var ttl time.Duration
+	var lastErr error
+	for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		a, aTTL, err := r.exchange(ctx, name, t)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(a) > 0 && (ttl == 0 || aTTL < ttl) {
+			ttl = aTTL
+		}
+		addrs = append(addrs, a...)
+	}
+	if len(addrs) == 0 {
+		if lastErr == nil {
+			lastErr = ErrNoAddresses
+		}
+		return nil, 0, lastErr
+	}
+	return addrs, ttl, nil
+}
+
+func (r *Resolver) exchange(ctx context.Context, name dnsmessage.Name, t dnsmessage.Type) ([]string, time.Duration, error) {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH server answered with status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(body); err != nil {
+		return nil, 0, err
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DoH server answered with rcode %s", answer.RCode)
+	}
+	var addrs []string
+	var ttl uint32
+	for _, a := range answer.Answers {
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IP(body.AAAA[:]).String())
+		default:
+			continue
+		}
+		if ttl == 0 || a.Header.TTL < ttl {
+			ttl = a.Header.TTL
+		}
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+func dnsFQDN(host string) string {
+	if len(host) > 0 && host[len(host)-1] == '.' {
+		return host
+	}
+	return host + "."
+}
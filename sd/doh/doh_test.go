@@ -0,0 +1,79 @@
+package doh
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResolver_LookupHost(t *testing.T) {
+	queries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		body, _ := ioutil.ReadAll(r.Body)
+		var msg dnsmessage.Message
+		if err := msg.Unpack(body); err != nil {
+			t.Error(err)
+			return
+		}
+		msg.Header.Response = true
+		if msg.Questions[0].Type == dnsmessage.TypeA {
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+			}}
+		}
+		packed, _ := msg.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer ts.Close()
+
+	r := New(ts.URL)
+	r.Fallback = nil
+	for i := 0; i < 2; i++ {
+		addrs, err := r.LookupHost(context.Background(), "backend.local")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+			t.Errorf("unexpected addresses: %v", addrs)
+		}
+	}
+	if queries != 2 {
+		t.Errorf("the answer was not cached: %d queries", queries)
+	}
+
+	literal := &Resolver{URL: ts.URL}
+	for i := 0; i < 2; i++ {
+		if addrs, err := literal.LookupHost(context.Background(), "backend.local"); err != nil || len(addrs) != 1 {
+			t.Errorf("unexpected addresses of the literal resolver: %v %v", addrs, err)
+		}
+	}
+	if queries != 4 {
+		t.Errorf("the answer of the literal resolver was not cached: %d queries", queries)
+	}
+}
+
+func TestResolver_LookupHost_fallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	r := New(ts.URL)
+	addrs, err := r.LookupHost(context.Background(), "localhost")
+	if err != nil || len(addrs) == 0 {
+		t.Errorf("the system resolver was not used: %v %v", addrs, err)
+	}
+
+	r.Fallback = nil
+	if _, err := r.LookupHost(context.Background(), "localhost"); err == nil {
+		t.Error("error expected")
+	}
+}
@@ -0,0 +1,123 @@
+package sd
+
+import (
+	"sort"
+	"sync"
+)
+
+// HostHealth keeps the health of the hosts reported by the health checks. A host becomes unhealthy
+// after a run of failed checks and healthy again after a run of passed ones, so a host answering
+// intermittently does not flap in and out of the balancers. The hosts never reported are healthy
+type HostHealth struct {
+	mu             sync.RWMutex
+	unhealthyAfter int
+	healthyAfter   int
+	hosts          map[string]*hostHealth
+}
+
+type hostHealth struct {
+	healthy bool
+	// consecutive results contradicting the current state
+	streak int
+}
+
+// DefaultHostHealth is the health of the hosts used by the balancers of the proxies
+var DefaultHostHealth = NewHostHealth(3, 2)
+
+// NewHostHealth returns an empty health registry marking the hosts as unhealthy after the given failed
+// checks in a row and as healthy after the given passed ones. The thresholds below 1 are set to 1
+func NewHostHealth(unhealthyAfter, healthyAfter int) *HostHealth {
+	h := &HostHealth{hosts: map[string]*hostHealth{}}
+	h.SetThresholds(unhealthyAfter, healthyAfter)
+	return h
+}
+
+// SetThresholds changes the number of checks in a row flipping the state of the hosts
+func (h *HostHealth) SetThresholds(unhealthyAfter, healthyAfter int) {
+	if unhealthyAfter < 1 {
+		unhealthyAfter = 1
+	}
+	if healthyAfter < 1 {
+		healthyAfter = 1
+	}
+	h.mu.Lock()
+	h.unhealthyAfter, h.healthyAfter = unhealthyAfter, healthyAfter
+	h.mu.Unlock()
+}
+
+// Report records the result of a check of the host, returning true if it changed the state of the host
+func (h *HostHealth) Report(host string, passed bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.hosts[host]
+	if !ok {
+		s = &hostHealth{healthy: true}
+		h.hosts[host] = s
+	}
+	if passed == s.healthy {
+		s.streak = 0
+		return false
+	}
+	s.streak++
+	threshold := h.unhealthyAfter
+	if passed {
+		threshold = h.healthyAfter
+	}
+	if s.streak < threshold {
+		return false
+	}
+	s.healthy, s.streak = passed, 0
+	return true
+}
+
+// Healthy tells if the host is healthy
+func (h *HostHealth) Healthy(host string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s, ok := h.hosts[host]
+	return !ok || s.healthy
+}
+
+// Unhealthy returns the sorted list of the unhealthy hosts
+func (h *HostHealth) Unhealthy() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := []string{}
+	for host, s := range h.hosts {
+		if !s.healthy {
+			res = append(res, host)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// NewHealthySubscriber returns a subscriber skipping the unhealthy hosts of the received one. If all
+// the hosts are unhealthy, all of them are returned: a broken health check must not take down a
+// backend that may still answer
+func NewHealthySubscriber(subscriber Subscriber, health *HostHealth) Subscriber {
+	return healthySubscriber{subscriber, health}
+}
+
+type healthySubscriber struct {
+	subscriber Subscriber
+	health     *HostHealth
+}
+
+// Hosts implements the Subscriber interface
+func (s healthySubscriber) Hosts() ([]string, error) {
+	hosts, err := s.subscriber.Hosts()
+	if err != nil {
+		return hosts, err
+	}
+	healthy := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if s.health.Healthy(h) {
+			healthy = append(healthy, h)
+		}
+	}
+	if len(healthy) == 0 {
+		return hosts, nil
+	}
+	return healthy, nil
+}
@@ -0,0 +1,56 @@
+package sd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostHealth_hysteresis(t *testing.T) {
+	h := NewHostHealth(3, 2)
+	for i, passed := range []bool{false, false, true, false, false} {
+		if h.Report("a", passed) {
+			t.Errorf("#%d: the state changed before the threshold", i)
+		}
+	}
+	if !h.Healthy("a") {
+		t.Fatal("the host must be healthy until 3 checks in a row fail")
+	}
+	if !h.Report("a", false) || h.Healthy("a") {
+		t.Fatal("the host must be unhealthy after 3 failed checks in a row")
+	}
+	if h.Report("a", true) || h.Healthy("a") {
+		t.Error("a single passed check must not restore the host")
+	}
+	if !h.Report("a", true) || !h.Healthy("a") {
+		t.Error("the host must be healthy after 2 passed checks in a row")
+	}
+	if !h.Healthy("unknown") {
+		t.Error("the hosts never reported must be healthy")
+	}
+}
+
+func TestHealthySubscriber(t *testing.T) {
+	h := NewHostHealth(1, 1)
+	s := NewHealthySubscriber(FixedSubscriber{"a", "b", "c"}, h)
+	h.Report("b", false)
+	if hosts, _ := s.Hosts(); strings.Join(hosts, ",") != "a,c" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+	h.Report("a", false)
+	h.Report("c", false)
+	if hosts, _ := s.Hosts(); strings.Join(hosts, ",") != "a,b,c" {
+		t.Errorf("all the hosts must be returned when none is healthy: %v", hosts)
+	}
+	if u := h.Unhealthy(); strings.Join(u, ",") != "a,b,c" {
+		t.Errorf("unexpected unhealthy hosts: %v", u)
+	}
+
+	lb := NewRoundRobinLB(s)
+	h.Report("a", true)
+	h.Report("c", true)
+	for i := 0; i < 4; i++ {
+		if host, _ := lb.Host(); host == "b" {
+			t.Error("the balancer selected the unhealthy host")
+		}
+	}
+}
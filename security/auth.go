@@ -1,12 +1,18 @@
 package security
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/hmac"
+	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -18,9 +24,25 @@ import (
 type AuthConfig struct {
 	JWTSecret     string              `json:"jwt_secret"`
 	JWTExpiration time.Duration       `json:"jwt_expiration"`
-	APIKeys       map[string]string   `json:"api_keys"`       // key -> client_id
+	APIKeys       map[string]string   `json:"api_keys"`       // key -> client_id, ignored if KeyStore is set
 	BasicAuth     map[string]string   `json:"basic_auth"`     // username -> password
-	RequiredRoles map[string][]string `json:"required_roles"` // endpoint -> roles
+	RequiredRoles map[string][]string `json:"required_roles"` // endpoint pattern -> roles, for all the methods
+	// endpoint pattern -> scopes of the scope or scp claims of the tokens, all of them required
+	RequiredScopes map[string][]string `json:"required_scopes"`
+	// access rules of the endpoints, extended with the required_roles ones
+	Policy *PolicyConfig `json:"policy"`
+	// JWKS of the identity provider. The tokens must be signed with one of its RSA or ECDSA keys
+	// instead of the shared secret if set
+	JWKSURL string `json:"jwks_url"`
+	// time the JWKS keys are used before fetching them again, like "15m" in the middleware settings
+	JWKSRefreshInterval time.Duration `json:"jwks_refresh_interval"`
+	// tolerance applied to the exp, nbf and iat claims, absorbing the clock drift with the issuer, like
+	// "30s" in the middleware settings
+	ClockSkew time.Duration `json:"clock_skew"`
+	// JSON file of the FileKeyStore holding the hashed API keys
+	APIKeysFile string `json:"api_keys_file"`
+	// store of the API keys, replacing the APIKeys map
+	KeyStore KeyStore `json:"-"`
 }
 
 // Claims represents JWT claims
@@ -28,27 +50,89 @@ type Claims struct {
 	UserID   string   `json:"user_id"`
 	ClientID string   `json:"client_id"`
 	Roles    []string `json:"roles"`
+	// scopes granted by the OAuth2 authorization server
+	Scope ScopeList `json:"scope,omitempty"`
+	Scp   ScopeList `json:"scp,omitempty"`
+	// tenant of the client
+	Tenant string `json:"tenant,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ScopeList is a scope claim, either a space separated string, as in RFC 8693, or a list of strings
+type ScopeList []string
+
+// UnmarshalJSON accepts both formats of the scope claims
+func (s *ScopeList) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = stringList(v)
+	return nil
+}
+
+// MarshalJSON encodes the scopes as a space separated string
+func (s ScopeList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.Join(s, " "))
+}
+
 // AuthContext holds authentication information
 type AuthContext struct {
 	UserID     string
 	ClientID   string
 	Roles      []string
 	AuthMethod string
+	// claims of the token, enriched with the userinfo ones, for the OpenID Connect authentication
+	Claims map[string]interface{}
+	// scopes of the OAuth2 tokens
+	Scopes []string
+	// rate limit tier of the API key
+	Tier string
+	// tenant of the API key or of the token
+	Tenant string
 }
 
 // AuthMiddleware provides authentication middleware
 type AuthMiddleware struct {
 	config *AuthConfig
+	jwks   *JWKS
+	keys   KeyStore
+	policy *Policy
 }
 
 // NewAuthMiddleware creates a new authentication middleware
 func NewAuthMiddleware(config *AuthConfig) *AuthMiddleware {
-	return &AuthMiddleware{
+	am := &AuthMiddleware{
 		config: config,
+		keys:   config.KeyStore,
+	}
+	if am.keys == nil {
+		am.keys = StaticKeyStore(config.APIKeys)
+	}
+
+	policy := PolicyConfig{}
+	if config.Policy != nil {
+		policy = *config.Policy
+		policy.Rules = append([]PolicyRule{}, policy.Rules...)
+	}
+	required := map[string]PolicyRule{}
+	for endpoint, roles := range config.RequiredRoles {
+		required[endpoint] = PolicyRule{Endpoint: endpoint, Roles: roles}
 	}
+	for endpoint, scopes := range config.RequiredScopes {
+		rule := required[endpoint]
+		rule.Endpoint, rule.Scopes = endpoint, scopes
+		required[endpoint] = rule
+	}
+	for _, rule := range required {
+		policy.Rules = append(policy.Rules, rule)
+	}
+	am.policy = NewPolicy(policy)
+	if config.JWKSURL != "" {
+		am.jwks = NewJWKS(config.JWKSURL)
+		am.jwks.RefreshInterval = config.JWKSRefreshInterval
+	}
+	return am
 }
 
 // Authenticate validates the request and returns auth context
@@ -67,12 +151,12 @@ func (am *AuthMiddleware) Authenticate(r *http.Request) (*AuthContext, error) {
 
 	// Try API Key authentication
 	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
-		return am.validateAPIKey(apiKey)
+		return am.validateAPIKey(r.Context(), apiKey)
 	}
 
 	// Try query parameter API key
 	if apiKey := r.URL.Query().Get("api_key"); apiKey != "" {
-		return am.validateAPIKey(apiKey)
+		return am.validateAPIKey(r.Context(), apiKey)
 	}
 
 	return nil, errors.New("no valid authentication provided")
@@ -80,40 +164,73 @@ func (am *AuthMiddleware) Authenticate(r *http.Request) (*AuthContext, error) {
 
 // validateJWT validates a JWT token
 func (am *AuthMiddleware) validateJWT(tokenString string) (*AuthContext, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(am.config.JWTSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, am.jwtKey, jwt.WithLeeway(am.config.ClockSkew))
 
 	if err != nil {
 		return nil, fmt.Errorf("invalid JWT token: %w", err)
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if claims.UserID == "" {
+			claims.UserID = claims.Subject
+		}
 		return &AuthContext{
 			UserID:     claims.UserID,
 			ClientID:   claims.ClientID,
 			Roles:      claims.Roles,
+			Scopes:     append(claims.Scope, claims.Scp...),
 			AuthMethod: "jwt",
+			Tenant:     claims.Tenant,
 		}, nil
 	}
 
 	return nil, errors.New("invalid JWT claims")
 }
 
-// validateAPIKey validates an API key
-func (am *AuthMiddleware) validateAPIKey(apiKey string) (*AuthContext, error) {
-	if clientID, exists := am.config.APIKeys[apiKey]; exists {
-		return &AuthContext{
-			ClientID:   clientID,
-			Roles:      []string{"api_user"},
-			AuthMethod: "api_key",
-		}, nil
+// jwtKey returns the key verifying the token: the shared secret for the HMAC tokens or, if the JWKS
+// is set, the public key selected by the kid of the token
+func (am *AuthMiddleware) jwtKey(token *jwt.Token) (interface{}, error) {
+	if am.jwks == nil {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(am.config.JWTSecret), nil
 	}
 
-	return nil, errors.New("invalid API key")
+	kid, _ := token.Header["kid"].(string)
+	key, err := am.jwks.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %v for the key [%s]", token.Header["alg"], kid)
+}
+
+// validateAPIKey validates an API key against the key store, rejecting the revoked and expired ones
+func (am *AuthMiddleware) validateAPIKey(ctx context.Context, apiKey string) (*AuthContext, error) {
+	k, err := am.keys.Lookup(ctx, HashAPIKey(apiKey))
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Valid(time.Now()); err != nil {
+		return nil, err
+	}
+	return &AuthContext{
+		ClientID:   k.ClientID,
+		Roles:      k.Roles,
+		AuthMethod: "api_key",
+		Tier:       k.Tier,
+		Tenant:     k.Tenant,
+	}, nil
 }
 
 // validateBasicAuth validates basic authentication
@@ -141,24 +258,9 @@ func (am *AuthMiddleware) validateBasicAuth(authHeader string) (*AuthContext, er
 	return nil, errors.New("invalid basic auth credentials")
 }
 
-// Authorize checks if the auth context has required permissions
-func (am *AuthMiddleware) Authorize(authCtx *AuthContext, endpoint string) error {
-	requiredRoles, exists := am.config.RequiredRoles[endpoint]
-	if !exists {
-		// No specific roles required for this endpoint
-		return nil
-	}
-
-	// Check if user has any of the required roles
-	for _, userRole := range authCtx.Roles {
-		for _, requiredRole := range requiredRoles {
-			if userRole == requiredRole {
-				return nil
-			}
-		}
-	}
-
-	return fmt.Errorf("insufficient permissions: requires one of %v, has %v", requiredRoles, authCtx.Roles)
+// Authorize checks if the auth context has the permissions required by the policy to send the request
+func (am *AuthMiddleware) Authorize(authCtx *AuthContext, method, path string) error {
+	return am.policy.Authorize(authCtx, method, path)
 }
 
 // HTTPMiddleware returns an HTTP middleware function
@@ -180,14 +282,16 @@ func (am *AuthMiddleware) HTTPMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Check authorization
-		if err := am.Authorize(authCtx, r.URL.Path); err != nil {
+		if err := am.Authorize(authCtx, r.Method, r.URL.Path); err != nil {
+			if errors.Is(err, ErrInsufficientScope) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			}
 			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
 			return
 		}
 
 		// Add auth context to request context
-		ctx := context.WithValue(r.Context(), "auth", authCtx)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, withAuthContext(r, authCtx))
 	})
 }
 
@@ -211,29 +315,50 @@ func (am *AuthMiddleware) GenerateJWT(userID, clientID string, roles []string) (
 
 // GetAuthContext extracts auth context from request context
 func GetAuthContext(r *http.Request) (*AuthContext, bool) {
-	authCtx, ok := r.Context().Value("auth").(*AuthContext)
+	return AuthContextFrom(r.Context())
+}
+
+// AuthContextFrom extracts the auth context from a context derived from the request one, like the
+// contexts received by the proxies
+func AuthContextFrom(ctx context.Context) (*AuthContext, bool) {
+	authCtx, ok := ctx.Value("auth").(*AuthContext)
 	return authCtx, ok
 }
 
-// SignatureAuth provides request signature authentication
+// SignatureWindow is the max difference between the timestamp of the signed requests and the clock of
+// the gateway
+const SignatureWindow = 5 * time.Minute
+
+// SignatureAuth provides request signature authentication. The X-Signature header holds the base64 HMAC
+// SHA256 of the method, path, query, X-Timestamp, X-Client-ID, X-Nonce and hex SHA256 of the body, joined
+// by new lines. Every nonce is accepted once during the signature window
 type SignatureAuth struct {
 	secrets map[string]string // client_id -> secret
+	nonces  NonceStore
 }
 
-// NewSignatureAuth creates a new signature authentication
+// NewSignatureAuth creates a new signature authentication remembering the nonces in memory
 func NewSignatureAuth(secrets map[string]string) *SignatureAuth {
+	return NewSharedSignatureAuth(secrets, NewMemoryNonceStore())
+}
+
+// NewSharedSignatureAuth creates a new signature authentication remembering the nonces in the received
+// store, like a redis one shared by the replicas of the gateway
+func NewSharedSignatureAuth(secrets map[string]string, nonces NonceStore) *SignatureAuth {
 	return &SignatureAuth{
 		secrets: secrets,
+		nonces:  nonces,
 	}
 }
 
-// ValidateSignature validates request signature
+// ValidateSignature validates request signature. The body of the request is restored after hashing it
 func (sa *SignatureAuth) ValidateSignature(r *http.Request) (*AuthContext, error) {
 	clientID := r.Header.Get("X-Client-ID")
 	signature := r.Header.Get("X-Signature")
 	timestamp := r.Header.Get("X-Timestamp")
+	nonce := r.Header.Get("X-Nonce")
 
-	if clientID == "" || signature == "" || timestamp == "" {
+	if clientID == "" || signature == "" || timestamp == "" || nonce == "" {
 		return nil, errors.New("missing signature headers")
 	}
 
@@ -248,8 +373,13 @@ func (sa *SignatureAuth) ValidateSignature(r *http.Request) (*AuthContext, error
 		return nil, errors.New("invalid timestamp format")
 	}
 
-	if time.Since(reqTime) > 5*time.Minute {
-		return nil, errors.New("request timestamp too old")
+	if skew := time.Since(reqTime); skew > SignatureWindow || skew < -SignatureWindow {
+		return nil, errors.New("request timestamp out of the signature window")
+	}
+
+	bodyHash, err := hashBody(r)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create signature string
@@ -257,8 +387,8 @@ func (sa *SignatureAuth) ValidateSignature(r *http.Request) (*AuthContext, error
 	path := r.URL.Path
 	query := r.URL.RawQuery
 
-	signatureString := fmt.Sprintf("%s\n%s\n%s\n%s\n%s",
-		method, path, query, timestamp, clientID)
+	signatureString := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s",
+		method, path, query, timestamp, clientID, nonce, bodyHash)
 
 	// Calculate expected signature
 	h := hmac.New(sha256.New, []byte(secret))
@@ -269,6 +399,15 @@ func (sa *SignatureAuth) ValidateSignature(r *http.Request) (*AuthContext, error
 		return nil, errors.New("invalid signature")
 	}
 
+	// the nonce is only recorded for valid signatures, so nobody can burn the nonces of a client
+	fresh, err := sa.nonces.Use(r.Context(), clientID+":"+nonce, 2*SignatureWindow)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, errors.New("replayed request")
+	}
+
 	return &AuthContext{
 		ClientID:   clientID,
 		Roles:      []string{"signed_user"},
@@ -276,48 +415,30 @@ func (sa *SignatureAuth) ValidateSignature(r *http.Request) (*AuthContext, error
 	}, nil
 }
 
-// OAuth2Config holds OAuth2 configuration
-type OAuth2Config struct {
-	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	RedirectURL  string `json:"redirect_url"`
-	AuthURL      string `json:"auth_url"`
-	TokenURL     string `json:"token_url"`
-	UserInfoURL  string `json:"user_info_url"`
-}
-
-// OAuth2Handler handles OAuth2 authentication flow
-type OAuth2Handler struct {
-	config *OAuth2Config
+// HTTPMiddleware returns an HTTP middleware rejecting the requests without a valid signature
+func (sa *SignatureAuth) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCtx, err := sa.ValidateSignature(r)
+		if err != nil {
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, withAuthContext(r, authCtx))
+	})
 }
 
-// NewOAuth2Handler creates a new OAuth2 handler
-func NewOAuth2Handler(config *OAuth2Config) *OAuth2Handler {
-	return &OAuth2Handler{
-		config: config,
+// hashBody returns the hex SHA256 of the body of the request, replacing the body with a copy
+func hashBody(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
 	}
-}
-
-// AuthURL generates OAuth2 authorization URL
-func (oh *OAuth2Handler) AuthURL(state string) string {
-	return fmt.Sprintf("%s?client_id=%s&redirect_uri=%s&response_type=code&state=%s",
-		oh.config.AuthURL, oh.config.ClientID, oh.config.RedirectURL, state)
-}
-
-// ExchangeCode exchanges authorization code for access token
-func (oh *OAuth2Handler) ExchangeCode(code string) (string, error) {
-	// Implementation would make HTTP request to token endpoint
-	// This is a simplified version
-	return "access_token_placeholder", nil
-}
-
-// GetUserInfo retrieves user information using access token
-func (oh *OAuth2Handler) GetUserInfo(accessToken string) (map[string]interface{}, error) {
-	// Implementation would make HTTP request to user info endpoint
-	// This is a simplified version
-	return map[string]interface{}{
-		"user_id": "oauth_user",
-		"email":   "user@example.com",
-		"roles":   []string{"oauth_user"},
-	}, nil
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
 }
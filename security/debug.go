@@ -0,0 +1,147 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Headers describing the decisions taken by the gateway for a request
+const (
+	DebugRateLimitKeyHeader = "X-Debug-RateLimit-Key"
+	DebugAuthMethodHeader   = "X-Debug-Auth-Method"
+	DebugBackendHostHeader  = "X-Debug-Backend-Host"
+)
+
+// DebugFlagHeader is the request header asking for the decision headers
+const DebugFlagHeader = "X-Porta-Debug"
+
+// Decisions collects the decisions taken by the middlewares and the proxies for a request
+type Decisions struct {
+	mu           sync.Mutex
+	rateLimitKey string
+	authMethod   string
+	roles        []string
+	backendHosts []string
+}
+
+type decisionsKey struct{}
+
+// WithDecisions returns a copy of the context collecting the decisions of the request
+func WithDecisions(ctx context.Context) (context.Context, *Decisions) {
+	d := &Decisions{}
+	return context.WithValue(ctx, decisionsKey{}, d), d
+}
+
+// DecisionsFrom returns the decisions collected for the request, if any
+func DecisionsFrom(ctx context.Context) (*Decisions, bool) {
+	d, ok := ctx.Value(decisionsKey{}).(*Decisions)
+	return d, ok
+}
+
+// RecordBackendHost records the host selected for a backend request
+func RecordBackendHost(ctx context.Context, host string) {
+	if d, ok := DecisionsFrom(ctx); ok {
+		d.mu.Lock()
+		d.backendHosts = append(d.backendHosts, host)
+		d.mu.Unlock()
+	}
+}
+
+func recordRateLimitKey(ctx context.Context, key string) {
+	if d, ok := DecisionsFrom(ctx); ok {
+		d.mu.Lock()
+		d.rateLimitKey = key
+		d.mu.Unlock()
+	}
+}
+
+// withAuthContext returns a copy of the request carrying the auth context
+func withAuthContext(r *http.Request, authCtx *AuthContext) *http.Request {
+	if d, ok := DecisionsFrom(r.Context()); ok {
+		d.mu.Lock()
+		d.authMethod, d.roles = authCtx.AuthMethod, authCtx.Roles
+		d.mu.Unlock()
+	}
+	return r.WithContext(context.WithValue(r.Context(), "auth", authCtx))
+}
+
+// DebugHeadersMiddleware adds the decision headers to the responses of the clients with the role or, if
+// allowFlag, of the requests with the DebugFlagHeader. The role is checked once the response starts,
+// so the auth middlewares run before
+type DebugHeadersMiddleware struct {
+	role      string
+	allowFlag bool
+}
+
+// NewDebugHeadersMiddleware creates the decision headers middleware
+func NewDebugHeadersMiddleware(role string, allowFlag bool) *DebugHeadersMiddleware {
+	return &DebugHeadersMiddleware{role: role, allowFlag: allowFlag}
+}
+
+// HTTPMiddleware returns an HTTP middleware function
+func (dm *DebugHeadersMiddleware) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, d := WithDecisions(r.Context())
+		flagged := dm.allowFlag && r.Header.Get(DebugFlagHeader) != ""
+		next.ServeHTTP(&debugWriter{ResponseWriter: w, decisions: d, role: dm.role, flagged: flagged}, r.WithContext(ctx))
+	})
+}
+
+type debugWriter struct {
+	http.ResponseWriter
+	decisions   *Decisions
+	role        string
+	flagged     bool
+	wroteHeader bool
+}
+
+func (w *debugWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.addHeaders()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *debugWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *debugWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *debugWriter) addHeaders() {
+	d := w.decisions
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !w.flagged && (w.role == "" || !contains(d.roles, w.role)) {
+		return
+	}
+	h := w.Header()
+	if d.rateLimitKey != "" {
+		h.Set(DebugRateLimitKeyHeader, d.rateLimitKey)
+	}
+	if d.authMethod != "" {
+		h.Set(DebugAuthMethodHeader, d.authMethod)
+	}
+	if len(d.backendHosts) > 0 {
+		h.Set(DebugBackendHostHeader, strings.Join(d.backendHosts, ", "))
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugHeadersMiddleware(t *testing.T) {
+	limiter := NewTokenBucketLimiter(&RateLimitConfig{RequestsPerSecond: 100, BurstSize: 100, WindowSize: time.Second, CleanupInterval: time.Minute})
+	defer limiter.Stop()
+	auth := NewAuthMiddleware(&AuthConfig{KeyStore: memoryKeyStore{
+		HashAPIKey("support"): {ClientID: "support", Roles: []string{"support"}},
+		HashAPIKey("client"):  {ClientID: "client", Roles: []string{"api_user"}},
+	}})
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RecordBackendHost(r.Context(), "http://10.0.0.1:8080")
+		w.Write([]byte("ok"))
+	})
+	newHandler := func(allowFlag bool) http.Handler {
+		h := auth.HTTPMiddleware(backend)
+		h = NewRateLimitMiddleware(limiter, IPKeyFunc).HTTPMiddleware(h)
+		return NewDebugHeadersMiddleware("support", allowFlag).HTTPMiddleware(h)
+	}
+	send := func(h http.Handler, key string, flag bool) http.Header {
+		r := httptest.NewRequest("GET", "/users", nil)
+		r.Header.Set("X-API-Key", key)
+		if flag {
+			r.Header.Set(DebugFlagHeader, "1")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Header()
+	}
+
+	h := send(newHandler(false), "support", false)
+	if h.Get(DebugAuthMethodHeader) != "api_key" || h.Get(DebugBackendHostHeader) != "http://10.0.0.1:8080" || h.Get(DebugRateLimitKeyHeader) == "" {
+		t.Errorf("unexpected headers for the support role: %v", h)
+	}
+	if h := send(newHandler(false), "client", true); h.Get(DebugAuthMethodHeader) != "" {
+		t.Errorf("the flag must be ignored if not allowed: %v", h)
+	}
+	if h := send(newHandler(true), "client", true); h.Get(DebugAuthMethodHeader) != "api_key" {
+		t.Errorf("the flag must enable the headers if allowed: %v", h)
+	}
+}
@@ -0,0 +1,187 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of the JWKS refresh
+const (
+	DefaultJWKSRefreshInterval = time.Hour
+	// minimum time between two refreshes triggered by tokens signed with an unknown key id, so a
+	// client sending random kids can not flood the identity provider
+	DefaultJWKSMinRefreshInterval = 5 * time.Minute
+)
+
+var errUnknownKeyID = errors.New("unknown key id")
+
+// JWKS keeps the public keys published by an identity provider as a JSON Web Key Set, like the ones of
+// Auth0, Keycloak or Cognito. The keys are fetched again once they are older than the refresh interval
+// and when a token is signed with an unknown key id, so the rotations are picked up without restarts
+type JWKS struct {
+	URL string
+	// time the keys are used before fetching them again. DefaultJWKSRefreshInterval if zero
+	RefreshInterval time.Duration
+	// minimum time between the refreshes caused by unknown key ids. DefaultJWKSMinRefreshInterval if zero
+	MinRefreshInterval time.Duration
+	Client             *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// NewJWKS returns a key set fetching its keys from the received url
+func NewJWKS(url string) *JWKS {
+	return &JWKS{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Key returns the public key with the received id. An empty kid selects the only key of the set
+func (j *JWKS) Key(kid string) (interface{}, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	if j.keys == nil || now.Sub(j.fetchedAt) > orDefault(j.RefreshInterval, DefaultJWKSRefreshInterval) {
+		if err := j.refresh(now); err != nil && j.keys == nil {
+			return nil, err
+		}
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	if now.Sub(j.fetchedAt) < orDefault(j.MinRefreshInterval, DefaultJWKSMinRefreshInterval) {
+		return nil, errUnknownKeyID
+	}
+	if err := j.refresh(now); err != nil {
+		return nil, err
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, errUnknownKeyID
+}
+
+func (j *JWKS) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// refresh replaces the keys with the ones published at the url. The fetch time is updated even on
+// failures, so an unavailable provider is not called on every request
+func (j *JWKS) refresh(now time.Time) error {
+	j.fetchedAt = now
+	client := j.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(j.URL)
+	if err != nil {
+		return fmt.Errorf("fetching the JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching the JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decoding the JWKS: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("decoding the key [%s] of the JWKS: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	j.keys = keys
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,84 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuthMiddleware_jwks(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+
+	var rotated, fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		keys := []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+		}
+		if atomic.LoadInt32(&rotated) == 1 {
+			keys = append(keys, map[string]string{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer ts.Close()
+
+	am := NewAuthMiddleware(&AuthConfig{JWKSURL: ts.URL, ClockSkew: time.Minute})
+	am.jwks.MinRefreshInterval = time.Nanosecond
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, exp time.Time) string {
+		token := jwt.NewWithClaims(method, jwt.RegisteredClaims{Subject: "supu", ExpiresAt: jwt.NewNumericDate(exp)})
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	authCtx, err := am.validateJWT(sign(jwt.SigningMethodRS256, "rsa", rsaKey, time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authCtx.UserID != "supu" {
+		t.Errorf("unexpected user: %s", authCtx.UserID)
+	}
+
+	// expired tokens are accepted within the clock skew
+	if _, err := am.validateJWT(sign(jwt.SigningMethodRS256, "rsa", rsaKey, time.Now().Add(-30*time.Second))); err != nil {
+		t.Errorf("unexpected error within the clock skew: %v", err)
+	}
+	if _, err := am.validateJWT(sign(jwt.SigningMethodRS256, "rsa", rsaKey, time.Now().Add(-2*time.Minute))); err == nil {
+		t.Error("expecting an error for an expired token")
+	}
+
+	ecToken := sign(jwt.SigningMethodES256, "ec", ecKey, time.Now().Add(time.Hour))
+	if _, err := am.validateJWT(ecToken); err == nil {
+		t.Error("expecting an error for an unknown kid")
+	}
+	atomic.StoreInt32(&rotated, 1)
+	if _, err := am.validateJWT(ecToken); err != nil {
+		t.Errorf("the rotated key was not picked up: %v", err)
+	}
+
+	// the keys can not be used with an algorithm of another family nor with the shared secret
+	if _, err := am.validateJWT(sign(jwt.SigningMethodHS256, "rsa", []byte("secret"), time.Now().Add(time.Hour))); err == nil {
+		t.Error("expecting an error for an HMAC token")
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 3 {
+		t.Errorf("unexpected number of fetches: %d", n)
+	}
+}
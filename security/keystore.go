@@ -0,0 +1,154 @@
+package security
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of the API key lookups
+var (
+	ErrUnknownAPIKey = errors.New("invalid API key")
+	ErrAPIKeyRevoked = errors.New("the API key was revoked")
+	ErrAPIKeyExpired = errors.New("the API key expired")
+)
+
+// APIKey is the record of an API key. The stores keep the hash of the keys, never the keys themselves
+type APIKey struct {
+	// sha256 of the key, as returned by HashAPIKey
+	Hash     string   `json:"hash"`
+	ClientID string   `json:"client_id"`
+	Roles    []string `json:"roles"`
+	// rate limit tier of the client, like free or premium
+	Tier string `json:"tier"`
+	// tenant owning the key
+	Tenant string `json:"tenant"`
+	// the key is rejected after this time. It does not expire if zero
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// Valid returns the reason the key can not be used, or nil
+func (k *APIKey) Valid(now time.Time) error {
+	if k.Revoked {
+		return ErrAPIKeyRevoked
+	}
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
+
+// KeyStore finds the records of the API keys by their hash. It returns ErrUnknownAPIKey if the store
+// does not know the key
+type KeyStore interface {
+	Lookup(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// HashAPIKey returns the hex encoded sha256 of the key, the id of the key in the stores
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// StaticKeyStore returns a store with the keys of the map, keyed by the plain key and holding the
+// client id, like the api_keys of the AuthConfig. The keys get the api_user role
+func StaticKeyStore(keys map[string]string) KeyStore {
+	s := memoryKeyStore{}
+	for key, clientID := range keys {
+		hash := HashAPIKey(key)
+		s[hash] = &APIKey{Hash: hash, ClientID: clientID, Roles: []string{"api_user"}}
+	}
+	return s
+}
+
+type memoryKeyStore map[string]*APIKey
+
+func (s memoryKeyStore) Lookup(_ context.Context, hash string) (*APIKey, error) {
+	if k, ok := s[hash]; ok {
+		return k, nil
+	}
+	return nil, ErrUnknownAPIKey
+}
+
+// DefaultKeyFileReloadInterval is the time between the checks of the modification time of the key files
+const DefaultKeyFileReloadInterval = 10 * time.Second
+
+// FileKeyStore keeps the keys of a JSON file, a list of APIKey records like
+// [{"hash":"<sha256>","client_id":"supu","roles":["admin"],"tier":"premium"}]. The file is loaded again
+// when it changes, so the keys can be added or revoked without restarting the gateway
+type FileKeyStore struct {
+	Path string
+	// time between the checks of the file. DefaultKeyFileReloadInterval if zero
+	ReloadInterval time.Duration
+
+	mu        sync.RWMutex
+	keys      memoryKeyStore
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewFileKeyStore returns a store with the keys of the file, failing if the file can not be loaded
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{Path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Lookup implements the KeyStore interface. The keys loaded last are kept if the file becomes invalid
+func (s *FileKeyStore) Lookup(ctx context.Context, hash string) (*APIKey, error) {
+	s.mu.RLock()
+	stale := time.Since(s.checkedAt) > orDefault(s.ReloadInterval, DefaultKeyFileReloadInterval)
+	s.mu.RUnlock()
+	if stale {
+		s.reloadIfChanged()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys.Lookup(ctx, hash)
+}
+
+// Reload loads the keys of the file
+func (s *FileKeyStore) Reload() error {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		return err
+	}
+	var records []*APIKey
+	if err := json.Unmarshal(b, &records); err != nil {
+		return err
+	}
+	keys := make(memoryKeyStore, len(records))
+	for _, k := range records {
+		keys[strings.ToLower(k.Hash)] = k
+	}
+
+	s.mu.Lock()
+	s.keys, s.modTime, s.checkedAt = keys, info.ModTime(), time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *FileKeyStore) reloadIfChanged() {
+	info, err := os.Stat(s.Path)
+	s.mu.Lock()
+	s.checkedAt = time.Now()
+	changed := err == nil && !info.ModTime().Equal(s.modTime)
+	s.mu.Unlock()
+	if changed {
+		s.Reload()
+	}
+}
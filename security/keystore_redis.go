@@ -0,0 +1,42 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisKeyPrefix is prepended to the hash of the API keys to build their redis keys
+const RedisKeyPrefix = "porta:apikey:"
+
+// NewRedisKeyStore returns a store reading the APIKey records, encoded as JSON, from the redis keys
+// made of the RedisKeyPrefix and the hash of the API key. The changes are visible on the next request
+func NewRedisKeyStore(pool *redis.Pool) KeyStore {
+	return redisKeyStore{pool}
+}
+
+type redisKeyStore struct {
+	pool *redis.Pool
+}
+
+func (s redisKeyStore) Lookup(ctx context.Context, hash string) (*APIKey, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("GET", RedisKeyPrefix+hash))
+	if err == redis.ErrNil {
+		return nil, ErrUnknownAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	k := &APIKey{}
+	if err := json.Unmarshal(b, k); err != nil {
+		return nil, err
+	}
+	k.Hash = hash
+	return k, nil
+}
@@ -0,0 +1,50 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// DefaultKeyQuery selects the record of an API key by its hash. The roles column holds a comma
+// separated list and the expires_at one is null for the keys that do not expire
+const DefaultKeyQuery = "SELECT client_id, roles, tier, expires_at, revoked FROM api_keys WHERE key_hash = ?"
+
+// SQLKeyStore reads the API keys from a database, so the revocations are effective right away. The
+// driver of the database is registered by the caller
+type SQLKeyStore struct {
+	DB *sql.DB
+	// query receiving the hash of the key and selecting its client_id, roles, tier, expires_at and
+	// revoked columns. DefaultKeyQuery if empty, whose placeholder must be adapted to the driver
+	Query string
+}
+
+// Lookup implements the KeyStore interface
+func (s SQLKeyStore) Lookup(ctx context.Context, hash string) (*APIKey, error) {
+	query := s.Query
+	if query == "" {
+		query = DefaultKeyQuery
+	}
+	var (
+		roles, tier sql.NullString
+		expiresAt   sql.NullTime
+	)
+	k := &APIKey{Hash: hash}
+	err := s.DB.QueryRowContext(ctx, query, hash).Scan(&k.ClientID, &roles, &tier, &expiresAt, &k.Revoked)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range strings.Split(roles.String, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			k.Roles = append(k.Roles, role)
+		}
+	}
+	k.Tier = tier.String
+	if expiresAt.Valid {
+		k.ExpiresAt = expiresAt.Time
+	}
+	return k, nil
+}
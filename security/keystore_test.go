@@ -0,0 +1,72 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(keys ...APIKey) {
+		b, _ := json.Marshal(keys)
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(
+		APIKey{Hash: HashAPIKey("supu"), ClientID: "client-1", Roles: []string{"admin"}, Tier: "premium"},
+		APIKey{Hash: HashAPIKey("tupu"), ClientID: "client-2", ExpiresAt: time.Now().Add(-time.Minute)},
+	)
+	store, err := NewFileKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.ReloadInterval = time.Nanosecond
+	am := NewAuthMiddleware(&AuthConfig{KeyStore: store})
+
+	authenticate := func(key string) (*AuthContext, error) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-API-Key", key)
+		return am.Authenticate(r)
+	}
+
+	authCtx, err := authenticate("supu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authCtx.ClientID != "client-1" || authCtx.Tier != "premium" || len(authCtx.Roles) != 1 || authCtx.Roles[0] != "admin" {
+		t.Errorf("unexpected auth context: %+v", authCtx)
+	}
+	if _, err := authenticate("tupu"); err != ErrAPIKeyExpired {
+		t.Errorf("unexpected error for the expired key: %v", err)
+	}
+	if _, err := authenticate("unknown"); err != ErrUnknownAPIKey {
+		t.Errorf("unexpected error for the unknown key: %v", err)
+	}
+
+	write(APIKey{Hash: HashAPIKey("supu"), ClientID: "client-1", Revoked: true})
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if _, err := authenticate("supu"); err != ErrAPIKeyRevoked {
+		t.Errorf("the revocation was not reloaded: %v", err)
+	}
+}
+
+func TestStaticKeyStore(t *testing.T) {
+	store := StaticKeyStore(map[string]string{"supu": "client-1"})
+	k, err := store.Lookup(context.Background(), HashAPIKey("supu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.ClientID != "client-1" || len(k.Roles) != 1 || k.Roles[0] != "api_user" {
+		t.Errorf("unexpected key: %+v", k)
+	}
+	if _, err := store.Lookup(context.Background(), "supu"); err != ErrUnknownAPIKey {
+		t.Errorf("the plain keys must not be accepted: %v", err)
+	}
+}
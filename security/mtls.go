@@ -0,0 +1,158 @@
+package security
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MTLSConfig holds the rules of the client certificates
+type MTLSConfig struct {
+	// PEM bundle of the CAs issuing the client certificates. The chains verified by the TLS server are
+	// trusted if empty
+	CAFile string `json:"ca_file"`
+	// DNS names, emails or URIs accepted in the SANs of the certificates. A *. prefix matches a single
+	// DNS label. Any SAN is accepted if empty
+	AllowedSANs []string `json:"allowed_sans"`
+	// organizational units accepted in the subject of the certificates. Any OU is accepted if empty
+	AllowedOUs []string `json:"allowed_ous"`
+	// access rules of the endpoints, with the OUs of the certificates as roles
+	Policy *PolicyConfig `json:"policy"`
+}
+
+// MTLSAuth authenticates the clients by their TLS certificates. The server must request the client
+// certificates, through the client_ca of the tls config of the service
+type MTLSAuth struct {
+	config *MTLSConfig
+	roots  *x509.CertPool
+	policy *Policy
+}
+
+// NewMTLSAuth creates the client certificate authentication, loading the CA bundle of the config
+func NewMTLSAuth(config *MTLSConfig) (*MTLSAuth, error) {
+	ma := &MTLSAuth{config: config, policy: NewPolicy(PolicyConfig{})}
+	if config.Policy != nil {
+		ma.policy = NewPolicy(*config.Policy)
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ma.roots = x509.NewCertPool()
+		if !ma.roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in %s", config.CAFile)
+		}
+	}
+	return ma, nil
+}
+
+// Authenticate validates the client certificate of the request and returns an auth context with its
+// common name as user and client id and its OUs as roles
+func (ma *MTLSAuth) Authenticate(r *http.Request) (*AuthContext, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("no client certificate provided")
+	}
+	leaf := r.TLS.PeerCertificates[0]
+
+	if ma.roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         ma.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+	} else if len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New("the client certificate was not verified")
+	}
+
+	if len(ma.config.AllowedSANs) > 0 && !ma.allowedSAN(leaf) {
+		return nil, errors.New("the SANs of the client certificate are not allowed")
+	}
+	if len(ma.config.AllowedOUs) > 0 && !containsAny(ma.config.AllowedOUs, leaf.Subject.OrganizationalUnit) {
+		return nil, errors.New("the organizational unit of the client certificate is not allowed")
+	}
+
+	sans := append(append([]string{}, leaf.DNSNames...), leaf.EmailAddresses...)
+	for _, u := range leaf.URIs {
+		sans = append(sans, u.String())
+	}
+	return &AuthContext{
+		UserID:     leaf.Subject.CommonName,
+		ClientID:   leaf.Subject.CommonName,
+		Roles:      leaf.Subject.OrganizationalUnit,
+		AuthMethod: "mtls",
+		Claims: map[string]interface{}{
+			"subject": leaf.Subject.String(),
+			"issuer":  leaf.Issuer.String(),
+			"serial":  leaf.SerialNumber.String(),
+			"sans":    sans,
+		},
+	}, nil
+}
+
+func (ma *MTLSAuth) allowedSAN(cert *x509.Certificate) bool {
+	for _, allowed := range ma.config.AllowedSANs {
+		for _, name := range cert.DNSNames {
+			if matchDNSName(allowed, name) {
+				return true
+			}
+		}
+		for _, email := range cert.EmailAddresses {
+			if strings.EqualFold(allowed, email) {
+				return true
+			}
+		}
+		for _, u := range cert.URIs {
+			if allowed == u.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HTTPMiddleware returns an HTTP middleware rejecting the requests without a valid client certificate
+// or not allowed by the policy
+func (ma *MTLSAuth) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCtx, err := ma.Authenticate(r)
+		if err != nil {
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err := ma.policy.Authorize(authCtx, r.Method, r.URL.Path); err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, withAuthContext(r, authCtx))
+	})
+}
+
+func matchDNSName(pattern, name string) bool {
+	pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	if !strings.HasPrefix(pattern, "*.") {
+		return pattern == name
+	}
+	i := strings.IndexByte(name, '.')
+	return i > 0 && name[i:] == pattern[1:]
+}
+
+func containsAny(allowed, values []string) bool {
+	for _, a := range allowed {
+		for _, v := range values {
+			if a == v {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMTLSAuth_HTTPMiddleware(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "porta-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600)
+
+	issue := func(cn, ou, dns string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: []string{ou}},
+			DNSNames:     []string{dns},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &otherKey.PublicKey, otherKey)
+	otherCA, _ := x509.ParseCertificate(otherDER)
+
+	ma, err := NewMTLSAuth(&MTLSConfig{
+		CAFile:      caFile,
+		AllowedSANs: []string{"*.svc.internal"},
+		AllowedOUs:  []string{"billing", "ops"},
+		Policy: &PolicyConfig{Rules: []PolicyRule{
+			{Endpoint: "/admin/*", Roles: []string{"ops"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var authCtx *AuthContext
+	h := ma.HTTPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		authCtx, _ = AuthContextFrom(r.Context())
+	}))
+
+	for name, tc := range map[string]struct {
+		cert   *x509.Certificate
+		path   string
+		status int
+	}{
+		"valid":          {issue("invoices", "billing", "invoices.svc.internal", ca, caKey), "/invoices", http.StatusOK},
+		"policy":         {issue("invoices", "billing", "invoices.svc.internal", ca, caKey), "/admin/users", http.StatusForbidden},
+		"san":            {issue("invoices", "billing", "invoices.example.com", ca, caKey), "/invoices", http.StatusUnauthorized},
+		"ou":             {issue("invoices", "marketing", "invoices.svc.internal", ca, caKey), "/invoices", http.StatusUnauthorized},
+		"untrusted":      {issue("invoices", "billing", "invoices.svc.internal", otherCA, otherKey), "/invoices", http.StatusUnauthorized},
+		"no certificate": {nil, "/invoices", http.StatusUnauthorized},
+	} {
+		authCtx = nil
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r.TLS = &tls.ConnectionState{}
+		if tc.cert != nil {
+			r.TLS.PeerCertificates = []*x509.Certificate{tc.cert}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: unexpected status %d", name, w.Code)
+		}
+		if tc.status == http.StatusOK && (authCtx == nil || authCtx.ClientID != "invoices" || authCtx.AuthMethod != "mtls" || authCtx.Roles[0] != "billing") {
+			t.Errorf("%s: unexpected auth context %+v", name, authCtx)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package security
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NonceStore remembers the nonces of the signed requests during their ttl, so a captured request can not
+// be replayed while its timestamp is still valid
+type NonceStore interface {
+	// Use records the nonce, returning false if it was already used and has not expired
+	Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// NewMemoryNonceStore returns a store keeping the nonces in memory. The replicas of the gateway do not
+// share their nonces, so a request can be replayed against another replica
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+type memoryNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	cleanedAt time.Time
+}
+
+func (s *memoryNonceStore) Use(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.cleanedAt) > ttl {
+		for n, expiresAt := range s.nonces {
+			if !now.Before(expiresAt) {
+				delete(s.nonces, n)
+			}
+		}
+		s.cleanedAt = now
+	}
+	if expiresAt, ok := s.nonces[nonce]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
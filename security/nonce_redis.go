@@ -0,0 +1,34 @@
+package security
+
+import (
+	"context"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisNoncePrefix is prepended to the nonces to build their redis keys
+const RedisNoncePrefix = "porta:nonce:"
+
+// NewRedisNonceStore returns a store keeping the nonces in redis, so every replica of the gateway
+// rejects the replays
+func NewRedisNonceStore(pool *redis.Pool) NonceStore {
+	return redisNonceStore{pool}
+}
+
+type redisNonceStore struct {
+	pool *redis.Pool
+}
+
+func (s redisNonceStore) Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	_, err = redis.String(conn.Do("SET", RedisNoncePrefix+nonce, 1, "NX", "PX", int64(ttl/time.Millisecond)))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,354 @@
+package security
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DiscoveryPath is the path of the OpenID provider metadata, relative to the issuer
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// OIDCConfig holds the OpenID Connect settings of an endpoint
+type OIDCConfig struct {
+	// issuer of the tokens, like https://example.auth0.com/. Its metadata is discovered from the
+	// well-known path
+	Issuer string `json:"issuer"`
+	// accepted audiences. The tokens must be issued for one of them if not empty
+	Audience []string `json:"audience"`
+	// enrich the auth context with the claims of the userinfo endpoint of the provider
+	UserInfo bool `json:"userinfo"`
+	// dotted path of the claim holding the roles, like realm_access.roles for Keycloak. roles if empty
+	RolesClaim string `json:"roles_claim"`
+	// tolerance applied to the exp, nbf and iat claims
+	ClockSkew time.Duration `json:"clock_skew"`
+	// credentials of the client for the authorization code flow
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURL  string `json:"redirect_url"`
+	// scopes requested in the authorization code flow. openid if empty
+	Scopes []string `json:"scopes"`
+}
+
+// ProviderMetadata is the subset of the OpenID provider metadata used by the gateway
+type ProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Token is the response of the token endpoint
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// OIDC authenticates the requests with the access tokens issued by an OpenID provider. The provider
+// metadata is discovered on the first request and fetched again while the discovery fails
+type OIDC struct {
+	config *OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *ProviderMetadata
+	jwks     *JWKS
+
+	userInfoMu sync.Mutex
+	userInfo   map[[sha256.Size]byte]cachedUserInfo
+}
+
+type cachedUserInfo struct {
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+// NewOIDC returns the authenticator of the provider of the config
+func NewOIDC(config *OIDCConfig) *OIDC {
+	return &OIDC{
+		config:   config,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		userInfo: map[[sha256.Size]byte]cachedUserInfo{},
+	}
+}
+
+// Discover returns the metadata of the provider, fetching it from its well-known path the first time
+func (o *OIDC) Discover(ctx context.Context) (*ProviderMetadata, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.metadata != nil {
+		return o.metadata, nil
+	}
+
+	m := &ProviderMetadata{}
+	if err := o.getJSON(ctx, strings.TrimSuffix(o.config.Issuer, "/")+DiscoveryPath, "", m); err != nil {
+		return nil, fmt.Errorf("discovering the OpenID provider: %w", err)
+	}
+	if m.Issuer != o.config.Issuer {
+		return nil, fmt.Errorf("the discovered issuer %s does not match %s", m.Issuer, o.config.Issuer)
+	}
+	if m.JWKSURI == "" {
+		return nil, errors.New("the OpenID provider does not publish a jwks_uri")
+	}
+	o.metadata = m
+	o.jwks = NewJWKS(m.JWKSURI)
+	o.jwks.Client = o.client
+	return m, nil
+}
+
+// Authenticate validates the bearer token of the request against the keys, the issuer and the
+// audiences of the provider
+func (o *OIDC) Authenticate(r *http.Request) (*AuthContext, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, errors.New("no bearer token provided")
+	}
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+	if _, err := o.Discover(r.Context()); err != nil {
+		return nil, err
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(o.config.Issuer),
+		jwt.WithLeeway(o.config.ClockSkew),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, o.key, opts...); err != nil {
+		return nil, fmt.Errorf("invalid JWT token: %w", err)
+	}
+	if err := o.checkAudience(claims); err != nil {
+		return nil, err
+	}
+
+	if o.config.UserInfo {
+		info, err := o.cachedUserInfo(r.Context(), tokenString, claims)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range info {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+
+	sub, _ := claims["sub"].(string)
+	clientID, _ := claims["azp"].(string)
+	if clientID == "" {
+		clientID, _ = claims["client_id"].(string)
+	}
+	return &AuthContext{
+		UserID:     sub,
+		ClientID:   clientID,
+		Roles:      stringList(claimPath(claims, o.config.RolesClaim)),
+		Scopes:     append(stringList(claims["scope"]), stringList(claims["scp"])...),
+		AuthMethod: "oidc",
+		Claims:     claims,
+	}, nil
+}
+
+// HTTPMiddleware rejects the requests without a valid token of the provider, storing the auth context
+// of the valid ones in the request context
+func (o *OIDC) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCtx, err := o.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, withAuthContext(r, authCtx))
+	})
+}
+
+// AuthCodeURL returns the URL of the provider starting the authorization code flow
+func (o *OIDC) AuthCodeURL(ctx context.Context, state string) (string, error) {
+	m, err := o.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := o.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+	q := url.Values{
+		"client_id":     {o.config.ClientID},
+		"redirect_uri":  {o.config.RedirectURL},
+		"response_type": {"code"},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+	}
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for the tokens at the token endpoint of the provider
+func (o *OIDC) Exchange(ctx context.Context, code string) (*Token, error) {
+	m, err := o.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.config.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging the code: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchanging the code: unexpected status %d", resp.StatusCode)
+	}
+	token := &Token{}
+	if err := json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, fmt.Errorf("decoding the token: %w", err)
+	}
+	return token, nil
+}
+
+// UserInfo returns the claims of the userinfo endpoint of the provider for the access token
+func (o *OIDC) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	m, err := o.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.UserInfoEndpoint == "" {
+		return nil, errors.New("the OpenID provider does not publish a userinfo_endpoint")
+	}
+	info := map[string]interface{}{}
+	if err := o.getJSON(ctx, m.UserInfoEndpoint, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("fetching the userinfo: %w", err)
+	}
+	return info, nil
+}
+
+// cachedUserInfo returns the userinfo of the token, reusing it until the token expires
+func (o *OIDC) cachedUserInfo(ctx context.Context, token string, claims jwt.MapClaims) (map[string]interface{}, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	o.userInfoMu.Lock()
+	cached, ok := o.userInfo[key]
+	o.userInfoMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.claims, nil
+	}
+
+	info, err := o.UserInfo(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		o.userInfoMu.Lock()
+		for k, v := range o.userInfo {
+			if !now.Before(v.expires) {
+				delete(o.userInfo, k)
+			}
+		}
+		o.userInfo[key] = cachedUserInfo{claims: info, expires: exp.Time}
+		o.userInfoMu.Unlock()
+	}
+	return info, nil
+}
+
+func (o *OIDC) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return o.jwks.Key(kid)
+}
+
+func (o *OIDC) checkAudience(claims jwt.MapClaims) error {
+	if len(o.config.Audience) == 0 {
+		return nil
+	}
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return err
+	}
+	for _, a := range aud {
+		for _, accepted := range o.config.Audience {
+			if a == accepted {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("the token audience %v is not accepted", []string(aud))
+}
+
+func (o *OIDC) getJSON(ctx context.Context, u, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// claimPath returns the claim at the dotted path, like realm_access.roles
+func claimPath(claims map[string]interface{}, path string) interface{} {
+	if path == "" {
+		path = "roles"
+	}
+	var v interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOIDC_Authenticate(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+
+	var userInfoCalls int32
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc(DiscoveryPath, func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(ProviderMetadata{
+			Issuer:           ts.URL,
+			JWKSURI:          ts.URL + "/keys",
+			UserInfoEndpoint: ts.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "1", "n": encode(key.N), "e": encode(big.NewInt(int64(key.E)))},
+		}})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&userInfoCalls, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"sub": "other", "email": "supu@example.com"})
+	})
+
+	o := NewOIDC(&OIDCConfig{Issuer: ts.URL, Audience: []string{"porta"}, UserInfo: true, RolesClaim: "realm_access.roles"})
+	sign := func(claims jwt.MapClaims) *http.Request {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "1"
+		s, _ := token.SignedString(key)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+s)
+		return r
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	valid := sign(jwt.MapClaims{"iss": ts.URL, "aud": "porta", "sub": "supu", "exp": exp, "realm_access": map[string]interface{}{"roles": []string{"admin"}}})
+	for i := 0; i < 2; i++ {
+		authCtx, err := o.Authenticate(valid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authCtx.UserID != "supu" || len(authCtx.Roles) != 1 || authCtx.Roles[0] != "admin" || authCtx.Claims["email"] != "supu@example.com" {
+			t.Errorf("unexpected auth context: %+v", authCtx)
+		}
+	}
+	if n := atomic.LoadInt32(&userInfoCalls); n != 1 {
+		t.Errorf("the userinfo was not cached: %d calls", n)
+	}
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"issuer":   {"iss": "http://evil", "aud": "porta", "exp": exp},
+		"audience": {"iss": ts.URL, "aud": "other", "exp": exp},
+		"expired":  {"iss": ts.URL, "aud": "porta", "exp": time.Now().Add(-time.Hour).Unix()},
+	} {
+		if _, err := o.Authenticate(sign(claims)); err == nil {
+			t.Errorf("%s: expecting an error", name)
+		}
+	}
+}
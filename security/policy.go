@@ -0,0 +1,175 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PolicyConfig holds the access rules of the endpoints
+type PolicyConfig struct {
+	Rules []PolicyRule `json:"rules"`
+	// roles granting the permissions of other roles, like {"admin": ["user"]}. The inheritance is transitive
+	RoleHierarchy map[string][]string `json:"role_hierarchy"`
+	// reject the requests not matching any rule instead of allowing them
+	DenyByDefault bool `json:"deny_by_default"`
+}
+
+// PolicyRule grants the access to the endpoints matching its pattern to the roles of the rule. The
+// segments of the pattern may be parameters, like :id or {id}, matching a single segment, and the last
+// one may be a *, matching the rest of the path
+type PolicyRule struct {
+	Endpoint string `json:"endpoint"`
+	// methods of the rule, all of them if empty
+	Methods []string `json:"methods"`
+	// roles allowed by the rule, any authenticated client if empty
+	Roles []string `json:"roles"`
+	// scopes the tokens must have, all of them
+	Scopes []string `json:"scopes"`
+}
+
+// ErrInsufficientScope is wrapped by the errors of the requests whose token lacks a scope of the rule
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// Policy decides the access of the clients to the endpoints. The most specific rule matching the
+// request applies: the one with more literal segments and, on ties, the one without a trailing *
+type Policy struct {
+	rules         []policyRule
+	hierarchy     map[string][]string
+	denyByDefault bool
+}
+
+type policyRule struct {
+	PolicyRule
+	segments []string
+	catchAll bool
+	literals int
+	methods  map[string]struct{}
+}
+
+// NewPolicy creates a policy with the rules of the config
+func NewPolicy(cfg PolicyConfig) *Policy {
+	p := &Policy{
+		rules:         make([]policyRule, 0, len(cfg.Rules)),
+		hierarchy:     cfg.RoleHierarchy,
+		denyByDefault: cfg.DenyByDefault,
+	}
+	for _, r := range cfg.Rules {
+		p.rules = append(p.rules, compileRule(r))
+	}
+	sort.SliceStable(p.rules, func(i, j int) bool {
+		a, b := p.rules[i], p.rules[j]
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		return !a.catchAll && b.catchAll
+	})
+	return p
+}
+
+func compileRule(r PolicyRule) policyRule {
+	rule := policyRule{PolicyRule: r, segments: splitPath(r.Endpoint)}
+	if n := len(rule.segments); n > 0 && rule.segments[n-1] == "*" {
+		rule.catchAll = true
+		rule.segments = rule.segments[:n-1]
+	}
+	for _, s := range rule.segments {
+		if !isParam(s) {
+			rule.literals++
+		}
+	}
+	if len(r.Methods) > 0 {
+		rule.methods = make(map[string]struct{}, len(r.Methods))
+		for _, m := range r.Methods {
+			rule.methods[strings.ToUpper(m)] = struct{}{}
+		}
+	}
+	return rule
+}
+
+// Authorize returns an error if the roles of the auth context are not allowed to send the request or
+// its scopes lack any of the ones of the rule
+func (p *Policy) Authorize(authCtx *AuthContext, method, path string) error {
+	rule, ok := p.match(method, splitPath(path))
+	if !ok {
+		if p.denyByDefault {
+			return fmt.Errorf("insufficient permissions: no rule allows %s %s", method, path)
+		}
+		return nil
+	}
+	if len(rule.Roles) > 0 && !p.hasRole(authCtx.Roles, rule.Roles) {
+		return fmt.Errorf("insufficient permissions: requires one of %v, has %v", rule.Roles, authCtx.Roles)
+	}
+	for _, required := range rule.Scopes {
+		if !contains(authCtx.Scopes, required) {
+			return fmt.Errorf("%w: requires %v, has %v", ErrInsufficientScope, rule.Scopes, authCtx.Scopes)
+		}
+	}
+	return nil
+}
+
+// hasRole reports if any of the roles, or the ones they inherit, is one of the allowed ones
+func (p *Policy) hasRole(roles, allowed []string) bool {
+	expanded := p.expand(roles)
+	for _, a := range allowed {
+		if _, ok := expanded[a]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) match(method string, path []string) (policyRule, bool) {
+	for _, r := range p.rules {
+		if r.methods != nil {
+			if _, ok := r.methods[method]; !ok {
+				continue
+			}
+		}
+		if r.matches(path) {
+			return r, true
+		}
+	}
+	return policyRule{}, false
+}
+
+func (r policyRule) matches(path []string) bool {
+	if len(path) < len(r.segments) || (!r.catchAll && len(path) != len(r.segments)) {
+		return false
+	}
+	for i, s := range r.segments {
+		if !isParam(s) && s != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// expand returns the roles and all the ones they inherit
+func (p *Policy) expand(roles []string) map[string]struct{} {
+	res := make(map[string]struct{}, len(roles))
+	pending := append([]string{}, roles...)
+	for len(pending) > 0 {
+		role := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := res[role]; ok {
+			continue
+		}
+		res[role] = struct{}{}
+		pending = append(pending, p.hierarchy[role]...)
+	}
+	return res
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, ":") || (strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"))
+}
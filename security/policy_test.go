@@ -0,0 +1,114 @@
+package security
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestPolicy_Authorize(t *testing.T) {
+	p := NewPolicy(PolicyConfig{
+		Rules: []PolicyRule{
+			{Endpoint: "/admin/*", Roles: []string{"admin"}},
+			{Endpoint: "/users/:id", Methods: []string{"GET"}, Roles: []string{"user"}},
+			{Endpoint: "/users/{id}", Methods: []string{"delete"}, Roles: []string{"admin"}},
+			{Endpoint: "/users/me", Roles: []string{"guest"}},
+			{Endpoint: "/public/*"},
+		},
+		RoleHierarchy: map[string][]string{"admin": {"user"}, "user": {"guest"}},
+		DenyByDefault: true,
+	})
+
+	for i, tc := range []struct {
+		roles        []string
+		method, path string
+		allowed      bool
+	}{
+		{[]string{"admin"}, "GET", "/admin/stats/daily", true},
+		{[]string{"user"}, "GET", "/admin/stats", false},
+		{[]string{"user"}, "GET", "/users/42", true},
+		{[]string{"admin"}, "GET", "/users/42", true},
+		{[]string{"guest"}, "GET", "/users/42", false},
+		{[]string{"user"}, "DELETE", "/users/42", false},
+		{[]string{"admin"}, "DELETE", "/users/42", true},
+		{[]string{"guest"}, "GET", "/users/me", true},
+		{[]string{"admin"}, "POST", "/users/42", false},
+		{[]string{"admin"}, "GET", "/users/42/orders", false},
+		{nil, "GET", "/public", true},
+		{nil, "GET", "/unknown", false},
+	} {
+		err := p.Authorize(&AuthContext{Roles: tc.roles}, tc.method, tc.path)
+		if (err == nil) != tc.allowed {
+			t.Errorf("#%d %v %s %s: unexpected result %v", i, tc.roles, tc.method, tc.path, err)
+		}
+	}
+
+	if err := NewPolicy(PolicyConfig{}).Authorize(&AuthContext{}, "GET", "/unknown"); err != nil {
+		t.Errorf("the requests not matching any rule must be allowed by default: %v", err)
+	}
+}
+
+func TestPolicy_Authorize_scopes(t *testing.T) {
+	p := NewPolicy(PolicyConfig{Rules: []PolicyRule{
+		{Endpoint: "/orders/*", Methods: []string{"POST"}, Scopes: []string{"orders:write"}},
+		{Endpoint: "/reports", Roles: []string{"analyst"}, Scopes: []string{"reports:read", "pii"}},
+	}})
+
+	for i, tc := range []struct {
+		roles, scopes []string
+		method, path  string
+		allowed       bool
+	}{
+		{nil, []string{"orders:read", "orders:write"}, "POST", "/orders/42", true},
+		{nil, []string{"orders:read"}, "POST", "/orders/42", false},
+		{[]string{"analyst"}, []string{"reports:read", "pii"}, "GET", "/reports", true},
+		{[]string{"analyst"}, []string{"reports:read"}, "GET", "/reports", false},
+		{nil, []string{"reports:read", "pii"}, "GET", "/reports", false},
+	} {
+		err := p.Authorize(&AuthContext{Roles: tc.roles, Scopes: tc.scopes}, tc.method, tc.path)
+		if (err == nil) != tc.allowed {
+			t.Errorf("#%d %v %v %s %s: unexpected result %v", i, tc.roles, tc.scopes, tc.method, tc.path, err)
+		}
+	}
+	err := p.Authorize(&AuthContext{}, "POST", "/orders/42")
+	if !errors.Is(err, ErrInsufficientScope) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAuthMiddleware_requiredScopes(t *testing.T) {
+	am := NewAuthMiddleware(&AuthConfig{
+		JWTSecret:      "secret",
+		RequiredRoles:  map[string][]string{"/orders": {"user"}},
+		RequiredScopes: map[string][]string{"/orders": {"orders:read"}},
+	})
+	for _, tc := range []struct {
+		claims string
+		status int
+	}{
+		{`"roles":["user"],"scope":"profile orders:read"`, http.StatusOK},
+		{`"roles":["user"],"scp":["orders:read"]`, http.StatusOK},
+		{`"roles":["user"],"scope":"profile"`, http.StatusForbidden},
+		{`"scope":"orders:read"`, http.StatusForbidden},
+	} {
+		claims := &Claims{}
+		if err := json.Unmarshal([]byte(`{"sub":"supu",`+tc.claims+`}`), claims); err != nil {
+			t.Fatal(err)
+		}
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		am.HTTPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})).ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: unexpected status %d", tc.claims, w.Code)
+		}
+		if tc.status == http.StatusForbidden && tc.claims != `"scope":"orders:read"` && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: the missing scopes must be reported to the client", tc.claims)
+		}
+	}
+}
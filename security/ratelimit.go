@@ -1,11 +1,14 @@
 package security
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/ph0m1/porta/lifecycle"
 )
 
 // RateLimitConfig holds rate limiting configuration
@@ -37,7 +40,7 @@ type TokenBucketLimiter struct {
 	config  *RateLimitConfig
 	buckets map[string]*tokenBucket
 	mu      sync.RWMutex
-	stopCh  chan struct{}
+	group   *lifecycle.Group
 }
 
 type tokenBucket struct {
@@ -52,11 +55,11 @@ func NewTokenBucketLimiter(config *RateLimitConfig) *TokenBucketLimiter {
 	limiter := &TokenBucketLimiter{
 		config:  config,
 		buckets: make(map[string]*tokenBucket),
-		stopCh:  make(chan struct{}),
+		group:   lifecycle.NewGroup(context.Background()),
 	}
 
 	// Start cleanup routine
-	go limiter.cleanup()
+	limiter.group.Go(limiter.cleanup)
 
 	return limiter
 }
@@ -136,13 +139,13 @@ func (tbl *TokenBucketLimiter) GetStats(key string) RateLimitStats {
 	}
 }
 
-// Stop stops the rate limiter
+// Stop stops the rate limiter and waits for its cleanup routine. It is safe to call it more than once
 func (tbl *TokenBucketLimiter) Stop() {
-	close(tbl.stopCh)
+	tbl.group.Stop(context.Background())
 }
 
 // cleanup removes old buckets
-func (tbl *TokenBucketLimiter) cleanup() {
+func (tbl *TokenBucketLimiter) cleanup(ctx context.Context) {
 	ticker := time.NewTicker(tbl.config.CleanupInterval)
 	defer ticker.Stop()
 
@@ -157,7 +160,7 @@ func (tbl *TokenBucketLimiter) cleanup() {
 				}
 			}
 			tbl.mu.Unlock()
-		case <-tbl.stopCh:
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -168,7 +171,7 @@ type SlidingWindowLimiter struct {
 	config  *RateLimitConfig
 	windows map[string]*slidingWindow
 	mu      sync.RWMutex
-	stopCh  chan struct{}
+	group   *lifecycle.Group
 }
 
 type slidingWindow struct {
@@ -182,10 +185,10 @@ func NewSlidingWindowLimiter(config *RateLimitConfig) *SlidingWindowLimiter {
 	limiter := &SlidingWindowLimiter{
 		config:  config,
 		windows: make(map[string]*slidingWindow),
-		stopCh:  make(chan struct{}),
+		group:   lifecycle.NewGroup(context.Background()),
 	}
 
-	go limiter.cleanup()
+	limiter.group.Go(limiter.cleanup)
 	return limiter
 }
 
@@ -276,13 +279,13 @@ func (swl *SlidingWindowLimiter) GetStats(key string) RateLimitStats {
 	}
 }
 
-// Stop stops the rate limiter
+// Stop stops the rate limiter and waits for its cleanup routine. It is safe to call it more than once
 func (swl *SlidingWindowLimiter) Stop() {
-	close(swl.stopCh)
+	swl.group.Stop(context.Background())
 }
 
 // cleanup removes old windows
-func (swl *SlidingWindowLimiter) cleanup() {
+func (swl *SlidingWindowLimiter) cleanup(ctx context.Context) {
 	ticker := time.NewTicker(swl.config.CleanupInterval)
 	defer ticker.Stop()
 
@@ -297,7 +300,7 @@ func (swl *SlidingWindowLimiter) cleanup() {
 				}
 			}
 			swl.mu.Unlock()
-		case <-swl.stopCh:
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -328,6 +331,7 @@ func (rlm *RateLimitMiddleware) SetOnLimit(onLimit func(http.ResponseWriter, *ht
 func (rlm *RateLimitMiddleware) HTTPMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := rlm.keyFunc(r)
+		recordRateLimitKey(r.Context(), key)
 
 		if !rlm.limiter.Allow(key) {
 			stats := rlm.limiter.GetStats(key)
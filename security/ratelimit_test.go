@@ -0,0 +1,23 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/lifecycle/leaktest"
+)
+
+func TestLimiters_Stop(t *testing.T) {
+	defer leaktest.VerifyNone(t)
+
+	cfg := &RateLimitConfig{RequestsPerSecond: 1, BurstSize: 1, WindowSize: time.Second, CleanupInterval: time.Millisecond}
+	tbl := NewTokenBucketLimiter(cfg)
+	swl := NewSlidingWindowLimiter(cfg)
+
+	if !tbl.Allow("supu") || tbl.Allow("supu") {
+		t.Error("unexpected token bucket decisions")
+	}
+	tbl.Stop()
+	tbl.Stop()
+	swl.Stop()
+}
@@ -0,0 +1,60 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureAuth_HTTPMiddleware(t *testing.T) {
+	sa := NewSignatureAuth(map[string]string{"client-1": "secret"})
+	var received string
+	h := sa.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	}))
+
+	sign := func(body, nonce string, ts time.Time) *http.Request {
+		r := httptest.NewRequest("POST", "/orders?a=1", strings.NewReader(body))
+		timestamp := ts.UTC().Format(time.RFC3339)
+		sum := sha256.Sum256([]byte(body))
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(strings.Join([]string{"POST", "/orders", "a=1", timestamp, "client-1", nonce, hex.EncodeToString(sum[:])}, "\n")))
+		r.Header.Set("X-Client-ID", "client-1")
+		r.Header.Set("X-Timestamp", timestamp)
+		r.Header.Set("X-Nonce", nonce)
+		r.Header.Set("X-Signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		return r
+	}
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve(sign(`{"id":1}`, "n1", time.Now())); code != http.StatusOK || received != `{"id":1}` {
+		t.Errorf("unexpected result for a valid signature: %d, %q", code, received)
+	}
+	if code := serve(sign(`{"id":1}`, "n1", time.Now())); code != http.StatusUnauthorized {
+		t.Errorf("the replays must be rejected: %d", code)
+	}
+
+	tampered := sign(`{"id":1}`, "n2", time.Now())
+	tampered.Body = io.NopCloser(strings.NewReader(`{"id":2}`))
+	if code := serve(tampered); code != http.StatusUnauthorized {
+		t.Errorf("the tampered bodies must be rejected: %d", code)
+	}
+	if code := serve(sign(`{"id":1}`, "n2", time.Now())); code != http.StatusOK {
+		t.Errorf("the nonce of a rejected request must stay usable: %d", code)
+	}
+	if code := serve(sign(`{"id":1}`, "n3", time.Now().Add(-time.Hour))); code != http.StatusUnauthorized {
+		t.Errorf("the old timestamps must be rejected: %d", code)
+	}
+}
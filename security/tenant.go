@@ -0,0 +1,200 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/monitoring/metrics"
+)
+
+var tenantRequests = metrics.NewCounter("porta_tenant_requests_total", "Requests of the tenants by outcome: allowed, rate_limited or over_quota", "tenant", "outcome")
+
+// TenantPolicy holds the limits of a tenant
+type TenantPolicy struct {
+	// requests per second of the tenant, shared by all its clients. Unlimited if zero
+	RequestsPerSecond int `json:"requests_per_second"`
+	// requests accepted at once after an idle period. The requests per second if zero
+	BurstSize int `json:"burst_size"`
+	// requests per day of the tenant, counted from midnight UTC. Unlimited if zero
+	DailyQuota int `json:"daily_quota"`
+	// url patterns of the backends the tenant can reach. A trailing * matches any suffix. Any backend
+	// if empty
+	AllowedBackends []string `json:"allowed_backends"`
+}
+
+// TenancyConfig holds the tenancy settings. The fields of the default policy apply to the tenants
+// without overrides and fill the zero fields of the overrides
+type TenancyConfig struct {
+	// claim of the OIDC tokens holding the tenant, tenant if empty. The API keys and the JWTs of the
+	// auth middleware carry the tenant in their own tenant field
+	Claim string `json:"claim"`
+	// tenant of the requests without one. They are rejected if empty
+	Default string `json:"default"`
+	TenantPolicy
+	// policies of the tenants, by id
+	Tenants map[string]TenantPolicy `json:"tenants"`
+}
+
+// Tenant is the tenant of a request along with its policy
+type Tenant struct {
+	ID string
+	TenantPolicy
+}
+
+// AllowsBackend reports if the tenant can reach the backend with the url pattern
+func (t *Tenant) AllowsBackend(urlPattern string) bool {
+	if len(t.AllowedBackends) == 0 {
+		return true
+	}
+	for _, allowed := range t.AllowedBackends {
+		if allowed == urlPattern || (strings.HasSuffix(allowed, "*") && strings.HasPrefix(urlPattern, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of the context carrying the tenant
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantFrom returns the tenant of the request, if any
+func TenantFrom(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(*Tenant)
+	return t, ok
+}
+
+// TenancyMiddleware resolves the tenant of the requests, applying its rate limit and quota. It must run
+// after the auth middlewares, since the tenants come from the auth contexts
+type TenancyMiddleware struct {
+	config *TenancyConfig
+	claim  string
+
+	mu      sync.Mutex
+	tenants map[string]*tenantState
+}
+
+type tenantState struct {
+	tenant *Tenant
+	// token bucket of the rate limit
+	tokens float64
+	last   time.Time
+	// requests of the current day of the quota
+	day  int64
+	used int
+}
+
+// NewTenancyMiddleware creates the tenancy middleware
+func NewTenancyMiddleware(config *TenancyConfig) *TenancyMiddleware {
+	tm := &TenancyMiddleware{config: config, claim: config.Claim, tenants: map[string]*tenantState{}}
+	if tm.claim == "" {
+		tm.claim = "tenant"
+	}
+	return tm
+}
+
+// Resolve returns the tenant of the request: the one of its auth context or the default one
+func (tm *TenancyMiddleware) Resolve(r *http.Request) string {
+	if authCtx, ok := GetAuthContext(r); ok {
+		if authCtx.Tenant != "" {
+			return authCtx.Tenant
+		}
+		if id, ok := authCtx.Claims[tm.claim].(string); ok && id != "" {
+			return id
+		}
+	}
+	return tm.config.Default
+}
+
+// HTTPMiddleware returns an HTTP middleware rejecting the requests without tenant or beyond its limits
+// and storing the tenant of the rest in the request context
+func (tm *TenancyMiddleware) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := tm.Resolve(r)
+		if id == "" {
+			http.Error(w, "Forbidden: no tenant", http.StatusForbidden)
+			return
+		}
+		recordRateLimitKey(r.Context(), "tenant:"+id)
+
+		tenant, outcome, retry := tm.admit(id, time.Now())
+		tenantRequests.Add(1, id, outcome)
+		if outcome != "allowed" {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			http.Error(w, "Too Many Requests: "+strings.Replace(outcome, "_", " ", 1), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+	})
+}
+
+// admit counts the request against the limits of the tenant, returning the outcome and, if rejected,
+// the time to wait
+func (tm *TenancyMiddleware) admit(id string, now time.Time) (*Tenant, string, time.Duration) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	s, ok := tm.tenants[id]
+	if !ok {
+		s = &tenantState{tenant: &Tenant{ID: id, TenantPolicy: tm.policy(id)}, last: now}
+		s.tokens = float64(s.tenant.BurstSize)
+		tm.tenants[id] = s
+	}
+	p := s.tenant.TenantPolicy
+
+	if p.RequestsPerSecond > 0 {
+		s.tokens += now.Sub(s.last).Seconds() * float64(p.RequestsPerSecond)
+		if s.tokens > float64(p.BurstSize) {
+			s.tokens = float64(p.BurstSize)
+		}
+		s.last = now
+		if s.tokens < 1 {
+			return s.tenant, "rate_limited", time.Duration((1 - s.tokens) / float64(p.RequestsPerSecond) * float64(time.Second))
+		}
+	}
+	if p.DailyQuota > 0 {
+		day := now.UTC().Truncate(24 * time.Hour)
+		if day.Unix() != s.day {
+			s.day, s.used = day.Unix(), 0
+		}
+		if s.used >= p.DailyQuota {
+			return s.tenant, "over_quota", day.Add(24 * time.Hour).Sub(now)
+		}
+		s.used++
+	}
+	if p.RequestsPerSecond > 0 {
+		s.tokens--
+	}
+	return s.tenant, "allowed", 0
+}
+
+// policy returns the policy of the tenant, filling its zero fields with the default ones
+func (tm *TenancyMiddleware) policy(id string) TenantPolicy {
+	p, ok := tm.config.Tenants[id]
+	if !ok {
+		p = tm.config.TenantPolicy
+	}
+	if p.RequestsPerSecond == 0 {
+		p.RequestsPerSecond = tm.config.RequestsPerSecond
+	}
+	if p.BurstSize == 0 {
+		p.BurstSize = tm.config.BurstSize
+	}
+	if p.BurstSize == 0 {
+		p.BurstSize = p.RequestsPerSecond
+	}
+	if p.DailyQuota == 0 {
+		p.DailyQuota = tm.config.DailyQuota
+	}
+	if len(p.AllowedBackends) == 0 {
+		p.AllowedBackends = tm.config.AllowedBackends
+	}
+	return p
+}
@@ -0,0 +1,69 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTenancyMiddleware(t *testing.T) {
+	tm := NewTenancyMiddleware(&TenancyConfig{
+		TenantPolicy: TenantPolicy{RequestsPerSecond: 100, DailyQuota: 2},
+		Tenants: map[string]TenantPolicy{
+			"acme": {DailyQuota: 3, AllowedBackends: []string{"/acme/*"}},
+		},
+	})
+	var tenant *Tenant
+	h := tm.HTTPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		tenant, _ = TenantFrom(r.Context())
+	}))
+	send := func(authCtx *AuthContext) int {
+		req := httptest.NewRequest("GET", "/supu", nil)
+		if authCtx != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "auth", authCtx))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send(nil); code != http.StatusForbidden {
+		t.Errorf("the requests without tenant must be rejected: %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := send(&AuthContext{Tenant: "acme"}); code != http.StatusOK {
+			t.Errorf("#%d unexpected status: %d", i, code)
+		}
+	}
+	if tenant.ID != "acme" || tenant.RequestsPerSecond != 100 || !tenant.AllowsBackend("/acme/users") || tenant.AllowsBackend("/other") {
+		t.Errorf("unexpected tenant: %+v", tenant)
+	}
+	if code := send(&AuthContext{Tenant: "acme"}); code != http.StatusTooManyRequests {
+		t.Errorf("the quota of the tenant must apply: %d", code)
+	}
+
+	oidc := &AuthContext{Claims: map[string]interface{}{"tenant": "globex"}}
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := send(oidc); code != expected {
+			t.Errorf("#%d the default quota must apply to the tenants without overrides: %d", i, code)
+		}
+	}
+	if !tenant.AllowsBackend("/other") {
+		t.Error("the tenants without allowed backends can reach any backend")
+	}
+}
+
+func TestTenancyMiddleware_rateLimit(t *testing.T) {
+	tm := NewTenancyMiddleware(&TenancyConfig{Default: "public", TenantPolicy: TenantPolicy{RequestsPerSecond: 1, BurstSize: 2}})
+	now := time.Now()
+	for i, expected := range []string{"allowed", "allowed", "rate_limited"} {
+		if _, outcome, _ := tm.admit(tm.Resolve(httptest.NewRequest("GET", "/", nil)), now); outcome != expected {
+			t.Errorf("#%d unexpected outcome: %s", i, outcome)
+		}
+	}
+	if _, outcome, _ := tm.admit("public", now.Add(time.Second)); outcome != "allowed" {
+		t.Errorf("the bucket must refill: %s", outcome)
+	}
+}
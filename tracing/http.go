@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/ph0m1/porta/proxy"
+)
+
+// Headers of the propagated traces
+const (
+	TraceParentHeader = "Traceparent"
+	B3TraceIDHeader   = "X-B3-Traceid"
+	B3SpanIDHeader    = "X-B3-Spanid"
+	B3SampledHeader   = "X-B3-Sampled"
+)
+
+// Extract returns the span context sent by the client in the W3C traceparent header or, if missing, in
+// the B3 headers
+func Extract(h http.Header) (*SpanContext, bool) {
+	if tp := h.Get(TraceParentHeader); tp != "" {
+		parts := strings.Split(tp, "-")
+		if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+			return nil, false
+		}
+		traceID, ok := parseTraceID(parts[1])
+		spanID, err := strconv.ParseUint(parts[2], 16, 64)
+		flags, ferr := strconv.ParseUint(parts[3], 16, 8)
+		if !ok || err != nil || ferr != nil {
+			return nil, false
+		}
+		return &SpanContext{TraceID: traceID, SpanID: spanID, Sampled: flags&1 == 1}, true
+	}
+
+	traceID, ok := parseTraceID(h.Get(B3TraceIDHeader))
+	spanID, err := strconv.ParseUint(h.Get(B3SpanIDHeader), 16, 64)
+	if !ok || err != nil {
+		return nil, false
+	}
+	sampled := h.Get(B3SampledHeader)
+	return &SpanContext{TraceID: traceID, SpanID: spanID, Sampled: sampled != "0" && sampled != "false"}, true
+}
+
+// Inject adds the W3C and B3 headers of the span context to the headers
+func Inject(sc SpanContext, headers map[string][]string) {
+	flags, sampled := "00", "0"
+	if sc.Sampled {
+		flags, sampled = "01", "1"
+	}
+	spanID := fmt.Sprintf("%016x", sc.SpanID)
+	headers[TraceParentHeader] = []string{"00-" + sc.TraceID.String() + "-" + spanID + "-" + flags}
+	headers[B3TraceIDHeader] = []string{sc.TraceID.String()}
+	headers[B3SpanIDHeader] = []string{spanID}
+	headers[B3SampledHeader] = []string{sampled}
+}
+
+// parseTraceID parses the 64 or 128 bits trace ids in hex
+func parseTraceID(s string) (TraceID, bool) {
+	if len(s) != 16 && len(s) != 32 {
+		return TraceID{}, false
+	}
+	id := TraceID{}
+	if len(s) == 32 {
+		high, err := strconv.ParseUint(s[:16], 16, 64)
+		if err != nil {
+			return id, false
+		}
+		id.High, s = high, s[16:]
+	}
+	low, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || (id.High == 0 && low == 0) {
+		return id, false
+	}
+	id.Low = low
+	return id, true
+}
+
+// HTTPMiddleware returns an HTTP middleware recording a server span per request, child of the span
+// propagated by the client, if any
+func (t *Tracer) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.StartRequest(r)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		FinishRequest(span, sw.status)
+	})
+}
+
+// StartRequest starts the server span of the request, for the routers wrapping the handlers by
+// themselves. The span must be finished with FinishRequest. The returned context also carries the
+// trace id for proxy.RequestLogger
+func (t *Tracer) StartRequest(r *http.Request) (context.Context, *Span) {
+	remote, _ := Extract(r.Header)
+	ctx, span := t.StartSpan(r.Context(), r.Method+" "+r.URL.Path, KindServer, remote)
+	span.SetTag("http.method", r.Method)
+	span.SetTag("http.path", r.URL.Path)
+	return proxy.WithTraceID(ctx, span.TraceID.String()), span
+}
+
+// FinishRequest records the status of the response and finishes the server span
+func FinishRequest(span *Span, status int) {
+	span.SetTag("http.status_code", strconv.Itoa(status))
+	if status >= http.StatusInternalServerError {
+		span.SetTag("error", "true")
+	}
+	span.Finish()
+}
+
+// BackendHook returns a proxy hook recording a client span per call to the backends and propagating
+// it in the headers of the backend requests
+func (t *Tracer) BackendHook() proxy.Hook {
+	return proxy.HookFuncs{
+		Before: func(ctx context.Context, call proxy.BackendCall) context.Context {
+			ctx, span := t.StartSpan(ctx, call.Backend.URLPattern, KindClient, nil)
+			span.SetTag("http.method", call.Request.Method)
+			if call.Request.URL != nil {
+				span.SetTag("http.url", call.Request.URL.String())
+			}
+			// the headers are shared by the clones of the request, so they are copied before adding
+			// the ones of the span
+			headers := make(map[string][]string, len(call.Request.Headers)+4)
+			for k, v := range call.Request.Headers {
+				headers[textproto.CanonicalMIMEHeaderKey(k)] = v
+			}
+			Inject(span.SpanContext, headers)
+			call.Request.Headers = headers
+			return ctx
+		},
+		After: func(ctx context.Context, _ proxy.BackendCall, outcome proxy.BackendOutcome) {
+			span, ok := SpanFrom(ctx)
+			if !ok {
+				return
+			}
+			if outcome.Err != nil {
+				span.SetTag("error", outcome.Err.Error())
+			}
+			span.Finish()
+		},
+	}
+}
+
+// statusWriter keeps the status code sent to the client
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// readOnlySpans converts the spans into the finished spans of OpenTelemetry, so they can be sent by its
+// exporters. The service is the service.name of their resource
+func readOnlySpans(service string, spans []*Span) []sdktrace.ReadOnlySpan {
+	res := resource.NewSchemaless(attribute.String("service.name", service))
+	stubs := make(tracetest.SpanStubs, len(spans))
+	for i, s := range spans {
+		stubs[i] = tracetest.SpanStub{
+			Name:        s.Name,
+			SpanContext: otelSpanContext(s.TraceID, s.SpanID),
+			SpanKind:    map[string]trace.SpanKind{KindServer: trace.SpanKindServer, KindClient: trace.SpanKindClient}[s.Kind],
+			StartTime:   s.Start,
+			EndTime:     s.Start.Add(s.Duration),
+			Attributes:  attributes(s.Tags()),
+			Resource:    res,
+		}
+		if s.ParentID != 0 {
+			stubs[i].Parent = otelSpanContext(s.TraceID, s.ParentID)
+		}
+	}
+	return stubs.Snapshots()
+}
+
+func otelSpanContext(traceID TraceID, spanID uint64) trace.SpanContext {
+	var tid trace.TraceID
+	binary.BigEndian.PutUint64(tid[:8], traceID.High)
+	binary.BigEndian.PutUint64(tid[8:], traceID.Low)
+	var sid trace.SpanID
+	binary.BigEndian.PutUint64(sid[:], spanID)
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: trace.FlagsSampled})
+}
+
+func attributes(tags map[string]string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(tags))
+	for k, v := range tags {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTLPExporter sends the spans to an OTLP/HTTP traces endpoint, like the one of Jaeger or of an
+// OpenTelemetry collector, with the OTLP exporter of OpenTelemetry
+type OTLPExporter struct {
+	URL string
+	// client sending the spans. The one of the OTLP exporter if nil
+	Client *http.Client
+
+	once     sync.Once
+	exporter sdktrace.SpanExporter
+	err      error
+}
+
+// Export implements the Exporter interface
+func (o *OTLPExporter) Export(ctx context.Context, service string, spans []*Span) error {
+	o.once.Do(func() {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(o.URL)}
+		if o.Client != nil {
+			opts = append(opts, otlptracehttp.WithHTTPClient(o.Client))
+		}
+		o.exporter, o.err = otlptracehttp.New(context.Background(), opts...)
+	})
+	if o.err != nil {
+		return o.err
+	}
+	return o.exporter.ExportSpans(ctx, readOnlySpans(service, spans))
+}
@@ -0,0 +1,273 @@
+// Package tracing records the spans of the requests served by the gateway and of the calls to the
+// backends, propagating the W3C and B3 trace headers and exporting the spans to Zipkin or to an OTLP endpoint, like the one of Jaeger
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/lifecycle"
+	"github.com/ph0m1/porta/logging"
+)
+
+// Namespace is the key of the service extra config holding the tracing settings
+const Namespace = "tracing"
+
+// Config defines where the spans of the gateway are exported. Every span is sent to all the declared
+// exporters
+type Config struct {
+	// name of the gateway in the traces. The name of the service if empty
+	ServiceName string `mapstructure:"service_name"`
+	// ratio of the traces started by the gateway that are exported, from 0 to 1. The traces started by
+	// the clients follow their own sampling decision. 1 if zero
+	SampleRate float64 `mapstructure:"sample_rate"`
+	// max spans sent in a single export. 100 if zero
+	BatchSize int `mapstructure:"batch_size"`
+	// max time the spans wait to be exported. 5s if zero
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// url of the v2 spans API of Zipkin, like http://zipkin:9411/api/v2/spans
+	ZipkinURL string `mapstructure:"zipkin_url"`
+	// url of an OTLP/HTTP traces endpoint, like http://jaeger:4318/v1/traces for Jaeger
+	OTLPURL string `mapstructure:"otlp_url"`
+}
+
+func (c *Config) init(name string) error {
+	if c.ZipkinURL == "" && c.OTLPURL == "" {
+		return errors.New("the tracing requires a zipkin_url or an otlp_url, like http://jaeger:4318/v1/traces for Jaeger")
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return errors.New("the sample rate of the tracing must be between 0 and 1")
+	}
+	if c.SampleRate == 0 {
+		c.SampleRate = 1
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = name
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 100
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = 5 * time.Second
+	}
+	return nil
+}
+
+// Kinds of the spans
+const (
+	KindServer = "SERVER"
+	KindClient = "CLIENT"
+)
+
+// TraceID is the 128 bits id of a trace
+type TraceID struct {
+	High, Low uint64
+}
+
+// String returns the id as 32 hex digits
+func (t TraceID) String() string {
+	return fmt.Sprintf("%016x%016x", t.High, t.Low)
+}
+
+// SpanContext is the part of a span propagated to the other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  uint64
+	Sampled bool
+}
+
+// Span is a timed operation of a trace
+type Span struct {
+	SpanContext
+	// id of the parent span. Zero for the root spans
+	ParentID uint64
+	Name     string
+	Kind     string
+	Start    time.Time
+	Duration time.Duration
+
+	mu     sync.Mutex
+	tags   map[string]string
+	tracer *Tracer
+}
+
+// SetTag sets a tag of the span
+func (s *Span) SetTag(key, value string) {
+	s.mu.Lock()
+	s.tags[key] = value
+	s.mu.Unlock()
+}
+
+// Tags returns a copy of the tags of the span
+func (s *Span) Tags() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Finish records the duration of the span and queues it for the export if the trace is sampled
+func (s *Span) Finish() {
+	s.Duration = time.Since(s.Start)
+	if s.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// Exporter sends the finished spans to a tracing system
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []*Span) error
+}
+
+// Tracer creates the spans and exports them in batches from a background routine
+type Tracer struct {
+	service       string
+	sampleRate    float64
+	batchSize     int
+	flushInterval time.Duration
+	exporters     []Exporter
+	logger        logging.Logger
+
+	queue chan *Span
+	group *lifecycle.Group
+}
+
+// New creates a tracer exporting the spans to the Zipkin and OTLP urls of the tracing settings of the
+// extra config of the service. It returns nil if the service does not declare them and an error if they
+// are not valid
+func New(cfg config.ServiceConfig, logger logging.Logger) (*Tracer, error) {
+	raw, ok := cfg.ExtraConfig[Namespace]
+	if !ok {
+		return nil, nil
+	}
+	var tc Config
+	if err := config.Decode(raw, &tc); err != nil {
+		return nil, fmt.Errorf("decoding the tracing settings: %w", err)
+	}
+	if err := tc.init(cfg.Name); err != nil {
+		return nil, err
+	}
+	exporters := []Exporter{}
+	if tc.ZipkinURL != "" {
+		exporters = append(exporters, &ZipkinExporter{URL: tc.ZipkinURL})
+	}
+	if tc.OTLPURL != "" {
+		exporters = append(exporters, &OTLPExporter{URL: tc.OTLPURL})
+	}
+	return NewTracer(tc, logger, exporters...), nil
+}
+
+// NewTracer creates a tracer sending the spans to the exporters with the sampling and the batching of
+// the config
+func NewTracer(tc Config, logger logging.Logger, exporters ...Exporter) *Tracer {
+	t := &Tracer{
+		service:       tc.ServiceName,
+		sampleRate:    tc.SampleRate,
+		batchSize:     tc.BatchSize,
+		flushInterval: tc.FlushInterval,
+		exporters:     exporters,
+		logger:        logger,
+		queue:         make(chan *Span, 10*tc.BatchSize),
+		group:         lifecycle.NewGroup(context.Background()),
+	}
+	t.group.Go(t.run)
+	return t
+}
+
+// StartSpan starts a span, child of the span of the context or of the remote parent, if any. The
+// returned context carries the new span
+func (t *Tracer) StartSpan(ctx context.Context, name, kind string, remote *SpanContext) (context.Context, *Span) {
+	s := &Span{Name: name, Kind: kind, Start: time.Now(), tags: map[string]string{}, tracer: t}
+	switch parent, ok := SpanFrom(ctx); {
+	case ok:
+		s.TraceID, s.ParentID, s.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	case remote != nil:
+		s.TraceID, s.ParentID, s.Sampled = remote.TraceID, remote.SpanID, remote.Sampled
+	default:
+		s.TraceID = TraceID{High: randomID(), Low: randomID()}
+		s.Sampled = t.sampleRate >= 1 || mrand.Float64() < t.sampleRate
+	}
+	s.SpanID = randomID()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Close exports the queued spans and stops the tracer
+func (t *Tracer) Close() error {
+	return t.group.Stop(context.Background())
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		// the exporters can not keep up: the span is dropped instead of blocking the requests
+	}
+}
+
+func (t *Tracer) run(ctx context.Context) {
+	ticker := time.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, t.batchSize)
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= t.batchSize {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends the batch to all the exporters and returns the emptied batch
+func (t *Tracer) export(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.flushInterval)
+	defer cancel()
+	for _, e := range t.exporters {
+		if err := e.Export(ctx, t.service, batch); err != nil && t.logger != nil {
+			t.logger.Warning("exporting the spans:", err.Error())
+		}
+	}
+	return batch[:0]
+}
+
+type spanKey struct{}
+
+// SpanFrom returns the span carried by the context, if any
+func SpanFrom(ctx context.Context) (*Span, bool) {
+	s, ok := ctx.Value(spanKey{}).(*Span)
+	return s, ok
+}
+
+func randomID() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+		return id
+	}
+	return 1
+}
@@ -0,0 +1,273 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type recordingExporter struct {
+	spans chan *Span
+}
+
+func (r recordingExporter) Export(_ context.Context, _ string, spans []*Span) error {
+	for _, s := range spans {
+		r.spans <- s
+	}
+	return nil
+}
+
+func newTestTracer(exporters ...Exporter) *Tracer {
+	return NewTracer(Config{ServiceName: "gateway", SampleRate: 1, BatchSize: 10, FlushInterval: time.Hour}, nil, exporters...)
+}
+
+func TestNew(t *testing.T) {
+	tracer, err := New(config.ServiceConfig{Name: "gateway", ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{
+		"zipkin_url": "http://zipkin:9411/api/v2/spans",
+		"otlp_url":   "http://jaeger:4318/v1/traces",
+	}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close()
+	if tracer.service != "gateway" || tracer.sampleRate != 1 || tracer.batchSize != 100 || tracer.flushInterval != 5*time.Second {
+		t.Errorf("unexpected defaults: %+v", tracer)
+	}
+	if len(tracer.exporters) != 2 {
+		t.Errorf("unexpected exporters: %v", tracer.exporters)
+	}
+
+	if tracer, err := New(config.ServiceConfig{}, nil); tracer != nil || err != nil {
+		t.Errorf("unexpected tracer without the tracing settings: %v %v", tracer, err)
+	}
+	for _, settings := range []interface{}{
+		map[string]interface{}{},
+		map[string]interface{}{"otlp_url": "http://jaeger:4318/v1/traces", "sample_rate": 2},
+		map[string]interface{}{"zipkin_url": "http://zipkin:9411/api/v2/spans", "flush_interval": "soon"},
+	} {
+		if _, err := New(config.ServiceConfig{ExtraConfig: config.ExtraConfig{Namespace: settings}}, nil); err == nil {
+			t.Errorf("%v: the invalid settings were accepted", settings)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	h := http.Header{}
+	h.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	sc, ok := Extract(h)
+	if !ok {
+		t.Fatal("the traceparent header was not extracted")
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID != 0x00f067aa0ba902b7 || !sc.Sampled {
+		t.Errorf("unexpected span context: %+v", sc)
+	}
+
+	h = http.Header{}
+	h.Set(B3TraceIDHeader, "a3ce929d0e0e4736")
+	h.Set(B3SpanIDHeader, "00f067aa0ba902b7")
+	h.Set(B3SampledHeader, "0")
+	sc, ok = Extract(h)
+	if !ok {
+		t.Fatal("the B3 headers were not extracted")
+	}
+	if sc.TraceID != (TraceID{Low: 0xa3ce929d0e0e4736}) || sc.Sampled {
+		t.Errorf("unexpected span context: %+v", sc)
+	}
+
+	h = http.Header{}
+	h.Set(TraceParentHeader, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	if _, ok := Extract(h); ok {
+		t.Error("the zero trace id must be rejected")
+	}
+}
+
+func TestInject(t *testing.T) {
+	sc := SpanContext{TraceID: TraceID{High: 1, Low: 2}, SpanID: 3, Sampled: true}
+	headers := map[string][]string{}
+	Inject(sc, headers)
+	extracted, ok := Extract(http.Header(headers))
+	if !ok || *extracted != sc {
+		t.Errorf("unexpected span context: %+v", extracted)
+	}
+	if h := headers[B3TraceIDHeader]; len(h) != 1 || h[0] != "00000000000000010000000000000002" {
+		t.Errorf("unexpected B3 trace id: %v", h)
+	}
+}
+
+func TestTracer_HTTPMiddleware(t *testing.T) {
+	spans := make(chan *Span, 10)
+	tracer := newTestTracer(recordingExporter{spans})
+
+	var child *Span
+	var traceID string
+	h := tracer.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = proxy.TraceID(r.Context())
+		_, child = tracer.StartSpan(r.Context(), "child", KindClient, nil)
+		child.Finish()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	<-spans
+	server := <-spans
+	if server.Kind != KindServer || server.ParentID != 0x00f067aa0ba902b7 || server.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected server span: %+v", server)
+	}
+	if tags := server.Tags(); tags["http.status_code"] != "502" || tags["error"] != "true" || tags["http.path"] != "/users/1" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+	if child.ParentID != server.SpanID || child.TraceID != server.TraceID {
+		t.Errorf("the child span is not linked to the server span: %+v", child)
+	}
+	if traceID != server.TraceID.String() {
+		t.Errorf("unexpected trace id in the context: %s", traceID)
+	}
+}
+
+func TestTracer_notSampled(t *testing.T) {
+	spans := make(chan *Span, 10)
+	tracer := NewTracer(Config{SampleRate: 1, BatchSize: 10, FlushInterval: time.Hour}, nil, recordingExporter{spans})
+	_, span := tracer.StartSpan(context.Background(), "ignored", KindServer, &SpanContext{TraceID: TraceID{Low: 1}, SpanID: 1})
+	span.Finish()
+	tracer.Close()
+	if len(spans) != 0 {
+		t.Error("the spans of the traces not sampled by the client must not be exported")
+	}
+}
+
+func TestTracer_BackendHook(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer backend.Close()
+
+	spans := make(chan *Span, 10)
+	tracer := newTestTracer(recordingExporter{spans})
+	cfg := &config.Backend{URLPattern: "/users/{{.User}}", Decoder: func(r io.Reader, v *map[string]interface{}) error { return json.NewDecoder(r).Decode(v) }}
+	bf := proxy.HookedBackendFactory(proxy.CustomHTTPProxyFactory(proxy.NewHttpClient), tracer.BackendHook())
+	p := bf(cfg)
+
+	ctx, parent := tracer.StartSpan(context.Background(), "GET /users/1", KindServer, nil)
+	headers := map[string][]string{"X-Custom": {"1"}}
+	u, _ := url.Parse(backend.URL + "/users/1")
+	req := &proxy.Request{Method: "GET", URL: u, Headers: headers, Body: io.NopCloser(&bytes.Buffer{})}
+	if _, err := p(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Close()
+
+	client := <-spans
+	if client.Kind != KindClient || client.Name != "/users/{{.User}}" || client.ParentID != parent.SpanID {
+		t.Errorf("unexpected client span: %+v", client)
+	}
+	if received.Get(B3SpanIDHeader) == "" || received.Get(TraceParentHeader) == "" || received.Get("X-Custom") != "1" {
+		t.Errorf("unexpected backend headers: %v", received)
+	}
+	if _, ok := headers[TraceParentHeader]; ok {
+		t.Error("the headers of the original request were modified")
+	}
+}
+
+func TestZipkinExporter(t *testing.T) {
+	var body []map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+
+	tracer := newTestTracer()
+	_, span := tracer.StartSpan(context.Background(), "GET /users", KindServer, &SpanContext{TraceID: TraceID{High: 1, Low: 2}, SpanID: 3, Sampled: true})
+	span.SetTag("http.method", "GET")
+	span.Duration = 1500 * time.Microsecond
+	tracer.Close()
+
+	if err := (&ZipkinExporter{URL: collector.URL}).Export(context.Background(), "gateway", []*Span{span}); err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 1 {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	s := body[0]
+	if s["traceId"] != "00000000000000010000000000000002" || s["parentId"] != "0000000000000003" || s["kind"] != "SERVER" || s["duration"] != 1500.0 {
+		t.Errorf("unexpected span: %v", s)
+	}
+	if s["localEndpoint"].(map[string]interface{})["serviceName"] != "gateway" || s["tags"].(map[string]interface{})["http.method"] != "GET" {
+		t.Errorf("unexpected span: %v", s)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if err := (&ZipkinExporter{URL: failing.URL}).Export(context.Background(), "gateway", []*Span{span}); err == nil {
+		t.Error("expecting an error for the failing collector")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body []byte
+	var contentType, path string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		contentType, path = r.Header.Get("Content-Type"), r.URL.Path
+	}))
+	defer collector.Close()
+
+	span := &Span{
+		SpanContext: SpanContext{TraceID: TraceID{High: 1, Low: 2}, SpanID: 3, Sampled: true},
+		ParentID:    4,
+		Name:        "op",
+		Kind:        KindClient,
+		Start:       time.UnixMicro(1000),
+		Duration:    time.Millisecond,
+		tags:        map[string]string{"http.method": "GET"},
+	}
+	if err := (&OTLPExporter{URL: collector.URL + "/v1/traces"}).Export(context.Background(), "gw", []*Span{span}); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/x-protobuf" || path != "/v1/traces" {
+		t.Errorf("unexpected request: %s %s", contentType, path)
+	}
+
+	req := &coltracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		t.Fatal(err)
+	}
+	rs := req.ResourceSpans
+	if len(rs) != 1 || len(rs[0].ScopeSpans) != 1 || len(rs[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("unexpected request: %v", req)
+	}
+	if attrs := rs[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.GetStringValue() != "gw" {
+		t.Errorf("unexpected resource: %v", rs[0].Resource)
+	}
+	s := rs[0].ScopeSpans[0].Spans[0]
+	if hex.EncodeToString(s.TraceId) != "00000000000000010000000000000002" || hex.EncodeToString(s.SpanId) != "0000000000000003" ||
+		hex.EncodeToString(s.ParentSpanId) != "0000000000000004" || s.Name != "op" || s.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Errorf("unexpected span: %v", s)
+	}
+	if s.StartTimeUnixNano != 1000000 || s.EndTimeUnixNano != 2000000 || len(s.Attributes) != 1 || s.Attributes[0].Value.GetStringValue() != "GET" {
+		t.Errorf("unexpected span: %v", s)
+	}
+}
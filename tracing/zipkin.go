@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel/exporters/zipkin"
+)
+
+// ZipkinExporter sends the spans to the v2 JSON API of Zipkin with the Zipkin exporter of OpenTelemetry
+type ZipkinExporter struct {
+	URL string
+	// client sending the spans. http.DefaultClient if nil
+	Client *http.Client
+
+	once     sync.Once
+	exporter *zipkin.Exporter
+	err      error
+}
+
+// Export implements the Exporter interface
+func (z *ZipkinExporter) Export(ctx context.Context, service string, spans []*Span) error {
+	z.once.Do(func() {
+		var opts []zipkin.Option
+		if z.Client != nil {
+			opts = append(opts, zipkin.WithClient(z.Client))
+		}
+		z.exporter, z.err = zipkin.New(z.URL, opts...)
+	})
+	if z.err != nil {
+		return z.err
+	}
+	return z.exporter.ExportSpans(ctx, readOnlySpans(service, spans))
+}